@@ -18,7 +18,7 @@ table_name = "catalog_items"
 fields = [
     'id', 'name', 'description', 'picture', 
     'price_usd_currency_code', 'price_usd_units', 'price_usd_nanos', 
-    'categories'
+    'categories', 'attributes'
 ]
 
 # Load the produts JSON
@@ -34,16 +34,20 @@ for product in data['products']:
     # Escape single quotes within product data 
     product['name'] = product['name'].replace("'", "")
     product['description'] = product['description'].replace("'", "")
+    attributes = json.dumps(product.get('attributes', {})).replace("'", "")
 
     escaped_values = (
-        f"'{product['id']}'",
+        # Product IDs are stored in canonical form, which the catalog
+        # service's lookups compare against.
+        f"'{product['id'].strip().upper()}'",
         f"'{product['name']}'",
         f"'{product['description']}'",
         f"'{product['picture']}'",
         f"'{product['priceUsd']['currencyCode']}'",
         product['priceUsd']['units'],
         product['priceUsd']['nanos'],
-        f"'{','.join(product['categories'])}'"
+        f"'{','.join(product['categories'])}'",
+        f"'{attributes}'"
     )
 
     # Render the formatted SQL query
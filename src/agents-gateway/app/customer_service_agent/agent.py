@@ -9,6 +9,7 @@ from .prompts import (
     generate_rma,
     verify_purchase,
 )
+from .callbacks import after_agent_callback
 from .tools import (
     initiate_return_tool,
     order_details_tool,
@@ -60,6 +61,8 @@ class SupportResult(BaseModel):
         description="Type of support inquiry (order_status, shipping, policy, return)")
     resolution_status: str = Field(
         description="Status of resolution (resolved, in_progress, escalated)")
+    escalation_reason: Optional[str] = Field(
+        description="Why a human agent is needed, when escalated", default=None)
     message: str = Field(description="Main response message")
     order_info: Optional[OrderInfo] = Field(
         description="Order details if applicable", default=None)
@@ -170,5 +173,6 @@ root_agent = LlmAgent(
         AgentTool(returns_workflow_agent),
     ],
     output_schema=CustomerServiceOutput,
-    output_key="customer_service_response"
+    output_key="customer_service_response",
+    after_agent_callback=after_agent_callback,
 )
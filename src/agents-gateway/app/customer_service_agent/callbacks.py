@@ -0,0 +1,44 @@
+from __future__ import annotations
+import json
+import logging
+from typing import Any, Optional
+
+
+logger = logging.getLogger("agents.customer_service.callbacks")
+
+
+def _support_result(response: Any) -> dict:
+    """Return the support_result of a stored customer_service_response."""
+    if isinstance(response, str):
+        try:
+            response = json.loads(response)
+        except ValueError:
+            return {}
+    if hasattr(response, "model_dump"):
+        response = response.model_dump()
+    if not isinstance(response, dict):
+        return {}
+    result = response.get("support_result")
+    return result if isinstance(result, dict) else {}
+
+
+def after_agent_callback(callback_context: Any = None, **kwargs) -> Optional[Any]:
+    """Publish the escalation decision as the structured "escalation" output.
+
+    The frontend reads {"required": bool, "reason": str} from the state delta
+    instead of guessing from the answer text. Escalation is required when the
+    agent resolved the inquiry as "escalated".
+    """
+    if callback_context is None:
+        return None
+    result = _support_result(callback_context.state.get("customer_service_response"))
+    if not result:
+        return None
+    required = str(result.get("resolution_status", "")).strip().lower() == "escalated"
+    escalation = {"required": required}
+    reason = result.get("escalation_reason")
+    if required and reason:
+        escalation["reason"] = str(reason)
+    callback_context.state["escalation"] = escalation
+    logger.info(f"customer service escalation: required={required}")
+    return None
@@ -16,6 +16,7 @@ Response Structure:
     "support_result": {
       "inquiry_type": "order_status|shipping|policy|return",
       "resolution_status": "resolved|in_progress|escalated",
+      "escalation_reason": "why a human agent is needed, only when escalated",
       "message": "Main response message to customer",
       "order_info": { /* if order-related */ },
       "shipping_info": { /* if shipping-related */ },
@@ -28,5 +29,7 @@ Response Structure:
   }
 }
 
+Set "resolution_status" to "escalated" only when the inquiry needs a human agent, and say why in "escalation_reason".
+
 Always provide structured JSON output with clear categorization, never conversational text only.
 """
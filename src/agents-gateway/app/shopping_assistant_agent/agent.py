@@ -69,7 +69,7 @@ class ShoppingAssistantOutput(BaseModel):
 root_agent = LlmAgent(
     name="shopping_assistant_agent",
     description="The main coordinator agent. Delegates to search_agent for product discovery and cart_agent for cart operations.",
-    instruction=recommendation.INSTRUCTION,
+    instruction=recommendation.instruction,
     model=GEMINI_MODEL,
     sub_agents=[cart_agent, search_agent],
     before_model_callback=before_model_callback,
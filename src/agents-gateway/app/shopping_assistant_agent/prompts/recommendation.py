@@ -5,3 +5,22 @@ You have specialized sub-agents:
 Analyze the user's query. If the user asks to find/browse/recommend/filter products, delegate to search_agent. If the user asks to add items to cart, show cart, place orders, checkout, or references items by number (1 to 5), delegate to cart_agent.
 For anything else, respond appropriately or state you cannot handle it.
 """
+
+# Arms of the frontend's "assistant_prompt" experiment, which it sends as the
+# "assistant_prompt_variant" session state. Arm 0 is the control.
+VARIANTS = [
+    "",
+    "Keep replies short: one or two sentences before any product list.",
+    "After answering, suggest one concrete next step, such as refining the search or adding an item to the cart.",
+]
+
+
+def instruction(context) -> str:
+    """Return INSTRUCTION with the prompt variant of the session's arm."""
+    try:
+        arm = int(context.state.get("assistant_prompt_variant", 0))
+    except (TypeError, ValueError):
+        arm = 0
+    if arm <= 0 or arm >= len(VARIANTS):
+        return INSTRUCTION
+    return INSTRUCTION + VARIANTS[arm] + "\n"
@@ -7,6 +7,7 @@ from .state import (
 )
 from .callbacks import _extract_user_id
 import base64
+import datetime
 from typing import Any, Dict, List
 import logging
 import requests
@@ -47,6 +48,22 @@ def image_search_tool(image_base64: str, mime_type: str, top_k: int, filters: Di
     return image_vector_search(image_bytes, filters or {}, k)
 
 
+def _frontend_user_id(tool_context: ToolContext, user_id: str) -> str:
+    """
+    Return the id to send as userId to the frontend /api endpoints.
+
+    The frontend only honors signed session ids from other callers; it seeds
+    the signed form of user_id into session state as "user_token".
+    """
+    try:
+        token = tool_context.state.get("user_token")
+        if isinstance(token, str) and token.startswith(user_id + "."):
+            return token
+    except Exception:
+        pass
+    return user_id
+
+
 def add_to_cart(number: int, tool_context: ToolContext) -> Dict[str, Any]:
     """
     Add one product to the user's cart by its ordinal number from the last search.
@@ -117,7 +134,8 @@ def add_to_cart(number: int, tool_context: ToolContext) -> Dict[str, Any]:
         }
     quantity = 1
 
-    payload = {"userId": user_id,
+    api_user_id = _frontend_user_id(tool_context, user_id)
+    payload = {"userId": api_user_id,
                "productId": product_id, "quantity": quantity}
     logger.info(f"Adding to cart: {payload}")
     url = f"{FRONTEND_BASE}/api/cart/add"
@@ -127,7 +145,7 @@ def add_to_cart(number: int, tool_context: ToolContext) -> Dict[str, Any]:
                      url, resp.status_code, resp.text)
         resp.raise_for_status()
         # Always fetch the fresh cart after adding, to normalize response
-        cart_url = f"{FRONTEND_BASE}/api/cart?userId={user_id}"
+        cart_url = f"{FRONTEND_BASE}/api/cart?userId={api_user_id}"
         cart_resp = requests.get(cart_url, timeout=HTTP_TIMEOUT)
         logger.debug("add_to_cart GET %s status=%s body=%s",
                      cart_url, cart_resp.status_code, cart_resp.text)
@@ -163,7 +181,7 @@ def get_cart(tool_context: ToolContext) -> Dict[str, Any]:
         logger.error("get_cart: stable user_id not found in context")
         return {"error": "user_id_missing", "message": "Session not recognized. Please retry or refresh the page."}
 
-    url = f"{FRONTEND_BASE}/api/cart?userId={user_id}"
+    url = f"{FRONTEND_BASE}/api/cart?userId={_frontend_user_id(tool_context, user_id)}"
     logger.info(f"Getting cart for user: {user_id}")
     try:
         resp = requests.get(url, timeout=HTTP_TIMEOUT)
@@ -219,7 +237,8 @@ def place_order(tool_context: ToolContext) -> Dict[str, Any]:
         if not user_id:
             return {"error": "user_id_missing", "message": "Session not recognized. Please retry or refresh the page."}
 
-        cart_url = f"{FRONTEND_BASE}/api/cart?userId={user_id}"
+        api_user_id = _frontend_user_id(tool_context, user_id)
+        cart_url = f"{FRONTEND_BASE}/api/cart?userId={api_user_id}"
         cart_resp = requests.get(cart_url, timeout=HTTP_TIMEOUT)
         logger.debug("place_order precheck GET %s status=%s body=%s",
                      cart_url, cart_resp.status_code, cart_resp.text)
@@ -235,18 +254,33 @@ def place_order(tool_context: ToolContext) -> Dict[str, Any]:
 
         # Demo hardcoded details from cart.html
         DEMO_EMAIL = "someone@example.com"
-        DEMO_ADDRESS = "1600 Amphitheatre Parkway, Mountain View, CA 94043, United States"
-        DEMO_LAST4 = "0454"
+        DEMO_ADDRESS = {
+            "streetAddress": "1600 Amphitheatre Parkway",
+            "city": "Mountain View",
+            "state": "CA",
+            "zipCode": 94043,
+            "country": "United States",
+        }
+        DEMO_CARD_NUMBER = "4432801561520454"
+        DEMO_LAST4 = DEMO_CARD_NUMBER[-4:]
+        DEMO_EXPIRATION_MONTH = 1
+        DEMO_EXPIRATION_YEAR = datetime.date.today().year + 1
+        DEMO_CVV = 672
 
         url = f"{FRONTEND_BASE}/api/checkout"
         payload = {
-            "userId": user_id,
+            "userId": api_user_id,
             "userDetails": {
-                "name": DEMO_EMAIL,  # using email as name surrogate for demo
+                "name": "Demo Shopper",
+                "email": DEMO_EMAIL,
                 "address": DEMO_ADDRESS,
             },
             "paymentInfo": {
                 "last4": DEMO_LAST4,
+                "cardNumber": DEMO_CARD_NUMBER,
+                "expirationMonth": DEMO_EXPIRATION_MONTH,
+                "expirationYear": DEMO_EXPIRATION_YEAR,
+                "cvv": DEMO_CVV,
             },
         }
         logger.info("Placing order for user %s", user_id)
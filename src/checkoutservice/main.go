@@ -141,8 +141,10 @@ func main() {
 	pb.RegisterCheckoutServiceServer(srv, svc)
 	healthpb.RegisterHealthServer(srv, svc)
 	log.Infof("starting to listen on tcp: %q", lis.Addr().String())
-	err = srv.Serve(lis)
-	log.Fatal(err)
+	if err := serveGracefully(srv, lis); err != nil {
+		log.Fatal(err)
+	}
+	log.Info("shutdown complete")
 }
 
 func initStats() {
@@ -242,15 +244,23 @@ func (cs *checkoutService) PlaceOrder(ctx context.Context, req *pb.PlaceOrderReq
 	total := pb.Money{CurrencyCode: req.UserCurrency,
 		Units: 0,
 		Nanos: 0}
-	total = money.Must(money.Sum(total, *prep.shippingCostLocalized))
+	total, err = money.Sum(total, *prep.shippingCostLocalized)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to compute order total: %+v", err)
+	}
 	for _, it := range prep.orderItems {
-		multPrice := money.MultiplySlow(*it.Cost, uint32(it.GetItem().GetQuantity()))
-		total = money.Must(money.Sum(total, multPrice))
+		multPrice, err := money.MultiplySlow(*it.Cost, uint32(it.GetItem().GetQuantity()))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to compute price of %q: %+v", it.GetItem().GetProductId(), err)
+		}
+		if total, err = money.Sum(total, multPrice); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to compute order total: %+v", err)
+		}
 	}
 
 	txID, err := cs.chargeCard(ctx, &total, req.CreditCard)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to charge card: %+v", err)
+		return nil, status.Errorf(codes.Internal, "failed to charge card: %s", redactCardNumbers(fmt.Sprintf("%+v", err)))
 	}
 	log.Infof("payment went through (transaction_id: %s)", txID)
 
@@ -270,9 +280,9 @@ func (cs *checkoutService) PlaceOrder(ctx context.Context, req *pb.PlaceOrderReq
 	}
 
 	if err := cs.sendOrderConfirmation(ctx, req.Email, orderResult); err != nil {
-		log.Warnf("failed to send order confirmation to %q: %+v", req.Email, err)
+		log.Warnf("failed to send order confirmation to %q: %+v", maskEmail(req.Email), err)
 	} else {
-		log.Infof("order confirmation email sent to %q", req.Email)
+		log.Infof("order confirmation email sent to %q", maskEmail(req.Email))
 	}
 	resp := &pb.PlaceOrderResponse{Order: orderResult}
 	return resp, nil
@@ -344,7 +354,7 @@ func (cs *checkoutService) prepOrderItems(ctx context.Context, items []*pb.CartI
 		if err != nil {
 			return nil, fmt.Errorf("failed to get product #%q", item.GetProductId())
 		}
-		price, err := cs.convertCurrency(ctx, product.GetPriceUsd(), userCurrency)
+		price, err := cs.convertCurrency(ctx, unitPrice(product), userCurrency)
 		if err != nil {
 			return nil, fmt.Errorf("failed to convert price of %q to %s", item.GetProductId(), userCurrency)
 		}
@@ -0,0 +1,36 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+// unitPrice returns the USD price a product is charged at: its sale price
+// while it is on sale (below the regular price, in the same currency), its
+// regular price otherwise. The frontend advertises and totals carts with the
+// same rule.
+func unitPrice(p *pb.Product) *pb.Money {
+	sale, price := p.GetSalePriceUsd(), p.GetPriceUsd()
+	if sale != nil && price != nil && sale.GetCurrencyCode() == price.GetCurrencyCode() &&
+		nanosOf(sale) < nanosOf(price) {
+		return sale
+	}
+	return price
+}
+
+func nanosOf(m *pb.Money) int64 {
+	return m.GetUnits()*1e9 + int64(m.GetNanos())
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+func TestUnitPrice(t *testing.T) {
+	price := &pb.Money{CurrencyCode: "USD", Units: 10, Nanos: 470000000}
+	tests := []struct {
+		name string
+		sale *pb.Money
+		want *pb.Money
+	}{
+		{"no sale", nil, price},
+		{"on sale", &pb.Money{CurrencyCode: "USD", Units: 8, Nanos: 490000000}, nil},
+		{"sale not cheaper", &pb.Money{CurrencyCode: "USD", Units: 10, Nanos: 470000000}, price},
+		{"sale in other currency", &pb.Money{CurrencyCode: "EUR", Units: 5}, price},
+	}
+	for _, tt := range tests {
+		want := tt.want
+		if want == nil {
+			want = tt.sale
+		}
+		if got := unitPrice(&pb.Product{PriceUsd: price, SalePriceUsd: tt.sale}); got != want {
+			t.Errorf("%s: unitPrice() = %v, want %v", tt.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// cardNumberPattern matches runs of 13 to 19 digits, optionally grouped with
+// spaces or dashes: anything that may be a payment card number.
+var cardNumberPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+// redactCardNumbers masks all but the last four digits of the card numbers
+// in s, such as an error message from the payment service.
+func redactCardNumbers(s string) string {
+	return cardNumberPattern.ReplaceAllStringFunc(s, func(pan string) string {
+		digits := strings.NewReplacer(" ", "", "-", "").Replace(pan)
+		return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+	})
+}
+
+// maskEmail keeps the first letter and the domain of an email address.
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return "***"
+	}
+	return email[:1] + "***" + email[at:]
+}
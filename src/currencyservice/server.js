@@ -132,6 +132,30 @@ function getSupportedCurrencies (call, callback) {
   });
 }
 
+/**
+ * Converts an amount into toCode using the given EUR exchange rates
+ */
+function _convert (data, from, toCode) {
+  // Convert: from_currency --> EUR
+  const euros = _carry({
+    units: from.units / data[from.currency_code],
+    nanos: from.nanos / data[from.currency_code]
+  });
+
+  euros.nanos = Math.round(euros.nanos);
+
+  // Convert: EUR --> to_currency
+  const result = _carry({
+    units: euros.units * data[toCode],
+    nanos: euros.nanos * data[toCode]
+  });
+
+  result.units = Math.floor(result.units);
+  result.nanos = Math.floor(result.nanos);
+  result.currency_code = toCode;
+  return result;
+}
+
 /**
  * Converts between currencies
  */
@@ -139,25 +163,7 @@ function convert (call, callback) {
   try {
     _getCurrencyData((data) => {
       const request = call.request;
-
-      // Convert: from_currency --> EUR
-      const from = request.from;
-      const euros = _carry({
-        units: from.units / data[from.currency_code],
-        nanos: from.nanos / data[from.currency_code]
-      });
-
-      euros.nanos = Math.round(euros.nanos);
-
-      // Convert: EUR --> to_currency
-      const result = _carry({
-        units: euros.units * data[request.to_code],
-        nanos: euros.nanos * data[request.to_code]
-      });
-
-      result.units = Math.floor(result.units);
-      result.nanos = Math.floor(result.nanos);
-      result.currency_code = request.to_code;
+      const result = _convert(data, request.from, request.to_code);
 
       logger.info(`conversion request successful`);
       callback(null, result);
@@ -168,6 +174,24 @@ function convert (call, callback) {
   }
 }
 
+/**
+ * Converts several amounts into the same currency, keeping their order
+ */
+function convertBatch (call, callback) {
+  try {
+    _getCurrencyData((data) => {
+      const request = call.request;
+      const results = request.from.map((from) => _convert(data, from, request.to_code));
+
+      logger.info(`batch conversion request successful (${results.length} amounts)`);
+      callback(null, {results});
+    });
+  } catch (err) {
+    logger.error(`batch conversion request failed: ${err}`);
+    callback(err.message);
+  }
+}
+
 /**
  * Endpoint for health checks
  */
@@ -182,7 +206,7 @@ function check (call, callback) {
 function main () {
   logger.info(`Starting gRPC server on port ${PORT}...`);
   const server = new grpc.Server();
-  server.addService(shopProto.CurrencyService.service, {getSupportedCurrencies, convert});
+  server.addService(shopProto.CurrencyService.service, {getSupportedCurrencies, convert, convertBatch});
   server.addService(healthProto.Health.service, {check});
 
   server.bindAsync(
@@ -0,0 +1,242 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// adkEvent mirrors an event in an agents-gateway /run response. Agents with an
+// output schema may also return their structured output as a top-level field,
+// and the legacy format carries candidates instead of content; see output.
+type adkEvent struct {
+	ID         string         `json:"id,omitempty"`
+	Author     string         `json:"author,omitempty"`
+	Content    *adkContent    `json:"content,omitempty"`
+	Actions    *adkActions    `json:"actions,omitempty"`
+	Candidates []adkCandidate `json:"candidates,omitempty"`
+
+	// raw is the event as received and fields its top-level fields, for
+	// structured outputs and for scanning responses of unexpected shape.
+	raw    json.RawMessage
+	fields map[string]json.RawMessage
+}
+
+type adkContent struct {
+	Role  string    `json:"role,omitempty"`
+	Parts []adkPart `json:"parts,omitempty"`
+}
+
+type adkPart struct {
+	Text             string               `json:"text,omitempty"`
+	FunctionCall     *adkFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *adkFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type adkFunctionCall struct {
+	ID   string                 `json:"id,omitempty"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// adkFunctionResponse is a tool result. Its payload is whatever the tool
+// returned, so it stays untyped.
+type adkFunctionResponse struct {
+	ID       string      `json:"id,omitempty"`
+	Name     string      `json:"name"`
+	Response interface{} `json:"response"`
+}
+
+type adkActions struct {
+	StateDelta map[string]json.RawMessage `json:"stateDelta,omitempty"`
+}
+
+type adkCandidate struct {
+	Content *adkContent `json:"content,omitempty"`
+}
+
+func (e *adkEvent) UnmarshalJSON(data []byte) error {
+	type event adkEvent // drops the method to avoid recursing
+	if err := json.Unmarshal(data, (*event)(e)); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &e.fields); err != nil {
+		return err
+	}
+	e.raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// output decodes the structured output stored under key, either at the top
+// level of the event or in actions.stateDelta, into v. It reports false if
+// there is no such output or it does not have the shape of v.
+func (e *adkEvent) output(key string, v interface{}) bool {
+	data, ok := e.fields[key]
+	if !ok && e.Actions != nil {
+		data, ok = e.Actions.StateDelta[key]
+	}
+	if !ok || !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.WithError(err).Warnf("unexpected shape of agent output %q", key)
+		return false
+	}
+	return true
+}
+
+// keys returns the top-level field names of the event, for logging.
+func (e *adkEvent) keys() []string {
+	keys := make([]string, 0, len(e.fields))
+	for k := range e.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// text joins the text parts of c.
+func (c *adkContent) text() string {
+	if c == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, p := range c.Parts {
+		if p.Text != "" {
+			b.WriteString(p.Text)
+			b.WriteString(" ")
+		}
+	}
+	return b.String()
+}
+
+// shoppingRecommendations is the output of shopping_assistant_agent.
+type shoppingRecommendations struct {
+	Action                string                   `json:"action"`
+	Summary               string                   `json:"summary"`
+	RecommendationSummary string                   `json:"recommendation_summary"`
+	Recommendations       []map[string]interface{} `json:"recommendations"`
+	Cart                  *struct {
+		Items []map[string]interface{} `json:"items"`
+	} `json:"cart"`
+}
+
+// searchResults is the output of product_discovery_agent.
+type searchResults struct {
+	Summary  string                   `json:"summary"`
+	Products []map[string]interface{} `json:"products"`
+}
+
+// orderSummary describes a placed order. Agents name the text summary or
+// message and the ordered products products or items.
+type orderSummary struct {
+	Summary  string                   `json:"summary"`
+	Message  string                   `json:"message"`
+	Products []map[string]interface{} `json:"products"`
+	Items    []map[string]interface{} `json:"items"`
+}
+
+// productComparison compares products, optionally with a verdict.
+type productComparison struct {
+	Summary        string                   `json:"summary"`
+	Message        string                   `json:"message"`
+	Verdict        string                   `json:"verdict"`
+	Recommendation string                   `json:"recommendation"`
+	Products       []map[string]interface{} `json:"products"`
+	Items          []map[string]interface{} `json:"items"`
+}
+
+func (s shoppingRecommendations) parse() (string, []map[string]interface{}) {
+	var message string
+	if s.Action != "" {
+		message = s.Summary
+	}
+	if s.RecommendationSummary != "" {
+		message = s.RecommendationSummary
+	}
+
+	products := normalizeProducts(s.Recommendations)
+	if strings.HasPrefix(s.Action, "cart_") && s.Cart != nil {
+		// Build light-weight products from the cart items.
+		for _, item := range s.Cart.Items {
+			products = append(products, map[string]interface{}{
+				"id":          item["product_id"],
+				"name":        item["name"],
+				"description": "",
+				"picture":     "",
+			})
+		}
+	}
+	return message, products
+}
+
+func (s searchResults) parse() (string, []map[string]interface{}) {
+	return s.Summary, normalizeProducts(s.Products)
+}
+
+func (s orderSummary) parse() (string, []map[string]interface{}) {
+	return firstNonEmpty(s.Summary, s.Message), productsFromItems(s.Products, s.Items)
+}
+
+func (c productComparison) parse() (string, []map[string]interface{}) {
+	message := firstNonEmpty(c.Summary, c.Message)
+	if verdict := firstNonEmpty(c.Verdict, c.Recommendation); verdict != "" {
+		message = strings.TrimSpace(message + " " + verdict)
+	}
+	return message, productsFromItems(c.Products, c.Items)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func normalizeProducts(list []map[string]interface{}) []map[string]interface{} {
+	var products []map[string]interface{}
+	for _, p := range list {
+		products = append(products, normalizeProductMap(p))
+	}
+	return products
+}
+
+// productsFromItems normalizes the first of lists that is present. Entries
+// identifying the product by product_id, as cart and order items do, are
+// accepted too.
+func productsFromItems(lists ...[]map[string]interface{}) []map[string]interface{} {
+	for _, list := range lists {
+		if list == nil {
+			continue
+		}
+		var products []map[string]interface{}
+		for _, item := range list {
+			product := normalizeProductMap(item)
+			if product["id"] == nil {
+				if product["id"] = item["product_id"]; product["id"] == nil {
+					continue
+				}
+			}
+			products = append(products, product)
+		}
+		return products
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// gatewayRunResponse is a representative agents-gateway /run response: a tool
+// call, its result and the final answer carrying the structured output.
+const gatewayRunResponse = `[
+	{"id": "e1", "author": "product_discovery_agent", "content": {"role": "model", "parts": [
+		{"functionCall": {"id": "call-1", "name": "search_products", "args": {"query": "mug"}}}
+	]}},
+	{"id": "e2", "author": "product_discovery_agent", "content": {"role": "user", "parts": [
+		{"functionResponse": {"id": "call-1", "name": "search_products", "response": {"result": "[]"}}}
+	]}},
+	{"id": "e3", "author": "product_discovery_agent",
+		"content": {"role": "model", "parts": [{"text": "Here are some mugs."}]},
+		"actions": {"stateDelta": {"search_results": {"summary": "Two mugs", "products": [
+			{"id": "6E92ZMYYFZ", "name": "Mug", "picture": "/static/img/products/mug.jpg"}
+		]}}}}
+]`
+
+func TestADKEventUnmarshal(t *testing.T) {
+	var events []adkEvent
+	if err := json.Unmarshal([]byte(gatewayRunResponse), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+
+	call := events[0].Content.Parts[0].FunctionCall
+	if call == nil || call.Name != "search_products" || call.Args["query"] != "mug" {
+		t.Errorf("function call = %+v", call)
+	}
+	resp := events[1].Content.Parts[0].FunctionResponse
+	if resp == nil || resp.Name != "search_products" || !reflect.DeepEqual(resp.Response, map[string]interface{}{"result": "[]"}) {
+		t.Errorf("function response = %+v", resp)
+	}
+
+	final := events[2]
+	if final.Author != "product_discovery_agent" || final.Content.text() != "Here are some mugs. " {
+		t.Errorf("final event = %+v", final)
+	}
+	var search searchResults
+	if !final.output("search_results", &search) {
+		t.Fatal("search_results not found in stateDelta")
+	}
+	if search.Summary != "Two mugs" || len(search.Products) != 1 || search.Products[0]["id"] != "6E92ZMYYFZ" {
+		t.Errorf("search results = %+v", search)
+	}
+	if want := []string{"actions", "author", "content", "id"}; !reflect.DeepEqual(final.keys(), want) {
+		t.Errorf("keys = %v, want %v", final.keys(), want)
+	}
+}
+
+func TestADKEventOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"top level", `{"order_summary": {"summary": "Placed."}}`, true},
+		{"state delta", `{"actions": {"stateDelta": {"order_summary": {"summary": "Placed."}}}}`, true},
+		{"missing", `{"search_results": {"summary": "Placed."}}`, false},
+		{"not an object", `{"order_summary": "Placed."}`, false},
+		{"null", `{"order_summary": null}`, false},
+		{"unexpected shape", `{"order_summary": {"summary": 42}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var event adkEvent
+			if err := json.Unmarshal([]byte(tt.body), &event); err != nil {
+				t.Fatal(err)
+			}
+			var order orderSummary
+			if got := event.output("order_summary", &order); got != tt.want {
+				t.Errorf("output() = %v, want %v", got, tt.want)
+			}
+			if tt.want && order.Summary != "Placed." {
+				t.Errorf("summary = %q, want Placed.", order.Summary)
+			}
+		})
+	}
+}
+
+func TestShoppingRecommendationsParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantMessage string
+		wantIDs     []interface{}
+	}{
+		{
+			name: "recommend",
+			body: `{"action": "recommend", "summary": "Try these", "recommendations": [
+				{"id": "OLJCESPC7Z", "name": "Sunglasses", "picture": "/static/img/products/sunglasses.jpg"}
+			]}`,
+			wantMessage: "Try these",
+			wantIDs:     []interface{}{"OLJCESPC7Z"},
+		},
+		{
+			name:        "cart update",
+			body:        `{"action": "cart_add", "summary": "Added", "cart": {"items": [{"product_id": "66VCHSJNUP", "name": "Tank Top"}]}}`,
+			wantMessage: "Added",
+			wantIDs:     []interface{}{"66VCHSJNUP"},
+		},
+		{
+			name:        "recommendation summary wins",
+			body:        `{"action": "recommend", "summary": "Try these", "recommendation_summary": "Best picks"}`,
+			wantMessage: "Best picks",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recs shoppingRecommendations
+			if err := json.Unmarshal([]byte(tt.body), &recs); err != nil {
+				t.Fatal(err)
+			}
+			message, products := recs.parse()
+			if message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", message, tt.wantMessage)
+			}
+			var ids []interface{}
+			for _, p := range products {
+				ids = append(ids, p["id"])
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("product ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestParseAgentResponseGatewayPayload(t *testing.T) {
+	fe := &frontendServer{}
+	message, products, err := fe.parseAgentResponse(discardLogger(), strings.NewReader(gatewayRunResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message != "Two mugs" {
+		t.Errorf("message = %q, want %q", message, "Two mugs")
+	}
+	if len(products) != 1 || products[0]["id"] != "6E92ZMYYFZ" {
+		t.Errorf("products = %v", products)
+	}
+}
+
+func TestParseAgentResponseUnexpectedStructure(t *testing.T) {
+	// parts is an object instead of a list, so the typed decode fails and the
+	// raw JSON is scanned instead.
+	body := `{"content": {"parts": {"data": [
+		{"id": "6E92ZMYYFZ", "name": "Mug", "picture": "/static/img/products/mug.jpg"}
+	]}}}`
+	fe := &frontendServer{}
+	_, products, err := fe.parseAgentResponse(discardLogger(), strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 1 || products[0]["id"] != "6E92ZMYYFZ" {
+		t.Errorf("products = %v", products)
+	}
+}
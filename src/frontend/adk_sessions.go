@@ -0,0 +1,201 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultAgentsGatewayURL is where the agents-gateway serves the ADK API.
+const defaultAgentsGatewayURL = "http://agents-gateway:80"
+
+// agentsGatewayBaseURL returns the base URL of the agents-gateway ADK API.
+func (fe *frontendServer) agentsGatewayBaseURL() string {
+	if fe.agentsGatewayURL != "" {
+		return fe.agentsGatewayURL
+	}
+	return defaultAgentsGatewayURL
+}
+
+// adkSession is a cached ADK session. It is only used until the user token
+// signed into its state expires, so agents never act with an expired one.
+type adkSession struct {
+	id      string
+	expires time.Time
+}
+
+func adkSessionCacheKey(app, userId string) string {
+	return userId + "::" + app
+}
+
+/*
+ensureADKSession returns the ADK session to use for userId on app, creating
+one on the agents-gateway the first time the pair is seen. Sessions are created
+with
+
+	POST /apps/{app}/users/{userId}/sessions
+	{"state": {"user_id": "<userId>"}}
+
+so the gateway assigns the session id and the agents can read the user from
+session state. The id from the response is cached per (userId, app) so every
+handler talking to the same agent shares one session, until the signed user
+token in its state expires.
+
+If the session cannot be created, browserSessionId is returned together with
+the error; callers that can talk to the agent without a registered session
+may ignore the error and use it as-is.
+*/
+func (fe *frontendServer) ensureADKSession(ctx context.Context, app, userId, browserSessionId string) (string, error) {
+	key := adkSessionCacheKey(app, userId)
+	fe.adkSessionsMu.RLock()
+	cached, ok := fe.adkSessions[key]
+	fe.adkSessionsMu.RUnlock()
+	if ok && cached.valid() {
+		return cached.id, nil
+	}
+
+	expires := time.Now().Add(sessionTokenTTL)
+	id, err := fe.createADKSession(ctx, app, userId)
+	if err != nil {
+		return browserSessionId, err
+	}
+
+	fe.adkSessionsMu.Lock()
+	if fe.adkSessions == nil {
+		fe.adkSessions = make(map[string]adkSession)
+	}
+	// Another request may have created a session concurrently; keep the
+	// first one so both callers end up in the same conversation.
+	if cached, ok := fe.adkSessions[key]; ok && cached.valid() {
+		id = cached.id
+	} else {
+		fe.adkSessions[key] = adkSession{id: id, expires: expires}
+	}
+	fe.adkSessionsMu.Unlock()
+	return id, nil
+}
+
+// pinADKSession makes sessionId the cached ADK session for userId on app, so
+// a conversation the client asked to continue is also the one later requests
+// without an explicit session id end up in.
+func (fe *frontendServer) pinADKSession(app, userId, sessionId string) {
+	fe.adkSessionsMu.Lock()
+	defer fe.adkSessionsMu.Unlock()
+	if fe.adkSessions == nil {
+		fe.adkSessions = make(map[string]adkSession)
+	}
+	fe.adkSessions[adkSessionCacheKey(app, userId)] = adkSession{id: sessionId, expires: time.Now().Add(sessionTokenTTL)}
+}
+
+func (s adkSession) valid() bool {
+	return s.id != "" && time.Now().Before(s.expires)
+}
+
+func (fe *frontendServer) createADKSession(ctx context.Context, app, userId string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, agentSessionTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]any{
+		"state": map[string]any{
+			"user_id": userId,
+			// The signed form of userId, for agent tools calling the
+			// frontend /api endpoints on the user's behalf.
+			"user_token": fe.signSessionID(userId),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	sessionURL := fmt.Sprintf("%s/apps/%s/users/%s/sessions",
+		fe.agentsGatewayBaseURL(), url.PathEscape(app), url.PathEscape(userId))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sessionURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "could not create ADK session")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", errors.Errorf("could not create ADK session: agents-gateway returned %s", resp.Status)
+	}
+
+	var session struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return "", errors.Wrap(err, "could not parse ADK session response")
+	}
+	if session.ID == "" {
+		return "", errors.New("ADK session response has no id")
+	}
+	return session.ID, nil
+}
+
+// runAgent sends req to the agents-gateway /run endpoint and returns the
+// message and products of the reply. Replies larger than
+// maxAssistantResponseBytes are rejected.
+func (fe *frontendServer) runAgent(ctx context.Context, log logrus.FieldLogger, req SearchRequest) (string, []map[string]interface{}, error) {
+	requestJSON, err := json.Marshal(req)
+	if err != nil {
+		return "", nil, err
+	}
+	log.WithField("payload", redactPayload(requestJSON)).Debug("Forwarding request to agents-gateway")
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, fe.agentsGatewayBaseURL()+"/run", bytes.NewReader(requestJSON))
+	if err != nil {
+		return "", nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+	resp, err := upstreamClient.Do(httpReq)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "agents-gateway request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, errors.Errorf("agents-gateway returned %s", resp.Status)
+	}
+
+	// Read one byte past the cap so oversized replies can be told apart
+	// from ones that are exactly at the limit.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAssistantResponseBytes+1))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not read agents-gateway response")
+	}
+	if len(body) > maxAssistantResponseBytes {
+		return "", nil, errors.Errorf("agents-gateway response exceeds %d bytes", maxAssistantResponseBytes)
+	}
+	log.WithFields(logrus.Fields{"app": req.AppName, "bytes": len(body)}).Info("Agent response received")
+
+	message, products, err := fe.parseAgentResponse(log, bytes.NewReader(body))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not parse agents-gateway response")
+	}
+	return message, products, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSessionGateway serves the ADK session endpoint, answering with id or,
+// if id is empty, an internal server error.
+func fakeSessionGateway(t *testing.T, id string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Method != http.MethodPost || r.URL.Path != "/apps/app/users/user-1/sessions" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var body struct {
+			State map[string]string `json:"state"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.State["user_id"] != "user-1" {
+			t.Errorf("unexpected session request body %+v (%v)", body, err)
+		}
+		if id == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestEnsureADKSessionCacheHit(t *testing.T) {
+	srv, calls := fakeSessionGateway(t, "new-session")
+	fe := &frontendServer{
+		agentsGatewayURL: srv.URL,
+		adkSessions: map[string]adkSession{adkSessionCacheKey("app", "user-1"): {
+			id: "cached-session", expires: time.Now().Add(time.Hour)}},
+	}
+
+	id, err := fe.ensureADKSession(context.Background(), "app", "user-1", "browser-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "cached-session" {
+		t.Errorf("id = %q, want cached-session", id)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("gateway called %d times, want 0", n)
+	}
+}
+
+// TestEnsureADKSessionRenewsExpired checks that a session whose signed user
+// token has expired is replaced, so agents are not left with a stale token.
+func TestEnsureADKSessionRenewsExpired(t *testing.T) {
+	srv, calls := fakeSessionGateway(t, "new-session")
+	fe := &frontendServer{
+		agentsGatewayURL: srv.URL,
+		adkSessions: map[string]adkSession{adkSessionCacheKey("app", "user-1"): {
+			id: "cached-session", expires: time.Now().Add(-time.Minute)}},
+	}
+
+	id, err := fe.ensureADKSession(context.Background(), "app", "user-1", "browser-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "new-session" || calls.Load() != 1 {
+		t.Errorf("id = %q after %d gateway calls, want a new session", id, calls.Load())
+	}
+}
+
+func TestEnsureADKSessionCreates(t *testing.T) {
+	srv, calls := fakeSessionGateway(t, "new-session")
+	fe := &frontendServer{agentsGatewayURL: srv.URL}
+
+	for i := 0; i < 2; i++ {
+		id, err := fe.ensureADKSession(context.Background(), "app", "user-1", "browser-session")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != "new-session" {
+			t.Errorf("id = %q, want new-session", id)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("gateway called %d times, want 1", n)
+	}
+}
+
+func TestEnsureADKSessionCreateFailure(t *testing.T) {
+	srv, _ := fakeSessionGateway(t, "")
+	fe := &frontendServer{agentsGatewayURL: srv.URL}
+
+	id, err := fe.ensureADKSession(context.Background(), "app", "user-1", "browser-session")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if id != "browser-session" {
+		t.Errorf("id = %q, want browser-session", id)
+	}
+	if _, ok := fe.adkSessions[adkSessionCacheKey("app", "user-1")]; ok {
+		t.Error("failed session creation was cached")
+	}
+}
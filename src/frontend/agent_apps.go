@@ -0,0 +1,95 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// agentFeature names a storefront feature backed by an agent.
+type agentFeature string
+
+const (
+	agentFeatureSearch       agentFeature = "search"
+	agentFeatureAssistant    agentFeature = "assistant"
+	agentFeatureCart         agentFeature = "cart"
+	agentFeatureCheckout     agentFeature = "checkout"
+	agentFeatureSupport      agentFeature = "support"
+	agentFeatureVisualSearch agentFeature = "visual_search"
+)
+
+// defaultAgentApps are the agent app names used for features that are not
+// configured.
+var defaultAgentApps = map[agentFeature]string{
+	agentFeatureSearch:       "product_discovery_agent",
+	agentFeatureAssistant:    "shopping_assistant_agent",
+	agentFeatureCart:         "shopping_assistant_agent",
+	agentFeatureCheckout:     "checkout_agent",
+	agentFeatureSupport:      "customer_service_agent",
+	agentFeatureVisualSearch: "shopping_assistant_agent",
+}
+
+// loadAgentApps returns the agent app name of each feature. AGENT_APP_NAMES
+// is a comma-separated list of feature=app pairs, e.g.
+// "checkout=checkout_agent_v2,support=support_agent". The older
+// ADK_APP_NAME still sets the assistant and cart apps, which it served
+// together, unless AGENT_APP_NAMES names them. REASONING_ENGINE_APP_NAME is a
+// Reasoning Engine resource path, not a gateway app, so it names no app.
+func loadAgentApps(log logrus.FieldLogger) map[agentFeature]string {
+	apps := make(map[agentFeature]string, len(defaultAgentApps))
+	for f, app := range defaultAgentApps {
+		apps[f] = app
+	}
+	if v := os.Getenv("ADK_APP_NAME"); v != "" {
+		apps[agentFeatureAssistant] = v
+		apps[agentFeatureCart] = v
+	}
+	for f, app := range parseAgentApps(log, os.Getenv("AGENT_APP_NAMES")) {
+		apps[f] = app
+	}
+	return apps
+}
+
+// parseAgentApps parses a list of feature=app pairs, skipping unknown
+// features and malformed entries.
+func parseAgentApps(log logrus.FieldLogger, spec string) map[agentFeature]string {
+	apps := make(map[agentFeature]string)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, app, ok := strings.Cut(pair, "=")
+		f := agentFeature(strings.ToLower(strings.TrimSpace(name)))
+		app = strings.TrimSpace(app)
+		if _, known := defaultAgentApps[f]; !ok || !known || app == "" {
+			log.Warnf("ignoring invalid AGENT_APP_NAMES entry %q", pair)
+			continue
+		}
+		apps[f] = app
+	}
+	return apps
+}
+
+// agentApp returns the agent app name serving feature.
+func (fe *frontendServer) agentApp(f agentFeature) string {
+	if app := fe.agentApps[f]; app != "" {
+		return app
+	}
+	return defaultAgentApps[f]
+}
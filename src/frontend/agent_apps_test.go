@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAgentAppResolution(t *testing.T) {
+	t.Setenv("ADK_APP_NAME", "")
+	t.Setenv("REASONING_ENGINE_APP_NAME", "projects/p/locations/l/reasoningEngines/1")
+	t.Setenv("AGENT_APP_NAMES", "checkout = checkout_agent_v2, Support=support_agent, bogus=x, search=")
+	log := logrus.New()
+	log.Out = io.Discard
+	fe := &frontendServer{agentApps: loadAgentApps(log)}
+
+	want := map[agentFeature]string{
+		agentFeatureSearch:    "product_discovery_agent",
+		agentFeatureAssistant: "shopping_assistant_agent",
+		agentFeatureCart:      "shopping_assistant_agent",
+		agentFeatureCheckout:  "checkout_agent_v2",
+		agentFeatureSupport:   "support_agent",
+	}
+	for f, app := range want {
+		if got := fe.agentApp(f); got != app {
+			t.Errorf("agentApp(%s) = %q, want %q", f, got, app)
+		}
+	}
+
+	// A server without configuration uses the defaults.
+	unconfigured := &frontendServer{}
+	for f, app := range defaultAgentApps {
+		if got := unconfigured.agentApp(f); got != app {
+			t.Errorf("unconfigured agentApp(%s) = %q, want %q", f, got, app)
+		}
+	}
+}
+
+func TestAgentAppNamesOverrideLegacyEnv(t *testing.T) {
+	t.Setenv("ADK_APP_NAME", "legacy_assistant")
+	t.Setenv("REASONING_ENGINE_APP_NAME", "")
+	t.Setenv("AGENT_APP_NAMES", "assistant=new_assistant")
+	log := logrus.New()
+	log.Out = io.Discard
+	apps := loadAgentApps(log)
+	if got := apps[agentFeatureAssistant]; got != "new_assistant" {
+		t.Errorf("assistant app = %q, want new_assistant", got)
+	}
+	if got := apps[agentFeatureCart]; got != "legacy_assistant" {
+		t.Errorf("cart app = %q, want legacy_assistant", got)
+	}
+}
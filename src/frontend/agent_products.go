@@ -0,0 +1,60 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dropStaleProducts removes the products agents returned that are no longer
+// in the catalog, so chat and search results never link to a missing
+// product. All ids are checked against one ListProducts call. Products
+// without an id are kept, as are all products if the catalog cannot be
+// listed. It does nothing unless the validate_agent_products flag is on.
+func (fe *frontendServer) dropStaleProducts(ctx context.Context, log logrus.FieldLogger, products []map[string]interface{}) []map[string]interface{} {
+	if !fe.flags.ValidateAgentProducts || len(products) == 0 {
+		return products
+	}
+	catalog, err := fe.getProducts(ctx)
+	if err != nil {
+		log.WithField("error", err).Warn("could not list the catalog, agent products not validated")
+		return products
+	}
+	known := make(map[string]bool, len(catalog))
+	for _, p := range catalog {
+		known[p.GetId()] = true
+	}
+
+	kept := products[:0:0]
+	var stale []string
+	for _, p := range products {
+		var id string
+		if v := p["id"]; v != nil {
+			id = fmt.Sprint(v)
+		}
+		if id != "" && !known[normalizeProductID(id)] {
+			stale = append(stale, id)
+			continue
+		}
+		kept = append(kept, p)
+	}
+	if len(stale) > 0 {
+		log.WithField("product_ids", stale).Warn("dropped agent products missing from the catalog")
+	}
+	return kept
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestAgentSearchDropsStaleProducts(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/run" {
+			io.WriteString(w, `[{"content": {"parts": [{"functionResponse": {"response": [{"id": "MUG", "name": "Mug"}, {"id": "GONE", "name": "Old mug"}]}}]}}]`)
+			return
+		}
+		io.WriteString(w, `{"id": "adk-session"}`)
+	}))
+	defer gateway.Close()
+	catalog := &fakeProductCatalog{products: []*pb.Product{{Id: "MUG", Name: "Mug"}}}
+	conn := serveGRPC(t, func(s *grpc.Server) { pb.RegisterProductCatalogServiceServer(s, catalog) })
+
+	tests := []struct {
+		name     string
+		validate bool
+		wantIDs  []string
+	}{
+		{"validated", true, []string{"MUG"}},
+		{"not validated", false, []string{"MUG", "GONE"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fe := &frontendServer{
+				agentsGatewayURL:      gateway.URL,
+				productCatalogSvcConn: conn,
+				flags:                 featureFlags{ValidateAgentProducts: tt.validate},
+			}
+			rec := httptest.NewRecorder()
+			fe.agentSearchHandler(rec, newTestRequest(http.MethodPost, "/api/agent-search",
+				`{"userId": "u1", "newMessage": {"role": "user", "parts": [{"text": "mugs"}]}}`))
+
+			var resp struct {
+				Products []struct {
+					ID string `json:"id"`
+				} `json:"products"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, p := range resp.Products {
+				ids = append(ids, p.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("product ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestDropStaleProductsCatalogDown(t *testing.T) {
+	fe := &frontendServer{
+		productCatalogSvcConn: serveGRPC(t, func(*grpc.Server) {}),
+		flags:                 featureFlags{ValidateAgentProducts: true},
+	}
+	products := []map[string]interface{}{{"id": "MUG"}, {"id": "GONE"}}
+	r := newTestRequest(http.MethodGet, "/", "")
+	if got := fe.dropStaleProducts(r.Context(), r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger), products); len(got) != 2 {
+		t.Errorf("kept %d products, want all 2 when the catalog is down", len(got))
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Deadlines for calls to the agent services. Each can be overridden with a
+// Go duration string (e.g. "45s") in the environment variable noted.
+var (
+	// AGENT_CHAT_TIMEOUT: chat, search and customer service requests.
+	agentChatTimeout = 30 * time.Second
+	// AGENT_CART_TIMEOUT: smart cart recommendations and checkout assistance.
+	agentCartTimeout = 15 * time.Second
+	// AGENT_CART_ANALYSIS_TIMEOUT: background analysis after add-to-cart.
+	agentCartAnalysisTimeout = 10 * time.Second
+	// AGENT_SESSION_TIMEOUT: ADK session creation.
+	agentSessionTimeout = 10 * time.Second
+)
+
+// upstreamClient is shared by the handlers calling the agent services.
+// Deadlines come from the request context rather than the client.
+var upstreamClient = &http.Client{}
+
+// loadAgentTimeouts applies the timeout overrides from the environment,
+// keeping the default for unset or invalid values.
+func loadAgentTimeouts(log logrus.FieldLogger) {
+	loadDurations(log, map[string]*time.Duration{
+		"AGENT_CHAT_TIMEOUT":          &agentChatTimeout,
+		"AGENT_CART_TIMEOUT":          &agentCartTimeout,
+		"AGENT_CART_ANALYSIS_TIMEOUT": &agentCartAnalysisTimeout,
+		"AGENT_SESSION_TIMEOUT":       &agentSessionTimeout,
+	})
+}
+
+// loadDurations sets each duration to the Go duration string in the
+// environment variable it is keyed by, if that is set and positive.
+func loadDurations(log logrus.FieldLogger, durations map[string]*time.Duration) {
+	for env, d := range durations {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Warnf("invalid %s %q, using default %s", env, v, *d)
+			continue
+		}
+		*d = parsed
+	}
+}
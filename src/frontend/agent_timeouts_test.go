@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLoadAgentTimeouts(t *testing.T) {
+	defer func(chat, cart time.Duration) { agentChatTimeout, agentCartTimeout = chat, cart }(agentChatTimeout, agentCartTimeout)
+	t.Setenv("AGENT_CHAT_TIMEOUT", "45s")
+	t.Setenv("AGENT_CART_TIMEOUT", "soon")
+
+	logger := logrus.New()
+	logger.Out = io.Discard
+	loadAgentTimeouts(logger)
+
+	if agentChatTimeout != 45*time.Second {
+		t.Errorf("agentChatTimeout = %s, want 45s", agentChatTimeout)
+	}
+	if agentCartTimeout != 15*time.Second {
+		t.Errorf("agentCartTimeout = %s, want the 15s default", agentCartTimeout)
+	}
+}
+
+func TestHandleChatWithAgentsTimeout(t *testing.T) {
+	defer func(d time.Duration) { agentChatTimeout = d }(agentChatTimeout)
+	agentChatTimeout = 50 * time.Millisecond
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/run" {
+			io.WriteString(w, `{"id": "adk-session"}`)
+			return
+		}
+		io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			io.WriteString(w, `{"search_results": {"summary": "too late"}}`)
+		}
+	}))
+	defer gateway.Close()
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"content": "fallback"}`)
+	}))
+	defer legacy.Close()
+	fe := &frontendServer{
+		agentsGatewayURL:         gateway.URL,
+		shoppingAssistantSvcAddr: strings.TrimPrefix(legacy.URL, "http://"),
+	}
+
+	start := time.Now()
+	req := newTestRequest(http.MethodPost, "/bot", `{"message": "mugs"}`)
+	rec := httptest.NewRecorder()
+	fe.handleChatWithAgents(rec, req, req.Context().Value(ctxKeyLog{}).(logrus.FieldLogger))
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("handler took %s, want it to give up after the configured timeout", elapsed)
+	}
+	if !strings.Contains(rec.Body.String(), "fallback") {
+		t.Errorf("body = %s, want the legacy fallback", rec.Body.String())
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Machine-readable error codes returned by the JSON API handlers.
+const (
+	errCodeBadRequest          = "bad_request"
+	errCodeCartFetchFailed     = "cart_fetch_failed"
+	errCodeValidationFailed    = "validation_failed"
+	errCodeCheckoutFailed      = "checkout_failed"
+	errCodeAddFailed           = "add_failed"
+	errCodeNotImplemented      = "not_implemented"
+	errCodeSearchUnavailable   = "search_unavailable"
+	errCodeSearchUnprocessable = "search_unprocessable"
+	errCodeCatalogUnavailable  = "catalog_unavailable"
+	errCodeCartLimitExceeded   = "cart_limit_exceeded"
+	errCodeUnauthorized        = "unauthorized"
+	errCodeCurrencyUnavailable = "currency_unavailable"
+	errCodeMethodNotAllowed    = "method_not_allowed"
+)
+
+// apiError is the error body returned by the JSON API handlers, wrapped as
+// {"error": {...}} so that clients can rely on a single envelope.
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// writeAPIError writes an apiError envelope with the given HTTP status.
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	writeAPIErrorDetails(w, r, status, code, msg, nil)
+}
+
+// writeAPIErrorDetails is like writeAPIError but attaches additional details.
+func writeAPIErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, msg string, details any) {
+	requestID, _ := r.Context().Value(ctxKeyRequestID{}).(string)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiErrorResponse{Error: apiError{
+		Code:      code,
+		Message:   msg,
+		Details:   details,
+		RequestID: requestID,
+	}})
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// decodeAPIError asserts that rec holds an apiError envelope and returns it.
+func decodeAPIError(t *testing.T, rec *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON body %q: %v", rec.Body.String(), err)
+	}
+	if len(body) != 1 || body["error"] == nil {
+		t.Fatalf("want a single \"error\" key, got %q", rec.Body.String())
+	}
+	var e apiError
+	if err := json.Unmarshal(body["error"], &e); err != nil {
+		t.Fatalf("invalid error envelope %q: %v", body["error"], err)
+	}
+	return e
+}
+
+func newAPIRequest(method, target, body string) *http.Request {
+	r := newTestRequest(method, target, body)
+	ctx := context.WithValue(r.Context(), ctxKeyRequestID{}, "req-123")
+	return r.WithContext(ctx)
+}
+
+func TestAPIAddToCartBadRequestEnvelope(t *testing.T) {
+	fe := &frontendServer{}
+	rec := httptest.NewRecorder()
+	fe.apiAddToCart(rec, newAPIRequest(http.MethodPost, "/api/cart/add", "{not json"))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	e := decodeAPIError(t, rec)
+	if e.Code != errCodeBadRequest || e.Message == "" || e.RequestID != "req-123" {
+		t.Errorf("unexpected envelope %+v", e)
+	}
+}
+
+func TestAPIRemoveFromCartNotImplementedEnvelope(t *testing.T) {
+	fe := &frontendServer{}
+	rec := httptest.NewRecorder()
+	fe.apiRemoveFromCart(rec, newAPIRequest(http.MethodPost, "/api/cart/remove", `{"productId":"OLJCESPC7Z"}`))
+
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+	e := decodeAPIError(t, rec)
+	if e.Code != errCodeNotImplemented || e.RequestID != "req-123" {
+		t.Errorf("unexpected envelope %+v", e)
+	}
+}
+
+func TestWriteAPIErrorDetails(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeAPIErrorDetails(rec, newAPIRequest(http.MethodGet, "/api/cart", ""), http.StatusUnprocessableEntity,
+		"validation_failed", "invalid input", map[string]string{"field": "quantity"})
+
+	e := decodeAPIError(t, rec)
+	details, ok := e.Details.(map[string]interface{})
+	if !ok || details["field"] != "quantity" {
+		t.Errorf("details = %#v, want field=quantity", e.Details)
+	}
+}
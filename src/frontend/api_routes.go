@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// registerAPIRoutes registers the JSON endpoints used by the agents and the
+// storefront scripts. Each route accepts only its methods; others get a 405
+// from methodNotAllowed.
+func (fe *frontendServer) registerAPIRoutes(r *mux.Router) {
+	r.HandleFunc(baseUrl+"/api/cart", fe.apiGetCart).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/cart/count", fe.apiCartCount).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/currencies", fe.apiCurrencies).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/product/{id}/availability", fe.apiProductAvailability).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/compare", fe.apiCompareProducts).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/buy-again", fe.apiBuyAgain).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/recently-viewed", fe.apiRecentlyViewed).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/cart/add", fe.apiAddToCart).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl+"/api/cart/add-bulk", fe.apiAddToCartBulk).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl+"/api/cart/remove", fe.apiRemoveFromCart).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl+"/api/checkout", fe.apiCheckout).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl+"/api/agent-search", fe.agentSearchHandler).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc(baseUrl+"/api/search", fe.fallbackSearchHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/search/suggest", fe.searchSuggestHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/search/image", fe.imageSearchHandler).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl+"/api/feature-flags", fe.featureFlagsHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/deployment", fe.apiDeployment).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/cart/recommendations", fe.smartCartRecommendationsHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/checkout/assistance", fe.checkoutAssistanceHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/api/customer-service", fe.customerServiceHandler).Methods(http.MethodPost, http.MethodOptions)
+}
+
+// allowedMethods are the methods methodNotAllowed offers in its Allow
+// header.
+var allowedMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// methodNotAllowed answers requests for a route of router that does not
+// accept their method with 405 and an Allow header listing the methods it
+// does accept. API routes get the JSON error envelope.
+func methodNotAllowed(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allow []string
+		for _, method := range allowedMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if router.Match(probe, &match) && match.MatchErr == nil && !slices.Contains(allow, method) {
+				allow = append(allow, method)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		if strings.HasPrefix(r.URL.Path, baseUrl+"/api/") {
+			writeAPIError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, r.Method+" is not allowed here")
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func newAPIRouter(fe *frontendServer) *mux.Router {
+	r := mux.NewRouter()
+	fe.registerAPIRoutes(r)
+	r.MethodNotAllowedHandler = methodNotAllowed(r)
+	return r
+}
+
+func TestAPIRoutesRejectWrongMethods(t *testing.T) {
+	router := newAPIRouter(&frontendServer{})
+	tests := []struct {
+		method, target string
+		wantAllow      string
+	}{
+		{http.MethodGet, "/api/cart/add", "POST"},
+		{http.MethodPost, "/api/cart", "GET"},
+		{http.MethodDelete, "/api/checkout", "POST"},
+		{http.MethodGet, "/api/customer-service", "POST, OPTIONS"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, newAPIRequest(tt.method, tt.target, ""))
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.target, rec.Code, http.StatusMethodNotAllowed)
+			continue
+		}
+		if allow := rec.Header().Get("Allow"); allow != tt.wantAllow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.target, allow, tt.wantAllow)
+		}
+		if code := decodeAPIError(t, rec).Code; code != errCodeMethodNotAllowed {
+			t.Errorf("%s %s: code = %q, want %q", tt.method, tt.target, code, errCodeMethodNotAllowed)
+		}
+	}
+}
+
+func TestAPIAddToCartReturnsUpdatedCart(t *testing.T) {
+	cart := &fakeCartService{}
+	catalog := &fakeProductCatalog{products: []*pb.Product{
+		{Id: "MUG", Name: "Mug", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 8}},
+	}}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, cart)
+		pb.RegisterProductCatalogServiceServer(s, catalog)
+	})
+	router := newAPIRouter(&frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, newAPIRequest(http.MethodPost, "/api/cart/add", `{"productId": "MUG", "quantity": 2}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var resp struct {
+		CartID string `json:"cart_id"`
+		Items  []struct {
+			ProductID string `json:"product_id"`
+			Quantity  int32  `json:"quantity"`
+		} `json:"items"`
+		TotalPrice string `json:"total_price"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.CartID != "test-session" || len(resp.Items) != 1 || resp.Items[0].ProductID != "MUG" ||
+		resp.Items[0].Quantity != 2 || resp.TotalPrice != "16.00" {
+		t.Errorf("response = %+v, want the cart holding 2 MUG for 16.00", resp)
+	}
+}
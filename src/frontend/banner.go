@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+The banner color illustrates canary deployments: BANNER_COLOR tints the
+navigation bar of every page. It is read once at startup. If ADMIN_TOKEN is
+set, POST /admin/banner {"color": "..."} with "Authorization: Bearer <token>"
+changes it at runtime; an empty color removes the banner tint.
+*/
+
+// bannerColorPattern accepts hex colors and CSS color names, which is all a
+// canary banner needs and keeps arbitrary CSS out of the style attribute.
+var bannerColorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]{1,30})$`)
+
+// bannerConfig holds the current banner color.
+type bannerConfig struct {
+	mu    sync.RWMutex
+	color string
+}
+
+var banner = &bannerConfig{color: strings.TrimSpace(os.Getenv("BANNER_COLOR"))}
+
+func (b *bannerConfig) get() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.color
+}
+
+func (b *bannerConfig) set(color string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.color = color
+}
+
+// requireAdminToken only lets requests carrying "Authorization: Bearer
+// <token>" through to next.
+func requireAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeAPIError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "missing or invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bannerAdminHandler sets the banner color from a {"color": "..."} body.
+func bannerAdminHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Color string `json:"color"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, "request body must be valid JSON")
+		return
+	}
+	color := strings.TrimSpace(req.Color)
+	if color != "" && !bannerColorPattern.MatchString(color) {
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, "color must be a hex color or a CSS color name")
+		return
+	}
+	banner.set(color)
+	if log, ok := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger); ok {
+		log.WithField("color", color).Info("banner color changed")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"color": color})
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestBannerColorOnEveryPage(t *testing.T) {
+	defer func(color string) { banner.set(color) }(banner.get())
+	banner.set("orange")
+
+	if got := injectCommonTemplateData(newTestRequest(http.MethodGet, "/", ""), nil)["banner_color"]; got != "orange" {
+		t.Errorf("common template data banner_color = %v, want orange", got)
+	}
+
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, &fakeProductCatalog{products: []*pb.Product{
+			{Id: "A", Name: "Mug", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 10}},
+		}})
+		pb.RegisterCurrencyServiceServer(s, fakeCurrencyService{})
+		pb.RegisterCartServiceServer(s, &fakeCartService{})
+		pb.RegisterRecommendationServiceServer(s, fakeRecommendationService{})
+		pb.RegisterShippingServiceServer(s, flatShippingService{})
+	})
+	fe := &frontendServer{
+		productCatalogSvcConn: conn,
+		currencySvcConn:       conn,
+		cartSvcConn:           conn,
+		recommendationSvcConn: conn,
+		adSvcConn:             conn,
+		shippingSvcConn:       conn,
+	}
+
+	pages := map[string]func(w http.ResponseWriter){
+		"product": func(w http.ResponseWriter) {
+			r := mux.SetURLVars(newTestRequest(http.MethodGet, "/product/A", ""), map[string]string{"id": "A"})
+			fe.productHandler(w, r)
+		},
+		"cart": func(w http.ResponseWriter) {
+			fe.viewCartHandler(w, newTestRequest(http.MethodGet, "/cart", ""))
+		},
+		"error": func(w http.ResponseWriter) {
+			r := newTestRequest(http.MethodGet, "/", "")
+			renderHTTPError(r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger), r, w, errors.New("boom"), http.StatusInternalServerError)
+		},
+	}
+	for name, render := range pages {
+		rec := httptest.NewRecorder()
+		render(rec)
+		if !strings.Contains(rec.Body.String(), `style="background-color: orange"`) {
+			t.Errorf("%s page (status %d) does not show the banner color", name, rec.Code)
+		}
+	}
+}
+
+func TestBannerAdminHandler(t *testing.T) {
+	defer func(color string) { banner.set(color) }(banner.get())
+	banner.set("")
+	handler := requireAdminToken("secret", http.HandlerFunc(bannerAdminHandler))
+
+	tests := []struct {
+		name       string
+		auth       string
+		body       string
+		wantStatus int
+		wantColor  string
+	}{
+		{"no token", "", `{"color": "red"}`, http.StatusUnauthorized, ""},
+		{"wrong token", "Bearer nope", `{"color": "red"}`, http.StatusUnauthorized, ""},
+		{"css injection", "Bearer secret", `{"color": "red; display: none"}`, http.StatusBadRequest, ""},
+		{"hex color", "Bearer secret", `{"color": "#00ff00"}`, http.StatusOK, "#00ff00"},
+		{"clear", "Bearer secret", `{"color": ""}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(http.MethodPost, "/admin/banner", tt.body)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := banner.get(); got != tt.wantColor {
+				t.Errorf("banner color = %q, want %q", got, tt.wantColor)
+			}
+		})
+	}
+}
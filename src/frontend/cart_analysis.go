@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultCartAnalysisConcurrency bounds the background cart analyses running
+// at once; override with AGENT_CART_ANALYSIS_CONCURRENCY.
+const defaultCartAnalysisConcurrency = 8
+
+// cartAnalysisDue reports whether the session's cart is worth an agent
+// analysis: it holds at least cartAnalysisMinItems units or, if
+// cartAnalysisMinTotalUSD is set, costs at least that many US dollars. With
+// the defaults every add-to-cart qualifies and the cart is not fetched.
+// Carts that cannot be read or priced are not analyzed.
+func (fe *frontendServer) cartAnalysisDue(ctx context.Context, log logrus.FieldLogger, sessionId string) bool {
+	if fe.cartAnalysisMinItems <= 1 && fe.cartAnalysisMinTotalUSD <= 0 {
+		return true
+	}
+	cart, err := fe.getCart(ctx, sessionId)
+	if err != nil {
+		log.WithField("error", err).Warn("could not retrieve cart, skipping cart analysis")
+		return false
+	}
+	if fe.cartAnalysisMinItems > 1 && cartSize(cart) >= fe.cartAnalysisMinItems {
+		return true
+	}
+	if fe.cartAnalysisMinTotalUSD <= 0 {
+		return false
+	}
+	products, err := fe.getCartProducts(ctx, cart)
+	if err != nil {
+		log.WithField("error", err).Warn("could not price cart, skipping cart analysis")
+		return false
+	}
+	var total int64
+	for i, p := range products {
+		total += moneyNanos(unitPrice(p)) * int64(cart[i].GetQuantity())
+	}
+	return total >= int64(fe.cartAnalysisMinTotalUSD)*1e9
+}
+
+// startCartAnalysis runs analyzeCartWithAgent in the background. When the
+// concurrency limit is reached the analysis is dropped rather than queued, so
+// a burst of add-to-carts cannot pile up agent calls. It reports whether the
+// analysis was started.
+func (fe *frontendServer) startCartAnalysis(ctx context.Context, log logrus.FieldLogger, sessionId, userId string, product interface{}, quantity uint64) bool {
+	select {
+	case fe.cartAnalysisSem <- struct{}{}:
+	default:
+		log.WithField("limit", cap(fe.cartAnalysisSem)).Warn("cart analysis pool saturated, skipping analysis")
+		return false
+	}
+	// The analysis outlives the add-to-cart request; only its values and
+	// logger (with the request's session and id) are kept.
+	ctx = context.WithoutCancel(ctx)
+	log = log.WithField("task", "cart_analysis")
+	go func() {
+		defer func() { <-fe.cartAnalysisSem }()
+		fe.analyzeCartWithAgent(ctx, log, sessionId, userId, product, quantity)
+	}()
+	return true
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestCartAnalysisConcurrencyLimit(t *testing.T) {
+	const limit = 3
+	release := make(chan struct{})
+	var (
+		mu                  sync.Mutex
+		inFlight, maxFlight int
+	)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/run" {
+			io.WriteString(w, `{"id": "adk-session"}`)
+			return
+		}
+		mu.Lock()
+		inFlight++
+		if inFlight > maxFlight {
+			maxFlight = inFlight
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer gateway.Close()
+
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, &fakeCartService{})
+		pb.RegisterProductCatalogServiceServer(s, &countingProductCatalog{})
+	})
+	fe := &frontendServer{
+		cartSvcConn:           conn,
+		productCatalogSvcConn: conn,
+		agentsGatewayURL:      gateway.URL,
+		flags:                 featureFlags{SmartAddToCart: true},
+		cartAnalysisSem:       make(chan struct{}, limit),
+	}
+
+	for i := 0; i < 20; i++ {
+		rec := httptest.NewRecorder()
+		req := newTestRequest(http.MethodPost, "/cart", "product_id=OLJCESPC7Z&quantity=1")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		fe.addToCartHandler(rec, req)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("add to cart status = %d, want %d", rec.Code, http.StatusFound)
+		}
+	}
+
+	// Wait for the admitted analyses to reach the gateway.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := inFlight
+		mu.Unlock()
+		if n == limit || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	// Acquiring every slot means all analyses have finished.
+	for i := 0; i < limit; i++ {
+		fe.cartAnalysisSem <- struct{}{}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxFlight > limit {
+		t.Errorf("%d concurrent gateway calls, want at most %d", maxFlight, limit)
+	}
+	if maxFlight == 0 {
+		t.Error("no cart analysis reached the gateway")
+	}
+}
+
+func TestCartAnalysisThreshold(t *testing.T) {
+	var runs atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/run" {
+			runs.Add(1)
+			io.WriteString(w, `[]`)
+			return
+		}
+		io.WriteString(w, `{"id": "adk-session"}`)
+	}))
+	defer gateway.Close()
+
+	tests := []struct {
+		name     string
+		minItems int
+		minTotal int
+		want     []int32 // analyses run after each add of a $10 product
+	}{
+		{"default", 0, 0, []int32{1, 2, 3}},
+		{"min items", 2, 0, []int32{0, 1, 2}},
+		{"min total", 5, 25, []int32{0, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs.Store(0)
+			conn := serveGRPC(t, func(s *grpc.Server) {
+				pb.RegisterCartServiceServer(s, &fakeCartService{})
+				pb.RegisterProductCatalogServiceServer(s, &fakeProductCatalog{products: []*pb.Product{
+					{Id: "MUG", Name: "Mug", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 10}},
+				}})
+			})
+			fe := &frontendServer{
+				cartSvcConn:             conn,
+				productCatalogSvcConn:   conn,
+				agentsGatewayURL:        gateway.URL,
+				flags:                   featureFlags{SmartAddToCart: true},
+				cartAnalysisSem:         make(chan struct{}, 1),
+				cartAnalysisMinItems:    tt.minItems,
+				cartAnalysisMinTotalUSD: tt.minTotal,
+			}
+			for i, want := range tt.want {
+				rec := httptest.NewRecorder()
+				req := newTestRequest(http.MethodPost, "/cart", "product_id=MUG&quantity=1")
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				fe.addToCartHandler(rec, req)
+				if rec.Code != http.StatusFound {
+					t.Fatalf("add to cart status = %d, want %d", rec.Code, http.StatusFound)
+				}
+				// Taking the only slot waits for a started analysis to finish.
+				fe.cartAnalysisSem <- struct{}{}
+				<-fe.cartAnalysisSem
+				if got := runs.Load(); got != want {
+					t.Errorf("after add %d: %d analyses, want %d", i+1, got, want)
+				}
+			}
+		})
+	}
+}
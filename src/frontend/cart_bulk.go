@@ -0,0 +1,138 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/validator"
+)
+
+// maxBulkAddItems is the most items one bulk add may carry.
+const maxBulkAddItems = 20
+
+// bulkAddResult is the outcome of one item of a bulk add.
+type bulkAddResult struct {
+	ProductID string `json:"productId"`
+	Quantity  int32  `json:"quantity"`
+	Added     bool   `json:"added"`
+	Error     string `json:"error,omitempty"`
+}
+
+// POST /api/cart/add-bulk {userId, items: [{productId, quantity}]}
+//
+// Adds several products at once, e.g. a whole set of recommendations. Items
+// with a missing or unknown product id are reported and skipped, and the
+// others are added. The cart limits apply to the batch as a whole: a batch
+// that would exceed them adds nothing, and so does a batch with an item over
+// the per-request quantity limit (validator.MaxQuantity). Returns the refreshed cart and one
+// result per item, in request order.
+func (fe *frontendServer) apiAddToCartBulk(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	var req struct {
+		UserId string `json:"userId"`
+		Items  []struct {
+			ProductId string `json:"productId"`
+			Quantity  int32  `json:"quantity"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, "request body must be valid JSON")
+		return
+	}
+	switch {
+	case len(req.Items) == 0:
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, "items is required")
+		return
+	case len(req.Items) > maxBulkAddItems:
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("at most %d items can be added at once", maxBulkAddItems))
+		return
+	}
+	userId := fe.apiUserID(r, req.UserId)
+
+	results := make([]bulkAddResult, len(req.Items))
+	var ids []string
+	for i, item := range req.Items {
+		results[i] = bulkAddResult{ProductID: normalizeProductID(item.ProductId), Quantity: item.Quantity}
+		if results[i].Quantity <= 0 {
+			results[i].Quantity = 1
+		}
+		if uint64(results[i].Quantity) > validator.MaxQuantity() {
+			writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("items[%d].quantity must be at most %d", i, validator.MaxQuantity()))
+			return
+		}
+		if results[i].ProductID == "" {
+			results[i].Error = "productId is required"
+			continue
+		}
+		ids = appendUnique(ids, results[i].ProductID)
+	}
+
+	_, unknown, err := fe.getProductsByID(r.Context(), ids)
+	if err != nil {
+		log.WithField("error", err).Error("could not validate bulk add products")
+		writeAPIError(w, r, http.StatusBadGateway, errCodeCatalogUnavailable, "product catalog temporarily unavailable")
+		return
+	}
+	var adds []*pb.CartItem
+	for i := range results {
+		if results[i].Error == "" && stringinSlice(unknown, results[i].ProductID) {
+			results[i].Error = "unknown product"
+		}
+		if results[i].Error == "" {
+			adds = append(adds, &pb.CartItem{ProductId: results[i].ProductID, Quantity: results[i].Quantity})
+		}
+	}
+
+	if err := fe.checkCartLimitsBatch(r.Context(), userId, adds); err != nil {
+		var limitErr *cartLimitError
+		if errors.As(err, &limitErr) {
+			writeAPIErrorDetails(w, r, http.StatusConflict, errCodeCartLimitExceeded, limitErr.Error(), limitErr)
+		} else {
+			writeAPIError(w, r, http.StatusInternalServerError, errCodeCartFetchFailed, "could not retrieve cart")
+		}
+		return
+	}
+	for i := range results {
+		res := &results[i]
+		if res.Error != "" {
+			continue
+		}
+		if err := fe.insertCart(r.Context(), userId, res.ProductID, res.Quantity); err != nil {
+			log.WithField("product_id", res.ProductID).WithField("error", err).Warn("bulk add: could not add item to cart")
+			res.Error = "could not add item to cart"
+			continue
+		}
+		res.Added = true
+		fe.emitProductEvent(r, eventAddToCart, res.ProductID, res.Quantity)
+	}
+
+	cart, err := fe.apiCart(r.Context(), log, userId)
+	if err != nil {
+		writeAPIError(w, r, http.StatusInternalServerError, errCodeCartFetchFailed, "could not retrieve cart")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"cart":    cart,
+		"results": results,
+	})
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestAPIAddToCartBulk(t *testing.T) {
+	catalog := &fakeProductCatalog{products: []*pb.Product{
+		{Id: "A", Name: "Mug", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 8}},
+		{Id: "B", Name: "Cup", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 4}},
+	}}
+	type response struct {
+		Cart    map[string]any  `json:"cart"`
+		Results []bulkAddResult `json:"results"`
+	}
+	tests := []struct {
+		name        string
+		body        string
+		wantResults []bulkAddResult
+		wantCart    []string
+	}{
+		{
+			name: "all added",
+			body: `{"items": [{"productId": "a", "quantity": 2}, {"productId": "B"}]}`,
+			wantResults: []bulkAddResult{
+				{ProductID: "A", Quantity: 2, Added: true},
+				{ProductID: "B", Quantity: 1, Added: true},
+			},
+			wantCart: []string{"A", "B"},
+		},
+		{
+			name: "unknown product",
+			body: `{"items": [{"productId": "A"}, {"productId": "NOPE"}, {"productId": ""}, {"productId": "B"}]}`,
+			wantResults: []bulkAddResult{
+				{ProductID: "A", Quantity: 1, Added: true},
+				{ProductID: "NOPE", Quantity: 1, Error: "unknown product"},
+				{ProductID: "", Quantity: 1, Error: "productId is required"},
+				{ProductID: "B", Quantity: 1, Added: true},
+			},
+			wantCart: []string{"A", "B"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cart := &fakeCartService{}
+			conn := serveGRPC(t, func(s *grpc.Server) {
+				pb.RegisterCartServiceServer(s, cart)
+				pb.RegisterProductCatalogServiceServer(s, catalog)
+			})
+			fe := &frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn}
+
+			rec := httptest.NewRecorder()
+			fe.apiAddToCartBulk(rec, newAPIRequest(http.MethodPost, "/api/cart/add-bulk", tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			var resp response
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp.Results, tt.wantResults) {
+				t.Errorf("results = %+v, want %+v", resp.Results, tt.wantResults)
+			}
+			var inCart []string
+			for _, item := range cart.items["test-session"] {
+				inCart = append(inCart, item.GetProductId())
+			}
+			if !reflect.DeepEqual(inCart, tt.wantCart) {
+				t.Errorf("cart holds %v, want %v", inCart, tt.wantCart)
+			}
+			if items, _ := resp.Cart["items"].([]any); len(items) != len(tt.wantCart) {
+				t.Errorf("returned cart has %d items, want %d", len(items), len(tt.wantCart))
+			}
+		})
+	}
+}
+
+func TestAPIAddToCartBulkEnforcesCartLimitOnBatch(t *testing.T) {
+	cart := &fakeCartService{items: map[string][]*pb.CartItem{"test-session": {{ProductId: "A", Quantity: 3}}}}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, cart)
+		pb.RegisterProductCatalogServiceServer(s, &countingProductCatalog{})
+	})
+	fe := &frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn, maxCartQuantity: 5}
+
+	// Each item fits on its own, but not both together.
+	body := `{"items": [{"productId": "B", "quantity": 2}, {"productId": "C", "quantity": 1}]}`
+	rec := httptest.NewRecorder()
+	fe.apiAddToCartBulk(rec, newAPIRequest(http.MethodPost, "/api/cart/add-bulk", body))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if code := decodeAPIError(t, rec).Code; code != errCodeCartLimitExceeded {
+		t.Errorf("code = %q, want %q", code, errCodeCartLimitExceeded)
+	}
+	if n := len(cart.items["test-session"]); n != 1 {
+		t.Errorf("cart has %d lines after the rejected batch, want 1", n)
+	}
+}
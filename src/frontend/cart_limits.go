@@ -0,0 +1,81 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+const (
+	// defaultMaxCartItems is the default number of distinct products a cart
+	// may hold. Each one costs a product RPC when the cart is viewed.
+	defaultMaxCartItems = 50
+	// defaultMaxCartQuantity is the default total quantity across a cart.
+	defaultMaxCartQuantity = 500
+)
+
+// cartLimitError reports an add to cart that would exceed a cart limit.
+type cartLimitError struct {
+	Limit string `json:"limit"` // "items" or "quantity"
+	Max   int    `json:"max"`
+	// Count is the cart's count for Limit had the add gone through.
+	Count int `json:"count"`
+}
+
+func (e *cartLimitError) Error() string {
+	if e.Limit == "items" {
+		return fmt.Sprintf("a cart can hold at most %d different products", e.Max)
+	}
+	return fmt.Sprintf("a cart can hold at most %d items in total", e.Max)
+}
+
+// checkCartLimits returns a *cartLimitError if adding quantity of productID
+// to userID's cart would exceed maxCartItems or maxCartQuantity. A zero limit
+// is not enforced.
+func (fe *frontendServer) checkCartLimits(ctx context.Context, userID, productID string, quantity int32) error {
+	return fe.checkCartLimitsBatch(ctx, userID, []*pb.CartItem{{ProductId: productID, Quantity: quantity}})
+}
+
+// checkCartLimitsBatch is like checkCartLimits for adding all of adds at
+// once.
+func (fe *frontendServer) checkCartLimitsBatch(ctx context.Context, userID string, adds []*pb.CartItem) error {
+	if fe.maxCartItems <= 0 && fe.maxCartQuantity <= 0 {
+		return nil
+	}
+	items, err := fe.getCart(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "could not retrieve cart")
+	}
+
+	products := make(map[string]bool, len(items)+len(adds))
+	total := 0
+	for _, item := range append(items, adds...) {
+		products[item.GetProductId()] = true
+		total += int(item.GetQuantity())
+	}
+
+	if fe.maxCartItems > 0 && len(products) > fe.maxCartItems {
+		return &cartLimitError{Limit: "items", Max: fe.maxCartItems, Count: len(products)}
+	}
+	if fe.maxCartQuantity > 0 && total > fe.maxCartQuantity {
+		return &cartLimitError{Limit: "quantity", Max: fe.maxCartQuantity, Count: total}
+	}
+	return nil
+}
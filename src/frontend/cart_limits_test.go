@@ -0,0 +1,153 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/validator"
+)
+
+func TestAddToCartRejectsTooManyProducts(t *testing.T) {
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, &fakeCartService{})
+		pb.RegisterProductCatalogServiceServer(s, &countingProductCatalog{})
+	})
+	fe := &frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn, maxCartItems: 2}
+
+	add := func(productID string) int {
+		rec := httptest.NewRecorder()
+		req := newTestRequest(http.MethodPost, "/cart", "product_id="+productID+"&quantity=1")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		fe.addToCartHandler(rec, req)
+		return rec.Code
+	}
+	for _, id := range []string{"A", "B", "A"} {
+		if code := add(id); code != http.StatusFound {
+			t.Fatalf("adding %s: status = %d, want %d", id, code, http.StatusFound)
+		}
+	}
+	if code := add("C"); code != http.StatusUnprocessableEntity {
+		t.Errorf("adding a third product: status = %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestAPIAddToCartRejectsTooLargeQuantity(t *testing.T) {
+	cart := &fakeCartService{}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, cart)
+		pb.RegisterProductCatalogServiceServer(s, &countingProductCatalog{})
+	})
+	fe := &frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn, maxCartQuantity: 5, sessionKey: testSessionKey}
+
+	add := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		fe.apiAddToCart(rec, newAPIRequest(http.MethodPost, "/api/cart/add", body))
+		return rec
+	}
+	for _, body := range []string{
+		`{"userId": "` + fe.signSessionID("u") + `", "productId": "A", "quantity": 3}`,
+		`{"userId": "` + fe.signSessionID("u") + `", "productId": "B", "quantity": 2}`,
+	} {
+		if rec := add(body); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d: %s", body, rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+
+	rec := add(`{"userId": "` + fe.signSessionID("u") + `", "productId": "A", "quantity": 1}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	var resp apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error.Code != errCodeCartLimitExceeded {
+		t.Errorf("code = %q, want %q", resp.Error.Code, errCodeCartLimitExceeded)
+	}
+	details, _ := resp.Error.Details.(map[string]any)
+	if details["limit"] != "quantity" || details["max"] != float64(5) || details["count"] != float64(6) {
+		t.Errorf("details = %v, want quantity limit 5 with count 6", resp.Error.Details)
+	}
+	if n := len(cart.items["u"]); n != 2 {
+		t.Errorf("cart has %d lines after the rejected add, want 2", n)
+	}
+}
+
+// TestAPIAddToCartRejectsQuantityOverItemMax checks that the JSON add
+// endpoints enforce the same per-request quantity limit as the form, even
+// with the cart limits lifted.
+func TestAPIAddToCartRejectsQuantityOverItemMax(t *testing.T) {
+	defer validator.SetMaxQuantity(validator.MaxQuantity())
+	validator.SetMaxQuantity(10)
+	cart := &fakeCartService{}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, cart)
+		pb.RegisterProductCatalogServiceServer(s, &countingProductCatalog{})
+	})
+	fe := &frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn}
+
+	tests := []struct {
+		name     string
+		add      func(w http.ResponseWriter, r *http.Request)
+		body     string
+		wantCode int
+	}{
+		{"single at max", fe.apiAddToCart, `{"productId": "A", "quantity": 10}`, http.StatusOK},
+		{"single over max", fe.apiAddToCart, `{"productId": "A", "quantity": 11}`, http.StatusBadRequest},
+		{"single huge", fe.apiAddToCart, `{"productId": "A", "quantity": 2147483647}`, http.StatusBadRequest},
+		{"bulk over max", fe.apiAddToCartBulk, `{"items": [{"productId": "B", "quantity": 1}, {"productId": "C", "quantity": 2147483647}]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.add(rec, newAPIRequest(http.MethodPost, "/api/cart/add", tt.body))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode == http.StatusBadRequest {
+				if code := decodeAPIError(t, rec).Code; code != errCodeBadRequest {
+					t.Errorf("code = %q, want %q", code, errCodeBadRequest)
+				}
+			}
+		})
+	}
+	if items := cart.items["test-session"]; len(items) != 1 || items[0].GetQuantity() != 10 {
+		t.Errorf("cart = %v, want only the add at the limit", items)
+	}
+}
+
+// TestCartLimitsAcceptZero checks that MAX_CART_ITEMS and
+// MAX_CART_TOTAL_QUANTITY can be set to 0 to lift the limits.
+func TestCartLimitsAcceptZero(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+	t.Setenv("MAX_CART_ITEMS", "0")
+	t.Setenv("MAX_CART_TOTAL_QUANTITY", "-5")
+	if got := nonNegativeIntEnv(log, "MAX_CART_ITEMS", defaultMaxCartItems); got != 0 {
+		t.Errorf("MAX_CART_ITEMS=0 gives %d, want 0", got)
+	}
+	if got := nonNegativeIntEnv(log, "MAX_CART_TOTAL_QUANTITY", defaultMaxCartQuantity); got != defaultMaxCartQuantity {
+		t.Errorf("MAX_CART_TOTAL_QUANTITY=-5 gives %d, want the default %d", got, defaultMaxCartQuantity)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+// slowProductCatalog answers GetProduct after a fixed delay, like a catalog
+// backed by a database.
+type slowProductCatalog struct {
+	*fakeProductCatalog
+	delay time.Duration
+}
+
+func (c slowProductCatalog) GetProduct(ctx context.Context, req *pb.GetProductRequest) (*pb.Product, error) {
+	select {
+	case <-time.After(c.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return c.fakeProductCatalog.GetProduct(ctx, req)
+}
+
+// relabelCurrencyService lists the fake currencies but converts like
+// identityCurrencyService, so cart totals are easy to check.
+type relabelCurrencyService struct {
+	fakeCurrencyService
+}
+
+func (relabelCurrencyService) Convert(ctx context.Context, req *pb.CurrencyConversionRequest) (*pb.Money, error) {
+	return identityCurrencyService{}.Convert(ctx, req)
+}
+
+// cartTestCatalog returns n products A, B, ... priced $1.25, $2.25, ... and a
+// cart holding i+1 of the i-th one.
+func cartTestCatalog(n int) (*fakeProductCatalog, []*pb.CartItem) {
+	catalog := &fakeProductCatalog{}
+	var cart []*pb.CartItem
+	for i := 0; i < n; i++ {
+		id := string(rune('A' + i))
+		catalog.products = append(catalog.products, &pb.Product{Id: id, Name: "Product " + id,
+			PriceUsd: &pb.Money{CurrencyCode: "USD", Units: int64(i + 1), Nanos: 250000000}})
+		cart = append(cart, &pb.CartItem{ProductId: id, Quantity: int32(i + 1)})
+	}
+	return catalog, cart
+}
+
+func TestViewCartWithManyItems(t *testing.T) {
+	catalog, cart := cartTestCatalog(6)
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, slowProductCatalog{catalog, 20 * time.Millisecond})
+		pb.RegisterCurrencyServiceServer(s, relabelCurrencyService{})
+		pb.RegisterCartServiceServer(s, &fakeCartService{items: map[string][]*pb.CartItem{"test-session": cart}})
+		pb.RegisterShippingServiceServer(s, flatShippingService{})
+		pb.RegisterRecommendationServiceServer(s, fakeRecommendationService{})
+	})
+	fe := &frontendServer{
+		productCatalogSvcConn: conn,
+		currencySvcConn:       conn,
+		cartSvcConn:           conn,
+		shippingSvcConn:       conn,
+		recommendationSvcConn: conn,
+	}
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	fe.viewCartHandler(rec, newTestRequest(http.MethodGet, "/cart", ""))
+	elapsed := time.Since(start)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	// Items: sum of (i+1.25)*(i) for i in 1..6 = 96.25, plus $8.99 shipping.
+	if want := "$105.24"; !strings.Contains(body, want) {
+		t.Errorf("cart page does not show the total %s", want)
+	}
+	for _, p := range catalog.products {
+		if !strings.Contains(body, p.GetName()) {
+			t.Errorf("cart page does not show %s", p.GetName())
+		}
+	}
+	if elapsed > 100*time.Millisecond {
+		t.Errorf("cart page took %s, want the product fetches to overlap", elapsed)
+	}
+}
+
+func TestGetCartProductsOrderAndError(t *testing.T) {
+	catalog, cart := cartTestCatalog(10)
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, slowProductCatalog{catalog, time.Millisecond})
+	})
+	fe := &frontendServer{productCatalogSvcConn: conn}
+
+	products, err := fe.getCartProducts(context.Background(), cart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range products {
+		if p.GetId() != cart[i].GetProductId() {
+			t.Errorf("products[%d] = %s, want %s", i, p.GetId(), cart[i].GetProductId())
+		}
+	}
+
+	cart = append(cart, &pb.CartItem{ProductId: "MISSING", Quantity: 1})
+	if _, err := fe.getCartProducts(context.Background(), cart); err == nil || !strings.Contains(err.Error(), "#MISSING") {
+		t.Errorf("error = %v, want one naming product #MISSING", err)
+	}
+}
+
+// getCartProductsSerially is the one-at-a-time lookup getCartProducts
+// replaced, kept as a baseline for BenchmarkGetCartProducts.
+func (fe *frontendServer) getCartProductsSerially(ctx context.Context, cart []*pb.CartItem) ([]*pb.Product, error) {
+	products := make([]*pb.Product, len(cart))
+	for i, item := range cart {
+		p, err := fe.getProduct(ctx, item.GetProductId())
+		if err != nil {
+			return nil, err
+		}
+		products[i] = p
+	}
+	return products, nil
+}
+
+func BenchmarkGetCartProducts(b *testing.B) {
+	catalog, cart := cartTestCatalog(10)
+	conn := serveGRPC(b, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, slowProductCatalog{catalog, 2 * time.Millisecond})
+	})
+	fe := &frontendServer{productCatalogSvcConn: conn}
+
+	for _, bb := range []struct {
+		name  string
+		fetch func(context.Context, []*pb.CartItem) ([]*pb.Product, error)
+	}{
+		{"serial", fe.getCartProductsSerially},
+		{"concurrent", fe.getCartProducts},
+	} {
+		b.Run(fmt.Sprintf("%s/%d-items", bb.name, len(cart)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := bb.fetch(context.Background(), cart); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxChatMessageLength is the longest chat message, in characters, sent
+	// on to the agents.
+	maxChatMessageLength = 2000
+	// maxChatBodyBytes bounds a chat request body, leaving room for the
+	// attached base64 images.
+	maxChatBodyBytes = 8 << 20
+	// maxChatImages and maxChatImageBytes bound the images attached to one
+	// chat message, by count and by their combined decoded size.
+	maxChatImages     = 4
+	maxChatImageBytes = 5 << 20
+)
+
+// chatImageTypes are the image formats the agents accept.
+var chatImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// chatRequest is the body of a chat message to the shopping assistant.
+type chatRequest struct {
+	Message string `json:"message"`
+	// Image is a single attached image, kept for older clients; Images
+	// holds any number of them. Both are base64, optionally as data URLs.
+	Image  string   `json:"image,omitempty"`
+	Images []string `json:"images,omitempty"`
+	// SessionId continues an earlier conversation; it is the session_id
+	// returned by a previous response.
+	SessionId string `json:"sessionId,omitempty"`
+
+	// images are the decoded attachments, set by decodeChatRequest.
+	images []chatImage
+}
+
+// chatImage is an attached image, ready for an inlineData part.
+type chatImage struct {
+	Data     string // base64, without a data URL prefix
+	MimeType string
+}
+
+// hasImage reports whether the request carries an image.
+func (c chatRequest) hasImage() bool {
+	return len(c.images) > 0
+}
+
+// imageParts returns an agent message part per attached image.
+func (c chatRequest) imageParts() []map[string]interface{} {
+	parts := make([]map[string]interface{}, 0, len(c.images))
+	for _, img := range c.images {
+		parts = append(parts, map[string]interface{}{
+			"inlineData": map[string]interface{}{
+				"data":     img.Data,
+				"mimeType": img.MimeType,
+			},
+		})
+	}
+	return parts
+}
+
+// decodeChatImages decodes the attached images and detects their type. The
+// assistant page sends "undefined" when no image is attached.
+func decodeChatImages(encoded []string) ([]chatImage, error) {
+	var images []chatImage
+	total := 0
+	for _, e := range encoded {
+		if e == "" || e == "undefined" {
+			continue
+		}
+		if len(images) == maxChatImages {
+			return nil, fmt.Errorf("at most %d images may be attached", maxChatImages)
+		}
+		if i := strings.Index(e, ","); i >= 0 && strings.HasPrefix(e, "data:") {
+			e = e[i+1:]
+		}
+		data, err := base64.StdEncoding.DecodeString(e)
+		if err != nil {
+			return nil, fmt.Errorf("image %d is not valid base64", len(images)+1)
+		}
+		if total += len(data); total > maxChatImageBytes {
+			return nil, fmt.Errorf("attached images must not exceed %d bytes in total", maxChatImageBytes)
+		}
+		mimeType := http.DetectContentType(data)
+		if !chatImageTypes[mimeType] {
+			return nil, fmt.Errorf("image %d is not a JPEG, PNG, GIF or WebP image", len(images)+1)
+		}
+		images = append(images, chatImage{Data: base64.StdEncoding.EncodeToString(data), MimeType: mimeType})
+	}
+	return images, nil
+}
+
+// decodeChatRequest reads and validates a chat request body. Unknown fields,
+// bodies over maxChatBodyBytes, images that cannot be decoded or exceed the
+// limits above, a missing message without an image and messages over
+// maxChatMessageLength are rejected with an error meant for the client.
+func decodeChatRequest(w http.ResponseWriter, r *http.Request) (chatRequest, error) {
+	var req chatRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxChatBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return req, fmt.Errorf("request body must not exceed %d bytes", maxChatBodyBytes)
+		}
+		if strings.HasPrefix(err.Error(), "json: unknown field") {
+			return req, errors.New(strings.TrimPrefix(err.Error(), "json: "))
+		}
+		return req, errors.New("request body must be a JSON object")
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return req, errors.New("request body must contain a single JSON object")
+	}
+
+	images, err := decodeChatImages(append([]string{req.Image}, req.Images...))
+	if err != nil {
+		return req, err
+	}
+	req.images = images
+
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" && !req.hasImage() {
+		return req, errors.New("message must not be empty unless an image is attached")
+	}
+	if n := utf8.RuneCountInString(req.Message); n > maxChatMessageLength {
+		return req, fmt.Errorf("message must not exceed %d characters (got %d)", maxChatMessageLength, n)
+	}
+	return req, nil
+}
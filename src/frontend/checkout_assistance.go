@@ -0,0 +1,53 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+const (
+	// defaultSimpleCheckoutMaxItems is the default largest number of units
+	// in a cart that gets local checkout guidance instead of the agent's.
+	defaultSimpleCheckoutMaxItems = 2
+	// defaultSimpleCheckoutMaxTotalUSD is the default total, in whole US
+	// dollars, below which such a cart counts as simple.
+	defaultSimpleCheckoutMaxTotalUSD = 100
+)
+
+// isSimpleCheckout reports whether cart is small and cheap enough that the
+// checkout agent has nothing to add to the local guidance: at most
+// simpleCheckoutMaxItems units costing less than simpleCheckoutMaxTotalUSD
+// in total. Carts whose products cannot be priced are not simple, and a zero
+// threshold disables the fast path.
+func (fe *frontendServer) isSimpleCheckout(ctx context.Context, cart []*pb.CartItem) bool {
+	if fe.simpleCheckoutMaxItems <= 0 || fe.simpleCheckoutMaxTotalUSD <= 0 {
+		return false
+	}
+	if cartSize(cart) > fe.simpleCheckoutMaxItems {
+		return false
+	}
+	products, err := fe.getCartProducts(ctx, cart)
+	if err != nil {
+		return false
+	}
+	var total int64
+	for i, p := range products {
+		total += moneyNanos(unitPrice(p)) * int64(cart[i].GetQuantity())
+	}
+	return total < int64(fe.simpleCheckoutMaxTotalUSD)*1e9
+}
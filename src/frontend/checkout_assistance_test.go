@@ -0,0 +1,111 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestCheckoutAssistanceSkipsAgentForSimpleCarts(t *testing.T) {
+	var runs atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/run" {
+			runs.Add(1)
+			io.WriteString(w, `{"message": "Bundle shipping to save."}`)
+			return
+		}
+		io.WriteString(w, `{"id": "adk-session"}`)
+	}))
+	defer gateway.Close()
+
+	tests := []struct {
+		name      string
+		cart      []*pb.CartItem
+		wantAgent bool
+	}{
+		{"one cheap item", []*pb.CartItem{{ProductId: "CHEAP", Quantity: 1}}, false},
+		{"two cheap items", []*pb.CartItem{{ProductId: "CHEAP", Quantity: 1}, {ProductId: "MID", Quantity: 1}}, false},
+		{"too many units", []*pb.CartItem{{ProductId: "CHEAP", Quantity: 3}}, true},
+		{"too expensive", []*pb.CartItem{{ProductId: "MID", Quantity: 2}}, true},
+		{"unknown product", []*pb.CartItem{{ProductId: "GONE", Quantity: 1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := serveGRPC(t, func(s *grpc.Server) {
+				pb.RegisterCartServiceServer(s, &fakeCartService{items: map[string][]*pb.CartItem{"test-session": tt.cart}})
+				pb.RegisterProductCatalogServiceServer(s, &fakeProductCatalog{products: []*pb.Product{
+					{Id: "CHEAP", Name: "Socks", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 5}},
+					{Id: "MID", Name: "Jacket", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 60}},
+				}})
+			})
+			fe := &frontendServer{
+				cartSvcConn:               conn,
+				productCatalogSvcConn:     conn,
+				agentsGatewayURL:          gateway.URL,
+				flags:                     featureFlags{CheckoutAssistance: true},
+				simpleCheckoutMaxItems:    2,
+				simpleCheckoutMaxTotalUSD: 100,
+			}
+			before := runs.Load()
+
+			rec := httptest.NewRecorder()
+			fe.checkoutAssistanceHandler(rec, newTestRequest(http.MethodGet, "/api/checkout/assistance", ""))
+
+			var resp struct {
+				AgentPowered bool `json:"agent_powered"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("%v: %s", err, rec.Body.String())
+			}
+			calledAgent := runs.Load() > before
+			if calledAgent != tt.wantAgent || resp.AgentPowered != tt.wantAgent {
+				t.Errorf("called agent = %v, agent_powered = %v, want %v", calledAgent, resp.AgentPowered, tt.wantAgent)
+			}
+		})
+	}
+}
+
+// TestSimpleCheckoutThresholdsAcceptZero checks that a zero threshold, which
+// disables the local guidance, survives configuration.
+func TestSimpleCheckoutThresholdsAcceptZero(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+	tests := []struct {
+		env  string
+		want int
+	}{
+		{"", defaultSimpleCheckoutMaxItems},
+		{"0", 0},
+		{"3", 3},
+		{"-1", defaultSimpleCheckoutMaxItems},
+		{"many", defaultSimpleCheckoutMaxItems},
+	}
+	for _, tt := range tests {
+		t.Setenv("CHECKOUT_ASSISTANCE_SIMPLE_MAX_ITEMS", tt.env)
+		if got := nonNegativeIntEnv(log, "CHECKOUT_ASSISTANCE_SIMPLE_MAX_ITEMS", defaultSimpleCheckoutMaxItems); got != tt.want {
+			t.Errorf("CHECKOUT_ASSISTANCE_SIMPLE_MAX_ITEMS=%q gives %d, want %d", tt.env, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+// maxCompareProducts is the most products compared side by side.
+const maxCompareProducts = 4
+
+// comparedProduct is one column of the comparison table.
+type comparedProduct struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Picture     string            `json:"picture"`
+	Price       string            `json:"price,omitempty"`
+	PriceMoney  *pb.Money         `json:"price_money,omitempty"`
+	Categories  []string          `json:"categories"`
+	Attributes  map[string]string `json:"attributes"`
+}
+
+// comparisonTable is the body of GET /api/compare. Attributes lists the
+// attribute names of all compared products, i.e. the rows of the table;
+// products lacking one have no value for it.
+type comparisonTable struct {
+	Currency   string            `json:"currency"`
+	Products   []comparedProduct `json:"products"`
+	Attributes []string          `json:"attributes"`
+	Missing    []string          `json:"missing"`
+}
+
+// parseCompareIDs reads the comma-separated ids parameter, normalizing and
+// de-duplicating the ids.
+func parseCompareIDs(r *http.Request) ([]string, error) {
+	var ids []string
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = normalizeProductID(id); id != "" {
+			ids = appendUnique(ids, id)
+		}
+	}
+	switch {
+	case len(ids) < 2:
+		return nil, fmt.Errorf("ids must name at least 2 products")
+	case len(ids) > maxCompareProducts:
+		return nil, fmt.Errorf("at most %d products can be compared", maxCompareProducts)
+	}
+	return ids, nil
+}
+
+// GET /api/compare?ids=a,b,c
+// Returns the products side by side, priced in the session's currency. IDs
+// the catalog does not know are listed under missing.
+func (fe *frontendServer) apiCompareProducts(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	ids, err := parseCompareIDs(r)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	}
+
+	products, missing, err := fe.getProductsByID(r.Context(), ids)
+	if err != nil {
+		log.WithField("error", err).Error("could not retrieve products to compare")
+		writeAPIError(w, r, http.StatusBadGateway, errCodeCatalogUnavailable, "product catalog temporarily unavailable")
+		return
+	}
+
+	// Products without a price are shown without one.
+	currency := currentCurrency(r)
+	var priced []int
+	for i, p := range products {
+		if p.GetPriceUsd() != nil {
+			priced = append(priced, i)
+		}
+	}
+	amounts := make([]*pb.Money, len(priced))
+	for j, i := range priced {
+		amounts[j] = products[i].GetPriceUsd()
+	}
+	converted, err := fe.convertCurrencyBatch(r.Context(), amounts, currency)
+	if err != nil {
+		log.WithField("error", err).Error("could not convert compared product prices")
+		writeAPIError(w, r, http.StatusBadGateway, errCodeCurrencyUnavailable, "currency conversion temporarily unavailable")
+		return
+	}
+	prices := make([]*pb.Money, len(products))
+	for j, i := range priced {
+		prices[i] = converted[j]
+	}
+
+	resp := comparisonTable{
+		Currency:   currency,
+		Products:   make([]comparedProduct, len(products)),
+		Attributes: []string{},
+		Missing:    missing,
+	}
+	if resp.Missing == nil {
+		resp.Missing = []string{}
+	}
+	names := map[string]bool{}
+	for i, p := range products {
+		attrs := p.GetAttributes()
+		if attrs == nil {
+			attrs = map[string]string{}
+		}
+		for name := range attrs {
+			if !names[name] {
+				names[name] = true
+				resp.Attributes = append(resp.Attributes, name)
+			}
+		}
+		categories := p.GetCategories()
+		if categories == nil {
+			categories = []string{}
+		}
+		resp.Products[i] = comparedProduct{
+			ID:          p.GetId(),
+			Name:        p.GetName(),
+			Description: p.GetDescription(),
+			Picture:     productPicture(p.GetPicture()),
+			PriceMoney:  prices[i],
+			Categories:  categories,
+			Attributes:  attrs,
+		}
+		if prices[i] != nil {
+			resp.Products[i].Price = renderMoney(*prices[i])
+		}
+	}
+	sort.Strings(resp.Attributes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getProductsByID fetches the products with ids, a few at once, returning
+// those found in order and the ids the catalog does not know. Other errors
+// cancel the remaining calls.
+func (fe *frontendServer) getProductsByID(ctx context.Context, ids []string) ([]*pb.Product, []string, error) {
+	found := make([]*pb.Product, len(ids))
+	var (
+		mu      sync.Mutex
+		unknown = map[string]bool{}
+	)
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(productFetchConcurrency)
+	for i, id := range ids {
+		g.Go(func() error {
+			p, err := fe.getProduct(ctx, id)
+			if status.Code(err) == codes.NotFound {
+				mu.Lock()
+				unknown[id] = true
+				mu.Unlock()
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			found[i] = p
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	var products []*pb.Product
+	var missing []string
+	for i, id := range ids {
+		if unknown[id] {
+			missing = append(missing, id)
+		} else {
+			products = append(products, found[i])
+		}
+	}
+	return products, missing, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestAPICompareProducts(t *testing.T) {
+	catalog := &fakeProductCatalog{products: []*pb.Product{
+		{Id: "MUG", Name: "Mug", Categories: []string{"kitchen"}, PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 8},
+			Attributes: map[string]string{"color": "white", "material": "ceramic"}},
+		{Id: "CUP", Name: "Cup", Categories: []string{"kitchen"}, PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 4},
+			Attributes: map[string]string{"color": "blue"}},
+	}}
+	fe := &frontendServer{
+		productCatalogSvcConn: serveGRPC(t, func(s *grpc.Server) { pb.RegisterProductCatalogServiceServer(s, catalog) }),
+		currencySvcConn:       serveGRPC(t, func(s *grpc.Server) { pb.RegisterCurrencyServiceServer(s, fakeCurrencyService{}) }),
+	}
+
+	tests := []struct {
+		name        string
+		ids         string
+		wantStatus  int
+		wantIDs     []string
+		wantMissing []string
+	}{
+		{"valid", "mug,CUP", http.StatusOK, []string{"MUG", "CUP"}, []string{}},
+		{"missing id", "MUG,NOPE,CUP", http.StatusOK, []string{"MUG", "CUP"}, []string{"NOPE"}},
+		{"single id", "MUG", http.StatusBadRequest, nil, nil},
+		{"too many ids", "A,B,C,D,E", http.StatusBadRequest, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(http.MethodGet, "/api/compare?ids="+tt.ids, "")
+			r.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "EUR"})
+			rec := httptest.NewRecorder()
+			fe.apiCompareProducts(rec, r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body = %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp comparisonTable
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, p := range resp.Products {
+				ids = append(ids, p.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) || !reflect.DeepEqual(resp.Missing, tt.wantMissing) {
+				t.Errorf("products = %v, missing = %v; want %v, %v", ids, resp.Missing, tt.wantIDs, tt.wantMissing)
+			}
+			if want := []string{"color", "material"}; !reflect.DeepEqual(resp.Attributes, want) {
+				t.Errorf("attributes = %v, want %v", resp.Attributes, want)
+			}
+			if resp.Currency != "EUR" || resp.Products[0].Price != "€4.00" {
+				t.Errorf("currency = %s, first price = %s; want EUR prices", resp.Currency, resp.Products[0].Price)
+			}
+			if resp.Products[1].Attributes["material"] != "" || resp.Products[1].Categories[0] != "kitchen" {
+				t.Errorf("second product = %+v", resp.Products[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// currencyListTTL is how long the supported currency list is reused before
+// asking the currency service again.
+const currencyListTTL = 5 * time.Minute
+
+// currencyList caches the supported currencies of a frontendServer.
+type currencyList struct {
+	mu        sync.Mutex
+	codes     []string
+	fetchedAt time.Time
+}
+
+// cachedCurrencies returns the supported currencies, calling fetch when the
+// cached list is missing or older than currencyListTTL.
+func (c *currencyList) cachedCurrencies(ctx context.Context, fetch func(context.Context) ([]string, error)) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.codes != nil && time.Since(c.fetchedAt) < currencyListTTL {
+		return c.codes, nil
+	}
+	codes, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.codes, c.fetchedAt = codes, time.Now()
+	return codes, nil
+}
+
+// displayCurrencies orders codes for the currency picker: the current
+// currency first, then the default currency, then the rest alphabetically.
+// codes itself is left untouched, as it is shared through the cache.
+func displayCurrencies(codes []string, current string) []string {
+	rank := func(code string) int {
+		switch code {
+		case current:
+			return 0
+		case defaultCurrency:
+			return 1
+		}
+		return 2
+	}
+	out := append([]string(nil), codes...)
+	sort.SliceStable(out, func(i, j int) bool {
+		if ri, rj := rank(out[i]), rank(out[j]); ri != rj {
+			return ri < rj
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+type currencyInfo struct {
+	Code   string `json:"code"`
+	Symbol string `json:"symbol"`
+}
+
+// GET /api/currencies
+// Returns the supported currencies with their display symbols, and the one
+// selected for this session.
+func (fe *frontendServer) apiCurrencies(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	codes, err := fe.getCurrencies(r.Context())
+	if err != nil {
+		log.WithField("error", err).Error("could not retrieve currencies")
+		writeAPIError(w, r, http.StatusBadGateway, errCodeCurrencyUnavailable, "currencies temporarily unavailable")
+		return
+	}
+	currencies := make([]currencyInfo, 0, len(codes))
+	for _, code := range codes {
+		currencies = append(currencies, currencyInfo{Code: code, Symbol: renderCurrencyLogo(code)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"currencies": currencies,
+		"selected":   currentCurrency(r),
+	})
+}
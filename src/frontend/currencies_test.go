@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+// listCountingCurrencyService counts GetSupportedCurrencies calls and also
+// offers a currency the frontend does not support.
+type listCountingCurrencyService struct {
+	fakeCurrencyService
+	lists atomic.Int32
+}
+
+func (s *listCountingCurrencyService) GetSupportedCurrencies(context.Context, *pb.Empty) (*pb.GetSupportedCurrenciesResponse, error) {
+	s.lists.Add(1)
+	return &pb.GetSupportedCurrenciesResponse{CurrencyCodes: []string{"USD", "EUR", "JPY", "XXX"}}, nil
+}
+
+func TestAPICurrencies(t *testing.T) {
+	currency := &listCountingCurrencyService{}
+	fe := &frontendServer{currencySvcConn: serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCurrencyServiceServer(s, currency)
+	})}
+
+	want, err := fe.getCurrencies(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		r := newTestRequest(http.MethodGet, "/api/currencies", "")
+		r.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "EUR"})
+		rec := httptest.NewRecorder()
+		fe.apiCurrencies(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+
+		var resp struct {
+			Currencies []currencyInfo `json:"currencies"`
+			Selected   string         `json:"selected"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Currencies) != len(want) {
+			t.Fatalf("currencies = %v, want %v", resp.Currencies, want)
+		}
+		for j, c := range resp.Currencies {
+			if c.Code != want[j] || c.Symbol != renderCurrencyLogo(want[j]) {
+				t.Errorf("currency %d = %+v, want %s with symbol %s", j, c, want[j], renderCurrencyLogo(want[j]))
+			}
+		}
+		if resp.Currencies[1].Symbol != "€" {
+			t.Errorf("EUR symbol = %q, want €", resp.Currencies[1].Symbol)
+		}
+		if resp.Selected != "EUR" {
+			t.Errorf("selected = %q, want EUR", resp.Selected)
+		}
+	}
+	if got := currency.lists.Load(); got != 1 {
+		t.Errorf("currency service listed %d times, want 1 (cached)", got)
+	}
+}
+
+func TestDisplayCurrencies(t *testing.T) {
+	codes := []string{"JPY", "EUR", "USD", "CAD", "GBP"}
+	tests := []struct {
+		current string
+		want    []string
+	}{
+		{"GBP", []string{"GBP", "USD", "CAD", "EUR", "JPY"}},
+		{"USD", []string{"USD", "CAD", "EUR", "GBP", "JPY"}},
+		{"XXX", []string{"USD", "CAD", "EUR", "GBP", "JPY"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.current, func(t *testing.T) {
+			if got := displayCurrencies(codes, tt.current); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("displayCurrencies(%v, %s) = %v, want %v", codes, tt.current, got, tt.want)
+			}
+		})
+	}
+	if codes[0] != "JPY" {
+		t.Errorf("displayCurrencies reordered its input: %v", codes)
+	}
+}
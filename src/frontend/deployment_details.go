@@ -1,17 +1,25 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
 	"os"
-	"time"
+	"sync"
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/sirupsen/logrus"
 )
 
-var deploymentDetailsMap map[string]string
+var (
+	deploymentDetailsMu  sync.RWMutex
+	deploymentDetailsMap map[string]string
+)
 var log *logrus.Logger
 
+// publicDeploymentDetails are the deployment details GET /api/deployment
+// exposes. Details added later stay private until listed here.
+var publicDeploymentDetails = []string{"HOSTNAME", "CLUSTERNAME", "ZONE"}
+
 func init() {
 	initializeLogger()
 	// Use a goroutine to ensure loadDeploymentDetails()'s GCP API
@@ -21,20 +29,11 @@ func init() {
 
 func initializeLogger() {
 	log = logrus.New()
-	log.Level = logrus.DebugLevel
-	log.Formatter = &logrus.JSONFormatter{
-		FieldMap: logrus.FieldMap{
-			logrus.FieldKeyTime:  "timestamp",
-			logrus.FieldKeyLevel: "severity",
-			logrus.FieldKeyMsg:   "message",
-		},
-		TimestampFormat: time.RFC3339Nano,
-	}
 	log.Out = os.Stdout
+	configureLogging(log)
 }
 
 func loadDeploymentDetails() {
-	deploymentDetailsMap = make(map[string]string)
 	var metaServerClient = metadata.NewClient(&http.Client{})
 
 	podHostname, err := os.Hostname()
@@ -52,9 +51,13 @@ func loadDeploymentDetails() {
 		log.Error("Failed to fetch the Zone of the node where the pod is scheduled", err)
 	}
 
-	deploymentDetailsMap["HOSTNAME"] = podHostname
-	deploymentDetailsMap["CLUSTERNAME"] = podCluster
-	deploymentDetailsMap["ZONE"] = podZone
+	deploymentDetailsMu.Lock()
+	deploymentDetailsMap = map[string]string{
+		"HOSTNAME":    podHostname,
+		"CLUSTERNAME": podCluster,
+		"ZONE":        podZone,
+	}
+	deploymentDetailsMu.Unlock()
 
 	log.WithFields(logrus.Fields{
 		"cluster":  podCluster,
@@ -62,3 +65,28 @@ func loadDeploymentDetails() {
 		"hostname": podHostname,
 	}).Debug("Loaded deployment details")
 }
+
+// deploymentDetails returns a copy of the deployment details, empty until
+// loadDeploymentDetails has finished.
+func deploymentDetails() map[string]string {
+	deploymentDetailsMu.RLock()
+	defer deploymentDetailsMu.RUnlock()
+	details := make(map[string]string, len(deploymentDetailsMap))
+	for k, v := range deploymentDetailsMap {
+		details[k] = v
+	}
+	return details
+}
+
+// GET /api/deployment
+// Returns where this frontend runs (publicDeploymentDetails), for dashboards
+// and canary checks. Every key is present; details not loaded yet are empty.
+func (fe *frontendServer) apiDeployment(w http.ResponseWriter, r *http.Request) {
+	details := deploymentDetails()
+	out := make(map[string]string, len(publicDeploymentDetails))
+	for _, k := range publicDeploymentDetails {
+		out[k] = details[k]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIDeployment(t *testing.T) {
+	deploymentDetailsMu.Lock()
+	saved := deploymentDetailsMap
+	deploymentDetailsMap = map[string]string{
+		"HOSTNAME":    "frontend-7d9c",
+		"CLUSTERNAME": "online-boutique",
+		"ZONE":        "us-central1-a",
+		"PRIVATE":     "not for the API",
+	}
+	deploymentDetailsMu.Unlock()
+	t.Cleanup(func() {
+		deploymentDetailsMu.Lock()
+		deploymentDetailsMap = saved
+		deploymentDetailsMu.Unlock()
+	})
+
+	rec := httptest.NewRecorder()
+	newAPIRouter(&frontendServer{}).ServeHTTP(rec, newAPIRequest(http.MethodGet, "/api/deployment", ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range publicDeploymentDetails {
+		if _, ok := got[k]; !ok {
+			t.Errorf("response %v has no %s", got, k)
+		}
+	}
+	if _, ok := got["PRIVATE"]; ok {
+		t.Errorf("response %v exposes a detail that is not public", got)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"unicode"
+)
+
+/*
+Whether a customer service answer needs a human is decided by the agent when
+it says so: an "escalation" output, at the top level of an event or in its
+actions.stateDelta, shaped {"required": bool, "reason": string}. The last such
+output in the response wins; customer_service_agent publishes it from its
+resolution_status. Agents that do not return one fall back to a
+keyword heuristic over the answer text. ESCALATION_KEYWORDS overrides its
+comma-separated keywords; a keyword matches at the start of a word, and not
+right after a negation ("this is not complex").
+*/
+
+var defaultEscalationKeywords = []string{"escalate", "human", "complex"}
+
+var escalationKeywords = parseEscalationKeywords(os.Getenv("ESCALATION_KEYWORDS"))
+
+// escalationNegations are the words that, within the two words before a
+// keyword, stop it from counting.
+var escalationNegations = map[string]bool{
+	"no": true, "not": true, "never": true, "without": true, "nothing": true,
+	"isn't": true, "isnt": true, "don't": true, "dont": true, "doesn't": true,
+	"doesnt": true, "won't": true, "wont": true,
+}
+
+// escalationSignal is the structured escalation output of the customer
+// service agent.
+type escalationSignal struct {
+	Required bool   `json:"required"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func parseEscalationKeywords(v string) []string {
+	var keywords []string
+	for _, k := range strings.Split(v, ",") {
+		if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	if len(keywords) == 0 {
+		return defaultEscalationKeywords
+	}
+	return keywords
+}
+
+// agentEscalation returns the last escalation output in an agents-gateway
+// response body, and false if there is none.
+func agentEscalation(body []byte) (escalationSignal, bool) {
+	var events []adkEvent
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := json.Unmarshal(body, &events); err != nil {
+			return escalationSignal{}, false
+		}
+	} else {
+		var event adkEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return escalationSignal{}, false
+		}
+		events = []adkEvent{event}
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		var signal escalationSignal
+		if events[i].output("escalation", &signal) {
+			return signal, true
+		}
+	}
+	return escalationSignal{}, false
+}
+
+// escalationWords splits text into lower-cased words, keeping apostrophes.
+func escalationWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '’'
+	})
+}
+
+// mentionsEscalation reports whether message contains one of keywords that
+// is not negated.
+func mentionsEscalation(message string, keywords []string) bool {
+	words := escalationWords(strings.ReplaceAll(message, "’", "'"))
+	for _, keyword := range keywords {
+		kw := escalationWords(keyword)
+		if len(kw) == 0 {
+			continue
+		}
+	search:
+		for i := 0; i+len(kw) <= len(words); i++ {
+			for j, k := range kw {
+				w := words[i+j]
+				if j == len(kw)-1 && !strings.HasPrefix(w, k) || j < len(kw)-1 && w != k {
+					continue search
+				}
+			}
+			negated := false
+			for back := 1; back <= 2 && i-back >= 0; back++ {
+				if escalationNegations[words[i-back]] {
+					negated = true
+				}
+			}
+			if !negated {
+				return true
+			}
+		}
+	}
+	return false
+}
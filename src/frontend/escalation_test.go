@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMentionsEscalation(t *testing.T) {
+	tests := []struct {
+		message string
+		want    bool
+	}{
+		{"I'll escalate this to our support team.", true},
+		{"Let me connect you with a human agent.", true},
+		{"Your case is complex, so a specialist will follow up.", true},
+		{"Good news: this is not complex, your refund is on its way.", false},
+		{"No human review is needed for this return.", false},
+		{"There is nothing complex about this exchange.", false},
+		{"Your order shipped yesterday.", false},
+		{"Our humanitarian program ships free.", true}, // prefix match is deliberate
+	}
+	for _, tt := range tests {
+		if got := mentionsEscalation(tt.message, defaultEscalationKeywords); got != tt.want {
+			t.Errorf("mentionsEscalation(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+
+	if !mentionsEscalation("Je vais transférer votre demande à un conseiller.", parseEscalationKeywords("conseiller, transférer")) {
+		t.Error("configured keywords were not matched")
+	}
+	if got := parseEscalationKeywords(" , "); len(got) != len(defaultEscalationKeywords) {
+		t.Errorf("empty ESCALATION_KEYWORDS = %v, want the defaults", got)
+	}
+}
+
+func TestCustomerServiceEscalationSignal(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		want       bool
+		wantReason string
+	}{
+		{
+			"structured signal overrides wording",
+			`[{"content": {"parts": [{"text": "A human could help, but this is simple: your refund was issued."}]},
+			  "actions": {"stateDelta": {"escalation": {"required": false}}}}]`,
+			false, "",
+		},
+		{
+			"structured signal requests escalation",
+			`[{"content": {"parts": [{"text": "Let me look into that."}]}},
+			  {"actions": {"stateDelta": {"escalation": {"required": true, "reason": "damaged item"}}}}]`,
+			true, "damaged item",
+		},
+		{
+			"heuristic ignores negated keyword",
+			`[{"content": {"parts": [{"text": "This is not complex: the return label is in your email."}]}}]`,
+			false, "",
+		},
+		{
+			"heuristic without signal",
+			`[{"content": {"parts": [{"text": "I will escalate this to our team."}]}}]`,
+			true, "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/run" {
+					io.WriteString(w, tt.body)
+					return
+				}
+				io.WriteString(w, `{"id": "adk-session"}`)
+			}))
+			defer gateway.Close()
+			fe := &frontendServer{
+				agentsGatewayURL: gateway.URL,
+				flags:            featureFlags{CustomerService: true},
+			}
+
+			rec := httptest.NewRecorder()
+			fe.customerServiceHandler(rec, newTestRequest(http.MethodPost, "/api/customer-service", `{"type": "policy", "message": "Where is my refund?"}`))
+
+			var resp struct {
+				AgentPowered       bool   `json:"agent_powered"`
+				EscalationRequired bool   `json:"escalation_required"`
+				EscalationReason   string `json:"escalation_reason"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !resp.AgentPowered {
+				t.Fatalf("response did not come from the agent: %s", rec.Body.String())
+			}
+			if resp.EscalationRequired != tt.want || resp.EscalationReason != tt.wantReason {
+				t.Errorf("escalation = %v (%q), want %v (%q)", resp.EscalationRequired, resp.EscalationReason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCustomerServiceRejectsOversizedResponse(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/run" {
+			io.WriteString(w, `[{"content": {"parts": [{"text": "`+strings.Repeat("a", maxAssistantResponseBytes)+`"}]}}]`)
+			return
+		}
+		io.WriteString(w, `{"id": "adk-session"}`)
+	}))
+	defer gateway.Close()
+	fe := &frontendServer{
+		agentsGatewayURL: gateway.URL,
+		flags:            featureFlags{CustomerService: true},
+	}
+
+	rec := httptest.NewRecorder()
+	fe.customerServiceHandler(rec, newTestRequest(http.MethodPost, "/api/customer-service", `{"type": "policy", "message": "Where is my refund?"}`))
+
+	var resp struct {
+		AgentPowered bool `json:"agent_powered"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.AgentPowered {
+		t.Error("oversized agent response was used instead of the fallback")
+	}
+}
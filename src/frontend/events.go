@@ -0,0 +1,158 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	eventProductView = "product_view"
+	eventAddToCart   = "add_to_cart"
+
+	// eventQueueSize bounds the events waiting to be delivered by an
+	// httpEventSink; further events are dropped until it catches up.
+	eventQueueSize = 256
+	// eventDeliveryTimeout bounds each delivery to the event sink.
+	eventDeliveryTimeout = 5 * time.Second
+)
+
+// productEvent is an analytics event about a product. The session is only
+// identified by a hash, so events cannot be tied back to a cookie.
+type productEvent struct {
+	Type        string    `json:"type"`
+	ProductID   string    `json:"product_id"`
+	SessionHash string    `json:"session_hash"`
+	Currency    string    `json:"currency"`
+	Quantity    int32     `json:"quantity,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// eventEmitter publishes analytics events. emit must not block the request
+// serving the page.
+type eventEmitter interface {
+	emit(productEvent)
+}
+
+// noopEmitter discards every event. It is used when no sink is configured.
+type noopEmitter struct{}
+
+func (noopEmitter) emit(productEvent) {}
+
+// httpEventSink POSTs each event as JSON to an HTTP endpoint from a single
+// background goroutine. Events that do not fit in its queue are dropped.
+type httpEventSink struct {
+	url    string
+	client *http.Client
+	log    logrus.FieldLogger
+	queue  chan productEvent
+}
+
+// newHTTPEventSink starts delivering events to url until ctx is done.
+func newHTTPEventSink(ctx context.Context, log logrus.FieldLogger, url string) *httpEventSink {
+	s := &httpEventSink{
+		url:    url,
+		client: upstreamClient,
+		log:    log.WithField("task", "event_sink"),
+		queue:  make(chan productEvent, eventQueueSize),
+	}
+	go s.run(ctx)
+	return s
+}
+
+func (s *httpEventSink) emit(e productEvent) {
+	select {
+	case s.queue <- e:
+	default:
+		s.log.WithField("type", e.Type).Warn("event queue full, dropping event")
+	}
+}
+
+func (s *httpEventSink) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-s.queue:
+			if err := s.deliver(ctx, e); err != nil {
+				s.log.WithField("error", err).WithField("type", e.Type).Warn("failed to deliver event")
+			}
+		}
+	}
+}
+
+func (s *httpEventSink) deliver(ctx context.Context, e productEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, eventDeliveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// newEventEmitter returns the emitter for EVENT_SINK_URL: an httpEventSink if
+// it is set, otherwise a noopEmitter.
+func newEventEmitter(ctx context.Context, log logrus.FieldLogger, url string) eventEmitter {
+	if url == "" {
+		return noopEmitter{}
+	}
+	log.WithField("url", url).Info("emitting product events")
+	return newHTTPEventSink(ctx, log, url)
+}
+
+// hashSessionID returns the hex SHA-256 of a session ID.
+func hashSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// emitProductEvent emits an event of the given type for the request's
+// session and currency. Servers without an emitter emit nothing.
+func (fe *frontendServer) emitProductEvent(r *http.Request, eventType, productID string, quantity int32) {
+	if fe.events == nil {
+		return
+	}
+	fe.events.emit(productEvent{
+		Type:        eventType,
+		ProductID:   productID,
+		SessionHash: hashSessionID(sessionID(r)),
+		Currency:    currentCurrency(r),
+		Quantity:    quantity,
+		Timestamp:   time.Now().UTC(),
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+// recordingEmitter keeps the events it is given.
+type recordingEmitter struct {
+	mu     sync.Mutex
+	events []productEvent
+}
+
+func (e *recordingEmitter) emit(ev productEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, ev)
+}
+
+func TestNewEventEmitterDefaultsToNoop(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+	if got := newEventEmitter(context.Background(), log, ""); got != (noopEmitter{}) {
+		t.Errorf("newEventEmitter without a URL = %T, want noopEmitter", got)
+	}
+
+	// A server without an emitter drops events.
+	fe := &frontendServer{}
+	fe.emitProductEvent(newTestRequest(http.MethodGet, "/product/A", ""), eventProductView, "A", 0)
+}
+
+func TestAddToCartEmitsEvents(t *testing.T) {
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, &fakeCartService{})
+		pb.RegisterProductCatalogServiceServer(s, &countingProductCatalog{})
+	})
+	events := &recordingEmitter{}
+	fe := &frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn, events: events}
+
+	req := newTestRequest(http.MethodPost, "/cart", "product_id=OLJCESPC7Z&quantity=2")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	fe.addToCartHandler(httptest.NewRecorder(), req)
+	fe.apiAddToCart(httptest.NewRecorder(), newTestRequest(http.MethodPost, "/api/cart/add", `{"productId": "66VCHSJNUP", "quantity": 1}`))
+
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if len(events.events) != 2 {
+		t.Fatalf("got %d events, want 2: %+v", len(events.events), events.events)
+	}
+	for i, want := range []struct {
+		productID string
+		quantity  int32
+	}{{"OLJCESPC7Z", 2}, {"66VCHSJNUP", 1}} {
+		e := events.events[i]
+		if e.Type != eventAddToCart || e.ProductID != want.productID || e.Quantity != want.quantity {
+			t.Errorf("event %d = %+v, want add_to_cart of %d x %s", i, e, want.quantity, want.productID)
+		}
+		if e.SessionHash != hashSessionID("test-session") || e.SessionHash == "test-session" {
+			t.Errorf("event %d session hash = %q", i, e.SessionHash)
+		}
+		if e.Currency == "" || e.Timestamp.IsZero() {
+			t.Errorf("event %d is missing currency or timestamp: %+v", i, e)
+		}
+	}
+}
+
+func TestHTTPEventSinkDelivers(t *testing.T) {
+	received := make(chan productEvent, 1)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e productEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("decoding event: %v", err)
+		}
+		received <- e
+	}))
+	defer sink.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := logrus.New()
+	log.Out = io.Discard
+	emitter := newEventEmitter(ctx, log, sink.URL)
+	emitter.emit(productEvent{Type: eventProductView, ProductID: "A", SessionHash: hashSessionID("s"), Currency: "EUR"})
+
+	select {
+	case e := <-received:
+		if e.Type != eventProductView || e.ProductID != "A" || e.Currency != "EUR" {
+			t.Errorf("sink received %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+}
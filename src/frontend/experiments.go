@@ -0,0 +1,115 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// experimentBucket deterministically assigns sessionID to an arm of
+// experimentName, arm i being chosen for a weights[i]/sum(weights) share of
+// sessions. The experiment name is part of the hash, so a session's arms in
+// different experiments are independent. Without a positive total weight
+// every session is in arm 0.
+//
+// The unnamed experiment hashes the session ID alone; with weights summing
+// to 100 it reproduces the original AGENT_MIGRATION_PERCENT buckets.
+func experimentBucket(sessionID, experimentName string, weights []int) int {
+	total := 0
+	for _, w := range weights {
+		if w > 0 {
+			total += w
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+
+	hash := fnv.New32a()
+	if experimentName != "" {
+		hash.Write([]byte(experimentName + "/"))
+	}
+	hash.Write([]byte(sessionID))
+	point := int(hash.Sum32() % uint32(total))
+	for arm, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		if point < w {
+			return arm
+		}
+		point -= w
+	}
+	return len(weights) - 1 // not reached
+}
+
+/*
+loadExperiments reads the arm weights of each experiment from EXPERIMENTS,
+e.g.
+
+	EXPERIMENTS="assistant_prompt=34:33:33,cart_layout=90:10"
+*/
+func loadExperiments(log logrus.FieldLogger) map[string][]int {
+	experiments := map[string][]int{}
+	for _, entry := range strings.Split(os.Getenv("EXPERIMENTS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, arms, _ := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		weights, ok := parseWeights(arms)
+		if name == "" || !ok {
+			log.Warnf("ignoring invalid EXPERIMENTS entry %q", entry)
+			continue
+		}
+		experiments[name] = weights
+	}
+	return experiments
+}
+
+func parseWeights(s string) ([]int, bool) {
+	var weights []int
+	total := 0
+	for _, field := range strings.Split(s, ":") {
+		w, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || w < 0 {
+			return nil, false
+		}
+		weights = append(weights, w)
+		total += w
+	}
+	return weights, len(weights) >= 2 && total > 0
+}
+
+// experimentAssistantPrompt chooses the shopping assistant's prompt variant:
+// the arm is sent to the agent as the "assistant_prompt_variant" session
+// state.
+const experimentAssistantPrompt = "assistant_prompt"
+
+// experimentArm returns the arm of the named experiment the session is in.
+// Sessions are in arm 0, the control, of experiments that are not configured.
+func (fe *frontendServer) experimentArm(sessionID, name string) int {
+	weights, ok := fe.experiments[name]
+	if !ok {
+		return 0
+	}
+	return experimentBucket(sessionID, name, weights)
+}
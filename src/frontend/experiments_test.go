@@ -0,0 +1,138 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestExperimentBucketStable(t *testing.T) {
+	weights := []int{34, 33, 33}
+	for i := 0; i < 100; i++ {
+		session := fmt.Sprintf("session-%d", i)
+		arm := experimentBucket(session, "assistant_prompt", weights)
+		for j := 0; j < 3; j++ {
+			if got := experimentBucket(session, "assistant_prompt", weights); got != arm {
+				t.Fatalf("%s: arm %d, then %d", session, arm, got)
+			}
+		}
+	}
+}
+
+func TestExperimentBucketDistribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+	}{
+		{"three arms", []int{50, 30, 20}},
+		{"uneven total", []int{1, 2}},
+		{"disabled arm", []int{70, 0, 30}},
+	}
+	const sessions = 30000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := make([]int, len(tt.weights))
+			for i := 0; i < sessions; i++ {
+				counts[experimentBucket(fmt.Sprintf("session-%d", i), "exp", tt.weights)]++
+			}
+			total := 0
+			for _, w := range tt.weights {
+				total += w
+			}
+			for arm, w := range tt.weights {
+				want := float64(sessions) * float64(w) / float64(total)
+				if diff := float64(counts[arm]) - want; diff > 0.02*sessions || diff < -0.02*sessions {
+					t.Errorf("arm %d got %d sessions, want about %.0f", arm, counts[arm], want)
+				}
+				if w == 0 && counts[arm] != 0 {
+					t.Errorf("arm %d has weight 0 but got %d sessions", arm, counts[arm])
+				}
+			}
+		})
+	}
+}
+
+func TestExperimentBucketMatchesMigrationRollout(t *testing.T) {
+	for _, percent := range []int{1, 25, 50, 99} {
+		for i := 0; i < 1000; i++ {
+			session := fmt.Sprintf("session-%d", i)
+			hash := fnv.New32a()
+			hash.Write([]byte(session))
+			want := int(hash.Sum32()%100) < percent
+			if got := experimentBucket(session, "", []int{percent, 100 - percent}) == 0; got != want {
+				t.Fatalf("percent %d, %s: in rollout = %v, want %v", percent, session, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadExperiments(t *testing.T) {
+	t.Setenv("EXPERIMENTS", "assistant_prompt=34:33:33, cart_layout = 90:10,single=100,bad=a:b,=50:50,empty=0:0")
+	logger := logrus.New()
+	logger.Out = io.Discard
+
+	got := loadExperiments(logger)
+	want := map[string][]int{
+		"assistant_prompt": {34, 33, 33},
+		"cart_layout":      {90, 10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadExperiments = %v, want %v", got, want)
+	}
+
+	fe := &frontendServer{experiments: got}
+	if arm := fe.experimentArm("session", "unknown"); arm != 0 {
+		t.Errorf("unconfigured experiment arm = %d, want 0", arm)
+	}
+}
+
+// TestAssistantPromptExperiment checks that the assistant sends the
+// session's arm of the assistant_prompt experiment to the agent.
+func TestAssistantPromptExperiment(t *testing.T) {
+	var forwarded SearchRequest
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/run" {
+			json.NewDecoder(r.Body).Decode(&forwarded)
+			io.WriteString(w, `{"content": {"parts": [{"text": "hi"}]}}`)
+			return
+		}
+		io.WriteString(w, `{"id": "adk-session"}`)
+	}))
+	defer gateway.Close()
+
+	weights := []int{0, 0, 1}
+	fe := &frontendServer{
+		agentsGatewayURL: gateway.URL,
+		experiments:      map[string][]int{experimentAssistantPrompt: weights},
+	}
+	req := newTestRequest(http.MethodPost, "/bot", `{"message": "mugs"}`)
+	fe.handleChatWithAgents(httptest.NewRecorder(), req, req.Context().Value(ctxKeyLog{}).(logrus.FieldLogger))
+
+	if got, _ := forwarded.StateDelta["assistant_prompt_variant"].(float64); got != 2 {
+		t.Errorf("assistant_prompt_variant = %v, want 2", forwarded.StateDelta["assistant_prompt_variant"])
+	}
+	if arm := (&frontendServer{}).experimentArm("test-session", experimentAssistantPrompt); arm != 0 {
+		t.Errorf("unconfigured experiment arm = %d, want 0", arm)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// featureFlags is the single source of truth for agent features. It is
+// computed once at startup, served by featureFlagsHandler and consulted by
+// the handlers that gate behavior on it.
+type featureFlags struct {
+	// Search features
+	AgentSearch       bool `json:"agent_search_enabled"`
+	SearchSuggestions bool `json:"search_suggestions_enabled"`
+	SearchAnalytics   bool `json:"search_analytics_enabled"`
+
+	// Shopping assistant features
+	AgentAssistant        bool `json:"agent_assistant_enabled"`
+	HybridAssistantMode   bool `json:"hybrid_assistant_mode"` // Use both old and new systems
+	SessionContinuity     bool `json:"assistant_session_continuity"`
+	AssistantPersonalize  bool `json:"assistant_personalization"`
+	AssistantMultimodal   bool `json:"assistant_multimodal"` // Support image + text
+	UseAgentsGateway      bool `json:"use_agents_gateway"`
+	AgentMigrationPercent int  `json:"agent_migration_percent"`
+
+	// Recommendation features
+	AgentRecommendations  bool `json:"agent_recommendations_enabled"`
+	EnhancedProductCards  bool `json:"enhanced_product_cards"`
+	ContextualSuggestions bool `json:"contextual_suggestions"`
+	ValidateAgentProducts bool `json:"validate_agent_products"` // Drop agent products missing from the catalog
+
+	// Cart and checkout features
+	SmartAddToCart      bool `json:"smart_add_to_cart_enabled"`
+	CartRecommendations bool `json:"cart_recommendations_enabled"`
+	CheckoutAssistance  bool `json:"checkout_assistance_enabled"`
+	IntelligentQuantity bool `json:"intelligent_quantity_suggest"`
+	CartOptimization    bool `json:"cart_optimization_enabled"`
+
+	// Customer service features
+	CustomerService     bool `json:"customer_service_enabled"`
+	AIOrderTracking     bool `json:"ai_order_tracking_enabled"`
+	AIReturnsProcessing bool `json:"ai_returns_processing_enabled"`
+	PolicyAssistance    bool `json:"policy_assistance_enabled"`
+	SupportEscalation   bool `json:"support_escalation_enabled"`
+	ChatSupport         bool `json:"chat_support_enabled"`
+}
+
+func defaultFeatureFlags() featureFlags {
+	return featureFlags{
+		AgentSearch:           true,
+		SearchSuggestions:     true,
+		AgentAssistant:        true,
+		HybridAssistantMode:   true,
+		SessionContinuity:     true,
+		AssistantPersonalize:  true,
+		AssistantMultimodal:   true,
+		AgentRecommendations:  true,
+		EnhancedProductCards:  true,
+		ContextualSuggestions: true,
+		ValidateAgentProducts: true,
+		SmartAddToCart:        true,
+		CartRecommendations:   true,
+		CheckoutAssistance:    true,
+		IntelligentQuantity:   true,
+		CartOptimization:      true,
+		CustomerService:       true,
+		AIOrderTracking:       true,
+		AIReturnsProcessing:   true,
+		PolicyAssistance:      true,
+		SupportEscalation:     true,
+		ChatSupport:           true,
+	}
+}
+
+// boolFlags maps the JSON name of each boolean flag to its field, so single
+// flags can be overridden by name.
+func (f *featureFlags) boolFlags() map[string]*bool {
+	return map[string]*bool{
+		"agent_search_enabled":          &f.AgentSearch,
+		"search_suggestions_enabled":    &f.SearchSuggestions,
+		"search_analytics_enabled":      &f.SearchAnalytics,
+		"agent_assistant_enabled":       &f.AgentAssistant,
+		"hybrid_assistant_mode":         &f.HybridAssistantMode,
+		"assistant_session_continuity":  &f.SessionContinuity,
+		"assistant_personalization":     &f.AssistantPersonalize,
+		"assistant_multimodal":          &f.AssistantMultimodal,
+		"use_agents_gateway":            &f.UseAgentsGateway,
+		"agent_recommendations_enabled": &f.AgentRecommendations,
+		"enhanced_product_cards":        &f.EnhancedProductCards,
+		"contextual_suggestions":        &f.ContextualSuggestions,
+		"validate_agent_products":       &f.ValidateAgentProducts,
+		"smart_add_to_cart_enabled":     &f.SmartAddToCart,
+		"cart_recommendations_enabled":  &f.CartRecommendations,
+		"checkout_assistance_enabled":   &f.CheckoutAssistance,
+		"intelligent_quantity_suggest":  &f.IntelligentQuantity,
+		"cart_optimization_enabled":     &f.CartOptimization,
+		"customer_service_enabled":      &f.CustomerService,
+		"ai_order_tracking_enabled":     &f.AIOrderTracking,
+		"ai_returns_processing_enabled": &f.AIReturnsProcessing,
+		"policy_assistance_enabled":     &f.PolicyAssistance,
+		"support_escalation_enabled":    &f.SupportEscalation,
+		"chat_support_enabled":          &f.ChatSupport,
+	}
+}
+
+/*
+loadFeatureFlags computes the flags from the environment. The coarse switches
+(AGENT_SEARCH_DISABLED, SMART_CART_DISABLED, ...) turn off groups of flags;
+FEATURE_FLAGS then overrides individual flags by name, e.g.
+
+	FEATURE_FLAGS="search_analytics_enabled=true,cart_optimization_enabled=false"
+*/
+func loadFeatureFlags(log logrus.FieldLogger) featureFlags {
+	f := defaultFeatureFlags()
+
+	f.UseAgentsGateway = os.Getenv("USE_AGENTS_GATEWAY") == "true"
+	if percent := os.Getenv("AGENT_MIGRATION_PERCENT"); percent != "" {
+		f.AgentMigrationPercent, _ = strconv.Atoi(percent)
+	}
+
+	if os.Getenv("AGENT_SEARCH_DISABLED") == "true" {
+		f.AgentSearch = false
+	}
+	if os.Getenv("AGENT_ASSISTANT_DISABLED") == "true" || os.Getenv("ASSISTANT_LEGACY_ONLY") == "true" {
+		f.AgentAssistant = false
+		f.HybridAssistantMode = false
+	}
+	if os.Getenv("SMART_CART_DISABLED") == "true" {
+		f.SmartAddToCart = false
+		f.CartRecommendations = false
+		f.IntelligentQuantity = false
+	}
+	if os.Getenv("CHECKOUT_AGENTS_DISABLED") == "true" {
+		f.CheckoutAssistance = false
+		f.CartOptimization = false
+	}
+	if os.Getenv("CUSTOMER_SERVICE_DISABLED") == "true" {
+		f.CustomerService = false
+		f.AIOrderTracking = false
+		f.AIReturnsProcessing = false
+		f.PolicyAssistance = false
+		f.SupportEscalation = false
+		f.ChatSupport = false
+	}
+
+	if overrides := os.Getenv("FEATURE_FLAGS"); overrides != "" {
+		fields := f.boolFlags()
+		for _, kv := range strings.Split(overrides, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(kv), "=")
+			field, ok := fields[name]
+			enabled, err := strconv.ParseBool(value)
+			if !ok || err != nil {
+				log.Warnf("ignoring invalid FEATURE_FLAGS entry %q", kv)
+				continue
+			}
+			*field = enabled
+		}
+	}
+	return f
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFeatureFlagsMatchGating(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"defaults", nil},
+		{"gateway enabled", map[string]string{"USE_AGENTS_GATEWAY": "true"}},
+		{"assistant disabled", map[string]string{"USE_AGENTS_GATEWAY": "true", "AGENT_ASSISTANT_DISABLED": "true"}},
+		{"smart cart disabled", map[string]string{"SMART_CART_DISABLED": "true"}},
+		{"single flag override", map[string]string{"FEATURE_FLAGS": "smart_add_to_cart_enabled=false, use_agents_gateway=true"}},
+	}
+	logger := logrus.New()
+	logger.Out = io.Discard
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fe := &frontendServer{flags: loadFeatureFlags(logger)}
+
+			rec := httptest.NewRecorder()
+			fe.featureFlagsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/feature-flags", nil))
+			var flags map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &flags); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := flags["smart_add_to_cart_enabled"], fe.shouldUseSmartCart(); got != want {
+				t.Errorf("smart_add_to_cart_enabled = %v, gating says %v", got, want)
+			}
+			assistant := flags["agent_assistant_enabled"] == true && flags["use_agents_gateway"] == true
+			if want := fe.shouldUseAgentsGateway("session"); assistant != want {
+				t.Errorf("agent_assistant_enabled && use_agents_gateway = %v, gating says %v", assistant, want)
+			}
+		})
+	}
+}
+
+func TestLoadFeatureFlagsOverride(t *testing.T) {
+	t.Setenv("SMART_CART_DISABLED", "true")
+	t.Setenv("FEATURE_FLAGS", "cart_recommendations_enabled=true,search_analytics_enabled=1,unknown=true,chat_support_enabled=maybe")
+	logger := logrus.New()
+	logger.Out = io.Discard
+
+	f := loadFeatureFlags(logger)
+	if f.SmartAddToCart {
+		t.Error("SmartAddToCart should stay disabled by SMART_CART_DISABLED")
+	}
+	if !f.CartRecommendations || !f.SearchAnalytics {
+		t.Errorf("overrides not applied: %+v", f)
+	}
+	if !f.ChatSupport {
+		t.Error("invalid override value changed chat_support_enabled")
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipMinSize is the smallest body worth compressing; below it the gzip
+// framing outweighs the savings.
+const gzipMinSize = 1024
+
+// incompressibleTypes are content types that are already compressed or must
+// reach the client unbuffered.
+var incompressibleTypes = map[string]bool{
+	"text/event-stream":        true,
+	"application/zip":          true,
+	"application/gzip":         true,
+	"application/x-gzip":       true,
+	"application/octet-stream": true,
+	"font/woff":                true,
+	"font/woff2":               true,
+}
+
+// gzipResponses compresses response bodies for clients that accept gzip.
+// Bodies are buffered until gzipMinSize bytes are written, so small responses,
+// already-encoded content and streamed responses (which flush early) are sent
+// as-is.
+func gzipResponses(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" ||
+			strings.Contains(r.Header.Get("Accept"), "text/event-stream") ||
+			!acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func shouldCompress(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	if incompressibleTypes[mediaType] {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return mediaType == "image/svg+xml"
+	case strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "audio/"):
+		return false
+	}
+	return true
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	started bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(statusCode int) {
+	if w.started || w.status != 0 {
+		return
+	}
+	w.status = statusCode
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < gzipMinSize {
+			return len(p), nil
+		}
+		return len(p), w.start()
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// start decides whether to compress, writes the headers and sends whatever
+// has been buffered so far.
+func (w *gzipResponseWriter) start() error {
+	w.started = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// Sniff before compressing, net/http would sniff the gzip bytes.
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if len(w.buf) >= gzipMinSize && shouldCompress(h) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends buffered data right away. A flush before gzipMinSize bytes have
+// been written marks a streamed response, which is then left uncompressed.
+func (w *gzipResponseWriter) Flush() {
+	if !w.started {
+		w.start()
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *gzipResponseWriter) close() error {
+	if !w.started {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGzipResponses(t *testing.T) {
+	large := `{"products":[` + strings.Repeat(`{"id":"OLJCESPC7Z","name":"Sunglasses"},`, 100) + `{}]}`
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		accept         string
+		contentType    string
+		encoding       string
+		body           string
+		wantGzip       bool
+	}{
+		{"json", "gzip, deflate", "", "application/json", "", large, true},
+		{"html sniffed", "gzip", "", "", "", "<html>" + large + "</html>", true},
+		{"no accept-encoding", "", "", "application/json", "", large, false},
+		{"gzip refused", "gzip;q=0", "", "application/json", "", large, false},
+		{"small body", "gzip", "", "application/json", "", `{"count":1}`, false},
+		{"image", "gzip", "", "image/png", "", large, false},
+		{"already encoded", "gzip", "", "application/json", "br", large, false},
+		{"event stream", "gzip", "", "text/event-stream", "", large, false},
+		{"event stream request", "gzip", "text/event-stream", "application/json", "", large, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := gzipResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				w.WriteHeader(http.StatusCreated)
+				// Write in chunks to exercise buffering across writes.
+				for i := 0; i < len(tt.body); i += 100 {
+					io.WriteString(w, tt.body[i:min(i+100, len(tt.body))])
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusCreated {
+				t.Errorf("status = %d, want %d", rr.Code, http.StatusCreated)
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			gotGzip := rr.Header().Get("Content-Encoding") == "gzip"
+			if gotGzip != tt.wantGzip {
+				t.Fatalf("gzip = %v, want %v", gotGzip, tt.wantGzip)
+			}
+
+			body := rr.Body.String()
+			if gotGzip {
+				if cl := rr.Header().Get("Content-Length"); cl != "" {
+					t.Errorf("Content-Length = %q on a gzip response", cl)
+				}
+				zr, err := gzip.NewReader(rr.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(b)
+			}
+			if body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestGzipResponsesFlushStreamsUncompressed(t *testing.T) {
+	handler := gzipResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, strings.Repeat("x", 2*gzipMinSize))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Content-Encoding") != "" {
+		t.Errorf("flushed response was compressed")
+	}
+	if !rr.Flushed {
+		t.Error("flush was not passed through")
+	}
+	if want := "data: 1\n\n" + strings.Repeat("x", 2*gzipMinSize); rr.Body.String() != want {
+		t.Errorf("unexpected body of %d bytes", rr.Body.Len())
+	}
+}
@@ -19,12 +19,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"hash/fnv"
-	"html/template"
 	"io"
+	"math"
 	"math/rand"
-	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -33,6 +32,8 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
 	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/money"
@@ -48,12 +49,7 @@ var (
 	frontendMessage  = strings.TrimSpace(os.Getenv("FRONTEND_MESSAGE"))
 	isCymbalBrand    = "true" == strings.ToLower(os.Getenv("CYMBAL_BRANDING"))
 	assistantEnabled = "true" == strings.ToLower(os.Getenv("ENABLE_ASSISTANT"))
-	templates        = template.Must(template.New("").
-				Funcs(template.FuncMap{
-			"renderMoney":        renderMoney,
-			"renderCurrencyLogo": renderCurrencyLogo,
-		}).ParseGlob("templates/*.html"))
-	plat platformDetails
+	templates        = mustLoadTemplates("templates/*.html", "true" == strings.ToLower(os.Getenv("DEV_MODE")), pageTemplates...)
 )
 
 var validEnvs = []string{"local", "gcp", "azure", "aws", "onprem", "alibaba"}
@@ -78,43 +74,40 @@ func (fe *frontendServer) homeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type productView struct {
-		Item  *pb.Product
-		Price *pb.Money
+		Item            *pb.Product
+		Price           *pb.Money
+		OnSale          bool
+		SalePrice       *pb.Money
+		DiscountPercent int
+		LowStock        bool
+	}
+	// Sale prices are converted in the same batch, after the regular prices.
+	amounts := productPrices(products)
+	for _, p := range products {
+		if isOnSale(p) {
+			amounts = append(amounts, p.GetSalePriceUsd())
+		}
+	}
+	prices, err := fe.localizePrices(r.Context(), log, amounts, currentCurrency(r))
+	if err != nil {
+		renderHTTPError(log, r, w, errors.Wrap(err, "failed to do currency conversion"), http.StatusInternalServerError)
+		return
 	}
 	ps := make([]productView, len(products))
+	salePrices := prices[len(products):]
 	for i, p := range products {
-		price, err := fe.convertCurrency(r.Context(), p.GetPriceUsd(), currentCurrency(r))
-		if err != nil {
-			renderHTTPError(log, r, w, errors.Wrapf(err, "failed to do currency conversion for product %s", p.GetId()), http.StatusInternalServerError)
-			return
+		ps[i] = productView{Item: p, Price: prices[i], LowStock: isLowStock(p)}
+		if isOnSale(p) {
+			ps[i].OnSale, ps[i].SalePrice, ps[i].DiscountPercent = true, salePrices[0], discountPercent(p)
+			salePrices = salePrices[1:]
 		}
-		ps[i] = productView{p, price}
 	}
 
-	// Set ENV_PLATFORM (default to local if not set; use env var if set; otherwise detect GCP, which overrides env)_
-	var env = os.Getenv("ENV_PLATFORM")
-	// Only override from env variable if set + valid env
-	if env == "" || stringinSlice(validEnvs, env) == false {
-		fmt.Println("env platform is either empty or invalid")
-		env = "local"
-	}
-	// Autodetect GCP
-	addrs, err := net.LookupHost("metadata.google.internal.")
-	if err == nil && len(addrs) >= 0 {
-		log.Debugf("Detected Google metadata server: %v, setting ENV_PLATFORM to GCP.", addrs)
-		env = "gcp"
-	}
-
-	log.Debugf("ENV_PLATFORM is: %s", env)
-	plat = platformDetails{}
-	plat.setPlatformDetails(strings.ToLower(env))
-
 	if err := templates.ExecuteTemplate(w, "home", injectCommonTemplateData(r, map[string]interface{}{
 		"show_currency": true,
-		"currencies":    currencies,
+		"currencies":    displayCurrencies(currencies, currentCurrency(r)),
 		"products":      ps,
 		"cart_size":     cartSize(cart),
-		"banner_color":  os.Getenv("BANNER_COLOR"), // illustrates canary deployments
 		"ad":            fe.chooseAd(r.Context(), []string{}, log),
 	})); err != nil {
 		log.Error(err)
@@ -155,25 +148,25 @@ func (fe *frontendServer) searchHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
+		prices, err := fe.localizePrices(r.Context(), log, productPrices(filteredProducts), currentCurrency(r))
+		if err != nil {
+			renderHTTPError(log, r, w, errors.Wrap(err, "failed to do currency conversion"), http.StatusInternalServerError)
+			return
+		}
+
 		// Convert to productView
 		ps = make([]productView, len(filteredProducts))
 		for i, p := range filteredProducts {
-			price, err := fe.convertCurrency(r.Context(), p.GetPriceUsd(), currentCurrency(r))
-			if err != nil {
-				renderHTTPError(log, r, w, errors.Wrapf(err, "failed to do currency conversion for product %s", p.GetId()), http.StatusInternalServerError)
-				return
-			}
-			ps[i] = productView{p, price}
+			ps[i] = productView{p, prices[i]}
 		}
 	}
 
 	if err := templates.ExecuteTemplate(w, "search", injectCommonTemplateData(r, map[string]interface{}{
 		"show_currency": true,
-		"currencies":    currencies,
+		"currencies":    displayCurrencies(currencies, currentCurrency(r)),
 		"products":      ps,
 		"query":         query,
 		"cart_size":     cartSize(cart),
-		"banner_color":  os.Getenv("BANNER_COLOR"),
 	})); err != nil {
 		log.Error(err)
 	}
@@ -203,7 +196,7 @@ func (plat *platformDetails) setPlatformDetails(env string) {
 
 func (fe *frontendServer) productHandler(w http.ResponseWriter, r *http.Request) {
 	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
-	id := mux.Vars(r)["id"]
+	id := normalizeProductID(mux.Vars(r)["id"])
 	if id == "" {
 		renderHTTPError(log, r, w, errors.New("product id not specified"), http.StatusBadRequest)
 		return
@@ -234,8 +227,11 @@ func (fe *frontendServer) productHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	fe.history.recordView(sessionID(r), p.GetId())
+	fe.emitProductEvent(r, eventProductView, p.GetId(), 0)
+
 	// ignores the error retrieving recommendations since it is not critical
-	recommendations, err := fe.getRecommendations(r.Context(), sessionID(r), []string{id})
+	recommendations, err := fe.pageRecommendations(r.Context(), sessionID(r), []string{id}, cart, 0)
 	if err != nil {
 		log.WithField("error", err).Warn("failed to get product recommendations")
 	}
@@ -245,24 +241,37 @@ func (fe *frontendServer) productHandler(w http.ResponseWriter, r *http.Request)
 		Price *pb.Money
 	}{p, price}
 
+	// A sale price that cannot be converted just hides the sale badge.
+	var salePrice *pb.Money
+	if isOnSale(p) {
+		salePrice, err = fe.convertCurrency(r.Context(), p.GetSalePriceUsd(), currentCurrency(r))
+		if err != nil {
+			log.WithField("error", err).Warn("failed to convert sale price")
+		}
+	}
+
 	// Fetch packaging info (weight/dimensions) of the product
 	// The packaging service is an optional microservice you can run as part of a Google Cloud demo.
 	var packagingInfo *PackagingInfo = nil
 	if isPackagingServiceConfigured() {
-		packagingInfo, err = httpGetPackagingInfo(id)
+		packagingInfo, err = httpGetPackagingInfo(r.Context(), log, id)
 		if err != nil {
-			fmt.Println("Failed to obtain product's packaging info:", err)
+			log.WithField("error", err).Warn("failed to obtain product's packaging info")
 		}
 	}
 
 	if err := templates.ExecuteTemplate(w, "product", injectCommonTemplateData(r, map[string]interface{}{
-		"ad":              fe.chooseAd(r.Context(), p.Categories, log),
-		"show_currency":   true,
-		"currencies":      currencies,
-		"product":         product,
-		"recommendations": recommendations,
-		"cart_size":       cartSize(cart),
-		"packagingInfo":   packagingInfo,
+		"ad":               fe.chooseAd(r.Context(), p.Categories, log),
+		"show_currency":    true,
+		"currencies":       displayCurrencies(currencies, currentCurrency(r)),
+		"product":          product,
+		"recommendations":  recommendations,
+		"cart_size":        cartSize(cart),
+		"packagingInfo":    packagingInfo,
+		"on_sale":          salePrice != nil,
+		"sale_price":       salePrice,
+		"discount_percent": discountPercent(p),
+		"low_stock":        isLowStock(p),
 	})); err != nil {
 		log.Println(err)
 	}
@@ -271,7 +280,7 @@ func (fe *frontendServer) productHandler(w http.ResponseWriter, r *http.Request)
 func (fe *frontendServer) addToCartHandler(w http.ResponseWriter, r *http.Request) {
 	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
 	quantity, _ := strconv.ParseUint(r.FormValue("quantity"), 10, 32)
-	productID := r.FormValue("product_id")
+	productID := normalizeProductID(r.FormValue("product_id"))
 	payload := validator.AddToCartPayload{
 		Quantity:  quantity,
 		ProductID: productID,
@@ -288,16 +297,28 @@ func (fe *frontendServer) addToCartHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if err := fe.checkCartLimits(r.Context(), sessionID(r), p.GetId(), int32(payload.Quantity)); err != nil {
+		var limitErr *cartLimitError
+		if errors.As(err, &limitErr) {
+			renderHTTPError(log, r, w, err, http.StatusUnprocessableEntity)
+		} else {
+			renderHTTPError(log, r, w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
 	// Add to cart first (preserve existing behavior)
 	if err := fe.insertCart(r.Context(), sessionID(r), p.GetId(), int32(payload.Quantity)); err != nil {
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to add to cart"), http.StatusInternalServerError)
 		return
 	}
+	fe.history.recordAdd(sessionID(r), p.GetId())
+	fe.emitProductEvent(r, eventAddToCart, p.GetId(), int32(payload.Quantity))
 
 	// Check if smart add-to-cart features are enabled
-	if fe.shouldUseSmartCart() {
+	if fe.shouldUseSmartCart() && fe.cartAnalysisDue(r.Context(), log, sessionID(r)) {
 		// Trigger agent-based cart analysis in background (don't block user)
-		go fe.analyzeCartWithAgent(r.Context(), sessionID(r), p, payload.Quantity)
+		fe.startCartAnalysis(r.Context(), log, sessionID(r), fe.resolveIdentity(r).UserID, p, payload.Quantity)
 	}
 
 	w.Header().Set("location", baseUrl+"/cart")
@@ -305,56 +326,28 @@ func (fe *frontendServer) addToCartHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (fe *frontendServer) shouldUseSmartCart() bool {
-	return os.Getenv("SMART_CART_DISABLED") != "true"
+	return fe.flags.SmartAddToCart
 }
 
-func (fe *frontendServer) analyzeCartWithAgent(ctx context.Context, sessionId string, product interface{}, quantity uint64) {
+func (fe *frontendServer) analyzeCartWithAgent(ctx context.Context, log logrus.FieldLogger, sessionId, userId string, product interface{}, quantity uint64) {
 	// This runs in background to provide intelligence without blocking the user
 	// We'll use this to populate recommendations and insights for the cart page
 
 	// Create a new context with timeout for this background operation
-	bgCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	bgCtx, cancel := context.WithTimeout(ctx, agentCartAnalysisTimeout)
 	defer cancel()
 
 	// Get current cart contents
 	cart, err := fe.getCart(bgCtx, sessionId)
 	if err != nil {
-		return // Fail silently for background operation
+		// Fail quietly, the user is not waiting for this
+		log.WithField("error", err).Debug("cart analysis: could not retrieve cart")
+		return
 	}
 
 	// Prepare agent request for cart analysis and ensure ADK session exists
-	userId := sessionId
-	agentGatewayBaseURL := "http://agents-gateway:80"
-	cacheKey := fmt.Sprintf("%s::%s", userId, fe.adkAppName)
-	fe.adkSessionsMu.RLock()
-	cachedSessionId, ok := fe.adkSessions[cacheKey]
-	fe.adkSessionsMu.RUnlock()
-	adkSessionId := cachedSessionId
-	if !ok || adkSessionId == "" {
-		// Create ADK session for this background analysis user/app
-		sessionURL := fmt.Sprintf("%s/apps/%s/users/%s/sessions", agentGatewayBaseURL, fe.adkAppName, userId)
-		sessionReqBody := map[string]string{
-			"appName": fe.adkAppName,
-			"userId":  userId,
-		}
-		sessionJSON, _ := json.Marshal(sessionReqBody)
-		client := &http.Client{Timeout: 10 * time.Second}
-		if resp, err := client.Post(sessionURL, "application/json", strings.NewReader(string(sessionJSON))); err == nil {
-			defer resp.Body.Close()
-			var sessionData map[string]interface{}
-			if json.NewDecoder(resp.Body).Decode(&sessionData) == nil {
-				if id, ok := sessionData["id"].(string); ok && id != "" {
-					adkSessionId = id
-					fe.adkSessionsMu.Lock()
-					fe.adkSessions[cacheKey] = id
-					fe.adkSessionsMu.Unlock()
-				}
-			}
-		}
-	}
-	if adkSessionId == "" {
-		adkSessionId = sessionId
-	}
+	appName := fe.agentApp(agentFeatureCart)
+	adkSessionId, _ := fe.ensureADKSession(bgCtx, appName, userId, sessionId)
 
 	// Build cart context for the agent
 	cartItems := make([]map[string]interface{}, len(cart))
@@ -366,7 +359,7 @@ func (fe *frontendServer) analyzeCartWithAgent(ctx context.Context, sessionId st
 	}
 
 	agentRequest := map[string]interface{}{
-		"appName":   fe.adkAppName,
+		"appName":   appName,
 		"userId":    userId,
 		"sessionId": adkSessionId,
 		"newMessage": map[string]interface{}{
@@ -380,20 +373,21 @@ func (fe *frontendServer) analyzeCartWithAgent(ctx context.Context, sessionId st
 	}
 
 	// Call agents-gateway for recommendations
-	agentGatewayURL := "http://agents-gateway:80/run"
+	agentGatewayURL := fe.agentsGatewayBaseURL() + "/run"
 	requestBody, _ := json.Marshal(agentRequest)
 
-	req, err := http.NewRequest(http.MethodPost, agentGatewayURL, strings.NewReader(string(requestBody)))
+	req, err := http.NewRequestWithContext(bgCtx, http.MethodPost, agentGatewayURL, strings.NewReader(string(requestBody)))
 	if err != nil {
-		return // Fail silently
+		log.WithField("error", err).Debug("cart analysis: could not create request")
+		return
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{Timeout: 10 * time.Second}
 
-	resp, err := client.Do(req)
+	resp, err := upstreamClient.Do(req)
 	if err != nil {
-		return // Fail silently
+		log.WithField("error", err).Debug("cart analysis: agents-gateway call failed")
+		return
 	}
 	defer resp.Body.Close()
 
@@ -401,7 +395,9 @@ func (fe *frontendServer) analyzeCartWithAgent(ctx context.Context, sessionId st
 	// This could be stored in Redis or a similar cache for the cart page to use
 	// For now, we'll just log it as a proof of concept
 	if resp.StatusCode == http.StatusOK {
-		fmt.Printf("Background cart analysis completed for session %s\n", sessionId)
+		log.Info("background cart analysis completed")
+	} else {
+		log.WithField("status", resp.StatusCode).Debug("cart analysis: agents-gateway returned an error")
 	}
 }
 
@@ -432,12 +428,12 @@ func (fe *frontendServer) viewCartHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// ignores the error retrieving recommendations since it is not critical
-	recommendations, err := fe.getRecommendations(r.Context(), sessionID(r), cartIDs(cart))
+	recommendations, err := fe.pageRecommendations(r.Context(), sessionID(r), cartIDs(cart), cart, fe.cartMaxRecommendations)
 	if err != nil {
 		log.WithField("error", err).Warn("failed to get product recommendations")
 	}
 
-	shippingCost, err := fe.getShippingQuote(r.Context(), cart, currentCurrency(r))
+	shipping, err := fe.estimateShippingCost(r.Context(), log, cart, currentCurrency(r))
 	if err != nil {
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to get shipping quote"), http.StatusInternalServerError)
 		return
@@ -448,37 +444,42 @@ func (fe *frontendServer) viewCartHandler(w http.ResponseWriter, r *http.Request
 		Quantity int32
 		Price    *pb.Money
 	}
+	products, err := fe.getCartProducts(r.Context(), cart)
+	if err != nil {
+		renderHTTPError(log, r, w, err, http.StatusInternalServerError)
+		return
+	}
+	// The cart total needs every price in the same currency, so there is
+	// no USD fallback here.
+	prices, err := fe.convertCurrencyBatch(r.Context(), unitPrices(products), currentCurrency(r))
+	if err != nil {
+		renderHTTPError(log, r, w, errors.Wrap(err, "could not convert currency"), http.StatusInternalServerError)
+		return
+	}
+
+	// The total is what checkout charges: the surcharge is only an estimate.
+	lineTotals, totalPrice, err := computeOrderTotal(cartOrderLines(cart, prices), shipping.Quote, currentCurrency(r))
+	if err != nil {
+		renderHTTPError(log, r, w, errors.Wrap(err, "could not compute cart total"), http.StatusInternalServerError)
+		return
+	}
 	items := make([]cartItemView, len(cart))
-	totalPrice := pb.Money{CurrencyCode: currentCurrency(r)}
 	for i, item := range cart {
-		p, err := fe.getProduct(r.Context(), item.GetProductId())
-		if err != nil {
-			renderHTTPError(log, r, w, errors.Wrapf(err, "could not retrieve product #%s", item.GetProductId()), http.StatusInternalServerError)
-			return
-		}
-		price, err := fe.convertCurrency(r.Context(), p.GetPriceUsd(), currentCurrency(r))
-		if err != nil {
-			renderHTTPError(log, r, w, errors.Wrapf(err, "could not convert currency for product #%s", item.GetProductId()), http.StatusInternalServerError)
-			return
-		}
-
-		multPrice := money.MultiplySlow(*price, uint32(item.GetQuantity()))
 		items[i] = cartItemView{
-			Item:     p,
+			Item:     products[i],
 			Quantity: item.GetQuantity(),
-			Price:    &multPrice}
-		totalPrice = money.Must(money.Sum(totalPrice, multPrice))
+			Price:    &lineTotals[i]}
 	}
-	totalPrice = money.Must(money.Sum(totalPrice, *shippingCost))
 	year := time.Now().Year()
 
 	if err := templates.ExecuteTemplate(w, "cart", injectCommonTemplateData(r, map[string]interface{}{
-		"currencies":       currencies,
+		"currencies":       displayCurrencies(currencies, currentCurrency(r)),
 		"recommendations":  recommendations,
 		"cart_size":        cartSize(cart),
-		"shipping_cost":    shippingCost,
+		"shipping_cost":    shipping.Quote,
+		"weight_surcharge": shipping.WeightSurcharge,
 		"show_currency":    true,
-		"total_cost":       totalPrice,
+		"total_cost":       &totalPrice,
 		"items":            items,
 		"expiration_years": []int{year, year + 1, year + 2, year + 3, year + 4},
 	})); err != nil {
@@ -520,23 +521,7 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	order, err := pb.NewCheckoutServiceClient(fe.checkoutSvcConn).
-		PlaceOrder(r.Context(), &pb.PlaceOrderRequest{
-			Email: payload.Email,
-			CreditCard: &pb.CreditCardInfo{
-				CreditCardNumber:          payload.CcNumber,
-				CreditCardExpirationMonth: int32(payload.CcMonth),
-				CreditCardExpirationYear:  int32(payload.CcYear),
-				CreditCardCvv:             int32(payload.CcCVV)},
-			UserId:       sessionID(r),
-			UserCurrency: currentCurrency(r),
-			Address: &pb.Address{
-				StreetAddress: payload.StreetAddress,
-				City:          payload.City,
-				State:         payload.State,
-				ZipCode:       int32(payload.ZipCode),
-				Country:       payload.Country},
-		})
+	order, err := fe.placeOrder(r.Context(), sessionID(r), currentCurrency(r), payload)
 	if err != nil {
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to complete the order"), http.StatusInternalServerError)
 		return
@@ -544,12 +529,12 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
 	log.WithField("order", order.GetOrder().GetOrderId()).Info("order placed")
 
 	order.GetOrder().GetItems()
-	recommendations, _ := fe.getRecommendations(r.Context(), sessionID(r), nil)
+	recommendations, _ := fe.getRecommendations(r.Context(), sessionID(r), nil, 0)
 
-	totalPaid := *order.GetOrder().GetShippingCost()
-	for _, v := range order.GetOrder().GetItems() {
-		multPrice := money.MultiplySlow(*v.GetCost(), uint32(v.GetItem().GetQuantity()))
-		totalPaid = money.Must(money.Sum(totalPaid, multPrice))
+	totalPaid, err := orderTotal(order.GetOrder(), currentCurrency(r))
+	if err != nil {
+		renderHTTPError(log, r, w, errors.Wrap(err, "could not compute order total"), http.StatusInternalServerError)
+		return
 	}
 
 	currencies, err := fe.getCurrencies(r.Context())
@@ -560,7 +545,7 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
 
 	if err := templates.ExecuteTemplate(w, "order", injectCommonTemplateData(r, map[string]interface{}{
 		"show_currency":   false,
-		"currencies":      currencies,
+		"currencies":      displayCurrencies(currencies, currentCurrency(r)),
 		"order":           order.GetOrder(),
 		"total_paid":      &totalPaid,
 		"recommendations": recommendations,
@@ -579,7 +564,7 @@ func (fe *frontendServer) assistantHandler(w http.ResponseWriter, r *http.Reques
 
 	if err := templates.ExecuteTemplate(w, "assistant", injectCommonTemplateData(r, map[string]interface{}{
 		"show_currency": false,
-		"currencies":    currencies,
+		"currencies":    displayCurrencies(currencies, currentCurrency(r)),
 	})); err != nil {
 		log.Println(err)
 	}
@@ -595,7 +580,7 @@ func (fe *frontendServer) supportHandler(w http.ResponseWriter, r *http.Request)
 
 	if err := templates.ExecuteTemplate(w, "support", injectCommonTemplateData(r, map[string]interface{}{
 		"show_currency": false,
-		"currencies":    currencies,
+		"currencies":    displayCurrencies(currencies, currentCurrency(r)),
 	})); err != nil {
 		log.Println(err)
 	}
@@ -614,19 +599,21 @@ func (fe *frontendServer) logoutHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (fe *frontendServer) getProductByID(w http.ResponseWriter, r *http.Request) {
-	id := mux.Vars(r)["ids"]
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	id := normalizeProductID(mux.Vars(r)["ids"])
 	if id == "" {
 		return
 	}
 
 	p, err := fe.getProduct(r.Context(), id)
 	if err != nil {
+		log.WithField("error", err).Warnf("could not retrieve product %s", id)
 		return
 	}
 
 	jsonData, err := json.Marshal(p)
 	if err != nil {
-		fmt.Println(err)
+		log.WithField("error", err).Error("could not encode product")
 		return
 	}
 
@@ -649,11 +636,6 @@ func (fe *frontendServer) chatBotHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (fe *frontendServer) handleChatWithAgents(w http.ResponseWriter, r *http.Request, log logrus.FieldLogger) {
-	type ChatRequest struct {
-		Message string `json:"message"`
-		Image   string `json:"image,omitempty"`
-	}
-
 	type ChatResponse struct {
 		Message     string                   `json:"message"`
 		Products    []map[string]interface{} `json:"products,omitempty"`
@@ -662,31 +644,23 @@ func (fe *frontendServer) handleChatWithAgents(w http.ResponseWriter, r *http.Re
 	}
 
 	// Parse request
-	var req ChatRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.WithField("error", err).Error("failed to decode chat request")
-		fe.legacyChatBotHandler(w, r)
+	req, err := decodeChatRequest(w, r)
+	if err != nil {
+		log.WithField("error", err).Warn("invalid chat request")
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
 		return
 	}
 
-	// Prepare agent parts
-	parts := []AgentPart{{Text: req.Message}}
-	if req.Image != "" && req.Image != "undefined" {
-		// Handle base64 image data
-		imageData := req.Image
-		if strings.Contains(imageData, ",") {
-			imageData = strings.Split(imageData, ",")[1]
-		}
-		// Note: We'd need to decode the base64 here for proper image handling
-		parts = append(parts, AgentPart{Text: "Image provided (processing not fully implemented)"})
-	}
-
 	// Use the same two-step process as search
-	userId := fe.getOrCreateUserId(r)
+	userId := fe.resolveIdentity(r).UserID
+
+	// The assistant picks its prompt variant from the session's arm.
+	arm := fe.experimentArm(userId, experimentAssistantPrompt)
+	log = log.WithField("assistant_prompt_arm", arm)
 
 	// Step 1: Create agent request using same pattern as search
 	searchReq := SearchRequest{
-		AppName:   fe.adkAppName,
+		AppName:   fe.agentApp(agentFeatureAssistant),
 		UserId:    userId,
 		SessionId: "", // Will be set after session creation
 		NewMessage: map[string]interface{}{
@@ -695,198 +669,58 @@ func (fe *frontendServer) handleChatWithAgents(w http.ResponseWriter, r *http.Re
 				{"text": req.Message},
 			},
 		},
+		StateDelta: map[string]interface{}{"assistant_prompt_variant": arm},
 	}
 
-	// Add image if provided
-	if req.Image != "" && req.Image != "undefined" {
-		imageData := req.Image
-		if strings.Contains(imageData, ",") {
-			imageData = strings.Split(imageData, ",")[1]
-		}
-		searchReq.NewMessage["parts"] = append(
-			searchReq.NewMessage["parts"].([]map[string]interface{}),
-			map[string]interface{}{
-				"inlineData": map[string]interface{}{
-					"data":     imageData,
-					"mimeType": "image/jpeg",
-				},
-			},
-		)
-	}
+	// Add the attached images
+	searchReq.NewMessage["parts"] = append(
+		searchReq.NewMessage["parts"].([]map[string]interface{}),
+		req.imageParts()...,
+	)
 
 	// Step 2: Use the same agents-gateway communication pattern as search
-	agentGatewayBaseURL := "http://agents-gateway:80"
-	client := &http.Client{Timeout: 30 * time.Second}
-
-	// Reuse ADK session per (userId, appName). Create only if absent.
-	cacheKey := fmt.Sprintf("%s::%s", searchReq.UserId, searchReq.AppName)
-	fe.adkSessionsMu.RLock()
-	cachedSessionId, ok := fe.adkSessions[cacheKey]
-	fe.adkSessionsMu.RUnlock()
-	if ok && cachedSessionId != "" {
-		log.WithFields(logrus.Fields{"user": searchReq.UserId, "app": searchReq.AppName, "session": cachedSessionId}).Info("Reusing ADK session")
-		searchReq.SessionId = cachedSessionId
-	} else {
-		// Create session with state seeded with user_id
-		sessionURL := fmt.Sprintf("%s/apps/%s/users/%s/sessions", agentGatewayBaseURL, fe.adkAppName, searchReq.UserId)
-		sessionReqBody := map[string]any{
-			"state": map[string]any{
-				"user_id": userId,
-			},
-		}
-		sessionJSON, _ := json.Marshal(sessionReqBody)
+	ctx, cancel := context.WithTimeout(r.Context(), agentChatTimeout)
+	defer cancel()
 
-		sessionResp, err := client.Post(sessionURL, "application/json", strings.NewReader(string(sessionJSON)))
+	// Continue the conversation the client asked for, otherwise reuse the
+	// ADK session per (userId, appName) and create one only if absent.
+	adkSessionId := strings.TrimSpace(req.SessionId)
+	if adkSessionId == "" {
+		adkSessionId, err = fe.ensureADKSession(ctx, searchReq.AppName, searchReq.UserId, "")
 		if err != nil {
 			log.WithField("error", err).Error("failed to create session with agents-gateway for assistant")
 			fe.legacyChatBotHandler(w, r)
 			return
 		}
-		defer sessionResp.Body.Close()
-
-		var sessionData map[string]interface{}
-		if err := json.NewDecoder(sessionResp.Body).Decode(&sessionData); err != nil {
-			log.WithField("error", err).Error("failed to parse session response for assistant")
-			fe.legacyChatBotHandler(w, r)
-			return
-		}
-
-		// Use and cache the session ID from the agents-gateway response
-		if sessionId, ok := sessionData["id"].(string); ok {
-			searchReq.SessionId = sessionId
-			fe.adkSessionsMu.Lock()
-			fe.adkSessions[cacheKey] = sessionId
-			fe.adkSessionsMu.Unlock()
-			log.WithFields(logrus.Fields{"user": searchReq.UserId, "app": searchReq.AppName, "session": sessionId}).Info("Created and cached ADK session")
-		}
 	}
+	searchReq.SessionId = adkSessionId
 
 	// Now make the actual assistant request (same as search)
-	agentGatewayURL := agentGatewayBaseURL + "/run"
-	requestJSON, _ := json.Marshal(searchReq)
-
-	log.WithField("request_body", string(requestJSON)).Info("Creating customer service request")
-	log.WithField("payload", string(requestJSON)).Info("Forwarding assistant request to agents-gateway")
-
-	agentReq, err := http.NewRequest(http.MethodPost, agentGatewayURL, strings.NewReader(string(requestJSON)))
-	if err != nil {
-		log.WithField("error", err).Error("failed to create agent request for assistant")
-		fe.legacyChatBotHandler(w, r)
-		return
-	}
-
-	agentReq.Header.Set("Content-Type", "application/json")
-	agentReq.Header.Set("Accept", "application/json")
-
-	// Execute the request
-	resp, err := client.Do(agentReq)
+	message, products, err := fe.runAgent(ctx, log, searchReq)
 	if err != nil {
+		if r.Context().Err() != nil {
+			// The browser went away; there is nobody to fall back for.
+			log.WithField("error", err).Info("assistant request cancelled by the client")
+			return
+		}
 		log.WithField("error", err).Error("assistant agent request failed")
 		fe.legacyChatBotHandler(w, r)
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.WithField("status", resp.StatusCode).Error("assistant agent returned error")
-		fe.legacyChatBotHandler(w, r)
-		return
-	}
-
-	// Read and parse agent response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.WithField("error", err).Error("failed to read assistant agent response")
-		fe.legacyChatBotHandler(w, r)
-		return
-	}
-
-	// Log full assistant agent response for observability
-	log.WithField("assistant_response_full", string(body)).Info("Assistant agent full response")
-
-	// Log response snippet for debugging
-	respSnippet := string(body)
-	if len(respSnippet) > 1000 {
-		respSnippet = respSnippet[:1000] + "..."
-	}
-	log.WithField("response_body", respSnippet).Info("Agent response received")
-
-	// Try to decode as object first, then as array if that fails
-	var agentResponse map[string]interface{}
-	if err := json.NewDecoder(strings.NewReader(string(body))).Decode(&agentResponse); err != nil {
-		// If object decode fails, try as array (agents-gateway sometimes returns arrays)
-		var arrayResponse []interface{}
-		if err2 := json.NewDecoder(strings.NewReader(string(body))).Decode(&arrayResponse); err2 != nil {
-			log.WithField("error", err).WithField("body", string(body)).Error("failed to decode assistant agent response as object or array")
-			fe.legacyChatBotHandler(w, r)
-			return
-		}
-		// First pass: scan all array elements for functionResponse with products
-		if len(arrayResponse) > 0 {
-			aggProducts := make([]map[string]interface{}, 0)
-			messageBuilder := strings.Builder{}
-			for _, elem := range arrayResponse {
-				obj, ok := elem.(map[string]interface{})
-				if !ok {
-					continue
-				}
-				if content, ok := obj["content"].(map[string]interface{}); ok {
-					if parts, ok := content["parts"].([]interface{}); ok {
-						for _, p := range parts {
-							if partMap, ok := p.(map[string]interface{}); ok {
-								if txt, ok := partMap["text"].(string); ok {
-									messageBuilder.WriteString(txt)
-									messageBuilder.WriteString(" ")
-								}
-								if funcResp, ok := partMap["functionResponse"].(map[string]interface{}); ok {
-									if resp, ok := funcResp["response"]; ok {
-										aggProducts = append(aggProducts, fe.extractProductsFromFunctionResponse(resp)...)
-									}
-								}
-							}
-						}
-					}
-				}
-			}
-			if len(aggProducts) > 0 {
-				msg := strings.TrimSpace(messageBuilder.String())
-				if msg == "" {
-					msg = "I found some products that might interest you!"
-				}
-				response := ChatResponse{Message: msg, Products: aggProducts, SessionId: userId, Suggestions: []string{}}
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(response)
-				log.WithField("products_count", len(aggProducts)).Info("Assistant request completed via agents-gateway (from array scan)")
-				return
-			}
 
-			// Fallback: convert array response to object format for parsing
-			// Prefer the LAST element; ADK often appends final state at the end
-			last := arrayResponse[len(arrayResponse)-1]
-			if objResp, ok := last.(map[string]interface{}); ok {
-				agentResponse = objResp
-			} else if first, ok := arrayResponse[0].(map[string]interface{}); ok {
-				// Fallback to first if last isn't an object
-				agentResponse = first
-			} else {
-				log.WithField("body", string(body)).Error("unexpected array response format from agent")
-				fe.legacyChatBotHandler(w, r)
-				return
-			}
-		} else {
-			log.Error("empty array response from agent")
-			fe.legacyChatBotHandler(w, r)
-			return
-		}
+	// The gateway only runs sessions of this user on this app, so a
+	// requested session that got an answer is safe to continue by default.
+	if req.SessionId != "" {
+		fe.pinADKSession(searchReq.AppName, searchReq.UserId, adkSessionId)
 	}
 
-	// Extract message and products from agent response
-	message, products := fe.parseAgentAssistantResponse(agentResponse)
+	products = fe.dropStaleProducts(r.Context(), log, products)
+	fe.convertProductPrices(r.Context(), log, products, currentCurrency(r))
 
 	response := ChatResponse{
 		Message:     message,
 		Products:    products,
-		SessionId:   userId,
+		SessionId:   adkSessionId,
 		Suggestions: []string{},
 	}
 
@@ -898,7 +732,7 @@ func (fe *frontendServer) handleChatWithAgents(w http.ResponseWriter, r *http.Re
 
 func (fe *frontendServer) shouldUseAgentAssistant() bool {
 	// Keep for backward compatibility, but prefer shouldUseAgentsGateway.
-	return fe.useAgentsGateway
+	return fe.flags.AgentAssistant && fe.flags.UseAgentsGateway
 }
 
 // Agent communication client
@@ -910,6 +744,8 @@ func (fe *frontendServer) callAgentsGateway(ctx context.Context, req AgentReques
 		return nil, err
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, agentChatTimeout)
+	defer cancel()
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, err
@@ -917,8 +753,7 @@ func (fe *frontendServer) callAgentsGateway(ctx context.Context, req AgentReques
 
 	httpReq.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(httpReq)
+	resp, err := upstreamClient.Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -934,15 +769,13 @@ func (fe *frontendServer) callAgentsGateway(ctx context.Context, req AgentReques
 
 // Fallback mechanism with gradual migration
 func (fe *frontendServer) shouldUseAgentsGateway(sessionID string) bool {
-	if !fe.useAgentsGateway {
+	if !fe.shouldUseAgentAssistant() {
 		return false
 	}
 
 	// Implement percentage-based rollout
-	if fe.migrationPercent > 0 {
-		hash := fnv.New32a()
-		hash.Write([]byte(sessionID))
-		return int(hash.Sum32()%100) < fe.migrationPercent
+	if percent := fe.flags.AgentMigrationPercent; percent > 0 {
+		return experimentBucket(sessionID, "", []int{percent, 100 - percent}) == 0
 	}
 
 	return true
@@ -976,11 +809,6 @@ func (fe *frontendServer) fallbackToLegacyServices(ctx context.Context, req Agen
 func (fe *frontendServer) enhancedChatBotHandler(w http.ResponseWriter, r *http.Request) {
 	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
 
-	type ChatRequest struct {
-		Message string `json:"message"`
-		Image   string `json:"image,omitempty"`
-	}
-
 	type ChatResponse struct {
 		Message     string                   `json:"message"`
 		Products    []map[string]interface{} `json:"products,omitempty"`
@@ -989,90 +817,43 @@ func (fe *frontendServer) enhancedChatBotHandler(w http.ResponseWriter, r *http.
 	}
 
 	// Parse the incoming request
-	var chatReq ChatRequest
-	if err := json.NewDecoder(r.Body).Decode(&chatReq); err != nil {
-		log.WithField("error", err).Error("failed to decode chat request")
-		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+	chatReq, err := decodeChatRequest(w, r)
+	if err != nil {
+		log.WithField("error", err).Warn("invalid chat request")
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
 		return
 	}
 
-	// Generate session ID for the user if not provided.
-	sessionId := fe.getOrCreateSessionId(r)
-	userId := fe.getOrCreateUserId(r)
-
-	// Ensure ADK session exists and reuse it for Vertex AI sessions.
-	agentGatewayBaseURL := "http://agents-gateway:80"
-	cacheKey := fmt.Sprintf("%s::%s", userId, fe.reAppName)
-	fe.adkSessionsMu.RLock()
-	cachedSessionId, ok := fe.adkSessions[cacheKey]
-	fe.adkSessionsMu.RUnlock()
-	adkSessionId := cachedSessionId
-	if !ok || adkSessionId == "" {
-		// Create or upsert ADK session using explicit browser sessionId
-		sessionURL := fmt.Sprintf("%s/apps/%s/users/%s/sessions/%s", agentGatewayBaseURL, fe.adkAppName, userId, sessionId)
-		sessionReqBody := map[string]any{
-			"state": map[string]any{
-				"user_id": userId,
-			},
-		}
-		sessionJSON, _ := json.Marshal(sessionReqBody)
-		client := &http.Client{Timeout: 30 * time.Second}
-		req, _ := http.NewRequest(http.MethodPost, sessionURL, strings.NewReader(string(sessionJSON)))
-		req.Header.Set("Content-Type", "application/json")
-		if _, err := client.Do(req); err == nil {
-			adkSessionId = sessionId
-			fe.adkSessionsMu.Lock()
-			fe.adkSessions[cacheKey] = adkSessionId
-			fe.adkSessionsMu.Unlock()
-		}
+	// The user as known to the agents, and the browser session.
+	id := fe.resolveIdentity(r)
+	sessionId, userId := id.SessionID, id.UserID
+
+	// Ensure ADK session exists and reuse it for Vertex AI sessions, falling
+	// back to the cookie session if it cannot be created.
+	appName := fe.agentApp(agentFeatureAssistant)
+	adkSessionId, err := fe.ensureADKSession(r.Context(), appName, userId, sessionId)
+	if err != nil {
+		log.WithField("error", err).Warn("failed to create ADK session, using browser session")
 	}
-	if adkSessionId == "" {
-		// Fall back to cookie session if ADK session creation failed
-		adkSessionId = sessionId
-	}
-
-	// Prepare agent request based on whether image is provided
-	var agentRequest map[string]interface{}
-
-	if chatReq.Image != "" && chatReq.Image != "undefined" {
-		// Multimodal request (text + image)
-		agentRequest = map[string]interface{}{
-			"appName":   fe.adkAppName,
-			"userId":    userId,
-			"sessionId": adkSessionId,
-			"newMessage": map[string]interface{}{
-				"role": "user",
-				"parts": []map[string]interface{}{
-					{"text": chatReq.Message},
-					{
-						"inlineData": map[string]interface{}{
-							"data":     strings.Split(chatReq.Image, ",")[1], // Remove data:image/... prefix
-							"mimeType": "image/jpeg",                         // Assume JPEG for now
-						},
-					},
-				},
-			},
-		}
-	} else {
-		// Text-only request
-		agentRequest = map[string]interface{}{
-			"appName":   fe.adkAppName,
-			"userId":    userId,
-			"sessionId": adkSessionId,
-			"newMessage": map[string]interface{}{
-				"role": "user",
-				"parts": []map[string]interface{}{
-					{"text": chatReq.Message},
-				},
-			},
-		}
+
+	// Prepare agent request: the text, followed by any attached images
+	agentRequest := map[string]interface{}{
+		"appName":   appName,
+		"userId":    userId,
+		"sessionId": adkSessionId,
+		"newMessage": map[string]interface{}{
+			"role":  "user",
+			"parts": append([]map[string]interface{}{{"text": chatReq.Message}}, chatReq.imageParts()...),
+		},
 	}
 
 	// Call agents-gateway
-	agentGatewayURL := "http://agents-gateway:80/run"
+	agentGatewayURL := fe.agentsGatewayBaseURL() + "/run"
 	requestBody, _ := json.Marshal(agentRequest)
 
-	req, err := http.NewRequest(http.MethodPost, agentGatewayURL, strings.NewReader(string(requestBody)))
+	ctx, cancel := context.WithTimeout(r.Context(), agentChatTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, agentGatewayURL, strings.NewReader(string(requestBody)))
 	if err != nil {
 		log.WithField("error", err).Error("failed to create agent request")
 		// Fallback to legacy assistant
@@ -1083,8 +864,7 @@ func (fe *frontendServer) enhancedChatBotHandler(w http.ResponseWriter, r *http.
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := upstreamClient.Do(req)
 	if err != nil {
 		log.WithField("error", err).Error("agent assistant request failed")
 		// Fallback to legacy assistant
@@ -1100,17 +880,17 @@ func (fe *frontendServer) enhancedChatBotHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	// Parse agent response
-	var agentResponse map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&agentResponse); err != nil {
+	// Extract message and products from agent response
+	message, products, err := fe.parseAgentResponse(log, resp.Body)
+	if err != nil {
 		log.WithField("error", err).Error("failed to decode agent response")
 		// Fallback to legacy assistant
 		fe.legacyChatBotHandler(w, r)
 		return
 	}
 
-	// Extract message and products from agent response
-	message, products := fe.parseAgentAssistantResponse(agentResponse)
+	products = fe.dropStaleProducts(r.Context(), log, products)
+	fe.convertProductPrices(r.Context(), log, products, currentCurrency(r))
 
 	// Prepare response
 	response := ChatResponse{
@@ -1126,6 +906,10 @@ func (fe *frontendServer) enhancedChatBotHandler(w http.ResponseWriter, r *http.
 	log.WithField("products_count", len(products)).Info("Enhanced assistant request completed")
 }
 
+// maxAssistantResponseBytes caps how much of an assistant's reply
+// legacyChatBotHandler and customerServiceHandler read.
+const maxAssistantResponseBytes = 1 << 20
+
 func (fe *frontendServer) legacyChatBotHandler(w http.ResponseWriter, r *http.Request) {
 	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
 
@@ -1140,28 +924,44 @@ func (fe *frontendServer) legacyChatBotHandler(w http.ResponseWriter, r *http.Re
 
 	var response LLMResponse
 
+	ctx, cancel := context.WithTimeout(r.Context(), agentChatTimeout)
+	defer cancel()
+
 	url := "http://" + fe.shoppingAssistantSvcAddr
-	req, err := http.NewRequest(http.MethodPost, url, r.Body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, r.Body)
 	if err != nil {
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to create request"), http.StatusInternalServerError)
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	res, err := http.DefaultClient.Do(req)
+	res, err := upstreamClient.Do(req)
 	if err != nil {
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to send request"), http.StatusInternalServerError)
 		return
 	}
+	defer res.Body.Close()
 
-	body, err := io.ReadAll(res.Body)
+	// Read one byte past the cap so oversized responses can be told apart
+	// from ones that are exactly at the limit.
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxAssistantResponseBytes+1))
 	if err != nil {
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to read response"), http.StatusInternalServerError)
 		return
 	}
+	log.WithFields(logrus.Fields{
+		"status":     res.StatusCode,
+		"body_bytes": len(body),
+	}).Debug("shopping assistant responded")
 
-	fmt.Printf("%+v\n", body)
-	fmt.Printf("%+v\n", res)
+	if res.StatusCode != http.StatusOK {
+		renderHTTPError(log, r, w, errors.Errorf("shopping assistant returned %s", res.Status), http.StatusBadGateway)
+		return
+	}
+	if len(body) > maxAssistantResponseBytes {
+		renderHTTPError(log, r, w, errors.Errorf("shopping assistant response exceeds %d bytes", maxAssistantResponseBytes), http.StatusBadGateway)
+		return
+	}
 
 	err = json.Unmarshal(body, &response)
 	if err != nil {
@@ -1177,8 +977,8 @@ func (fe *frontendServer) legacyChatBotHandler(w http.ResponseWriter, r *http.Re
 
 func (fe *frontendServer) getOrCreateSessionId(r *http.Request) string {
 	// Prefer cookie first for stability across requests
-	if c, err := r.Cookie(cookieSessionID); err == nil && c != nil && c.Value != "" {
-		return c.Value
+	if id, err := fe.cookieSessionID(r); err == nil {
+		return id
 	}
 	// Fall back to context-injected ID (middleware)
 	if sessionId := sessionID(r); sessionId != "" {
@@ -1188,145 +988,139 @@ func (fe *frontendServer) getOrCreateSessionId(r *http.Request) string {
 	return "session_" + strconv.FormatInt(time.Now().UnixNano(), 36) + "_" + fmt.Sprintf("%x", rand.Uint32())
 }
 
-func (fe *frontendServer) getOrCreateUserId(r *http.Request) string {
-	// For now, use session ID as user ID
-	// In a real implementation, this would be tied to user authentication
-	sessionId := fe.getOrCreateSessionId(r)
-	return sessionId // Return direct session ID to match frontend cart operations
-}
-
-func (fe *frontendServer) parseAgentAssistantResponse(agentResponse map[string]interface{}) (string, []map[string]interface{}) {
-	message := ""
-	var products []map[string]interface{}
+// parseAgentResponse extracts the message and products from an agents-gateway
+// /run response. The gateway returns either a single object or an array of
+// ADK events; for arrays, products returned by tool calls in any event win,
+// otherwise the last event is parsed since ADK appends the final state last.
+// Responses that do not match the ADK types are scanned for products as-is.
+func (fe *frontendServer) parseAgentResponse(log logrus.FieldLogger, r io.Reader) (string, []map[string]interface{}, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not read agent response")
+	}
+	body = bytes.TrimSpace(body)
 
-	log.WithField("agent_response_keys", getMapKeys(agentResponse)).Info("Parsing agent assistant response")
+	switch {
+	case bytes.HasPrefix(body, []byte("{")):
+		var event adkEvent
+		if err := json.Unmarshal(body, &event); err == nil {
+			message, products := fe.parseAgentAssistantResponse(log, event)
+			return message, products, nil
+		}
+	case bytes.HasPrefix(body, []byte("[")):
+		var events []adkEvent
+		if err := json.Unmarshal(body, &events); err == nil {
+			return fe.parseAgentEvents(log, events)
+		}
+	}
+	return parseRawAgentResponse(log, body)
+}
 
-	// Handle structured output from shopping_assistant_agent
-	var shoppingRecs map[string]interface{}
-	var found bool
+// parseRawAgentResponse is the fallback for responses of unexpected
+// structure: it scans the decoded JSON for anything that looks like a product.
+func parseRawAgentResponse(log logrus.FieldLogger, body []byte) (string, []map[string]interface{}, error) {
+	var raw interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return "", nil, errors.Wrap(err, "could not decode agent response")
+	}
+	products := dedupeProducts(extractProductsFromAny(raw))
+	if len(products) == 0 {
+		return "", nil, errors.Errorf("unexpected agent response format %T", raw)
+	}
+	log.Debug("agent response has an unexpected format, extracted products from raw JSON")
+	return "I found some products that might interest you!", products, nil
+}
 
-	// First try direct access
-	if recs, ok := agentResponse["shopping_recommendations"].(map[string]interface{}); ok {
-		shoppingRecs = recs
-		found = true
-	} else {
-		// Try ADK actions.stateDelta structure
-		if actions, ok := agentResponse["actions"].(map[string]interface{}); ok {
-			if stateDelta, ok := actions["stateDelta"].(map[string]interface{}); ok {
-				if recs, ok := stateDelta["shopping_recommendations"].(map[string]interface{}); ok {
-					shoppingRecs = recs
-					found = true
-				}
-			}
-		}
+func (fe *frontendServer) parseAgentEvents(log logrus.FieldLogger, events []adkEvent) (string, []map[string]interface{}, error) {
+	if len(events) == 0 {
+		return "", nil, errors.New("empty agent response")
 	}
 
-	if found {
-		log.Info("Found 'shopping_recommendations' key, parsing structured output.")
-		// Optional action-aware parsing (recommend/cart/order)
-		if action, ok := shoppingRecs["action"].(string); ok {
-			// Set message from top-level summary if present
-			if sum, ok := shoppingRecs["summary"].(string); ok && sum != "" {
-				message = sum
-			}
-			if action == "recommend" {
-				if recs, ok := shoppingRecs["recommendations"].([]interface{}); ok {
-					for _, rec := range recs {
-						if recMap, ok := rec.(map[string]interface{}); ok {
-							products = append(products, normalizeProductMap(recMap))
-						}
-					}
-				}
-			} else if strings.HasPrefix(action, "cart_") {
-				if cart, ok := shoppingRecs["cart"].(map[string]interface{}); ok {
-					// Build light-weight product list from cart items if available
-					if items, ok := cart["items"].([]interface{}); ok {
-						for _, it := range items {
-							if itm, ok := it.(map[string]interface{}); ok {
-								idVal := itm["product_id"]
-								nameVal := itm["name"]
-								products = append(products, map[string]interface{}{
-									"id":          idVal,
-									"name":        nameVal,
-									"description": "",
-									"picture":     "",
-								})
-							}
-						}
-					}
-				}
-			} else if action == "order_submit" {
-				// Show confirmation message; products remain empty
+	// First pass: scan all events for functionResponse with products
+	aggProducts := make([]map[string]interface{}, 0)
+	messageBuilder := strings.Builder{}
+	for _, event := range events {
+		if event.Content == nil {
+			continue
+		}
+		messageBuilder.WriteString(event.Content.text())
+		for _, part := range event.Content.Parts {
+			if part.FunctionResponse != nil {
+				aggProducts = append(aggProducts, fe.extractProductsFromFunctionResponse(part.FunctionResponse.Response)...)
 			}
 		}
-		// Extract recommendation summary as message
-		if summary, ok := shoppingRecs["recommendation_summary"].(string); ok {
-			message = summary
+	}
+	if len(aggProducts) > 0 {
+		msg := strings.TrimSpace(messageBuilder.String())
+		if msg == "" {
+			msg = "I found some products that might interest you!"
 		}
+		return msg, dedupeProducts(aggProducts), nil
+	}
 
-		// Extract product recommendations
-		if recommendations, ok := shoppingRecs["recommendations"].([]interface{}); ok {
-			for _, rec := range recommendations {
-				if recMap, ok := rec.(map[string]interface{}); ok {
-					products = append(products, normalizeProductMap(recMap))
-				}
-			}
-		}
-	} else if searchResults, ok := agentResponse["search_results"].(map[string]interface{}); ok {
-		// Handle structured output from product_discovery_agent.
-		log.Info("Found 'search_results' key, parsing structured output.")
-		if summary, ok := searchResults["summary"].(string); ok {
-			message = summary
+	message, products := fe.parseAgentAssistantResponse(log, events[len(events)-1])
+	return message, products, nil
+}
+
+func (fe *frontendServer) parseAgentAssistantResponse(log logrus.FieldLogger, event adkEvent) (string, []map[string]interface{}) {
+	message := ""
+	var products []map[string]interface{}
+
+	log.WithField("agent_response_keys", event.keys()).Debug("Parsing agent assistant response")
+
+	var (
+		recs       shoppingRecommendations
+		order      orderSummary
+		comparison productComparison
+		search     searchResults
+	)
+	switch {
+	case event.output("shopping_recommendations", &recs):
+		log.Debug("Found 'shopping_recommendations' key, parsing structured output.")
+		message, products = recs.parse()
+	case event.output("order_summary", &order):
+		log.Debug("Found 'order_summary' key, parsing structured output.")
+		message, products = order.parse()
+	case event.output("comparison", &comparison):
+		log.Debug("Found 'comparison' key, parsing structured output.")
+		message, products = comparison.parse()
+	case event.output("search_results", &search):
+		log.Debug("Found 'search_results' key, parsing structured output.")
+		message, products = search.parse()
+	default:
+		// For agents without output_schema, parse the event content and the
+		// older candidates format for text and function responses.
+		log.Debug("Did not find a structured output key, parsing ADK content.")
+		contents := []*adkContent{event.Content}
+		for _, candidate := range event.Candidates {
+			contents = append(contents, candidate.Content)
 		}
-		if productList, ok := searchResults["products"].([]interface{}); ok {
-			for _, p := range productList {
-				if pMap, ok := p.(map[string]interface{}); ok {
-					products = append(products, normalizeProductMap(pMap))
-				}
+		for _, content := range contents {
+			if content == nil {
+				continue
 			}
-		}
-	} else {
-		// For agents without output_schema, parse raw ADK response with functionResponse
-		// Fallback for older ADK format if the structured output is not found
-		log.Info("Did not find 'shopping_recommendations' key, attempting to parse legacy ADK format.")
-		if candidates, ok := agentResponse["candidates"].([]interface{}); ok && len(candidates) > 0 {
-			for _, cand := range candidates {
-				candidate, ok := cand.(map[string]interface{})
-				if !ok {
-					continue
-				}
-				if content, ok := candidate["content"].(map[string]interface{}); ok {
-					if parts, ok := content["parts"].([]interface{}); ok {
-						for _, part := range parts {
-							if partMap, ok := part.(map[string]interface{}); ok {
-								// Extract text message and parse embedded JSON products
-								if text, ok := partMap["text"].(string); ok {
-									message += text + " "
-									products = append(products, parseProductsFromJSONString(text)...)
-								}
-								// Extract function responses that might contain products
-								if funcResp, ok := partMap["functionResponse"].(map[string]interface{}); ok {
-									if response, ok := funcResp["response"]; ok {
-										products = append(products, fe.extractProductsFromFunctionResponse(response)...)
-									} else if respStr, ok := funcResp["response"].(string); ok {
-										products = append(products, parseProductsFromJSONString(respStr)...)
-									}
-								}
-							}
-						}
-					}
+			message += content.text()
+			for _, part := range content.Parts {
+				// Text parts may embed JSON products
+				products = append(products, parseProductsFromJSONString(part.Text)...)
+				if part.FunctionResponse != nil {
+					products = append(products, fe.extractProductsFromFunctionResponse(part.FunctionResponse.Response)...)
 				}
 			}
 		}
 
 		// Deep fallback: scan any nested structures for product-like maps
 		if len(products) == 0 {
-			products = append(products, extractProductsFromAny(agentResponse)...)
+			var raw interface{}
+			if err := json.Unmarshal(event.raw, &raw); err == nil {
+				products = extractProductsFromAny(raw)
+			}
 		}
 	}
 
 	// Clean up message
 	message = strings.TrimSpace(message)
+	products = dedupeProducts(products)
 	if message == "" && len(products) > 0 {
 		message = "I found some products that might interest you!"
 	}
@@ -1334,13 +1128,32 @@ func (fe *frontendServer) parseAgentAssistantResponse(agentResponse map[string]i
 	return message, products
 }
 
-// Helper function to get keys from a map for logging
-func getMapKeys(m map[string]interface{}) []string {
-	keys := make([]string, 0, len(m))
-	for k := range m {
-		keys = append(keys, k)
+// dedupeProducts drops products whose id was already seen, keeping the first
+// occurrence and the original order. Products without an id are kept.
+func dedupeProducts(products []map[string]interface{}) []map[string]interface{} {
+	seen := make(map[string]bool, len(products))
+	out := products[:0:0]
+	for _, p := range products {
+		if id, ok := p["id"]; ok && id != nil {
+			key := fmt.Sprint(id)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// firstString returns the first non-empty string value among keys.
+func firstString(m map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := m[k].(string); ok && v != "" {
+			return v
+		}
 	}
-	return keys
+	return ""
 }
 
 func (fe *frontendServer) extractProductsFromFunctionResponse(response interface{}) []map[string]interface{} {
@@ -1363,6 +1176,9 @@ func (fe *frontendServer) extractProductsFromFunctionResponse(response interface
 		if _, hasId := resp["id"]; hasId {
 			products = append(products, normalizeProductMap(resp))
 		}
+	case string:
+		// Tools that return serialized JSON
+		products = append(products, parseProductsFromJSONString(resp)...)
 	}
 
 	return products
@@ -1403,50 +1219,194 @@ func extractProductsFromAny(v interface{}) []map[string]interface{} {
 		if arr, ok := val["products"].([]interface{}); ok {
 			for _, p := range arr {
 				if pm, ok := p.(map[string]interface{}); ok {
-					collected = append(collected, normalizeProductMap(pm))
+					if id, ok := pm["id"].(string); ok && id != "" {
+						collected = append(collected, normalizeProductMap(pm))
+					}
 				}
 			}
 		}
-		for _, nested := range val {
+		for k, nested := range val {
+			if k == "products" {
+				continue
+			}
 			collected = append(collected, extractProductsFromAny(nested)...)
 		}
 	}
 	return collected
 }
 
+// productAttributes are fields only catalog products carry. Requiring one of
+// them keeps lookalikes such as ADK function calls ({"id", "name", "args"})
+// out of the deep scan.
+var productAttributes = []string{"picture", "product_image_url", "description", "price_usd", "priceUsd", "categories"}
+
 func isProductMap(m map[string]interface{}) bool {
-	_, hasID := m["id"]
-	_, hasName := m["name"]
-	return hasID && hasName
+	id, _ := m["id"].(string)
+	name, _ := m["name"].(string)
+	if id == "" || name == "" {
+		return false
+	}
+	for _, attr := range productAttributes {
+		if _, ok := m[attr]; ok {
+			return true
+		}
+	}
+	return false
 }
 
 func normalizeProductMap(m map[string]interface{}) map[string]interface{} {
 	// Normalize picture field from product_image_url if needed
-	picture := m["picture"]
-	if picture == nil || picture == "" {
-		if piu, ok := m["product_image_url"]; ok {
-			picture = piu
-		}
+	picture, _ := m["picture"].(string)
+	if strings.TrimSpace(picture) == "" {
+		picture, _ = m["product_image_url"].(string)
 	}
 	return map[string]interface{}{
 		"id":          m["id"],
 		"name":        m["name"],
 		"description": m["description"],
-		"picture":     picture,
+		"picture":     productPicture(picture),
+		"price":       normalizeProductPrice(m),
+		"categories":  normalizeCategories(m["categories"]),
 	}
 }
 
-func (fe *frontendServer) agentSearchHandler(w http.ResponseWriter, r *http.Request) {
-	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+// normalizeProductPrice renders the price an agent returned for a product.
+// Agents either send a display string ("$19.99") or a money object under
+// price, price_usd or priceUsd; a missing or unusable price yields "".
+func normalizeProductPrice(m map[string]interface{}) string {
+	for _, key := range []string{"price", "price_usd", "priceUsd"} {
+		switch v := m[key].(type) {
+		case string:
+			if v = strings.TrimSpace(v); v != "" {
+				return v
+			}
+		case float64:
+			return renderMoney(*moneyFromFloat("USD", v))
+		case map[string]interface{}:
+			if money, ok := moneyFromMap(v); ok {
+				return renderMoney(*money)
+			}
+		}
+	}
+	return ""
+}
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// moneyFromMap decodes a JSON money object in either snake_case or camelCase
+// form. Units may be encoded as a string, as protojson does for int64.
+func moneyFromMap(m map[string]interface{}) (*pb.Money, bool) {
+	code := firstString(m, "currency_code", "currencyCode")
+	if code == "" {
+		code = "USD"
+	}
+	var units int64
+	switch u := m["units"].(type) {
+	case float64:
+		units = int64(u)
+	case string:
+		n, err := strconv.ParseInt(u, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		units = n
+	case nil:
+	default:
+		return nil, false
+	}
+	nanos, _ := m["nanos"].(float64)
+	if _, hasUnits := m["units"]; !hasUnits && nanos == 0 {
+		return nil, false
 	}
+	return &pb.Money{CurrencyCode: code, Units: units, Nanos: int32(nanos)}, true
+}
 
-	// Set CORS headers for frontend access
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+func moneyFromFloat(currencyCode string, v float64) *pb.Money {
+	units := int64(v)
+	return &pb.Money{
+		CurrencyCode: currencyCode,
+		Units:        units,
+		Nanos:        int32(math.Round((v - float64(units)) * 1e9)),
+	}
+}
+
+// convertProductPrices rewrites the price of agent-returned products into
+// currency. Products without a parseable price are left untouched, as are
+// prices the currency service fails to convert.
+func (fe *frontendServer) convertProductPrices(ctx context.Context, log logrus.FieldLogger, products []map[string]interface{}, currency string) {
+	for _, p := range products {
+		price, _ := p["price"].(string)
+		m, ok := parsePriceString(price)
+		if !ok || m.GetCurrencyCode() == currency {
+			continue
+		}
+		converted, err := fe.convertCurrency(ctx, m, currency)
+		if err != nil {
+			log.WithField("error", err).WithField("product_id", p["id"]).Warn("failed to convert agent product price")
+			continue
+		}
+		p["price"] = renderMoney(*converted)
+	}
+}
+
+// priceSymbols maps the symbols renderCurrencyLogo emits back to a currency.
+// "$" is ambiguous between USD and CAD; agents price in USD.
+var priceSymbols = map[string]string{
+	"$": "USD",
+	"¥": "JPY",
+	"€": "EUR",
+	"₺": "TRY",
+	"£": "GBP",
+}
+
+// parsePriceString parses display prices such as "$8.99", "8.99", "USD 8.99"
+// or "€8.99". Amounts without a symbol or code are taken to be USD.
+func parsePriceString(s string) (*pb.Money, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, false
+	}
+	code := "USD"
+	for symbol, c := range priceSymbols {
+		if strings.HasPrefix(s, symbol) {
+			code, s = c, strings.TrimPrefix(s, symbol)
+			break
+		}
+	}
+	if fields := strings.Fields(s); len(fields) == 2 && len(fields[0]) == 3 {
+		code, s = strings.ToUpper(fields[0]), fields[1]
+	}
+	v, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), ",", ""), 64)
+	if err != nil || v < 0 {
+		return nil, false
+	}
+	return moneyFromFloat(code, v), true
+}
+
+func normalizeCategories(v interface{}) []string {
+	categories := []string{}
+	switch c := v.(type) {
+	case []string:
+		categories = append(categories, c...)
+	case []interface{}:
+		for _, item := range c {
+			if s, ok := item.(string); ok && s != "" {
+				categories = append(categories, s)
+			}
+		}
+	}
+	return categories
+}
+
+func (fe *frontendServer) agentSearchHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Set CORS headers for frontend access
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	w.Header().Set("Content-Type", "application/json")
 
@@ -1463,48 +1423,32 @@ func (fe *frontendServer) agentSearchHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	log.WithField("query", searchReq).Info("Agent search request received")
+	// The configured search agent serves the request, whatever app the
+	// client named.
+	searchReq.AppName = fe.agentApp(agentFeatureSearch)
+	log.WithField("user_id", searchReq.UserId).Info("Agent search request received")
 
 	// Create session with agents-gateway if needed
-	agentGatewayBaseURL := "http://agents-gateway:80"
-	client := &http.Client{Timeout: 30 * time.Second}
-
-	// Try to create session first
-	sessionURL := fmt.Sprintf("%s/apps/%s/users/%s/sessions", agentGatewayBaseURL, searchReq.AppName, searchReq.UserId)
-	sessionReqBody := map[string]string{
-		"appName": searchReq.AppName,
-		"userId":  searchReq.UserId,
-	}
-	sessionJSON, _ := json.Marshal(sessionReqBody)
+	agentGatewayBaseURL := fe.agentsGatewayBaseURL()
+	ctx, cancel := context.WithTimeout(r.Context(), agentChatTimeout)
+	defer cancel()
 
-	sessionResp, err := client.Post(sessionURL, "application/json", strings.NewReader(string(sessionJSON)))
+	sessionId, err := fe.ensureADKSession(ctx, searchReq.AppName, searchReq.UserId, "")
 	if err != nil {
 		log.WithField("error", err).Error("failed to create session with agents-gateway")
 		// Fall back to fallback search
 		fe.fallbackSearchWrapper(w, r, searchReq)
 		return
 	}
-	defer sessionResp.Body.Close()
-
-	var sessionData map[string]interface{}
-	if err := json.NewDecoder(sessionResp.Body).Decode(&sessionData); err != nil {
-		log.WithField("error", err).Error("failed to parse session response")
-		fe.fallbackSearchWrapper(w, r, searchReq)
-		return
-	}
-
-	// Use the session ID from the agents-gateway response
-	if sessionId, ok := sessionData["id"].(string); ok {
-		searchReq.SessionId = sessionId
-	}
+	searchReq.SessionId = sessionId
 
 	// Now make the actual search request
 	agentGatewayURL := agentGatewayBaseURL + "/run"
 	requestJSON, _ := json.Marshal(searchReq)
 
-	log.WithField("payload", string(requestJSON)).Info("Forwarding search request to agents-gateway")
+	log.WithField("payload", redactPayload(requestJSON)).Debug("Forwarding search request to agents-gateway")
 
-	req, err := http.NewRequest(http.MethodPost, agentGatewayURL, strings.NewReader(string(requestJSON)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, agentGatewayURL, strings.NewReader(string(requestJSON)))
 	if err != nil {
 		log.WithField("error", err).Error("failed to create agent request")
 		fe.fallbackSearchWrapper(w, r, searchReq)
@@ -1515,7 +1459,7 @@ func (fe *frontendServer) agentSearchHandler(w http.ResponseWriter, r *http.Requ
 	req.Header.Set("Accept", "application/json")
 
 	// Execute the request
-	resp, err := client.Do(req)
+	resp, err := upstreamClient.Do(req)
 	if err != nil {
 		log.WithField("error", err).Error("agent search request failed")
 		fe.fallbackSearchWrapper(w, r, searchReq)
@@ -1531,21 +1475,45 @@ func (fe *frontendServer) agentSearchHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Log full agent response at debug level for observability
-	log.WithField("agent_response_full", string(body)).Info("Agent search full response")
+	log.WithFields(logrus.Fields{"status": resp.StatusCode, "bytes": len(body)}).Info("Agent search response")
 
-	// Log response snippet
-	respSnippet := string(body)
-	if len(respSnippet) > 512 {
-		respSnippet = respSnippet[:512] + "..."
+	if resp.StatusCode != http.StatusOK {
+		log.WithField("status", resp.StatusCode).Error("agent search returned error")
+		fe.fallbackSearchWrapper(w, r, searchReq)
+		return
+	}
+	message, products, err := fe.parseAgentResponse(log, bytes.NewReader(body))
+	if err != nil {
+		log.WithField("error", err).Error("failed to parse agent search response")
+		fe.fallbackSearchWrapper(w, r, searchReq)
+		return
 	}
-	log.WithFields(logrus.Fields{"status": resp.StatusCode, "response": respSnippet}).Info("Agent search response")
 
-	// Forward the status code and response
-	w.WriteHeader(resp.StatusCode)
-	w.Write(body)
+	products = fe.dropStaleProducts(r.Context(), log, products)
+	query, _ := searchReq.query()
+	json.NewEncoder(w).Encode(searchResponse(query, products, message))
+	log.WithField("count", len(products)).Info("Agent search request completed")
+}
 
-	log.WithField("status", resp.StatusCode).Info("Agent search request completed")
+// searchResponse is the body of both agent and fallback search responses.
+// message defaults to a summary of the results.
+func searchResponse(query string, products []map[string]interface{}, message string) map[string]interface{} {
+	if products == nil {
+		products = []map[string]interface{}{}
+	}
+	message = strings.TrimSpace(message)
+	switch {
+	case len(products) == 0:
+		message = fmt.Sprintf("We couldn't find any products matching %q. Try different words or browse our categories.", query)
+	case message == "":
+		message = fmt.Sprintf("Found %d products matching %q.", len(products), query)
+	}
+	return map[string]interface{}{
+		"products": products,
+		"query":    query,
+		"count":    len(products),
+		"message":  message,
+	}
 }
 
 type SearchRequest struct {
@@ -1553,70 +1521,184 @@ type SearchRequest struct {
 	UserId     string                 `json:"userId"`
 	SessionId  string                 `json:"sessionId"`
 	NewMessage map[string]interface{} `json:"newMessage"`
+	StateDelta map[string]interface{} `json:"stateDelta,omitempty"`
+}
+
+// query returns the text of the first part of the search message.
+func (s SearchRequest) query() (string, bool) {
+	parts, ok := s.NewMessage["parts"].([]interface{})
+	if !ok || len(parts) == 0 {
+		return "", false
+	}
+	part, ok := parts[0].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	query, ok := part["text"].(string)
+	return query, ok
+}
+
+// fallbackSearchProducts runs query through the catalog search RPC so the
+// fallback and agent search paths return the same results as the search
+// page. Only when the RPC itself fails does it scan the full product list in
+// the frontend.
+func (fe *frontendServer) fallbackSearchProducts(ctx context.Context, query string) ([]*pb.Product, error) {
+	products, err := fe.searchProducts(ctx, query)
+	if err == nil {
+		return products, nil
+	}
+	if log, ok := ctx.Value(ctxKeyLog{}).(logrus.FieldLogger); ok {
+		log.WithField("error", err).Warn("catalog search failed, scanning products in the frontend")
+	}
+	all, err := fe.getProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return matchProducts(all, query), nil
+}
+
+// matchProducts returns the products whose name, description or categories
+// contain query, ignoring case.
+func matchProducts(products []*pb.Product, query string) []*pb.Product {
+	queryLower := strings.ToLower(query)
+	var matches []*pb.Product
+	for _, product := range products {
+		if strings.Contains(strings.ToLower(product.GetName()), queryLower) ||
+			strings.Contains(strings.ToLower(product.GetDescription()), queryLower) {
+			matches = append(matches, product)
+			continue
+		}
+		for _, category := range product.GetCategories() {
+			if strings.Contains(strings.ToLower(category), queryLower) {
+				matches = append(matches, product)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// searchResultJSON is the JSON shape of a product in fallback search results.
+func searchResultJSON(product *pb.Product) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          product.GetId(),
+		"name":        product.GetName(),
+		"description": product.GetDescription(),
+		"picture":     product.GetPicture(),
+		"categories":  product.GetCategories(),
+	}
 }
 
 func (fe *frontendServer) fallbackSearchWrapper(w http.ResponseWriter, r *http.Request, searchReq SearchRequest) {
 	// Extract search query from the agent request and perform fallback search
-	if newMessage, ok := searchReq.NewMessage["parts"].([]interface{}); ok {
-		if len(newMessage) > 0 {
-			if part, ok := newMessage[0].(map[string]interface{}); ok {
-				if query, ok := part["text"].(string); ok {
-					// Perform fallback search and return results
-					products, err := fe.getProducts(r.Context())
-					if err != nil {
-						http.Error(w, `{"error": "Search temporarily unavailable"}`, http.StatusInternalServerError)
-						return
-					}
+	query, ok := searchReq.query()
+	if !ok {
+		writeAPIError(w, r, http.StatusInternalServerError, errCodeSearchUnprocessable, "could not process search request")
+		return
+	}
+	opts, err := parseFallbackSearchOptions(r)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	}
+	response, err := fe.doFallbackSearch(r.Context(), query, opts)
+	if err != nil {
+		writeAPIError(w, r, http.StatusInternalServerError, errCodeSearchUnavailable, "search temporarily unavailable")
+		return
+	}
 
-					// Simple text matching
-					var matchingProducts []map[string]interface{}
-					queryLower := strings.ToLower(query)
-
-					for _, product := range products {
-						nameMatch := strings.Contains(strings.ToLower(product.GetName()), queryLower)
-						descMatch := strings.Contains(strings.ToLower(product.GetDescription()), queryLower)
-
-						// Check categories
-						categoryMatch := false
-						for _, category := range product.GetCategories() {
-							if strings.Contains(strings.ToLower(category), queryLower) {
-								categoryMatch = true
-								break
-							}
-						}
-
-						if nameMatch || descMatch || categoryMatch {
-							matchingProducts = append(matchingProducts, map[string]interface{}{
-								"id":          product.GetId(),
-								"name":        product.GetName(),
-								"description": product.GetDescription(),
-								"picture":     product.GetPicture(),
-								"categories":  product.GetCategories(),
-							})
-						}
-
-						// Limit results
-						if len(matchingProducts) >= 10 {
-							break
-						}
-					}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-					response := map[string]interface{}{
-						"products": matchingProducts,
-						"query":    query,
-						"count":    len(matchingProducts),
-					}
+const (
+	// fallbackSearchLimit is the default page size of the fallback search.
+	fallbackSearchLimit = 10
+	// maxFallbackSearchLimit is the largest page size a client may ask for.
+	maxFallbackSearchLimit = 50
+)
 
-					w.Header().Set("Content-Type", "application/json")
-					json.NewEncoder(w).Encode(response)
-					return
-				}
+// fallbackSearchOptions select a page of fallback search results, optionally
+// restricted to one category.
+type fallbackSearchOptions struct {
+	Limit    int
+	Offset   int
+	Category string
+}
+
+// parseFallbackSearchOptions reads the limit, offset and category query
+// parameters of r.
+func parseFallbackSearchOptions(r *http.Request) (fallbackSearchOptions, error) {
+	q := r.URL.Query()
+	opts := fallbackSearchOptions{Limit: fallbackSearchLimit, Category: strings.TrimSpace(q.Get("category"))}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, errors.New("offset must be a non-negative integer")
+		}
+		opts.Offset = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxFallbackSearchLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxFallbackSearchLimit)
+		}
+		opts.Limit = n
+	}
+	return opts, nil
+}
+
+// doFallbackSearch searches the catalog for query and returns the page of
+// matches selected by opts as the fallback search JSON. The total counts every
+// match in the category, not only the returned page.
+func (fe *frontendServer) doFallbackSearch(ctx context.Context, query string, opts fallbackSearchOptions) (map[string]interface{}, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = fallbackSearchLimit
+	}
+	products, err := fe.fallbackSearchProducts(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Category != "" {
+		var inCategory []*pb.Product
+		for _, p := range products {
+			if hasCategory(p, opts.Category) {
+				inCategory = append(inCategory, p)
 			}
 		}
+		products = inCategory
 	}
 
-	// If we can't extract the query, return an error
-	http.Error(w, `{"error": "Could not process search request"}`, http.StatusInternalServerError)
+	matchingProducts := []map[string]interface{}{}
+	total := len(products)
+	if opts.Offset < total {
+		end := opts.Offset + opts.Limit
+		if end > total {
+			end = total
+		}
+		for _, product := range products[opts.Offset:end] {
+			matchingProducts = append(matchingProducts, searchResultJSON(product))
+		}
+	}
+
+	response := searchResponse(query, matchingProducts, "")
+	response["total"] = total
+	response["offset"] = opts.Offset
+	response["limit"] = opts.Limit
+	if opts.Category != "" {
+		response["category"] = opts.Category
+	}
+	return response, nil
+}
+
+// hasCategory reports whether p is in category, ignoring case.
+func hasCategory(p *pb.Product, category string) bool {
+	for _, c := range p.GetCategories() {
+		if strings.EqualFold(strings.TrimSpace(c), category) {
+			return true
+		}
+	}
+	return false
 }
 
 func (fe *frontendServer) fallbackSearchHandler(w http.ResponseWriter, r *http.Request) {
@@ -1635,53 +1717,19 @@ func (fe *frontendServer) fallbackSearchHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	log.WithField("query", query).Info("Performing fallback search")
-
-	// Simple fallback: get all products and filter by name/description
-	products, err := fe.getProducts(r.Context())
+	opts, err := parseFallbackSearchOptions(r)
 	if err != nil {
-		log.WithField("error", err).Error("failed to get products for fallback search")
-		http.Error(w, `{"error": "Search temporarily unavailable"}`, http.StatusInternalServerError)
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
 		return
 	}
 
-	// Simple text matching
-	var matchingProducts []map[string]interface{}
-	queryLower := strings.ToLower(query)
-
-	for _, product := range products {
-		nameMatch := strings.Contains(strings.ToLower(product.GetName()), queryLower)
-		descMatch := strings.Contains(strings.ToLower(product.GetDescription()), queryLower)
-
-		// Check categories
-		categoryMatch := false
-		for _, category := range product.GetCategories() {
-			if strings.Contains(strings.ToLower(category), queryLower) {
-				categoryMatch = true
-				break
-			}
-		}
-
-		if nameMatch || descMatch || categoryMatch {
-			matchingProducts = append(matchingProducts, map[string]interface{}{
-				"id":          product.GetId(),
-				"name":        product.GetName(),
-				"description": product.GetDescription(),
-				"picture":     product.GetPicture(),
-				"categories":  product.GetCategories(),
-			})
-		}
-
-		// Limit results
-		if len(matchingProducts) >= 10 {
-			break
-		}
-	}
+	log.WithField("query", query).Info("Performing fallback search")
 
-	response := map[string]interface{}{
-		"products": matchingProducts,
-		"query":    query,
-		"count":    len(matchingProducts),
+	response, err := fe.doFallbackSearch(r.Context(), query, opts)
+	if err != nil {
+		log.WithField("error", err).Error("failed to get products for fallback search")
+		writeAPIError(w, r, http.StatusInternalServerError, errCodeSearchUnavailable, "search temporarily unavailable")
+		return
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -1691,72 +1739,7 @@ func (fe *frontendServer) featureFlagsHandler(w http.ResponseWriter, r *http.Req
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	// Feature flags for smart search and shopping assistant
-	flags := map[string]interface{}{
-		// Search features
-		"agent_search_enabled":       true, // Can be controlled via environment variable
-		"search_suggestions_enabled": true,
-		"search_analytics_enabled":   false,
-
-		// Shopping assistant features
-		"agent_assistant_enabled":      true,
-		"hybrid_assistant_mode":        true, // Use both old and new systems
-		"assistant_session_continuity": true,
-		"assistant_personalization":    true,
-		"assistant_multimodal":         true, // Support image + text
-
-		// Recommendation features
-		"agent_recommendations_enabled": true,
-		"enhanced_product_cards":        true,
-		"contextual_suggestions":        true,
-
-		// Cart and checkout features
-		"smart_add_to_cart_enabled":    true,
-		"cart_recommendations_enabled": true,
-		"checkout_assistance_enabled":  true,
-		"intelligent_quantity_suggest": true,
-		"cart_optimization_enabled":    true,
-
-		// Customer service features
-		"customer_service_enabled":      true,
-		"ai_order_tracking_enabled":     true,
-		"ai_returns_processing_enabled": true,
-		"policy_assistance_enabled":     true,
-		"support_escalation_enabled":    true,
-		"chat_support_enabled":          true,
-	}
-
-	// Check environment variables for feature flags
-	if os.Getenv("AGENT_SEARCH_DISABLED") == "true" {
-		flags["agent_search_enabled"] = false
-	}
-	if os.Getenv("AGENT_ASSISTANT_DISABLED") == "true" {
-		flags["agent_assistant_enabled"] = false
-		flags["hybrid_assistant_mode"] = false
-	}
-	if os.Getenv("ASSISTANT_LEGACY_ONLY") == "true" {
-		flags["agent_assistant_enabled"] = false
-		flags["hybrid_assistant_mode"] = false
-	}
-	if os.Getenv("SMART_CART_DISABLED") == "true" {
-		flags["smart_add_to_cart_enabled"] = false
-		flags["cart_recommendations_enabled"] = false
-		flags["intelligent_quantity_suggest"] = false
-	}
-	if os.Getenv("CHECKOUT_AGENTS_DISABLED") == "true" {
-		flags["checkout_assistance_enabled"] = false
-		flags["cart_optimization_enabled"] = false
-	}
-	if os.Getenv("CUSTOMER_SERVICE_DISABLED") == "true" {
-		flags["customer_service_enabled"] = false
-		flags["ai_order_tracking_enabled"] = false
-		flags["ai_returns_processing_enabled"] = false
-		flags["policy_assistance_enabled"] = false
-		flags["support_escalation_enabled"] = false
-		flags["chat_support_enabled"] = false
-	}
-
-	json.NewEncoder(w).Encode(flags)
+	json.NewEncoder(w).Encode(fe.flags)
 }
 
 func (fe *frontendServer) smartCartRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
@@ -1765,7 +1748,7 @@ func (fe *frontendServer) smartCartRecommendationsHandler(w http.ResponseWriter,
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	if !fe.shouldUseSmartCart() {
+	if !fe.flags.CartRecommendations {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"recommendations": []interface{}{},
 			"message":         "Smart cart features disabled",
@@ -1804,27 +1787,36 @@ func (fe *frontendServer) smartCartRecommendationsHandler(w http.ResponseWriter,
 		}
 	}
 
+	prompt := fmt.Sprintf("Based on my current cart contents %v, suggest 3-5 complementary products that would go well with these items. Focus on accessories, matching items, or things commonly bought together.", cartItems)
+	if recent := fe.personalizationContext(sessionId); len(recent) > 0 {
+		prompt += fmt.Sprintf(" I recently looked at or added these products: %v.", recent)
+	}
+
 	// Prepare agent request
-	userId := sessionId
+	userId := fe.resolveIdentity(r).UserID
+	appName := fe.agentApp(agentFeatureCart)
+	adkSessionId, _ := fe.ensureADKSession(r.Context(), appName, userId, sessionId)
 	agentRequest := map[string]interface{}{
-		"appName":   fe.reAppName,
+		"appName":   appName,
 		"userId":    userId,
-		"sessionId": sessionId,
+		"sessionId": adkSessionId,
 		"newMessage": map[string]interface{}{
 			"role": "user",
 			"parts": []map[string]interface{}{
 				{
-					"text": fmt.Sprintf("Based on my current cart contents %v, suggest 3-5 complementary products that would go well with these items. Focus on accessories, matching items, or things commonly bought together.", cartItems),
+					"text": prompt,
 				},
 			},
 		},
 	}
 
 	// Call agents-gateway
-	agentGatewayURL := "http://agents-gateway:80/run"
+	agentGatewayURL := fe.agentsGatewayBaseURL() + "/run"
 	requestBody, _ := json.Marshal(agentRequest)
 
-	req, err := http.NewRequest(http.MethodPost, agentGatewayURL, strings.NewReader(string(requestBody)))
+	ctx, cancel := context.WithTimeout(r.Context(), agentCartTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, agentGatewayURL, strings.NewReader(string(requestBody)))
 	if err != nil {
 		log.WithField("error", err).Error("failed to create agent request")
 		http.Error(w, `{"error": "Failed to create recommendation request"}`, http.StatusInternalServerError)
@@ -1832,9 +1824,8 @@ func (fe *frontendServer) smartCartRecommendationsHandler(w http.ResponseWriter,
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{Timeout: 15 * time.Second}
 
-	resp, err := client.Do(req)
+	resp, err := upstreamClient.Do(req)
 	if err != nil {
 		log.WithField("error", err).Error("agent recommendation request failed")
 		// Return empty recommendations instead of error to maintain UX
@@ -1855,9 +1846,9 @@ func (fe *frontendServer) smartCartRecommendationsHandler(w http.ResponseWriter,
 		return
 	}
 
-	// Parse agent response
-	var agentResponse map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&agentResponse); err != nil {
+	// Extract recommendations from agent response
+	message, products, err := fe.parseAgentResponse(log, resp.Body)
+	if err != nil {
 		log.WithField("error", err).Error("failed to decode agent response")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"recommendations": []interface{}{},
@@ -1866,9 +1857,6 @@ func (fe *frontendServer) smartCartRecommendationsHandler(w http.ResponseWriter,
 		return
 	}
 
-	// Extract recommendations from agent response
-	message, products := fe.parseAgentAssistantResponse(agentResponse)
-
 	response := map[string]interface{}{
 		"recommendations": products,
 		"message":         message,
@@ -1885,7 +1873,7 @@ func (fe *frontendServer) checkoutAssistanceHandler(w http.ResponseWriter, r *ht
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	if os.Getenv("CHECKOUT_AGENTS_DISABLED") == "true" {
+	if !fe.flags.CheckoutAssistance {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"guidance":    "Checkout assistance is currently disabled",
 			"suggestions": []string{},
@@ -1926,12 +1914,21 @@ func (fe *frontendServer) checkoutAssistanceHandler(w http.ResponseWriter, r *ht
 		totalItems += int(item.GetQuantity())
 	}
 
+	// Small, cheap carts get local guidance without paying for an agent call.
+	if fe.isSimpleCheckout(r.Context(), cart) {
+		log.Debug("simple cart, providing local checkout guidance")
+		fe.provideFallbackCheckoutGuidance(w, len(cart), totalItems)
+		return
+	}
+
 	// Prepare agent request for checkout guidance
-	userId := sessionId
+	userId := fe.resolveIdentity(r).UserID
+	appName := fe.agentApp(agentFeatureCheckout)
+	adkSessionId, _ := fe.ensureADKSession(r.Context(), appName, userId, sessionId)
 	agentRequest := map[string]interface{}{
-		"appName":   "checkout_agent",
+		"appName":   appName,
 		"userId":    userId,
-		"sessionId": sessionId,
+		"sessionId": adkSessionId,
 		"newMessage": map[string]interface{}{
 			"role": "user",
 			"parts": []map[string]interface{}{
@@ -1943,10 +1940,12 @@ func (fe *frontendServer) checkoutAssistanceHandler(w http.ResponseWriter, r *ht
 	}
 
 	// Call agents-gateway
-	agentGatewayURL := "http://agents-gateway:80/run"
+	agentGatewayURL := fe.agentsGatewayBaseURL() + "/run"
 	requestBody, _ := json.Marshal(agentRequest)
 
-	req, err := http.NewRequest(http.MethodPost, agentGatewayURL, strings.NewReader(string(requestBody)))
+	ctx, cancel := context.WithTimeout(r.Context(), agentCartTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, agentGatewayURL, strings.NewReader(string(requestBody)))
 	if err != nil {
 		log.WithField("error", err).Error("failed to create checkout agent request")
 		// Provide fallback guidance
@@ -1955,9 +1954,8 @@ func (fe *frontendServer) checkoutAssistanceHandler(w http.ResponseWriter, r *ht
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{Timeout: 15 * time.Second}
 
-	resp, err := client.Do(req)
+	resp, err := upstreamClient.Do(req)
 	if err != nil {
 		log.WithField("error", err).Error("checkout agent request failed")
 		fe.provideFallbackCheckoutGuidance(w, len(cart), totalItems)
@@ -1971,17 +1969,14 @@ func (fe *frontendServer) checkoutAssistanceHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	// Parse agent response
-	var agentResponse map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&agentResponse); err != nil {
+	// Extract guidance from agent response
+	guidance, _, err := fe.parseAgentResponse(log, resp.Body)
+	if err != nil {
 		log.WithField("error", err).Error("failed to decode checkout agent response")
 		fe.provideFallbackCheckoutGuidance(w, len(cart), totalItems)
 		return
 	}
 
-	// Extract guidance from agent response
-	guidance, _ := fe.parseAgentAssistantResponse(agentResponse)
-
 	response := map[string]interface{}{
 		"guidance": guidance,
 		"suggestions": []string{
@@ -2032,7 +2027,7 @@ func (fe *frontendServer) customerServiceHandler(w http.ResponseWriter, r *http.
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	// Check if customer service agents are enabled
-	if os.Getenv("CUSTOMER_SERVICE_DISABLED") == "true" {
+	if !fe.flags.CustomerService {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"response":            "Customer service agents are currently disabled. Please contact support directly.",
 			"escalation_required": true,
@@ -2055,33 +2050,69 @@ func (fe *frontendServer) customerServiceHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	sessionId := fe.getOrCreateSessionId(r)
-	userId := fe.getOrCreateUserId(r)
+	id := fe.resolveIdentity(r)
+	sessionId, userId := id.SessionID, id.UserID
 
-	// Route to appropriate agent based on request type
-	var agentName string
+	// Build the agent prompt based on the request type
+	agentName := fe.agentApp(agentFeatureSupport)
 	var enhancedMessage string
 
+	var order *orderStatus
+	var ret *returnRecord
+	returnEligible := false
 	switch request.Type {
 	case "order_tracking":
-		agentName = "customer_service_agent"
-		enhancedMessage = fmt.Sprintf("Order tracking request: %s. Order ID: %s, Email: %s", request.Message, request.OrderId, request.Email)
+		// Only orders this replica placed since it started can be found,
+		// see getOrderStatus; other orders get a not-found answer that
+		// says the lookup was local.
+		var err error
+		order, err = fe.getOrderStatus(r.Context(), request.OrderId, request.Email)
+		if errors.Is(err, errOrderNotFound) {
+			writeOrderNotFound(w, request.Type)
+			return
+		} else if err != nil {
+			log.WithField("error", err).Error("order status lookup failed")
+			fe.provideEscalationResponse(w, request.Type, "Order lookup failed")
+			return
+		}
+		enhancedMessage = fmt.Sprintf("Order tracking request: %s. Order status: %s", request.Message, order.describe())
 	case "returns":
-		agentName = "customer_service_agent"
-		enhancedMessage = fmt.Sprintf("Returns request: %s. Order ID: %s, Email: %s", request.Message, request.OrderId, request.Email)
+		// With localReturns, only orders this replica placed since it
+		// started can be returned; other orders get a not-found answer
+		// that says the lookup was local.
+		var err error
+		ret, err = fe.returns.CreateReturn(r.Context(), returnRequest{
+			OrderID: request.OrderId,
+			Email:   request.Email,
+			Reason:  request.Message,
+		})
+		switch {
+		case errors.Is(err, errOrderNotFound):
+			writeOrderNotFound(w, request.Type)
+			return
+		case errors.Is(err, errReturnWindowClosed):
+			enhancedMessage = fmt.Sprintf("Returns request: %s. Result: order %s is not eligible for return; it was placed more than %d days ago.",
+				request.Message, strings.TrimSpace(request.OrderId), int(returnWindow.Hours()/24))
+		case err != nil:
+			log.WithField("error", err).Error("creating return failed")
+			fe.provideEscalationResponse(w, request.Type, "Return could not be created")
+			return
+		default:
+			returnEligible = true
+			enhancedMessage = fmt.Sprintf("Returns request: %s. Result: %s", request.Message, ret.describe())
+		}
 	case "policy":
-		agentName = "customer_service_agent"
 		enhancedMessage = fmt.Sprintf("Policy question: %s", request.Message)
 	default:
-		agentName = "customer_service_agent"
 		enhancedMessage = request.Message
 	}
 
 	// Prepare agent request
+	adkSessionId, _ := fe.ensureADKSession(r.Context(), agentName, userId, sessionId)
 	agentRequest := map[string]interface{}{
 		"appName":   agentName,
 		"userId":    userId,
-		"sessionId": sessionId,
+		"sessionId": adkSessionId,
 		"newMessage": map[string]interface{}{
 			"role": "user",
 			"parts": []map[string]interface{}{
@@ -2091,12 +2122,14 @@ func (fe *frontendServer) customerServiceHandler(w http.ResponseWriter, r *http.
 	}
 
 	// Call agents-gateway
-	agentGatewayURL := "http://agents-gateway:80/run"
+	agentGatewayURL := fe.agentsGatewayBaseURL() + "/run"
 	requestBody, _ := json.Marshal(agentRequest)
 
-	log.WithField("request_body", string(requestBody)).Info("Creating customer service request")
+	log.WithField("request_body", redactPayload(requestBody)).Debug("Creating customer service request")
 
-	req, err := http.NewRequest(http.MethodPost, agentGatewayURL, strings.NewReader(string(requestBody)))
+	ctx, cancel := context.WithTimeout(r.Context(), agentChatTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, agentGatewayURL, strings.NewReader(string(requestBody)))
 	if err != nil {
 		log.WithField("error", err).Error("failed to create customer service request")
 		fe.provideEscalationResponse(w, request.Type, "Failed to create support request")
@@ -2104,9 +2137,8 @@ func (fe *frontendServer) customerServiceHandler(w http.ResponseWriter, r *http.
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{Timeout: 30 * time.Second}
 
-	resp, err := client.Do(req)
+	resp, err := upstreamClient.Do(req)
 	if err != nil {
 		log.WithField("error", err).Error("customer service agent request failed")
 		fe.provideEscalationResponse(w, request.Type, "Customer service temporarily unavailable")
@@ -2120,39 +2152,78 @@ func (fe *frontendServer) customerServiceHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	// Parse agent response
-	var agentResponse map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&agentResponse); err != nil {
+	// Extract response from agent, reading one byte past the cap so
+	// oversized responses can be told apart from ones at the limit.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAssistantResponseBytes+1))
+	if err != nil {
+		log.WithField("error", err).Error("failed to read customer service response")
+		fe.provideEscalationResponse(w, request.Type, "Failed to process support request")
+		return
+	}
+	if len(body) > maxAssistantResponseBytes {
+		log.WithField("limit", maxAssistantResponseBytes).Error("customer service response too large")
+		fe.provideEscalationResponse(w, request.Type, "Failed to process support request")
+		return
+	}
+	message, _, err := fe.parseAgentResponse(log, bytes.NewReader(body))
+	if err != nil {
 		log.WithField("error", err).Error("failed to decode customer service response")
 		fe.provideEscalationResponse(w, request.Type, "Failed to process support request")
 		return
 	}
 
-	// Extract response from agent
-	message, _ := fe.parseAgentAssistantResponse(agentResponse)
-
-	// Check if escalation is needed (simple heuristic)
-	escalationNeeded := strings.Contains(strings.ToLower(message), "escalate") ||
-		strings.Contains(strings.ToLower(message), "human") ||
-		strings.Contains(strings.ToLower(message), "complex")
+	// Prefer the agent's own escalation decision over the keyword heuristic
+	signal, ok := agentEscalation(body)
+	if !ok {
+		signal.Required = mentionsEscalation(message, escalationKeywords)
+	}
 
 	response := map[string]interface{}{
 		"response":            message,
 		"type":                request.Type,
-		"escalation_required": escalationNeeded,
+		"escalation_required": signal.Required,
 		"session_id":          sessionId,
 		"agent_powered":       true,
 	}
+	if signal.Reason != "" {
+		response["escalation_reason"] = signal.Reason
+	}
 
 	// Add specific fields based on request type
-	if request.Type == "order_tracking" && request.OrderId != "" {
-		response["order_id"] = request.OrderId
+	if order != nil {
+		response["order_id"] = order.OrderID
+		response["order_status"] = order
+	}
+	if request.Type == "returns" {
+		response["return_eligible"] = returnEligible
+		if ret != nil {
+			response["order_id"] = ret.OrderID
+			response["rma_number"] = ret.RMANumber
+			response["return"] = ret
+		}
 	}
 
 	json.NewEncoder(w).Encode(response)
 	log.WithField("request_type", request.Type).Info("Customer service request processed")
 }
 
+// writeOrderNotFound answers a support request about an order this replica
+// does not know with that email address, without asking the agent. Orders
+// live in each replica's memory (see orderHistory), so this is not proof the
+// order does not exist: the answer says so, and not_found_reason tells
+// clients the lookup only covered this replica.
+func writeOrderNotFound(w http.ResponseWriter, requestType string) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"response": "We couldn't find that order ID and email address among the recent orders this server knows about. " +
+			"Please check both and try again; if the order was placed a while ago, our support team can look it up for you.",
+		"type":                requestType,
+		"order_found":         false,
+		"not_found_reason":    "not_found_on_replica",
+		"escalation_required": false,
+		"agent_powered":       false,
+	})
+}
+
 func (fe *frontendServer) provideEscalationResponse(w http.ResponseWriter, requestType, reason string) {
 	var message string
 	switch requestType {
@@ -2195,37 +2266,66 @@ func (fe *frontendServer) setCurrencyHandler(w http.ResponseWriter, r *http.Requ
 			MaxAge: cookieMaxAge,
 		})
 	}
-	referer := r.Header.Get("referer")
-	if referer == "" {
-		referer = baseUrl + "/"
-	}
-	w.Header().Set("Location", referer)
+	w.Header().Set("Location", sameOriginReferer(r))
 	w.WriteHeader(http.StatusFound)
 }
 
+// sameOriginReferer returns the path of the page r came from, to redirect
+// back to, or the home page if the Referer is missing, malformed or points
+// to another site or outside baseUrl.
+func sameOriginReferer(r *http.Request) string {
+	home := baseUrl + "/"
+	u, err := url.Parse(r.Header.Get("Referer"))
+	if err != nil || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https") ||
+		(u.Host != "" && !strings.EqualFold(u.Host, r.Host)) || (u.Scheme != "" && u.Host == "") {
+		return home
+	}
+	// Browsers read "//host" and "/\host" as another site.
+	if p := u.Path; !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") ||
+		(p != baseUrl && !strings.HasPrefix(p, home)) {
+		return home
+	}
+	target := u.EscapedPath()
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	return target
+}
+
 // ===================== Agent Tool HTTP Endpoints (Option A) =====================
 
 // GET /api/cart?userId=...
 func (fe *frontendServer) apiGetCart(w http.ResponseWriter, r *http.Request) {
-	userId := r.URL.Query().Get("userId")
-	if userId == "" {
-		userId = sessionID(r)
-	}
-	cart, err := fe.getCart(r.Context(), userId)
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	userId := fe.apiUserID(r, r.URL.Query().Get("userId"))
+	resp, err := fe.apiCart(r.Context(), log, userId)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]any{"error": "cart_fetch_failed"})
+		writeAPIError(w, r, http.StatusInternalServerError, errCodeCartFetchFailed, "could not retrieve cart")
 		return
 	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// apiCart returns userId's cart as served by GET /api/cart.
+func (fe *frontendServer) apiCart(ctx context.Context, log logrus.FieldLogger, userId string) (map[string]any, error) {
+	cart, err := fe.getCart(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
 
-	// Enrich cart items with product details
+	// Enrich cart items with product details. Items whose product cannot be
+	// fetched have no price, so the total leaves them out and the response
+	// is marked partial.
 	items := make([]map[string]any, 0, len(cart))
-	var totalPrice float64
+	totalPrice := pb.Money{CurrencyCode: defaultCurrency}
+	failed := []string{}
 
 	for _, it := range cart {
 		// Fetch product details for each cart item
-		product, err := fe.getProduct(r.Context(), it.GetProductId())
+		product, err := fe.getProduct(ctx, it.GetProductId())
 		if err != nil {
+			log.WithField("product_id", it.GetProductId()).WithField("error", err).Warn("could not enrich cart item")
+			failed = append(failed, it.GetProductId())
 			// If product fetch fails, use basic info
 			items = append(items, map[string]any{
 				"product_id": it.GetProductId(),
@@ -2238,98 +2338,340 @@ func (fe *frontendServer) apiGetCart(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		// Calculate line total
-		unitPrice := float64(product.GetPriceUsd().GetUnits()) + float64(product.GetPriceUsd().GetNanos())/1000000000.0
-		lineTotal := unitPrice * float64(it.GetQuantity())
-		totalPrice += lineTotal
+		// Calculate line total in units and nanos; only the display strings
+		// are rounded.
+		var lineTotal pb.Money
+		price := unitPrice(product)
+		if price == nil {
+			err = errors.New("product has no price")
+		} else if lineTotal, err = money.MultiplySlow(*price, uint32(it.GetQuantity())); err == nil {
+			totalPrice, err = money.Sum(totalPrice, lineTotal)
+		}
+		if err != nil {
+			log.WithField("product_id", it.GetProductId()).WithField("error", err).Warn("could not price cart item")
+			failed = append(failed, it.GetProductId())
+			items = append(items, map[string]any{
+				"product_id": it.GetProductId(),
+				"name":       product.GetName(),
+				"quantity":   it.GetQuantity(),
+				"price":      "",
+				"image":      product.GetPicture(),
+				"line_total": "",
+			})
+			continue
+		}
 
 		items = append(items, map[string]any{
 			"product_id": it.GetProductId(),
 			"name":       product.GetName(),
 			"quantity":   it.GetQuantity(),
-			"price":      fmt.Sprintf("%.2f", unitPrice),
+			"price":      formatAmount(price),
 			"image":      product.GetPicture(),
-			"line_total": fmt.Sprintf("%.2f", lineTotal),
+			"line_total": formatAmount(&lineTotal),
 		})
 	}
 
-	json.NewEncoder(w).Encode(map[string]any{
+	resp := map[string]any{
 		"cart_id":     userId,
 		"items":       items,
-		"total_price": fmt.Sprintf("%.2f", totalPrice),
+		"total_price": formatAmount(&totalPrice),
+	}
+	if len(failed) > 0 {
+		resp["partial"] = true
+		resp["failed_product_ids"] = failed
+	}
+	return resp, nil
+}
+
+// GET /api/cart/count?userId=
+// Returns only the number of items in the cart, without fetching product
+// details, for cheap badge updates.
+func (fe *frontendServer) apiCartCount(w http.ResponseWriter, r *http.Request) {
+	userId := fe.apiUserID(r, r.URL.Query().Get("userId"))
+	cart, err := fe.getCart(r.Context(), userId)
+	if err != nil {
+		writeAPIError(w, r, http.StatusInternalServerError, errCodeCartFetchFailed, "could not retrieve cart")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"count": cartSize(cart)})
+}
+
+// GET /api/buy-again?userId=
+// Returns the distinct products of the user's recent orders, most recently
+// ordered first, that are still in the catalog and in stock, priced in the
+// user's currency.
+func (fe *frontendServer) apiBuyAgain(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	userId := fe.apiUserID(r, r.URL.Query().Get("userId"))
+	currency := currentCurrency(r)
+
+	products := make([]map[string]any, 0)
+	seen := make(map[string]bool)
+	for _, order := range fe.orders.recent(userId) {
+		for _, item := range order.GetItems() {
+			id := item.GetItem().GetProductId()
+			if id == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			product, err := fe.getProduct(r.Context(), id)
+			if status.Code(err) == codes.NotFound {
+				log.WithField("product", id).Debug("skipping discontinued product")
+				continue
+			}
+			if err != nil {
+				log.WithError(err).Warn("could not retrieve previously ordered product")
+				writeAPIError(w, r, http.StatusBadGateway, errCodeCatalogUnavailable, "could not retrieve products")
+				return
+			}
+			if product.Stock != nil && product.GetStock() <= 0 {
+				log.WithField("product", id).Debug("skipping out-of-stock product")
+				continue
+			}
+
+			price := unitPrice(product)
+			if converted, err := fe.convertCurrency(r.Context(), price, currency); err == nil {
+				price = converted
+			} else {
+				log.WithError(err).Warnf("could not convert price of %s to %s", id, currency)
+			}
+			products = append(products, map[string]any{
+				"id":            product.GetId(),
+				"name":          product.GetName(),
+				"description":   product.GetDescription(),
+				"picture":       product.GetPicture(),
+				"price":         renderMoney(*price),
+				"last_quantity": item.GetItem().GetQuantity(),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"user_id":  userId,
+		"products": products,
 	})
 }
 
 // POST /api/cart/add {userId, productId, quantity}
 func (fe *frontendServer) apiAddToCart(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
 	var req struct {
 		UserId    string `json:"userId"`
 		ProductId string `json:"productId"`
 		Quantity  int32  `json:"quantity"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]any{"error": "bad_request"})
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, "request body must be valid JSON")
 		return
 	}
-	if req.UserId == "" {
-		req.UserId = sessionID(r)
+	req.UserId = fe.apiUserID(r, req.UserId)
+	req.ProductId = normalizeProductID(req.ProductId)
+	if req.ProductId == "" {
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, "productId is required")
+		return
 	}
 	if req.Quantity <= 0 {
 		req.Quantity = 1
 	}
+	if uint64(req.Quantity) > validator.MaxQuantity() {
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("quantity must be at most %d", validator.MaxQuantity()))
+		return
+	}
+	if err := fe.checkCartLimits(r.Context(), req.UserId, req.ProductId, req.Quantity); err != nil {
+		var limitErr *cartLimitError
+		if errors.As(err, &limitErr) {
+			writeAPIErrorDetails(w, r, http.StatusConflict, errCodeCartLimitExceeded, limitErr.Error(), limitErr)
+		} else {
+			writeAPIError(w, r, http.StatusInternalServerError, errCodeCartFetchFailed, "could not retrieve cart")
+		}
+		return
+	}
 	if err := fe.insertCart(r.Context(), req.UserId, req.ProductId, req.Quantity); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]any{"error": "add_failed"})
+		writeAPIError(w, r, http.StatusInternalServerError, errCodeAddFailed, "could not add item to cart")
+		return
+	}
+	fe.emitProductEvent(r, eventAddToCart, req.ProductId, req.Quantity)
+
+	cart, err := fe.apiCart(r.Context(), log, req.UserId)
+	if err != nil {
+		writeAPIError(w, r, http.StatusInternalServerError, errCodeCartFetchFailed, "could not retrieve cart")
 		return
 	}
-	fe.apiGetCart(w, r.WithContext(r.Context()))
+	json.NewEncoder(w).Encode(cart)
 }
 
 // POST /api/cart/remove {userId, productId}
 func (fe *frontendServer) apiRemoveFromCart(w http.ResponseWriter, r *http.Request) {
 	var req struct{ UserId, ProductId string }
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]any{"error": "bad_request"})
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, "request body must be valid JSON")
 		return
 	}
-	if req.UserId == "" {
-		req.UserId = sessionID(r)
-	}
+	req.UserId = fe.apiUserID(r, req.UserId)
 	// Simple implementation: empty cart then re-add everything except ProductId (for demo keep as no-op)
 	// Real impl would call a RemoveItem RPC.
-	json.NewEncoder(w).Encode(map[string]any{"status": "not_implemented"})
+	writeAPIError(w, r, http.StatusNotImplemented, errCodeNotImplemented, "removing individual cart items is not supported")
 }
 
-// POST /api/checkout {userId, userDetails{name,address}, paymentInfo{last4}}
+// POST /api/checkout {userId, userDetails{name,email,address{streetAddress,city,state,zipCode,country}}, paymentInfo{last4,cardNumber,expirationMonth,expirationYear,cvv}}
+//
+// Places a real order through CheckoutService. When CHECKOUT_DEMO_MODE is
+// "true" (e.g. environments without a payment service) a synthetic, clearly
+// labeled confirmation is returned instead and the cart is simply emptied.
 func (fe *frontendServer) apiCheckout(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		UserId      string                         `json:"userId"`
-		UserDetails struct{ Name, Address string } `json:"userDetails"`
-		PaymentInfo struct{ Last4 string }         `json:"paymentInfo"`
-	}
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	var req apiCheckoutRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]any{"error": "bad_request"})
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, "request body must be valid JSON")
+		return
+	}
+	req.UserId = fe.apiUserID(r, req.UserId)
+
+	if fe.checkoutDemoMode {
+		log.WithField("user", req.UserId).Info("api checkout running in demo mode, no order placed")
+		// Best-effort cart clear. Ignore errors for demo.
+		_ = fe.emptyCart(r.Context(), req.UserId)
+		json.NewEncoder(w).Encode(map[string]any{
+			"order_id":           "DEMO-" + fmt.Sprintf("%x", rand.Uint32()),
+			"status":             "success",
+			"tracking_id":        fmt.Sprintf("DEMO-1Z%x", rand.Uint32()),
+			"estimated_delivery": time.Now().Add(48 * time.Hour).Format("2006-01-02"),
+			"message":            "Demo mode: no payment was processed and no order was placed.",
+			"demo":               true,
+		})
 		return
 	}
-	if req.UserId == "" {
-		req.UserId = sessionID(r)
+
+	if field := req.missingField(); field != "" {
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, field+" is required")
+		return
 	}
-	// For demo, return a synthetic confirmation and clear the user's cart
-	resp := map[string]any{
-		"order_id":           "ORDER-" + fmt.Sprintf("%x", rand.Uint32()),
+	payload, err := req.toPlaceOrderPayload()
+	if err != nil {
+		writeAPIError(w, r, http.StatusUnprocessableEntity, errCodeValidationFailed, err.Error())
+		return
+	}
+	if err := payload.Validate(); err != nil {
+		writeAPIErrorDetails(w, r, http.StatusUnprocessableEntity, errCodeValidationFailed,
+			"invalid checkout details", validator.ValidationErrorResponse(err).Error())
+		return
+	}
+
+	order, err := fe.placeOrder(r.Context(), req.UserId, currentCurrency(r), payload)
+	if err != nil {
+		log.WithField("error", redactOrderData(err.Error())).Error("api checkout failed")
+		writeAPIError(w, r, http.StatusBadGateway, errCodeCheckoutFailed, "could not place the order")
+		return
+	}
+	log.WithField("order", order.GetOrder().GetOrderId()).Info("order placed via api")
+
+	// Item costs and shipping come back converted to the order currency.
+	total, err := orderTotal(order.GetOrder(), currentCurrency(r))
+	if err != nil {
+		log.WithField("error", err).Error("could not compute api order total")
+		writeAPIError(w, r, http.StatusInternalServerError, errCodeCheckoutFailed, "order placed but its total could not be computed")
+		return
+	}
+	lineTotals, _, err := computeOrderTotal(orderResultLines(order.GetOrder()), nil, total.GetCurrencyCode())
+	if err != nil {
+		log.WithField("error", err).Error("could not compute api order line totals")
+		writeAPIError(w, r, http.StatusInternalServerError, errCodeCheckoutFailed, "order placed but its total could not be computed")
+		return
+	}
+	items := make([]map[string]any, 0, len(order.GetOrder().GetItems()))
+	for i, it := range order.GetOrder().GetItems() {
+		items = append(items, map[string]any{
+			"product_id": it.GetItem().GetProductId(),
+			"quantity":   it.GetItem().GetQuantity(),
+			"price":      formatAmount(it.GetCost()),
+			"line_total": formatAmount(&lineTotals[i]),
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"order_id":           order.GetOrder().GetOrderId(),
 		"status":             "success",
-		"tracking_id":        fmt.Sprintf("1Z%x", rand.Uint32()),
+		"tracking_id":        order.GetOrder().GetShippingTrackingId(),
 		"estimated_delivery": time.Now().Add(48 * time.Hour).Format("2006-01-02"),
 		"message":            "Your order has been placed successfully!",
-	}
+		"currency":           total.GetCurrencyCode(),
+		"items":              items,
+		"shipping_cost":      formatAmount(order.GetOrder().GetShippingCost()),
+		"total":              formatAmount(&total),
+	})
+}
 
-	// Best-effort cart clear after successful checkout. Ignore errors for demo.
-	_ = fe.emptyCart(r.Context(), req.UserId)
+type apiCheckoutRequest struct {
+	UserId      string `json:"userId"`
+	UserDetails struct {
+		Name    string             `json:"name"`
+		Email   string             `json:"email"`
+		Address apiCheckoutAddress `json:"address"`
+	} `json:"userDetails"`
+	PaymentInfo struct {
+		Last4           string `json:"last4"`
+		CardNumber      string `json:"cardNumber"`
+		ExpirationMonth int64  `json:"expirationMonth"`
+		ExpirationYear  int64  `json:"expirationYear"`
+		CVV             int64  `json:"cvv"`
+	} `json:"paymentInfo"`
+}
 
-	json.NewEncoder(w).Encode(resp)
+// apiCheckoutAddress is the shipping address of an /api/checkout request.
+type apiCheckoutAddress struct {
+	StreetAddress string `json:"streetAddress"`
+	City          string `json:"city"`
+	State         string `json:"state"`
+	ZipCode       int64  `json:"zipCode"`
+	Country       string `json:"country"`
+}
+
+// missingField returns the JSON path of the first required field the
+// request leaves empty, or "" if all are there.
+func (req apiCheckoutRequest) missingField() string {
+	addr := req.UserDetails.Address
+	for _, f := range []struct {
+		path    string
+		missing bool
+	}{
+		{"userDetails.email", strings.TrimSpace(req.UserDetails.Email) == ""},
+		{"userDetails.address.streetAddress", strings.TrimSpace(addr.StreetAddress) == ""},
+		{"userDetails.address.city", strings.TrimSpace(addr.City) == ""},
+		{"userDetails.address.state", strings.TrimSpace(addr.State) == ""},
+		{"userDetails.address.zipCode", addr.ZipCode == 0},
+		{"userDetails.address.country", strings.TrimSpace(addr.Country) == ""},
+		{"paymentInfo.cardNumber", strings.TrimSpace(req.PaymentInfo.CardNumber) == ""},
+	} {
+		if f.missing {
+			return f.path
+		}
+	}
+	return ""
+}
+
+// toPlaceOrderPayload maps the agent-facing checkout request onto the same
+// payload the checkout form is validated with.
+func (req apiCheckoutRequest) toPlaceOrderPayload() (validator.PlaceOrderPayload, error) {
+	addr := req.UserDetails.Address
+	payload := validator.PlaceOrderPayload{
+		Email:         strings.TrimSpace(req.UserDetails.Email),
+		StreetAddress: strings.TrimSpace(addr.StreetAddress),
+		ZipCode:       addr.ZipCode,
+		City:          strings.TrimSpace(addr.City),
+		State:         strings.TrimSpace(addr.State),
+		Country:       strings.TrimSpace(addr.Country),
+		CcNumber:      strings.ReplaceAll(req.PaymentInfo.CardNumber, " ", ""),
+		CcMonth:       req.PaymentInfo.ExpirationMonth,
+		CcYear:        req.PaymentInfo.ExpirationYear,
+		CcCVV:         req.PaymentInfo.CVV,
+	}
+	if last4 := req.PaymentInfo.Last4; last4 != "" && !strings.HasSuffix(payload.CcNumber, last4) {
+		return payload, errors.New("paymentInfo.last4 does not match paymentInfo.cardNumber")
+	}
+	return payload, nil
 }
 
 // chooseAd queries for advertisements available and randomly chooses one, if
@@ -2340,12 +2682,16 @@ func (fe *frontendServer) chooseAd(ctx context.Context, ctxKeys []string, log lo
 		log.WithField("error", err).Warn("failed to retrieve ads")
 		return nil
 	}
+	if len(ads) == 0 {
+		return nil
+	}
 	return ads[rand.Intn(len(ads))]
 }
 
 func renderHTTPError(log logrus.FieldLogger, r *http.Request, w http.ResponseWriter, err error, code int) {
-	log.WithField("error", err).Error("request error")
-	errMsg := fmt.Sprintf("%+v", err)
+	// Errors from checkout may quote the order they failed on.
+	log.WithField("error", redactOrderData(err.Error())).Error("request error")
+	errMsg := redactOrderData(fmt.Sprintf("%+v", err))
 
 	w.WriteHeader(code)
 
@@ -2359,16 +2705,20 @@ func renderHTTPError(log logrus.FieldLogger, r *http.Request, w http.ResponseWri
 }
 
 func injectCommonTemplateData(r *http.Request, payload map[string]interface{}) map[string]interface{} {
+	platform := currentPlatform(r.Context())
 	data := map[string]interface{}{
 		"session_id":        sessionID(r),
 		"request_id":        r.Context().Value(ctxKeyRequestID{}),
 		"user_currency":     currentCurrency(r),
-		"platform_css":      plat.css,
-		"platform_name":     plat.provider,
+		"locale":            currentLocale(r),
+		"locales":           localeNames(),
+		"platform_css":      platform.css,
+		"platform_name":     platform.provider,
 		"is_cymbal_brand":   isCymbalBrand,
 		"assistant_enabled": assistantEnabled,
-		"deploymentDetails": deploymentDetailsMap,
+		"deploymentDetails": deploymentDetails(),
 		"frontendMessage":   frontendMessage,
+		"banner_color":      banner.get(), // illustrates canary deployments
 		"currentYear":       time.Now().Year(),
 		"baseUrl":           baseUrl,
 	}
@@ -2382,7 +2732,7 @@ func injectCommonTemplateData(r *http.Request, payload map[string]interface{}) m
 
 func currentCurrency(r *http.Request) string {
 	c, _ := r.Cookie(cookieCurrency)
-	if c != nil {
+	if c != nil && whitelistedCurrencies[c.Value] {
 		return c.Value
 	}
 	return defaultCurrency
@@ -2404,6 +2754,25 @@ func cartIDs(c []*pb.CartItem) []string {
 	return out
 }
 
+// productPrices returns the USD prices of products, in order.
+func productPrices(products []*pb.Product) []*pb.Money {
+	out := make([]*pb.Money, len(products))
+	for i, p := range products {
+		out[i] = p.GetPriceUsd()
+	}
+	return out
+}
+
+// unitPrices returns the USD prices products are charged at, in order; see
+// unitPrice.
+func unitPrices(products []*pb.Product) []*pb.Money {
+	out := make([]*pb.Money, len(products))
+	for i, p := range products {
+		out[i] = unitPrice(p)
+	}
+	return out
+}
+
 // get total # of items in cart
 func cartSize(c []*pb.CartItem) int {
 	cartSize := 0
@@ -2413,9 +2782,88 @@ func cartSize(c []*pb.CartItem) int {
 	return cartSize
 }
 
+// orderLine is a unit price and the quantity bought at it.
+type orderLine struct {
+	Price    *pb.Money
+	Quantity int32
+}
+
+// cartOrderLines pairs the cart items with their unit prices, in order.
+func cartOrderLines(cart []*pb.CartItem, prices []*pb.Money) []orderLine {
+	lines := make([]orderLine, len(cart))
+	for i, item := range cart {
+		lines[i] = orderLine{Price: prices[i], Quantity: item.GetQuantity()}
+	}
+	return lines
+}
+
+// orderResultLines returns the lines of a placed order.
+func orderResultLines(order *pb.OrderResult) []orderLine {
+	lines := make([]orderLine, len(order.GetItems()))
+	for i, item := range order.GetItems() {
+		lines[i] = orderLine{Price: item.GetCost(), Quantity: item.GetItem().GetQuantity()}
+	}
+	return lines
+}
+
+// computeOrderTotal returns the total of each line and the grand total:
+// the line totals plus shipping, in currency. The cart page and the order
+// confirmation both price baskets with it, so their totals cannot drift. It
+// fails, rather than panicking, on missing prices, amounts in another
+// currency and overflows.
+func computeOrderTotal(lines []orderLine, shippingCost *pb.Money, currency string) ([]pb.Money, pb.Money, error) {
+	total := pb.Money{CurrencyCode: currency}
+	lineTotals := make([]pb.Money, len(lines))
+	for i, line := range lines {
+		if line.Price == nil {
+			return nil, pb.Money{}, errors.Errorf("line %d has no price", i+1)
+		}
+		lineTotal, err := money.MultiplySlow(*line.Price, uint32(line.Quantity))
+		if err == nil {
+			total, err = money.Sum(total, lineTotal)
+		}
+		if err != nil {
+			return nil, pb.Money{}, errors.Wrapf(err, "could not add line %d", i+1)
+		}
+		lineTotals[i] = lineTotal
+	}
+	if shippingCost != nil {
+		var err error
+		if total, err = money.Sum(total, *shippingCost); err != nil {
+			return nil, pb.Money{}, errors.Wrap(err, "could not add shipping")
+		}
+	}
+	return lineTotals, total, nil
+}
+
+// orderTotal returns what was paid for order: each item's cost times its
+// quantity, plus shipping. The order's amounts share the currency they were
+// charged in; currency is used when the order has no amounts.
+func orderTotal(order *pb.OrderResult, currency string) (pb.Money, error) {
+	if order.GetShippingCost() != nil {
+		currency = order.GetShippingCost().GetCurrencyCode()
+	} else if len(order.GetItems()) > 0 {
+		currency = order.GetItems()[0].GetCost().GetCurrencyCode()
+	}
+	_, total, err := computeOrderTotal(orderResultLines(order), order.GetShippingCost(), currency)
+	return total, err
+}
+
+// formatAmount formats m without a currency symbol, with the currency's
+// fraction digits like renderMoney, for JSON responses. A nil m formats as
+// zero.
+func formatAmount(m *pb.Money) string {
+	if m == nil {
+		m = &pb.Money{}
+	}
+	return money.Format(m, currencyDigits(m.GetCurrencyCode()))
+}
+
+// renderMoney formats m with its currency symbol and as many fraction digits
+// as the currency uses (see currencyDigits), rounded like formatAmount so
+// pages and the JSON API show the same amount.
 func renderMoney(money pb.Money) string {
-	currencyLogo := renderCurrencyLogo(money.GetCurrencyCode())
-	return fmt.Sprintf("%s%d.%02d", currencyLogo, money.GetUnits(), money.GetNanos()/10000000)
+	return renderCurrencyLogo(money.GetCurrencyCode()) + formatAmount(&money)
 }
 
 func renderCurrencyLogo(currencyCode string) string {
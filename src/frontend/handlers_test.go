@@ -0,0 +1,1313 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/money"
+)
+
+// newTestRequest returns a request carrying the values the middleware would
+// normally inject.
+func newTestRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	ctx := context.WithValue(r.Context(), ctxKeyLog{}, discardLogger())
+	ctx = context.WithValue(ctx, ctxKeySessionID{}, "test-session")
+	return r.WithContext(ctx)
+}
+
+// discardLogger returns a logger that drops everything.
+func discardLogger() logrus.FieldLogger {
+	logger := logrus.New()
+	logger.Out = io.Discard
+	return logger
+}
+
+// serveGRPC starts an in-process gRPC server configured by register and
+// returns a client connection to it.
+func serveGRPC(t testing.TB, register func(*grpc.Server)) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	register(srv)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+type fakeCheckoutService struct {
+	pb.UnimplementedCheckoutServiceServer
+	got *pb.PlaceOrderRequest
+}
+
+func (f *fakeCheckoutService) PlaceOrder(ctx context.Context, req *pb.PlaceOrderRequest) (*pb.PlaceOrderResponse, error) {
+	f.got = req
+	return &pb.PlaceOrderResponse{Order: &pb.OrderResult{
+		OrderId:            "order-42",
+		ShippingTrackingId: "TR-42",
+		ShippingCost:       &pb.Money{CurrencyCode: req.GetUserCurrency(), Units: 8, Nanos: 990000000},
+		Items: []*pb.OrderItem{
+			{Item: &pb.CartItem{ProductId: "A", Quantity: 2}, Cost: &pb.Money{CurrencyCode: req.GetUserCurrency(), Units: 10, Nanos: 500000000}},
+			{Item: &pb.CartItem{ProductId: "B", Quantity: 1}, Cost: &pb.Money{CurrencyCode: req.GetUserCurrency(), Units: 3}},
+		},
+	}}, nil
+}
+
+const validCheckoutBody = `{
+	"userId": "user-1",
+	"userDetails": {
+		"name": "Someone",
+		"email": "someone@example.com",
+		"address": {"streetAddress": "1600 Amphitheatre Parkway", "city": "Mountain View", "state": "CA", "zipCode": 94043, "country": "United States"}
+	},
+	"paymentInfo": {"last4": "0454", "cardNumber": "4432801561520454", "expirationMonth": 1, "expirationYear": 2030, "cvv": 672}
+}`
+
+func TestAPICheckoutPlacesOrder(t *testing.T) {
+	checkout := &fakeCheckoutService{}
+	fe := &frontendServer{sessionKey: testSessionKey, checkoutSvcConn: serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCheckoutServiceServer(s, checkout)
+	})}
+	body := strings.Replace(validCheckoutBody, `"user-1"`, `"`+fe.signSessionID("user-1")+`"`, 1)
+
+	rec := httptest.NewRecorder()
+	fe.apiCheckout(rec, newTestRequest(http.MethodPost, "/api/checkout", body))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["order_id"] != "order-42" || resp["tracking_id"] != "TR-42" || resp["demo"] != nil {
+		t.Errorf("unexpected response %v", resp)
+	}
+	if resp["currency"] != "USD" || resp["shipping_cost"] != "8.99" || resp["total"] != "32.99" {
+		t.Errorf("currency, shipping and total = %v, %v, %v, want USD, 8.99, 32.99", resp["currency"], resp["shipping_cost"], resp["total"])
+	}
+	wantItems := []any{
+		map[string]any{"product_id": "A", "quantity": float64(2), "price": "10.50", "line_total": "21.00"},
+		map[string]any{"product_id": "B", "quantity": float64(1), "price": "3.00", "line_total": "3.00"},
+	}
+	if !reflect.DeepEqual(resp["items"], wantItems) {
+		t.Errorf("items = %v, want %v", resp["items"], wantItems)
+	}
+	if checkout.got == nil {
+		t.Fatal("PlaceOrder was not called")
+	}
+	if got := checkout.got; got.GetUserId() != "user-1" || got.GetEmail() != "someone@example.com" ||
+		got.GetAddress().GetZipCode() != 94043 || got.GetAddress().GetState() != "CA" ||
+		got.GetCreditCard().GetCreditCardNumber() != "4432801561520454" {
+		t.Errorf("unexpected PlaceOrder request %v", got)
+	}
+}
+
+func TestAPICheckoutValidation(t *testing.T) {
+	fe := &frontendServer{}
+	body := strings.Replace(validCheckoutBody, `"last4": "0454"`, `"last4": "9999"`, 1)
+
+	rec := httptest.NewRecorder()
+	fe.apiCheckout(rec, newTestRequest(http.MethodPost, "/api/checkout", body))
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	if e := decodeAPIError(t, rec); e.Code != errCodeValidationFailed {
+		t.Errorf("code = %q, want %q", e.Code, errCodeValidationFailed)
+	}
+}
+
+func TestAPICheckoutMissingField(t *testing.T) {
+	fe := &frontendServer{}
+	for field, body := range map[string]string{
+		"userDetails.email":           strings.Replace(validCheckoutBody, `"someone@example.com"`, `""`, 1),
+		"userDetails.address.zipCode": strings.Replace(validCheckoutBody, `"zipCode": 94043, `, "", 1),
+		"userDetails.address.city":    strings.Replace(validCheckoutBody, `"city": "Mountain View", `, "", 1),
+	} {
+		rec := httptest.NewRecorder()
+		fe.apiCheckout(rec, newTestRequest(http.MethodPost, "/api/checkout", body))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s missing: status = %d, want %d", field, rec.Code, http.StatusBadRequest)
+			continue
+		}
+		if e := decodeAPIError(t, rec); e.Code != errCodeBadRequest || !strings.Contains(e.Message, field) {
+			t.Errorf("%s missing: error = %+v, want it named", field, e)
+		}
+	}
+}
+
+func TestAPICheckoutDemoMode(t *testing.T) {
+	fe := &frontendServer{checkoutDemoMode: true, cartSvcConn: serveGRPC(t, func(*grpc.Server) {})}
+
+	rec := httptest.NewRecorder()
+	fe.apiCheckout(rec, newTestRequest(http.MethodPost, "/api/checkout", `{"userId": "user-1", "paymentInfo": {"last4": "0454"}}`))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["demo"] != true || !strings.HasPrefix(resp["order_id"].(string), "DEMO-") {
+		t.Errorf("unexpected demo response %v", resp)
+	}
+}
+
+type fakeProductCatalog struct {
+	pb.UnimplementedProductCatalogServiceServer
+	products []*pb.Product
+}
+
+func (f *fakeProductCatalog) ListProducts(context.Context, *pb.Empty) (*pb.ListProductsResponse, error) {
+	return &pb.ListProductsResponse{Products: f.products}, nil
+}
+
+func (f *fakeProductCatalog) GetProduct(ctx context.Context, req *pb.GetProductRequest) (*pb.Product, error) {
+	for _, p := range f.products {
+		if p.GetId() == req.GetId() {
+			return p, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "no product with ID %s", req.GetId())
+}
+
+func TestFallbackSearchPagination(t *testing.T) {
+	catalog := &fakeProductCatalog{}
+	for i := 0; i < fallbackSearchLimit+5; i++ {
+		catalog.products = append(catalog.products, &pb.Product{Id: fmt.Sprintf("MUG%02d", i), Name: "Mug"})
+	}
+	catalog.products = append(catalog.products, &pb.Product{Id: "HAT", Name: "Hat"})
+	fe := &frontendServer{productCatalogSvcConn: serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, catalog)
+	})}
+
+	tests := []struct {
+		name      string
+		target    string
+		wantCount int
+		wantFirst string
+	}{
+		{"first page", "/api/search/fallback?q=mug", fallbackSearchLimit, "MUG00"},
+		{"second page", "/api/search/fallback?q=mug&offset=10", 5, "MUG10"},
+		{"past the end", "/api/search/fallback?q=mug&offset=100", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			fe.fallbackSearchHandler(rec, newTestRequest(http.MethodGet, tt.target, ""))
+
+			var resp struct {
+				Products []struct {
+					ID string `json:"id"`
+				} `json:"products"`
+				Count int `json:"count"`
+				Total int `json:"total"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Total != fallbackSearchLimit+5 {
+				t.Errorf("total = %d, want %d", resp.Total, fallbackSearchLimit+5)
+			}
+			if resp.Count != tt.wantCount || len(resp.Products) != tt.wantCount {
+				t.Errorf("count = %d (%d products), want %d", resp.Count, len(resp.Products), tt.wantCount)
+			}
+			if tt.wantFirst != "" && (len(resp.Products) == 0 || resp.Products[0].ID != tt.wantFirst) {
+				t.Errorf("first product = %v, want %s", resp.Products, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestFallbackSearchInvalidOffset(t *testing.T) {
+	fe := &frontendServer{}
+	rec := httptest.NewRecorder()
+	fe.fallbackSearchHandler(rec, newTestRequest(http.MethodGet, "/api/search/fallback?q=mug&offset=-1", ""))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDoFallbackSearch(t *testing.T) {
+	catalog := &fakeProductCatalog{}
+	for i := 0; i < 6; i++ {
+		category := "kitchen"
+		if i%2 == 1 {
+			category = "office"
+		}
+		catalog.products = append(catalog.products, &pb.Product{
+			Id: fmt.Sprintf("MUG%d", i), Name: "Mug", Categories: []string{category},
+		})
+	}
+	fe := &frontendServer{productCatalogSvcConn: serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, searchableCatalog{catalog})
+	})}
+
+	tests := []struct {
+		name      string
+		opts      fallbackSearchOptions
+		wantIDs   []string
+		wantTotal int
+	}{
+		{"default limit", fallbackSearchOptions{}, []string{"MUG0", "MUG1", "MUG2", "MUG3", "MUG4", "MUG5"}, 6},
+		{"limit", fallbackSearchOptions{Limit: 2}, []string{"MUG0", "MUG1"}, 6},
+		{"limit and offset", fallbackSearchOptions{Limit: 2, Offset: 3}, []string{"MUG3", "MUG4"}, 6},
+		{"offset past the end", fallbackSearchOptions{Limit: 2, Offset: 6}, nil, 6},
+		{"category", fallbackSearchOptions{Limit: 10, Category: "Office"}, []string{"MUG1", "MUG3", "MUG5"}, 3},
+		{"category page", fallbackSearchOptions{Limit: 1, Offset: 1, Category: "kitchen"}, []string{"MUG2"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := fe.doFallbackSearch(context.Background(), "mug", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, p := range resp["products"].([]map[string]interface{}) {
+				ids = append(ids, p["id"].(string))
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("products = %v, want %v", ids, tt.wantIDs)
+			}
+			if resp["total"] != tt.wantTotal {
+				t.Errorf("total = %v, want %d", resp["total"], tt.wantTotal)
+			}
+			if resp["count"] != len(tt.wantIDs) {
+				t.Errorf("count = %v, want %d", resp["count"], len(tt.wantIDs))
+			}
+		})
+	}
+}
+
+func TestFallbackSearchInvalidLimit(t *testing.T) {
+	fe := &frontendServer{}
+	for _, limit := range []string{"0", "-1", "abc", "1000"} {
+		rec := httptest.NewRecorder()
+		fe.fallbackSearchHandler(rec, newTestRequest(http.MethodGet, "/api/search?q=mug&limit="+limit, ""))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("limit=%s: status = %d, want %d", limit, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestFallbackSearchMatchesCatalogSearch(t *testing.T) {
+	catalog := &fakeProductCatalog{products: []*pb.Product{
+		{Id: "MUG", Name: "Mug"},
+		{Id: "JAR", Name: "Jar", Description: "Holds more than a mug"},
+		{Id: "CUP", Name: "Travel mug"},
+	}}
+	fe := &frontendServer{productCatalogSvcConn: serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, searchableCatalog{catalog})
+	})}
+
+	primary, err := fe.searchProducts(context.Background(), "mug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, p := range primary {
+		want = append(want, p.GetId())
+	}
+
+	rec := httptest.NewRecorder()
+	fe.fallbackSearchHandler(rec, newTestRequest(http.MethodGet, "/api/search/fallback?q=mug", ""))
+	var resp struct {
+		Products []struct {
+			ID string `json:"id"`
+		} `json:"products"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range resp.Products {
+		got = append(got, p.ID)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fallback search = %v, catalog search = %v", got, want)
+	}
+}
+
+func TestParseAgentResponseShapes(t *testing.T) {
+	const finalState = `{"search_results": {"summary": "Here you go", "products": [
+		{"id": "OLJCESPC7Z", "name": "Sunglasses", "description": "Shades", "picture": "/static/img/products/sunglasses.jpg"}
+	]}}`
+	want := []map[string]interface{}{{
+		"id":          "OLJCESPC7Z",
+		"name":        "Sunglasses",
+		"description": "Shades",
+		"picture":     "/static/img/products/sunglasses.jpg",
+		"price":       "",
+		"categories":  []string{},
+	}}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"object", finalState},
+		{"array with final state last", `[
+			{"content": {"parts": [{"text": "Searching..."}]}},
+			{"content": {"parts": [{"functionCall": {"name": "search"}}]}},
+			` + finalState + `
+		]`},
+		{"array with function response", `[
+			{"content": {"parts": [{"functionResponse": {"response": [
+				{"id": "OLJCESPC7Z", "name": "Sunglasses", "description": "Shades", "picture": "/static/img/products/sunglasses.jpg"}
+			]}}]}},
+			{"content": {"parts": [{"text": "Here you go"}]}}
+		]`},
+	}
+	fe := &frontendServer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, products, err := fe.parseAgentResponse(discardLogger(), strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message != "Here you go" {
+				t.Errorf("message = %q, want %q", message, "Here you go")
+			}
+			if !reflect.DeepEqual(products, want) {
+				t.Errorf("products = %v, want %v", products, want)
+			}
+		})
+	}
+}
+
+func TestParseAgentResponseInvalid(t *testing.T) {
+	fe := &frontendServer{}
+	for _, body := range []string{`[]`, `"text"`, `[1, 2]`, `not json`} {
+		if _, _, err := fe.parseAgentResponse(discardLogger(), strings.NewReader(body)); err == nil {
+			t.Errorf("parseAgentResponse(%s) succeeded, want error", body)
+		}
+	}
+}
+
+func TestParseAgentAssistantResponseSchemas(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantMessage string
+		wantIDs     []string
+	}{
+		{
+			name: "order summary",
+			body: `{"order_summary": {"summary": "Order 123 placed.", "order_id": "123", "items": [
+				{"product_id": "OLJCESPC7Z", "name": "Sunglasses", "quantity": 2},
+				{"product_id": "66VCHSJNUP", "name": "Tank Top", "quantity": 1}
+			]}}`,
+			wantMessage: "Order 123 placed.",
+			wantIDs:     []string{"OLJCESPC7Z", "66VCHSJNUP"},
+		},
+		{
+			name: "order summary in state delta",
+			body: `{"actions": {"stateDelta": {"order_summary": {"message": "Order placed.", "products": [
+				{"id": "OLJCESPC7Z", "name": "Sunglasses"}
+			]}}}}`,
+			wantMessage: "Order placed.",
+			wantIDs:     []string{"OLJCESPC7Z"},
+		},
+		{
+			name: "comparison",
+			body: `{"comparison": {"summary": "Both are mugs.", "verdict": "Pick the blue one.", "products": [
+				{"id": "6E92ZMYYFZ", "name": "Mug", "picture": "/static/img/products/mug.jpg"},
+				{"id": "1YMWWN1N4O", "name": "Watch", "picture": "/static/img/products/watch.jpg"}
+			]}}`,
+			wantMessage: "Both are mugs. Pick the blue one.",
+			wantIDs:     []string{"6E92ZMYYFZ", "1YMWWN1N4O"},
+		},
+		{
+			name:        "lookalike non-product map",
+			body:        `{"content": {"parts": [{"functionCall": {"id": "adk-1", "name": "search_products", "args": {"query": "mug"}}}]}}`,
+			wantMessage: "",
+			wantIDs:     nil,
+		},
+	}
+	fe := &frontendServer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var event adkEvent
+			if err := json.Unmarshal([]byte(tt.body), &event); err != nil {
+				t.Fatal(err)
+			}
+			message, products := fe.parseAgentAssistantResponse(discardLogger(), event)
+			if message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", message, tt.wantMessage)
+			}
+			var ids []string
+			for _, p := range products {
+				ids = append(ids, p["id"].(string))
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("product ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestNormalizeProductMapPrice(t *testing.T) {
+	tests := []struct {
+		name      string
+		product   string
+		wantPrice string
+	}{
+		{"string price", `{"id": "A", "name": "Mug", "price": "$8.99"}`, "$8.99"},
+		{"structured price", `{"id": "A", "name": "Mug", "price_usd": {"currency_code": "USD", "units": 8, "nanos": 990000000}}`, "$8.99"},
+		{"camel case price", `{"id": "A", "name": "Mug", "priceUsd": {"currencyCode": "EUR", "units": "8", "nanos": 500000000}}`, "€8.50"},
+		{"no price", `{"id": "A", "name": "Mug"}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.product), &m); err != nil {
+				t.Fatal(err)
+			}
+			got := normalizeProductMap(m)
+			if got["price"] != tt.wantPrice {
+				t.Errorf("price = %#v, want %q", got["price"], tt.wantPrice)
+			}
+			if got["id"] != "A" || got["name"] != "Mug" {
+				t.Errorf("existing keys not kept: %v", got)
+			}
+		})
+	}
+}
+
+func TestNormalizeProductMapCategories(t *testing.T) {
+	got := normalizeProductMap(map[string]interface{}{
+		"id":         "A",
+		"categories": []interface{}{"kitchen", 1, "home"},
+	})
+	if want := []string{"kitchen", "home"}; !reflect.DeepEqual(got["categories"], want) {
+		t.Errorf("categories = %v, want %v", got["categories"], want)
+	}
+}
+
+type fakeCurrencyService struct {
+	pb.UnimplementedCurrencyServiceServer
+}
+
+func (fakeCurrencyService) GetSupportedCurrencies(context.Context, *pb.Empty) (*pb.GetSupportedCurrenciesResponse, error) {
+	return &pb.GetSupportedCurrenciesResponse{CurrencyCodes: []string{"USD", "EUR"}}, nil
+}
+
+// Convert halves the amount, which is enough to tell converted prices apart.
+// Like the real service it fails for currencies it does not know.
+func (fakeCurrencyService) Convert(ctx context.Context, req *pb.CurrencyConversionRequest) (*pb.Money, error) {
+	if !whitelistedCurrencies[req.GetToCode()] {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported currency %q", req.GetToCode())
+	}
+	nanos := int64(req.GetFrom().GetUnits())*1e9 + int64(req.GetFrom().GetNanos())
+	nanos /= 2
+	return &pb.Money{CurrencyCode: req.GetToCode(), Units: nanos / 1e9, Nanos: int32(nanos % 1e9)}, nil
+}
+
+func TestHandleChatWithAgentsConvertsPrices(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/run" {
+			io.WriteString(w, `{"search_results": {"summary": "Mugs", "products": [
+				{"id": "A", "name": "Mug", "price": "$9.00"},
+				{"id": "B", "name": "Cup", "price": "call us"},
+				{"id": "C", "name": "Jar"}
+			]}}`)
+			return
+		}
+		io.WriteString(w, `{"id": "adk-session"}`)
+	}))
+	defer gateway.Close()
+	fe := &frontendServer{
+		agentsGatewayURL: gateway.URL,
+		currencySvcConn: serveGRPC(t, func(s *grpc.Server) {
+			pb.RegisterCurrencyServiceServer(s, fakeCurrencyService{})
+		}),
+	}
+
+	req := newTestRequest(http.MethodPost, "/bot", `{"message": "mugs"}`)
+	req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "EUR"})
+	rec := httptest.NewRecorder()
+	fe.handleChatWithAgents(rec, req, req.Context().Value(ctxKeyLog{}).(logrus.FieldLogger))
+
+	var resp struct {
+		Products []map[string]interface{} `json:"products"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body.String())
+	}
+	var prices []interface{}
+	for _, p := range resp.Products {
+		prices = append(prices, p["price"])
+	}
+	if want := []interface{}{"€4.50", "call us", ""}; !reflect.DeepEqual(prices, want) {
+		t.Errorf("prices = %v, want %v", prices, want)
+	}
+}
+
+func TestHandleChatWithAgentsSessionContinuity(t *testing.T) {
+	var created atomic.Int32
+	var mu sync.Mutex
+	var runSessions []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/run" {
+			var body SearchRequest
+			json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			runSessions = append(runSessions, body.SessionId)
+			mu.Unlock()
+			if body.SessionId == "foreign-session" {
+				http.Error(w, `{"detail": "Session not found"}`, http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, `{"message": "hi"}`)
+			return
+		}
+		created.Add(1)
+		io.WriteString(w, `{"id": "adk-session"}`)
+	}))
+	defer gateway.Close()
+	fe := &frontendServer{agentsGatewayURL: gateway.URL}
+
+	chat := func(body string) string {
+		t.Helper()
+		req := newTestRequest(http.MethodPost, "/bot", body)
+		rec := httptest.NewRecorder()
+		fe.handleChatWithAgents(rec, req, req.Context().Value(ctxKeyLog{}).(logrus.FieldLogger))
+		var resp struct {
+			SessionId string `json:"session_id"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%v: %s", err, rec.Body.String())
+		}
+		return resp.SessionId
+	}
+
+	first := chat(`{"message": "mugs"}`)
+	if first != "adk-session" {
+		t.Fatalf("first session_id = %q, want the ADK session", first)
+	}
+	if got := chat(`{"message": "cheaper ones", "sessionId": "` + first + `"}`); got != first {
+		t.Errorf("second session_id = %q, want %q", got, first)
+	}
+	if got := chat(`{"message": "and blue?"}`); got != first {
+		t.Errorf("session_id without sessionId = %q, want %q", got, first)
+	}
+	if got := chat(`{"message": "start over", "sessionId": "other-session"}`); got != "other-session" {
+		t.Errorf("session_id = %q, want the requested session", got)
+	}
+	// A session the gateway rejects is not pinned.
+	req := newTestRequest(http.MethodPost, "/bot", `{"message": "hijack", "sessionId": "foreign-session"}`)
+	fe.handleChatWithAgents(httptest.NewRecorder(), req, req.Context().Value(ctxKeyLog{}).(logrus.FieldLogger))
+	if got := chat(`{"message": "still there?"}`); got != "other-session" {
+		t.Errorf("session_id after a rejected session = %q, want other-session", got)
+	}
+
+	if n := created.Load(); n != 1 {
+		t.Errorf("created %d ADK sessions, want 1", n)
+	}
+	if want := []string{"adk-session", "adk-session", "adk-session", "other-session", "foreign-session", "other-session"}; !reflect.DeepEqual(runSessions, want) {
+		t.Errorf("/run sessions = %v, want %v", runSessions, want)
+	}
+}
+
+func TestLegacyChatBotHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"ok", http.StatusOK, `{"content": "hello"}`, http.StatusOK, `{"message":"hello"}`},
+		{"upstream error", http.StatusServiceUnavailable, `{"detail": "down"}`, http.StatusBadGateway, ""},
+		{"oversized", http.StatusOK, `{"content": "` + strings.Repeat("a", maxAssistantResponseBytes) + `"}`, http.StatusBadGateway, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer upstream.Close()
+			fe := &frontendServer{shoppingAssistantSvcAddr: strings.TrimPrefix(upstream.URL, "http://")}
+
+			rec := httptest.NewRecorder()
+			fe.legacyChatBotHandler(rec, newTestRequest(http.MethodPost, "/bot", `{"message": "hi"}`))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && strings.TrimSpace(rec.Body.String()) != tt.wantBody {
+				t.Errorf("body = %s, want %s", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestLegacyChatBotHandlerCancellation(t *testing.T) {
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client going away once the body is read.
+		io.ReadAll(r.Body)
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+	fe := &frontendServer{shoppingAssistantSvcAddr: strings.TrimPrefix(upstream.URL, "http://")}
+
+	req := newTestRequest(http.MethodPost, "/bot", `{"message": "hi"}`)
+	ctx, cancel := context.WithCancel(req.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fe.legacyChatBotHandler(httptest.NewRecorder(), req.WithContext(ctx))
+	}()
+
+	<-started
+	cancel()
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream call was not aborted")
+	}
+	<-done
+}
+
+func TestHandleChatWithAgentsCancellation(t *testing.T) {
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/run" {
+			io.WriteString(w, `{"id": "adk-session"}`)
+			return
+		}
+		io.ReadAll(r.Body)
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer gateway.Close()
+	var legacyCalls atomic.Int32
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		legacyCalls.Add(1)
+	}))
+	defer legacy.Close()
+	fe := &frontendServer{
+		agentsGatewayURL:         gateway.URL,
+		shoppingAssistantSvcAddr: strings.TrimPrefix(legacy.URL, "http://"),
+	}
+
+	req := newTestRequest(http.MethodPost, "/bot", `{"message": "mugs"}`)
+	ctx, cancel := context.WithCancel(req.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fe.handleChatWithAgents(httptest.NewRecorder(), req.WithContext(ctx), req.Context().Value(ctxKeyLog{}).(logrus.FieldLogger))
+	}()
+
+	<-started
+	start := time.Now()
+	cancel()
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("gateway call was not aborted")
+	}
+	<-done
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("handler returned %s after the client went away", elapsed)
+	}
+	if n := legacyCalls.Load(); n != 0 {
+		t.Errorf("fell back to the legacy assistant %d times for a gone client", n)
+	}
+}
+
+type fakeCartService struct {
+	pb.UnimplementedCartServiceServer
+	mu    sync.Mutex
+	items map[string][]*pb.CartItem
+}
+
+func (f *fakeCartService) GetCart(ctx context.Context, req *pb.GetCartRequest) (*pb.Cart, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &pb.Cart{UserId: req.GetUserId(), Items: f.items[req.GetUserId()]}, nil
+}
+
+func (f *fakeCartService) AddItem(ctx context.Context, req *pb.AddItemRequest) (*pb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.items == nil {
+		f.items = make(map[string][]*pb.CartItem)
+	}
+	f.items[req.GetUserId()] = append(f.items[req.GetUserId()], req.GetItem())
+	return &pb.Empty{}, nil
+}
+
+func (f *fakeCartService) EmptyCart(ctx context.Context, req *pb.EmptyCartRequest) (*pb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.items, req.GetUserId())
+	return &pb.Empty{}, nil
+}
+
+// countingProductCatalog records GetProduct calls.
+type countingProductCatalog struct {
+	pb.UnimplementedProductCatalogServiceServer
+	getProductCalls atomic.Int32
+}
+
+func (c *countingProductCatalog) GetProduct(ctx context.Context, req *pb.GetProductRequest) (*pb.Product, error) {
+	c.getProductCalls.Add(1)
+	return &pb.Product{Id: req.GetId()}, nil
+}
+
+func TestAPIGetCartPartial(t *testing.T) {
+	cart := &fakeCartService{items: map[string][]*pb.CartItem{
+		"complete": {{ProductId: "A", Quantity: 2}},
+		"partial":  {{ProductId: "A", Quantity: 2}, {ProductId: "GONE", Quantity: 1}},
+	}}
+	catalog := &fakeProductCatalog{products: []*pb.Product{
+		{Id: "A", Name: "Mug", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 10, Nanos: 500000000}},
+	}}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, cart)
+		pb.RegisterProductCatalogServiceServer(s, catalog)
+	})
+	fe := &frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn, sessionKey: testSessionKey}
+
+	tests := []struct {
+		userID      string
+		wantPartial bool
+		wantFailed  []string
+	}{
+		{"complete", false, nil},
+		{"partial", true, []string{"GONE"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userID, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			fe.apiGetCart(rec, newTestRequest(http.MethodGet, "/api/cart?userId="+fe.signSessionID(tt.userID), ""))
+			var resp struct {
+				Items            []map[string]any `json:"items"`
+				TotalPrice       string           `json:"total_price"`
+				Partial          bool             `json:"partial"`
+				FailedProductIDs []string         `json:"failed_product_ids"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Partial != tt.wantPartial || !reflect.DeepEqual(resp.FailedProductIDs, tt.wantFailed) {
+				t.Errorf("partial = %v, failed = %v; want %v, %v", resp.Partial, resp.FailedProductIDs, tt.wantPartial, tt.wantFailed)
+			}
+			if resp.TotalPrice != "21.00" {
+				t.Errorf("total_price = %q, want the enriched items' 21.00", resp.TotalPrice)
+			}
+			if len(resp.Items) != len(cart.items[tt.userID]) {
+				t.Errorf("got %d items, want every cart item", len(resp.Items))
+			}
+		})
+	}
+}
+
+func TestAPIGetCartExactCents(t *testing.T) {
+	cart := &fakeCartService{items: map[string][]*pb.CartItem{
+		"u": {{ProductId: "A", Quantity: 1}, {ProductId: "B", Quantity: 3}, {ProductId: "C", Quantity: 1}},
+	}}
+	// Each price rounds the wrong way when computed and formatted as a float.
+	catalog := &fakeProductCatalog{products: []*pb.Product{
+		{Id: "A", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 2, Nanos: 675000000}},
+		{Id: "B", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 0, Nanos: 335000000}},
+		{Id: "C", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 1, Nanos: 5000000}},
+	}}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, cart)
+		pb.RegisterProductCatalogServiceServer(s, catalog)
+	})
+	fe := &frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn, sessionKey: testSessionKey}
+
+	rec := httptest.NewRecorder()
+	fe.apiGetCart(rec, newTestRequest(http.MethodGet, "/api/cart?userId="+fe.signSessionID("u"), ""))
+	var resp struct {
+		Items []struct {
+			Price     string `json:"price"`
+			LineTotal string `json:"line_total"`
+		} `json:"items"`
+		TotalPrice string `json:"total_price"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, it := range resp.Items {
+		got = append(got, it.Price+"/"+it.LineTotal)
+	}
+	if want := []string{"2.68/2.68", "0.34/1.01", "1.01/1.01"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("price/line_total = %v, want %v", got, want)
+	}
+	if resp.TotalPrice != "4.69" {
+		t.Errorf("total_price = %q, want 4.69", resp.TotalPrice)
+	}
+}
+
+func TestAPICartCount(t *testing.T) {
+	cart := &fakeCartService{items: map[string][]*pb.CartItem{
+		"user-1":       {{ProductId: "A", Quantity: 2}, {ProductId: "B", Quantity: 3}},
+		"test-session": {{ProductId: "C", Quantity: 1}},
+	}}
+	catalog := &countingProductCatalog{}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, cart)
+		pb.RegisterProductCatalogServiceServer(s, catalog)
+	})
+	fe := &frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn, sessionKey: testSessionKey}
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/api/cart/count?userId=" + fe.signSessionID("user-1"), 5},
+		{"/api/cart/count", 1},
+		{"/api/cart/count?userId=" + fe.signSessionID("nobody"), 0},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		fe.apiCartCount(rec, newTestRequest(http.MethodGet, tt.target, ""))
+		var resp struct {
+			Count int `json:"count"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Count != tt.want {
+			t.Errorf("%s: count = %d, want %d", tt.target, resp.Count, tt.want)
+		}
+	}
+	if n := catalog.getProductCalls.Load(); n != 0 {
+		t.Errorf("GetProduct called %d times, want 0", n)
+	}
+}
+
+func TestInvalidCurrencyCookie(t *testing.T) {
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCurrencyServiceServer(s, fakeCurrencyService{})
+		pb.RegisterProductCatalogServiceServer(s, &fakeProductCatalog{products: []*pb.Product{
+			{Id: "A", Name: "Mug", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 8}},
+		}})
+		pb.RegisterCartServiceServer(s, &fakeCartService{})
+	})
+	fe := &frontendServer{currencySvcConn: conn, productCatalogSvcConn: conn, cartSvcConn: conn, adSvcConn: conn}
+	handler := ensureValidCurrency(http.HandlerFunc(fe.homeHandler))
+
+	req := newTestRequest(http.MethodGet, "/", "")
+	req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "XYZ"})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var corrected string
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == cookieCurrency {
+			corrected = c.Value
+		}
+	}
+	if corrected != defaultCurrency {
+		t.Errorf("currency cookie = %q, want it reset to %q", corrected, defaultCurrency)
+	}
+}
+
+func TestParseAgentResponseDeduplicatesProducts(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"repeated across events", `[
+			{"content": {"parts": [{"functionResponse": {"response": [{"id": "A", "name": "Mug"}, {"id": "B", "name": "Cup"}]}}]}},
+			{"content": {"parts": [{"functionResponse": {"response": {"id": "A", "name": "Mug"}}}]}}
+		]`},
+		{"repeated in structured output", `{"search_results": {"products": [
+			{"id": "A", "name": "Mug"}, {"id": "B", "name": "Cup"}, {"id": "A", "name": "Mug"}
+		]}}`},
+	}
+	fe := &frontendServer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, products, err := fe.parseAgentResponse(discardLogger(), strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, p := range products {
+				ids = append(ids, p["id"].(string))
+			}
+			if want := []string{"A", "B"}; !reflect.DeepEqual(ids, want) {
+				t.Errorf("product ids = %v, want %v", ids, want)
+			}
+		})
+	}
+}
+
+func TestAgentSearchResponseShape(t *testing.T) {
+	tests := []struct {
+		name        string
+		agentBody   string
+		wantCount   int
+		wantMessage string
+	}{
+		{"populated", `[
+			{"content": {"parts": [{"functionResponse": {"response": [{"id": "A", "name": "Mug"}, {"id": "B", "name": "Cup"}]}}]}},
+			{"content": {"parts": [{"text": "Here are some mugs."}]}}
+		]`, 2, "Here are some mugs."},
+		{"no results", `[{"content": {"parts": [{"text": ""}]}}]`, 0, "We couldn't find any products matching \"mugs\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/run" {
+					io.WriteString(w, tt.agentBody)
+					return
+				}
+				io.WriteString(w, `{"id": "adk-session"}`)
+			}))
+			defer gateway.Close()
+			fe := &frontendServer{agentsGatewayURL: gateway.URL}
+
+			rec := httptest.NewRecorder()
+			fe.agentSearchHandler(rec, newTestRequest(http.MethodPost, "/api/agent-search",
+				`{"userId": "u1", "newMessage": {"role": "user", "parts": [{"text": "mugs"}]}}`))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+			}
+			var resp map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for k := range resp {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if want := []string{"count", "message", "products", "query"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("keys = %v, want %v", keys, want)
+			}
+			var body struct {
+				Products []map[string]interface{} `json:"products"`
+				Query    string                   `json:"query"`
+				Count    int                      `json:"count"`
+				Message  string                   `json:"message"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body.Products == nil || len(body.Products) != tt.wantCount || body.Count != tt.wantCount || body.Query != "mugs" {
+				t.Errorf("response = %s, want %d products for query mugs", rec.Body.String(), tt.wantCount)
+			}
+			if !strings.HasPrefix(body.Message, tt.wantMessage) {
+				t.Errorf("message = %q, want it to start with %q", body.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+type fakeRecommendationService struct {
+	pb.UnimplementedRecommendationServiceServer
+	ids []string
+}
+
+func (f fakeRecommendationService) ListRecommendations(context.Context, *pb.ListRecommendationsRequest) (*pb.ListRecommendationsResponse, error) {
+	return &pb.ListRecommendationsResponse{ProductIds: f.ids}, nil
+}
+
+func TestGetRecommendationsLimit(t *testing.T) {
+	ranked := []string{"F", "E", "D", "C", "B", "A"}
+	tests := []struct {
+		name       string
+		configured int
+		limit      int
+		want       []string
+	}{
+		{"default", 0, 0, ranked[:defaultMaxRecommendations]},
+		{"configured", 3, 0, ranked[:3]},
+		{"per call", 3, 2, ranked[:2]},
+		{"more than available", 10, 0, ranked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog := &countingProductCatalog{}
+			conn := serveGRPC(t, func(s *grpc.Server) {
+				pb.RegisterRecommendationServiceServer(s, fakeRecommendationService{ids: ranked})
+				pb.RegisterProductCatalogServiceServer(s, catalog)
+			})
+			fe := &frontendServer{recommendationSvcConn: conn, productCatalogSvcConn: conn, maxRecommendations: tt.configured}
+
+			products, err := fe.getRecommendations(context.Background(), "user-1", nil, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, p := range products {
+				ids = append(ids, p.GetId())
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("ids = %v, want %v", ids, tt.want)
+			}
+			if n := int(catalog.getProductCalls.Load()); n != len(tt.want) {
+				t.Errorf("GetProduct called %d times, want %d", n, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestAPIBuyAgain(t *testing.T) {
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCurrencyServiceServer(s, fakeCurrencyService{})
+		pb.RegisterProductCatalogServiceServer(s, &fakeProductCatalog{products: []*pb.Product{
+			{Id: "MUG", Name: "Mug", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 8}},
+			{Id: "HAT", Name: "Hat", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 20}},
+			{Id: "SOLDOUT", Name: "Scarf", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 15}, Stock: proto.Int32(0)},
+		}})
+	})
+	orderOf := func(ids ...string) *pb.OrderResult {
+		order := &pb.OrderResult{}
+		for _, id := range ids {
+			order.Items = append(order.Items, &pb.OrderItem{Item: &pb.CartItem{ProductId: id, Quantity: 1}})
+		}
+		return order
+	}
+	fe := &frontendServer{currencySvcConn: conn, productCatalogSvcConn: conn, orders: &orderHistory{}, sessionKey: testSessionKey}
+	fe.orders.record("user-1", "", orderOf("MUG", "DISCONTINUED"))
+	fe.orders.record("user-1", "", orderOf("HAT", "SOLDOUT", "MUG"))
+
+	tests := []struct {
+		name    string
+		target  string
+		wantIDs []string
+	}{
+		{"with history", "/api/buy-again?userId=" + fe.signSessionID("user-1"), []string{"HAT", "MUG"}},
+		{"without history", "/api/buy-again?userId=" + fe.signSessionID("user-2"), []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTestRequest(http.MethodGet, tt.target, "")
+			req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "EUR"})
+			rec := httptest.NewRecorder()
+			fe.apiBuyAgain(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+			}
+			var resp struct {
+				Products []struct {
+					ID    string `json:"id"`
+					Price string `json:"price"`
+				} `json:"products"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Products == nil {
+				t.Fatalf("products is null: %s", rec.Body.String())
+			}
+			ids := []string{}
+			for _, p := range resp.Products {
+				ids = append(ids, p.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+			if len(resp.Products) > 0 && resp.Products[0].Price != "€10.00" {
+				t.Errorf("price = %q, want the converted €10.00", resp.Products[0].Price)
+			}
+		})
+	}
+}
+
+func TestSetCurrencyRedirect(t *testing.T) {
+	tests := []struct {
+		name    string
+		referer string
+		want    string
+	}{
+		{"same origin", "http://shop.example.com/product/MUG?ref=home", "/product/MUG?ref=home"},
+		{"relative", "/cart", "/cart"},
+		{"cross origin", "https://evil.example.com/phish", "/"},
+		{"protocol relative", "//evil.example.com/phish", "/"},
+		{"backslash host", "/\\evil.example.com", "/"},
+		{"javascript", "javascript:alert(1)", "/"},
+		{"empty", "", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(http.MethodPost, "http://shop.example.com/setCurrency", "")
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Body = io.NopCloser(strings.NewReader("currency_code=EUR"))
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			rec := httptest.NewRecorder()
+			(&frontendServer{}).setCurrencyHandler(rec, r)
+
+			if rec.Code != http.StatusFound {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+			}
+			if got := rec.Header().Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+			if c := rec.Result().Cookies(); len(c) != 1 || c[0].Name != cookieCurrency || c[0].Value != "EUR" {
+				t.Errorf("cookies = %v, want the currency set to EUR", c)
+			}
+		})
+	}
+}
+
+func TestCartAndOrderTotalsMatch(t *testing.T) {
+	eur := func(units int64, nanos int32) *pb.Money {
+		return &pb.Money{CurrencyCode: "EUR", Units: units, Nanos: nanos}
+	}
+	cart := []*pb.CartItem{{ProductId: "A", Quantity: 3}, {ProductId: "B", Quantity: 1}}
+	prices := []*pb.Money{eur(2, 675000000), eur(19, 990000000)}
+	shipping := eur(8, 990000000)
+	order := &pb.OrderResult{ShippingCost: shipping}
+	for i, item := range cart {
+		order.Items = append(order.Items, &pb.OrderItem{Item: item, Cost: prices[i]})
+	}
+
+	cartLines, cartTotal, err := computeOrderTotal(cartOrderLines(cart, prices), shipping, "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paid, err := orderTotal(order, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !money.AreEquals(cartTotal, paid) || money.Format(&paid, 2) != "37.01" {
+		t.Errorf("cart total = %v, order total = %v; want both EUR 37.01", &cartTotal, &paid)
+	}
+	if money.Format(&cartLines[0], 2) != "8.03" {
+		t.Errorf("first line total = %v, want 8.03", &cartLines[0])
+	}
+}
+
+func TestComputeOrderTotalErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []orderLine
+		shipping *pb.Money
+	}{
+		{"missing price", []orderLine{{Quantity: 1}}, nil},
+		{"overflow", []orderLine{{Price: &pb.Money{CurrencyCode: "USD", Units: math.MaxInt64 / 2}, Quantity: 3}}, nil},
+		{"mixed currencies", []orderLine{{Price: &pb.Money{CurrencyCode: "USD", Units: 1}, Quantity: 1}}, &pb.Money{CurrencyCode: "EUR", Units: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := computeOrderTotal(tt.lines, tt.shipping, "USD"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestPageRecommendationsSkipCartAndPageProducts(t *testing.T) {
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterRecommendationServiceServer(s, fakeRecommendationService{ids: []string{"A", "B", "C", "D", "E"}})
+		pb.RegisterProductCatalogServiceServer(s, &countingProductCatalog{})
+	})
+	fe := &frontendServer{recommendationSvcConn: conn, productCatalogSvcConn: conn}
+	cart := []*pb.CartItem{{ProductId: "B", Quantity: 1}}
+
+	products, err := fe.pageRecommendations(context.Background(), "user-1", []string{"A"}, cart, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, p := range products {
+		ids = append(ids, p.GetId())
+	}
+	if want := []string{"C", "D"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ids = %v, want %v", ids, want)
+	}
+}
+
+type capturingAdService struct {
+	pb.UnimplementedAdServiceServer
+	mu  sync.Mutex
+	got []string
+}
+
+func (f *capturingAdService) GetAds(ctx context.Context, req *pb.AdRequest) (*pb.AdResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.got = req.GetContextKeys()
+	return &pb.AdResponse{Ads: []*pb.Ad{{RedirectUrl: "/product/A", Text: "ad"}}}, nil
+}
+
+func TestChooseAdCapsContextKeys(t *testing.T) {
+	ads := &capturingAdService{}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterAdServiceServer(s, ads)
+	})
+	fe := &frontendServer{adSvcConn: conn, maxAdContextKeys: 3}
+	p := &pb.Product{Categories: []string{" Kitchen", "kitchen", "", "Decor ", "HOME", "garden", "tools"}}
+
+	if ad := fe.chooseAd(context.Background(), p.Categories, logrus.New()); ad == nil {
+		t.Fatal("chooseAd returned no ad")
+	}
+	if want := []string{"kitchen", "decor", "home"}; !reflect.DeepEqual(ads.got, want) {
+		t.Errorf("context keys = %q, want %q", ads.got, want)
+	}
+}
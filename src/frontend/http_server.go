@@ -0,0 +1,92 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Timeouts of the frontend HTTP server. Each can be overridden with a Go
+// duration string in the environment variable noted.
+var (
+	// HTTP_READ_HEADER_TIMEOUT: time to read the request headers.
+	httpReadHeaderTimeout = 5 * time.Second
+	// HTTP_READ_TIMEOUT: time to read the whole request, body included.
+	httpReadTimeout = 30 * time.Second
+	// HTTP_WRITE_TIMEOUT: time from the end of the request headers to the end
+	// of the response. It has to cover the slowest agent call, see
+	// agentChatTimeout.
+	httpWriteTimeout = 60 * time.Second
+	// HTTP_IDLE_TIMEOUT: time a keep-alive connection may sit idle.
+	httpIdleTimeout = 120 * time.Second
+	// SHUTDOWN_TIMEOUT: time in-flight requests get to finish after SIGTERM,
+	// below the pod's default 30s termination grace period.
+	shutdownTimeout = 25 * time.Second
+)
+
+func loadHTTPServerTimeouts(log logrus.FieldLogger) {
+	loadDurations(log, map[string]*time.Duration{
+		"HTTP_READ_HEADER_TIMEOUT": &httpReadHeaderTimeout,
+		"HTTP_READ_TIMEOUT":        &httpReadTimeout,
+		"HTTP_WRITE_TIMEOUT":       &httpWriteTimeout,
+		"HTTP_IDLE_TIMEOUT":        &httpIdleTimeout,
+		"SHUTDOWN_TIMEOUT":         &shutdownTimeout,
+	})
+}
+
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+}
+
+// serveGracefully serves srv on lis until ctx is done, then stops accepting
+// connections and waits up to shutdownTimeout for in-flight requests.
+func serveGracefully(ctx context.Context, log logrus.FieldLogger, srv *http.Server, lis net.Listener) error {
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(lis) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Infof("shutting down, draining requests for up to %s", shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return errors.Wrap(err, "in-flight requests did not finish in time")
+	}
+	return nil
+}
+
+// disableWriteTimeout lifts the server's write timeout for the response
+// being written to w. Streaming handlers (e.g. server-sent events) call it
+// before holding the connection open beyond httpWriteTimeout.
+func disableWriteTimeout(w http.ResponseWriter) error {
+	return http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
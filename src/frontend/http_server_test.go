@@ -0,0 +1,155 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// startTestServer serves handler with newHTTPServer on a local port.
+func startTestServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newHTTPServer(ln.Addr().String(), handler)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func TestHTTPServerDropsSlowHeaders(t *testing.T) {
+	defer func(d time.Duration) { httpReadHeaderTimeout = d }(httpReadHeaderTimeout)
+	httpReadHeaderTimeout = 100 * time.Millisecond
+
+	addr := startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called for an incomplete request")
+	}))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Send the request line and one header, then stall.
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: frontend\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	_, err = io.Copy(io.Discard, conn)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("server kept the connection of a slow client open")
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("connection dropped after %v, want about %v", took, httpReadHeaderTimeout)
+	}
+}
+
+func TestDisableWriteTimeout(t *testing.T) {
+	defer func(d time.Duration) { httpWriteTimeout = d }(httpWriteTimeout)
+	httpWriteTimeout = 50 * time.Millisecond
+
+	logger := logrus.New()
+	logger.Out = io.Discard
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := disableWriteTimeout(w); err != nil {
+			t.Errorf("disableWriteTimeout: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			time.Sleep(2 * httpWriteTimeout)
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			http.NewResponseController(w).Flush()
+		}
+	})
+	// Wrap like main does, so the controller has to unwrap the middlewares.
+	addr := startTestServer(t, gzipResponses(&logHandler{log: logger, next: stream}))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("stream cut off: %v", err)
+	}
+	if want := "data: 0\n\ndata: 1\n\ndata: 2\n\n"; string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestServeGracefullyDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		fmt.Fprint(w, "done")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.Out = io.Discard
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serveGracefully(ctx, logger, newHTTPServer(ln.Addr().String(), handler), ln) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{string(body), err}
+	}()
+
+	<-started
+	cancel() // as on SIGTERM
+
+	if err := <-served; err != nil {
+		t.Fatalf("serveGracefully = %v", err)
+	}
+	res := <-responses
+	if res.err != nil || res.body != "done" {
+		t.Fatalf("in-flight request = %q, %v; want it to complete", res.body, res.err)
+	}
+	if _, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second); err == nil {
+		t.Error("server still accepts connections after shutdown")
+	}
+}
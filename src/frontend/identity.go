@@ -0,0 +1,76 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// ctxKeyAuthUserID is the context key of the authenticated user id, set by
+// authentication middleware.
+type ctxKeyAuthUserID struct{}
+
+// authUserPrefix namespaces the ids of authenticated users. Authentication
+// middleware keys the request's session by the namespaced id and
+// ensureSessionID never accepts a cookie carrying the prefix, so an anonymous
+// shopper cannot pick a signed-in user's session, and with it their cart.
+const authUserPrefix = "user:"
+
+// authUserID returns the id the user authenticated as uid is known by, to
+// the agents and as the session carts and orders are keyed by.
+func authUserID(uid string) string { return authUserPrefix + uid }
+
+// identity is who a request is made by. UserID identifies the user to the
+// agents: the authenticated user id when there is one, otherwise the browser
+// session id, so anonymous shoppers keep today's behavior. SessionID is
+// always the browser session.
+type identity struct {
+	UserID        string
+	SessionID     string
+	Authenticated bool
+}
+
+// resolveIdentity returns the identity of r. The authenticated user id is
+// taken from the request context, where authentication middleware puts it.
+func (fe *frontendServer) resolveIdentity(r *http.Request) identity {
+	id := identity{SessionID: fe.getOrCreateSessionId(r)}
+	if uid, _ := r.Context().Value(ctxKeyAuthUserID{}).(string); uid != "" {
+		id.UserID, id.Authenticated = uid, true
+	} else {
+		id.UserID = id.SessionID
+	}
+	return id
+}
+
+// authUserHeaderMiddleware makes the user id in the given header the
+// request's identity, like jwtVerifier.middleware does for a token subject:
+// namespaced by authUserID, it becomes both the authenticated user id and the
+// session id that carts and orders are keyed by, so the agents and the cart
+// see the same user. The header must only be configured (AUTH_USER_HEADER)
+// behind a proxy that authenticates users and overwrites it. It must run
+// inside ensureSessionID.
+func authUserHeaderMiddleware(header string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if uid := strings.TrimSpace(r.Header.Get(header)); uid != "" {
+			uid = authUserID(uid)
+			ctx := context.WithValue(r.Context(), ctxKeyAuthUserID{}, uid)
+			ctx = context.WithValue(ctx, ctxKeySessionID{}, uid)
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveIdentity(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctxUser string
+		want    identity
+	}{
+		{"anonymous", "", identity{UserID: "test-session", SessionID: "test-session"}},
+		{"authenticated context", "bob", identity{UserID: "bob", SessionID: "test-session", Authenticated: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(http.MethodGet, "/bot", "")
+			if tt.ctxUser != "" {
+				r = r.WithContext(context.WithValue(r.Context(), ctxKeyAuthUserID{}, tt.ctxUser))
+			}
+			if got := (&frontendServer{}).resolveIdentity(r); got != tt.want {
+				t.Errorf("resolveIdentity() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAuthUserHeaderMiddleware checks that the proxy's user header keys both
+// the agents and the cart, so they agree on who the user is.
+func TestAuthUserHeaderMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		headerUser  string
+		wantUser    string
+		wantSession string
+	}{
+		{"proxy header", " alice ", "user:alice", "user:alice"},
+		{"no proxy header", "", "test-session", "test-session"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUser, gotSession string
+			h := authUserHeaderMiddleware("X-Auth-User", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser, gotSession = (&frontendServer{}).resolveIdentity(r).UserID, sessionID(r)
+			}))
+			r := newTestRequest(http.MethodGet, "/cart", "")
+			r.Header.Set("X-Auth-User", tt.headerUser)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if gotUser != tt.wantUser || gotSession != tt.wantSession {
+				t.Errorf("user, session = %q, %q; want %q, %q", gotUser, gotSession, tt.wantUser, tt.wantSession)
+			}
+		})
+	}
+}
+
+// TestAuthUserHeaderSessionClash checks that a session cookie naming a
+// header user does not reach that user's cart.
+func TestAuthUserHeaderSessionClash(t *testing.T) {
+	fe := &frontendServer{sessionKey: testSessionKey}
+	var got string
+	h := fe.ensureSessionID(authUserHeaderMiddleware("X-Auth-User", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = sessionID(r)
+	})))
+	serve := func(header, cookie string) string {
+		got = ""
+		r := httptest.NewRequest(http.MethodGet, "/cart", nil)
+		r.Header.Set("X-Auth-User", header)
+		r.AddCookie(&http.Cookie{Name: cookieSessionID, Value: cookie})
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		return got
+	}
+
+	victim := serve("alice", fe.signSessionID("alice-browser"))
+	for _, cookie := range []string{"alice", victim, fe.signSessionID(victim)} {
+		if s := serve("", cookie); s == victim {
+			t.Errorf("cookie %q reached the session of header user alice (%q)", cookie, s)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// visualSearchPrompt asks the visual search app for catalog products like
+// the attached image when the client sends no text of its own.
+const visualSearchPrompt = "Find products in the catalog that look like this image."
+
+// imageSearchRequest is the body of POST /api/search/image.
+type imageSearchRequest struct {
+	// Image is base64, optionally as a data URL.
+	Image string `json:"image"`
+	// Message optionally narrows the search, e.g. "in blue".
+	Message string `json:"message,omitempty"`
+}
+
+// decodeImageSearchRequest reads an image search body, returning the image
+// ready for an inlineData part. Images are held to the chat limits.
+func decodeImageSearchRequest(w http.ResponseWriter, r *http.Request) (imageSearchRequest, chatImage, error) {
+	var req imageSearchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxChatBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return req, chatImage{}, errors.New("request body is too large")
+		}
+		return req, chatImage{}, errors.New("request body must be a JSON object with an image")
+	}
+	images, err := decodeChatImages([]string{req.Image})
+	if err != nil {
+		return req, chatImage{}, err
+	}
+	if len(images) == 0 {
+		return req, chatImage{}, errors.New("image must not be empty")
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if len(req.Message) > maxChatMessageLength {
+		return req, chatImage{}, errors.New("message is too long")
+	}
+	return req, images[0], nil
+}
+
+// imageSearchHandler serves POST /api/search/image: it forwards the image to
+// the visual search app (by default the shopping assistant, which reads
+// attached images) and returns the products it finds, priced in the user's
+// currency. When the agent cannot be reached the response is an empty result
+// with a message rather than an error.
+func (fe *frontendServer) imageSearchHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+
+	req, img, err := decodeImageSearchRequest(w, r)
+	if err != nil {
+		log.WithField("error", err).Warn("invalid image search request")
+		writeAPIError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	}
+
+	message, products, err := fe.runVisualSearch(r.Context(), log, fe.resolveIdentity(r).UserID, req.Message, img)
+	if err != nil {
+		log.WithField("error", err).Error("visual search failed")
+		products = nil
+		message = "Image search is temporarily unavailable. Please try again later or search by text."
+	} else {
+		products = fe.dropStaleProducts(r.Context(), log, products)
+		fe.convertProductPrices(r.Context(), log, products, currentCurrency(r))
+		if len(products) == 0 {
+			message = "We couldn't find any products like this image. Try another photo or search by text."
+		}
+	}
+	if products == nil {
+		products = []map[string]interface{}{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"products": products,
+		"count":    len(products),
+		"message":  strings.TrimSpace(message),
+	})
+	log.WithField("count", len(products)).Info("image search request completed")
+}
+
+// runVisualSearch sends the image, and the optional text narrowing the
+// search, to the visual search app.
+func (fe *frontendServer) runVisualSearch(ctx context.Context, log logrus.FieldLogger, userId, text string, img chatImage) (string, []map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, agentChatTimeout)
+	defer cancel()
+
+	app := fe.agentApp(agentFeatureVisualSearch)
+	sessionId, err := fe.ensureADKSession(ctx, app, userId, "")
+	if err != nil {
+		return "", nil, err
+	}
+	if text == "" {
+		text = visualSearchPrompt
+	}
+	return fe.runAgent(ctx, log, SearchRequest{
+		AppName:   app,
+		UserId:    userId,
+		SessionId: sessionId,
+		NewMessage: map[string]interface{}{
+			"role": "user",
+			"parts": append([]map[string]interface{}{{"text": text}},
+				chatRequest{images: []chatImage{img}}.imageParts()...),
+		},
+	})
+}
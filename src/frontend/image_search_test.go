@@ -0,0 +1,108 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImageSearchHandler(t *testing.T) {
+	png := base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	pdf := base64.StdEncoding.EncodeToString([]byte("%PDF-1.4 not an image"))
+
+	var forwarded string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/run" {
+			body, _ := io.ReadAll(r.Body)
+			forwarded = string(body)
+			io.WriteString(w, `[{"content": {"parts": [{"functionResponse": {"response": [{"id": "A", "name": "Mug", "price": "$8.99"}]}}]}}]`)
+			return
+		}
+		io.WriteString(w, `{"id": "adk-session"}`)
+	}))
+	defer gateway.Close()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCount  int
+	}{
+		{"valid image", `{"image": "data:image/png;base64,` + png + `"}`, http.StatusOK, 1},
+		{"unsupported type", `{"image": "` + pdf + `"}`, http.StatusBadRequest, 0},
+		{"missing image", `{"message": "mugs"}`, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = ""
+			fe := &frontendServer{agentsGatewayURL: gateway.URL}
+			rec := httptest.NewRecorder()
+			fe.imageSearchHandler(rec, newTestRequest(http.MethodPost, "/api/search/image", tt.body))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body = %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if forwarded != "" {
+					t.Errorf("rejected image was forwarded to the agent: %s", forwarded)
+				}
+				return
+			}
+			var resp struct {
+				Products []map[string]interface{} `json:"products"`
+				Count    int                      `json:"count"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Count != tt.wantCount || len(resp.Products) != tt.wantCount {
+				t.Errorf("response = %s, want %d products", rec.Body.String(), tt.wantCount)
+			}
+			if !strings.Contains(forwarded, `"mimeType":"image/png"`) || !strings.Contains(forwarded, `"appName":"shopping_assistant_agent"`) {
+				t.Errorf("agent request = %s, want a PNG inlineData part for the visual search app", forwarded)
+			}
+		})
+	}
+}
+
+func TestImageSearchAgentUnavailable(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer gateway.Close()
+	fe := &frontendServer{agentsGatewayURL: gateway.URL}
+
+	png := base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	rec := httptest.NewRecorder()
+	fe.imageSearchHandler(rec, newTestRequest(http.MethodPost, "/api/search/image", `{"image": "`+png+`"}`))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var resp struct {
+		Products []interface{} `json:"products"`
+		Message  string        `json:"message"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Products == nil || len(resp.Products) != 0 || !strings.Contains(resp.Message, "unavailable") {
+		t.Errorf("response = %s, want an empty result explaining the outage", rec.Body.String())
+	}
+}
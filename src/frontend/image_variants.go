@@ -0,0 +1,196 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+/img/{productId} redirects to the best format of a product picture that the
+client accepts. Next-gen variants are published next to the original picture
+under the same path with the format as extension, e.g. mug.jpg has mug.avif
+and mug.webp. IMAGE_VARIANTS lists the published formats in order of
+preference ("avif,webp"); it is empty by default, so every client gets the
+original. Variants of pictures served from ./static are only used if the file
+exists.
+*/
+
+// imageVariantTTL is how long a negotiated picture URL is reused before the
+// product is looked up again.
+const imageVariantTTL = 10 * time.Minute
+
+var imageVariantFormats []string
+
+func init() {
+	imageVariantFormats = parseImageVariants(os.Getenv("IMAGE_VARIANTS"))
+}
+
+// parseImageVariants returns the formats in a comma-separated list, lowercased
+// and without a leading dot.
+func parseImageVariants(v string) []string {
+	var formats []string
+	for _, f := range strings.Split(v, ",") {
+		f = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(f), "."))
+		if f != "" {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+// acceptedImageFormats returns the configured formats that the Accept header
+// names explicitly, in order of preference. Wildcards do not count: legacy
+// browsers send "*/*" without being able to decode WebP or AVIF.
+func acceptedImageFormats(accept string, formats []string) []string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if !strings.HasPrefix(mediaType, "image/") {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			accepted[strings.TrimPrefix(mediaType, "image/")] = true
+		}
+	}
+	var out []string
+	for _, f := range formats {
+		if accepted[f] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// imageVariantURL returns picture with its extension replaced by format,
+// keeping any query string.
+func imageVariantURL(picture, format string) string {
+	p, query, _ := strings.Cut(picture, "?")
+	ext := path.Ext(p)
+	if ext == "" || strings.Contains(ext, "/") {
+		return ""
+	}
+	v := strings.TrimSuffix(p, ext) + "." + format
+	if query != "" {
+		v += "?" + query
+	}
+	return v
+}
+
+// imageVariantAvailable reports whether the variant can be served. Variants
+// on other origins are assumed to be published; local ones must exist under
+// ./static.
+func imageVariantAvailable(variant string) bool {
+	if isAbsoluteURL(variant) {
+		return true
+	}
+	rel, ok := strings.CutPrefix(variant, "/static/")
+	if !ok {
+		return false
+	}
+	rel, _, _ = strings.Cut(rel, "?")
+	_, err := os.Stat(filepath.Join("static", filepath.FromSlash(path.Clean("/"+rel))))
+	return err == nil
+}
+
+type imageVariantEntry struct {
+	url       string
+	expiresAt time.Time
+}
+
+// imageVariants caches negotiated picture URLs by product and accepted
+// formats.
+type imageVariants struct {
+	mu      sync.Mutex
+	entries map[string]imageVariantEntry
+}
+
+func (c *imageVariants) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expiresAt) {
+		return "", false
+	}
+	return e.url, true
+}
+
+func (c *imageVariants) put(key, url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]imageVariantEntry)
+	}
+	c.entries[key] = imageVariantEntry{url: url, expiresAt: time.Now().Add(imageVariantTTL)}
+}
+
+// productImageHandler redirects to the picture of a product, in the most
+// preferred next-gen format the client accepts if a variant is available.
+func (fe *frontendServer) productImageHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["productId"]
+	formats := acceptedImageFormats(r.Header.Get("Accept"), imageVariantFormats)
+	key := id + "|" + strings.Join(formats, ",")
+	w.Header().Set("Vary", "Accept")
+
+	if target, ok := fe.imageVariants.get(key); ok {
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+
+	p, err := fe.getProduct(r.Context(), id)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "could not retrieve product", http.StatusBadGateway)
+		return
+	}
+	if p.GetPicture() == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	picture := p.GetPicture()
+	for _, f := range formats {
+		if v := imageVariantURL(picture, f); v != "" && imageVariantAvailable(v) {
+			picture = v
+			break
+		}
+	}
+	target := renderImageURL(picture)
+	fe.imageVariants.put(key, target)
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+// lookupCountingCatalog counts GetProduct calls on top of fakeProductCatalog.
+type lookupCountingCatalog struct {
+	*fakeProductCatalog
+	lookups atomic.Int32
+}
+
+func (c *lookupCountingCatalog) GetProduct(ctx context.Context, req *pb.GetProductRequest) (*pb.Product, error) {
+	c.lookups.Add(1)
+	return c.fakeProductCatalog.GetProduct(ctx, req)
+}
+
+func TestAcceptedImageFormats(t *testing.T) {
+	formats := []string{"avif", "webp"}
+	tests := []struct {
+		accept string
+		want   []string
+	}{
+		{"", nil},
+		{"*/*", nil},
+		{"image/png,image/*;q=0.8,*/*;q=0.5", nil},
+		{"image/webp,*/*", []string{"webp"}},
+		{"image/avif,image/webp,image/apng,*/*;q=0.8", []string{"avif", "webp"}},
+		{"image/avif;q=0,image/webp", []string{"webp"}},
+		{"IMAGE/WEBP", []string{"webp"}},
+	}
+	for _, tt := range tests {
+		if got := acceptedImageFormats(tt.accept, formats); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("acceptedImageFormats(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestImageVariantURL(t *testing.T) {
+	tests := []struct {
+		picture, format, want string
+	}{
+		{"/static/img/products/mug.jpg", "webp", "/static/img/products/mug.webp"},
+		{"https://cdn.example.com/img/mug.jpg?w=200", "avif", "https://cdn.example.com/img/mug.avif?w=200"},
+		{"https://cdn.example.com/img/mug", "webp", ""},
+	}
+	for _, tt := range tests {
+		if got := imageVariantURL(tt.picture, tt.format); got != tt.want {
+			t.Errorf("imageVariantURL(%q, %q) = %q, want %q", tt.picture, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestProductImageHandler(t *testing.T) {
+	defer func(f []string) { imageVariantFormats = f }(imageVariantFormats)
+	imageVariantFormats = []string{"avif", "webp"}
+
+	catalog := &lookupCountingCatalog{fakeProductCatalog: &fakeProductCatalog{products: []*pb.Product{
+		{Id: "MUG", Picture: "https://cdn.example.com/img/mug.jpg"},
+		{Id: "JAR", Picture: "/static/img/products/bamboo-glass-jar.jpg"},
+	}}}
+	fe := &frontendServer{productCatalogSvcConn: serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, catalog)
+	})}
+
+	tests := []struct {
+		name   string
+		id     string
+		accept string
+		want   string
+	}{
+		{"webp client", "MUG", "image/webp,*/*", "https://cdn.example.com/img/mug.webp"},
+		{"avif client", "MUG", "image/avif,image/webp,*/*", "https://cdn.example.com/img/mug.avif"},
+		{"legacy client", "MUG", "*/*", "https://cdn.example.com/img/mug.jpg"},
+		{"webp client again", "MUG", "image/webp,*/*", "https://cdn.example.com/img/mug.webp"},
+		{"local variant missing", "JAR", "image/webp,*/*", baseUrl + "/static/img/products/bamboo-glass-jar.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mux.SetURLVars(newTestRequest(http.MethodGet, "/img/"+tt.id, ""), map[string]string{"productId": tt.id})
+			r.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			fe.productImageHandler(rec, r)
+
+			if rec.Code != http.StatusFound {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+			}
+			if got := rec.Header().Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("Vary = %q, want Accept", got)
+			}
+		})
+	}
+	if got := catalog.lookups.Load(); got != 4 {
+		t.Errorf("catalog looked up %d times, want 4 (the repeated webp request is cached)", got)
+	}
+
+	r := mux.SetURLVars(newTestRequest(http.MethodGet, "/img/NOPE", ""), map[string]string{"productId": "NOPE"})
+	rec := httptest.NewRecorder()
+	fe.productImageHandler(rec, r)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown product: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
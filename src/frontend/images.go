@@ -0,0 +1,143 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+/*
+Product pictures are stored in the catalog either as paths relative to the
+frontend (e.g. "/static/img/products/foo.jpg") or as absolute URLs. For
+CDN-fronted or air-gapped deployments, IMAGE_BASE_URL rewrites relative paths
+onto another origin. Absolute URLs are left untouched unless
+IMAGE_BASE_URL_FORCE is "true", in which case their scheme and host are
+swapped for the ones in IMAGE_BASE_URL.
+*/
+
+var (
+	imageBaseURL      string
+	forceImageRewrite bool
+	// placeholderImage is shown for products without a usable picture. Set
+	// with PRODUCT_IMAGE_PLACEHOLDER.
+	placeholderImage = "/static/img/products/placeholder.jpg"
+)
+
+func init() {
+	imageBaseURL = strings.TrimSpace(os.Getenv("IMAGE_BASE_URL"))
+	forceImageRewrite = strings.ToLower(os.Getenv("IMAGE_BASE_URL_FORCE")) == "true"
+	if v := strings.TrimSpace(os.Getenv("PRODUCT_IMAGE_PLACEHOLDER")); v != "" {
+		placeholderImage = v
+	}
+}
+
+// productPicture returns picture, or placeholderImage when picture is empty
+// or does not look like an image URL: agents sometimes send "", "null" or
+// free text.
+func productPicture(picture string) string {
+	picture = strings.TrimSpace(picture)
+	if !isImageURL(picture) {
+		return placeholderImage
+	}
+	return picture
+}
+
+// isImageURL is a basic sanity check of a picture URL: a path, or an http(s)
+// URL with a host.
+func isImageURL(picture string) bool {
+	if picture == "" || strings.ContainsAny(picture, " \t\n\r\"'<>") {
+		return false
+	}
+	switch strings.ToLower(picture) {
+	case "null", "undefined", "none", "n/a":
+		return false
+	}
+	u, err := url.Parse(picture)
+	if err != nil {
+		return false
+	}
+	if u.Scheme != "" {
+		return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	}
+	return u.Path != "" || u.Host != ""
+}
+
+// rewriteProductPictures rewrites the picture of each product in place
+// according to the configured image base URL.
+func rewriteProductPictures(products ...*pb.Product) {
+	if imageBaseURL == "" {
+		return
+	}
+	for _, p := range products {
+		if p != nil {
+			p.Picture = rewriteImageURL(p.GetPicture(), imageBaseURL, forceImageRewrite)
+		}
+	}
+}
+
+// rewriteImageURL returns picture rebased onto base. Relative paths are joined
+// to base; absolute URLs only have their scheme and host replaced when force
+// is set.
+func rewriteImageURL(picture, base string, force bool) string {
+	if picture == "" || base == "" {
+		return picture
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil || baseURL.Host == "" {
+		return picture
+	}
+	pic, err := url.Parse(picture)
+	if err != nil {
+		return picture
+	}
+	if pic.IsAbs() || pic.Host != "" {
+		if !force {
+			return picture
+		}
+		pic.Scheme = baseURL.Scheme
+		pic.Host = baseURL.Host
+		pic.Path = joinURLPath(baseURL.Path, pic.Path)
+		return pic.String()
+	}
+
+	out := *baseURL
+	out.Path = joinURLPath(baseURL.Path, pic.Path)
+	out.RawQuery = pic.RawQuery
+	out.Fragment = pic.Fragment
+	return out.String()
+}
+
+// isAbsoluteURL reports whether u points to another origin, in which case it
+// must not be prefixed with the frontend's base URL.
+func isAbsoluteURL(u string) bool {
+	return strings.HasPrefix(u, "//") || strings.Contains(u, "://")
+}
+
+// renderImageURL is the template helper for product pictures.
+func renderImageURL(picture string) string {
+	picture = productPicture(picture)
+	if isAbsoluteURL(picture) {
+		return picture
+	}
+	return baseUrl + picture
+}
+
+func joinURLPath(base, p string) string {
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(p, "/")
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestRewriteImageURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		picture string
+		base    string
+		force   bool
+		want    string
+	}{
+		{"no base", "/static/img/products/a.jpg", "", false, "/static/img/products/a.jpg"},
+		{"relative", "/static/img/products/a.jpg", "https://cdn.example.com", false, "https://cdn.example.com/static/img/products/a.jpg"},
+		{"relative without slash", "static/img/a.jpg", "https://cdn.example.com/", false, "https://cdn.example.com/static/img/a.jpg"},
+		{"relative with base path", "/static/img/a.jpg", "https://cdn.example.com/shop", false, "https://cdn.example.com/shop/static/img/a.jpg"},
+		{"absolute untouched", "https://images.example.org/a.jpg", "https://cdn.example.com", false, "https://images.example.org/a.jpg"},
+		{"absolute forced", "https://images.example.org/img/a.jpg?w=200", "http://cdn.internal", true, "http://cdn.internal/img/a.jpg?w=200"},
+		{"invalid base", "/static/img/a.jpg", "not a url", false, "/static/img/a.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteImageURL(tt.picture, tt.base, tt.force); got != tt.want {
+				t.Errorf("rewriteImageURL(%q, %q, %v) = %q, want %q", tt.picture, tt.base, tt.force, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderImageURL(t *testing.T) {
+	if got, want := renderImageURL("https://cdn.example.com/a.jpg"), "https://cdn.example.com/a.jpg"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := renderImageURL("/static/img/a.jpg"), baseUrl+"/static/img/a.jpg"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProductPicture(t *testing.T) {
+	tests := []struct {
+		name    string
+		picture string
+		want    string
+	}{
+		{"empty", "", placeholderImage},
+		{"whitespace", "  \t ", placeholderImage},
+		{"null", "null", placeholderImage},
+		{"free text", "a red mug", placeholderImage},
+		{"other scheme", "javascript:alert(1)", placeholderImage},
+		{"scheme without host", "https:///a.jpg", placeholderImage},
+		{"path", "/static/img/products/mug.jpg", "/static/img/products/mug.jpg"},
+		{"padded path", " /static/img/products/mug.jpg ", "/static/img/products/mug.jpg"},
+		{"absolute", "https://cdn.example.com/mug.jpg", "https://cdn.example.com/mug.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := productPicture(tt.picture); got != tt.want {
+				t.Errorf("productPicture(%q) = %q, want %q", tt.picture, got, tt.want)
+			}
+			if got := normalizeProductMap(map[string]interface{}{"id": "MUG", "picture": tt.picture})["picture"]; got != tt.want {
+				t.Errorf("normalizeProductMap picture = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if got, want := renderImageURL(""), baseUrl+placeholderImage; got != want {
+		t.Errorf("renderImageURL(\"\") = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksRefreshInterval is how long fetched signing keys are trusted.
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefetch rate-limits refetches triggered by unknown key ids.
+	jwksMinRefetch = time.Minute
+	// jwtClockSkew is the leeway allowed on exp and nbf.
+	jwtClockSkew = 30 * time.Second
+)
+
+// jwtVerifier verifies RS256 and ES256 bearer tokens against the keys
+// published at a JWKS URL.
+type jwtVerifier struct {
+	jwksURL  string
+	audience string
+	issuer   string // optional
+	client   *http.Client
+	now      func() time.Time
+
+	// refreshing lets one caller fetch the JWKS while the others wait for
+	// its result, without holding mu through the fetch.
+	refreshing singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// loadJWTVerifier configures JWT authentication from JWT_JWKS_URL,
+// JWT_AUDIENCE and the optional JWT_ISSUER. It returns nil, disabling
+// authentication, when JWT_JWKS_URL is not set.
+func loadJWTVerifier(log logrus.FieldLogger) *jwtVerifier {
+	jwksURL := os.Getenv("JWT_JWKS_URL")
+	if jwksURL == "" {
+		return nil
+	}
+	audience := os.Getenv("JWT_AUDIENCE")
+	if audience == "" {
+		log.Fatal("JWT_AUDIENCE must be set when JWT_JWKS_URL is")
+	}
+	log.Infof("JWT authentication enabled (audience %q)", audience)
+	return &jwtVerifier{
+		jwksURL:  jwksURL,
+		audience: audience,
+		issuer:   os.Getenv("JWT_ISSUER"),
+		client:   &http.Client{Timeout: 5 * time.Second},
+		now:      time.Now,
+	}
+}
+
+// middleware verifies the bearer token of each request carrying one and
+// makes the token subject the request's identity: namespaced by authUserID,
+// it becomes both the authenticated user id seen by resolveIdentity and the
+// session id that carts and orders are keyed by. Requests with an invalid or expired token
+// are rejected with 401; requests without one stay anonymous. It must run
+// inside ensureSessionID so the subject replaces the cookie session.
+func (v *jwtVerifier) middleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sub, err := v.verify(r.Context(), strings.TrimSpace(token))
+		if err != nil {
+			if log, ok := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger); ok {
+				log.WithError(err).Warn("rejected bearer token")
+			}
+			writeAPIError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "invalid or expired bearer token")
+			return
+		}
+		uid := authUserID(sub)
+		ctx := context.WithValue(r.Context(), ctxKeyAuthUserID{}, uid)
+		ctx = context.WithValue(ctx, ctxKeySessionID{}, uid)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// jwtClaims are the registered claims checked by verify. Audience may be a
+// string or an array of strings.
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+}
+
+// verify checks the signature, expiry, audience and issuer of a compact
+// JWS token and returns its subject.
+func (v *jwtVerifier) verify(ctx context.Context, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return "", fmt.Errorf("header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("signature: %w", err)
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+			return "", errors.New("bad signature")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 || !ecdsa.Verify(pub, digest[:],
+			new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return "", errors.New("bad signature")
+		}
+	default:
+		return "", fmt.Errorf("unsupported alg %q", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("claims: %w", err)
+	}
+	now := v.now()
+	if claims.ExpiresAt == nil || now.After(time.Unix(*claims.ExpiresAt, 0).Add(jwtClockSkew)) {
+		return "", errors.New("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtClockSkew).Before(time.Unix(*claims.NotBefore, 0)) {
+		return "", errors.New("token not yet valid")
+	}
+	if !claims.hasAudience(v.audience) {
+		return "", errors.New("wrong audience")
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return "", errors.New("wrong issuer")
+	}
+	if claims.Subject == "" {
+		return "", errors.New("missing subject")
+	}
+	return claims.Subject, nil
+}
+
+func (c jwtClaims) hasAudience(aud string) bool {
+	var one string
+	if json.Unmarshal(c.Audience, &one) == nil {
+		return one == aud
+	}
+	var many []string
+	return json.Unmarshal(c.Audience, &many) == nil && slices.Contains(many, aud)
+}
+
+func decodeJWTSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// key returns the signing key with the given id, fetching the JWKS when the
+// cached keys are stale or, at most once a minute, when the id is unknown
+// (the issuer may have rotated keys).
+func (v *jwtVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	age := v.now().Sub(v.fetchedAt)
+	key, ok := v.keys[kid]
+	v.mu.Unlock()
+	if (!ok && age >= jwksMinRefetch) || age >= jwksRefreshInterval {
+		keys, err := v.refreshKeys(ctx)
+		if err != nil {
+			if ok {
+				return key, nil // keep using the cached key
+			}
+			return nil, fmt.Errorf("fetch JWKS: %w", err)
+		}
+		key, ok = keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// refreshKeys fetches the JWKS and caches its keys. Concurrent callers share
+// a single fetch.
+func (v *jwtVerifier) refreshKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	keys, err, _ := v.refreshing.Do("", func() (any, error) {
+		keys, err := v.fetchKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v.mu.Lock()
+		v.keys, v.fetchedAt = keys, v.now()
+		v.mu.Unlock()
+		return keys, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys.(map[string]crypto.PublicKey), nil
+}
+
+// jsonWebKey holds the JWK members of the supported RSA and P-256 keys.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (v *jwtVerifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	b64 := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, errors.New("bad key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch {
+	case k.Kty == "RSA":
+		n, err := b64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point not on curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
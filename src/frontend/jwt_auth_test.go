@@ -0,0 +1,218 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestJWTVerifier serves a JWKS holding key as "k1" and returns a
+// verifier for audience "shop" trusting it.
+func newTestJWTVerifier(t *testing.T, key *rsa.PrivateKey) *jwtVerifier {
+	t.Helper()
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(jwks.Close)
+	return &jwtVerifier{jwksURL: jwks.URL, audience: "shop", client: jwks.Client(), now: time.Now}
+}
+
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fe := &frontendServer{sessionKey: testSessionKey}
+	var got identity
+	var gotSession string
+	h := fe.ensureSessionID(newTestJWTVerifier(t, key).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, gotSession = fe.resolveIdentity(r), sessionID(r)
+	})))
+	serve := func(token string) *httptest.ResponseRecorder {
+		got, gotSession = identity{}, ""
+		req := httptest.NewRequest(http.MethodGet, "/api/cart", nil)
+		req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: fe.signSessionID("browser-session")})
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	now := time.Now().Unix()
+
+	t.Run("valid token", func(t *testing.T) {
+		rec := serve(signTestJWT(t, key, map[string]any{"sub": "user-42", "aud": []string{"other", "shop"}, "exp": now + 300}))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		if want := (identity{UserID: "user:user-42", SessionID: "browser-session", Authenticated: true}); got != want {
+			t.Errorf("identity = %+v, want %+v", got, want)
+		}
+		if gotSession != "user:user-42" {
+			t.Errorf("cart session = %q, want the namespaced token subject", gotSession)
+		}
+	})
+	t.Run("expired token", func(t *testing.T) {
+		rec := serve(signTestJWT(t, key, map[string]any{"sub": "user-42", "aud": "shop", "exp": now - 3600}))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+		if code := decodeAPIError(t, rec).Code; code != errCodeUnauthorized {
+			t.Errorf("code = %q, want %q", code, errCodeUnauthorized)
+		}
+	})
+	t.Run("rejected tokens", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, token := range map[string]string{
+			"wrong audience": signTestJWT(t, key, map[string]any{"sub": "u", "aud": "elsewhere", "exp": now + 300}),
+			"wrong key":      signTestJWT(t, other, map[string]any{"sub": "u", "aud": "shop", "exp": now + 300}),
+			"no expiry":      signTestJWT(t, key, map[string]any{"sub": "u", "aud": "shop"}),
+			"malformed":      "not-a-jwt",
+		} {
+			if rec := serve(token); rec.Code != http.StatusUnauthorized {
+				t.Errorf("%s: status = %d, want %d", name, rec.Code, http.StatusUnauthorized)
+			}
+		}
+	})
+	t.Run("cookie naming a subject", func(t *testing.T) {
+		// Neither the bare subject nor its namespaced session, sent as a
+		// session cookie, reaches the subject's cart, even when signed.
+		for _, cookie := range []string{"user-42", "user:user-42", fe.signSessionID("user:user-42")} {
+			got, gotSession = identity{}, ""
+			req := httptest.NewRequest(http.MethodGet, "/api/cart", nil)
+			req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: cookie})
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if gotSession == "user:user-42" || got.Authenticated {
+				t.Errorf("cookie %q got identity %+v, session %q", cookie, got, gotSession)
+			}
+		}
+	})
+	t.Run("no token", func(t *testing.T) {
+		if rec := serve(""); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if got.Authenticated || got.UserID != "browser-session" || gotSession != "browser-session" {
+			t.Errorf("anonymous request got identity %+v, session %q", got, gotSession)
+		}
+	})
+}
+
+func TestJWKSFetchedOutsideLock(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		json.NewEncoder(w).Encode(map[string]any{"keys": []any{}})
+	}))
+	defer jwks.Close()
+	v := &jwtVerifier{jwksURL: jwks.URL, audience: "shop", client: jwks.Client(), now: time.Now,
+		keys: map[string]crypto.PublicKey{"k1": &key.PublicKey}, fetchedAt: time.Now().Add(-2 * jwksMinRefetch)}
+
+	// Tokens with unknown key ids trigger a refetch ...
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v.key(context.Background(), "made-up")
+		}()
+	}
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// ... which must not hold up tokens signed with a cached key.
+	verified := make(chan error)
+	go func() {
+		_, err := v.verify(context.Background(), signTestJWT(t, key, map[string]any{"sub": "u", "aud": "shop", "exp": time.Now().Unix() + 300}))
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("verify: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("verify blocked behind the JWKS fetch")
+	}
+	close(release)
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("%d JWKS fetches by 5 concurrent callers, want 1", n)
+	}
+}
+
+func TestJWTDisabled(t *testing.T) {
+	t.Setenv("JWT_JWKS_URL", "")
+	if v := loadJWTVerifier(logrus.New()); v != nil {
+		t.Fatal("verifier enabled without JWT_JWKS_URL")
+	}
+
+	// Without the middleware a bearer token is not an identity.
+	req := newTestRequest(http.MethodGet, "/api/cart", "")
+	req.Header.Set("Authorization", "Bearer "+strings.Repeat("x", 20))
+	got := (&frontendServer{}).resolveIdentity(req)
+	if got.Authenticated || got.UserID != "test-session" {
+		t.Errorf("identity = %+v, want the anonymous session", got)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+const cookieLocale = cookiePrefix + "locale"
+
+// supportedLocales are the locales money values can be formatted for. The
+// first entry is the fallback the matcher uses for unsupported languages.
+var supportedLocales = []language.Tag{
+	language.AmericanEnglish,
+	language.BritishEnglish,
+	language.German,
+	language.French,
+	language.Spanish,
+	language.Italian,
+	language.Japanese,
+	language.Turkish,
+}
+
+var localeMatcher = language.NewMatcher(supportedLocales)
+
+// symbolAfterLanguages place the currency symbol after the amount, separated
+// by a non-breaking space, e.g. "12,50 €".
+var symbolAfterLanguages = map[language.Base]bool{
+	language.MustParseBase("de"): true,
+	language.MustParseBase("fr"): true,
+	language.MustParseBase("es"): true,
+	language.MustParseBase("it"): true,
+}
+
+// currentLocale returns the locale to format values for, taken from the
+// locale cookie or the Accept-Language header. An empty string means no
+// preference was expressed and the legacy formatting should be used.
+func currentLocale(r *http.Request) string {
+	if c, err := r.Cookie(cookieLocale); err == nil && c.Value != "" {
+		if tag, err := language.Parse(c.Value); err == nil {
+			return matchLocale(tag)
+		}
+	}
+	if accept := r.Header.Get("Accept-Language"); accept != "" {
+		tags, _, err := language.ParseAcceptLanguage(accept)
+		if err == nil && len(tags) > 0 {
+			return matchLocale(tags...)
+		}
+	}
+	return ""
+}
+
+// setLocaleHandler serves POST /setLocale: it stores the chosen locale, one
+// of supportedLocales, in the cookie currentLocale reads and redirects back.
+// An empty locale clears the cookie, so Accept-Language applies again.
+func (fe *frontendServer) setLocaleHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	cookie := &http.Cookie{Name: cookieLocale, MaxAge: -1}
+	if v := strings.TrimSpace(r.FormValue("locale")); v != "" {
+		locale := ""
+		if tag, err := language.Parse(v); err == nil {
+			locale = matchLocale(tag)
+		}
+		if locale == "" {
+			renderHTTPError(log, r, w, errors.Errorf("unsupported locale %q", v), http.StatusUnprocessableEntity)
+			return
+		}
+		log.WithField("locale.new", locale).WithField("locale.old", currentLocale(r)).Debug("setting locale")
+		cookie = &http.Cookie{Name: cookieLocale, Value: locale, MaxAge: cookieMaxAge}
+	}
+	http.SetCookie(w, cookie)
+	w.Header().Set("Location", sameOriginReferer(r))
+	w.WriteHeader(http.StatusFound)
+}
+
+// localeNames returns the names of supportedLocales for the locale picker.
+func localeNames() []string {
+	names := make([]string, len(supportedLocales))
+	for i, tag := range supportedLocales {
+		names[i] = tag.String()
+	}
+	return names
+}
+
+func matchLocale(tags ...language.Tag) string {
+	_, idx, conf := localeMatcher.Match(tags...)
+	if conf == language.No {
+		return ""
+	}
+	return supportedLocales[idx].String()
+}
+
+// currencyDigits is the number of fraction digits amounts in the currency
+// are shown with, from the CLDR data in golang.org/x/text: 0 for JPY or KRW,
+// 3 for BHD or KWD and 2 for most others, including unknown codes.
+func currencyDigits(code string) int {
+	unit, err := currency.ParseISO(code)
+	if err != nil {
+		return 2
+	}
+	digits, _ := currency.Standard.Rounding(unit)
+	return digits
+}
+
+// renderLocalizedMoney formats m for the given locale using the locale's
+// digit grouping, decimal separator and symbol placement. An empty or
+// unparseable locale falls back to renderMoney.
+func renderLocalizedMoney(locale string, m *pb.Money) string {
+	if locale == "" {
+		return renderMoney(*m)
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return renderMoney(*m)
+	}
+
+	// Round to the minor unit first, half away from zero like renderMoney,
+	// so float formatting cannot round differently.
+	digits := currencyDigits(m.GetCurrencyCode())
+	scale := int64(math.Pow10(digits))
+	step := int64(math.Pow10(9 - digits))
+	nanos := int64(m.GetNanos())
+	minor := m.GetUnits()*scale + nanos/step
+	if rem := nanos % step; rem*2 >= step {
+		minor++
+	} else if -rem*2 >= step {
+		minor--
+	}
+	amount := message.NewPrinter(tag).Sprintf(fmt.Sprintf("%%.%df", digits), float64(minor)/float64(scale))
+	symbol := renderCurrencyLogo(m.GetCurrencyCode())
+
+	base, _ := tag.Base()
+	if symbolAfterLanguages[base] {
+		return amount + "\u00a0" + symbol
+	}
+	return symbol + amount
+}
+
+// regionCurrencies maps regions to the supported currency shoppers there
+// most likely want.
+var regionCurrencies = map[string]string{
+	"US": "USD",
+	"CA": "CAD",
+	"GB": "GBP",
+	"JP": "JPY",
+	"TR": "TRY",
+	"AT": "EUR", "BE": "EUR", "DE": "EUR", "ES": "EUR", "FI": "EUR",
+	"FR": "EUR", "GR": "EUR", "IE": "EUR", "IT": "EUR", "LU": "EUR",
+	"NL": "EUR", "PT": "EUR", "SK": "EUR", "SI": "EUR",
+}
+
+// currencyForLanguages returns the supported currency for the region of the
+// most preferred language in an Accept-Language header, or defaultCurrency
+// when no region maps to one.
+func currencyForLanguages(accept string) string {
+	tags, _, err := language.ParseAcceptLanguage(accept)
+	if err != nil {
+		return defaultCurrency
+	}
+	for _, tag := range tags {
+		region, conf := tag.Region()
+		if conf == language.No {
+			continue
+		}
+		if c := regionCurrencies[region.String()]; whitelistedCurrencies[c] {
+			return c
+		}
+	}
+	return defaultCurrency
+}
+
+// autoSelectCurrency picks the currency of a visitor without a currency
+// cookie from their Accept-Language header and stores it in the cookie. An
+// existing cookie, i.e. an explicit choice, is never overridden.
+func autoSelectCurrency(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie(cookieCurrency); err == http.ErrNoCookie {
+			if accept := r.Header.Get("Accept-Language"); accept != "" {
+				c := &http.Cookie{
+					Name:   cookieCurrency,
+					Value:  currencyForLanguages(accept),
+					MaxAge: cookieMaxAge,
+				}
+				http.SetCookie(w, c)
+				// Price this request in the selected currency as well.
+				r.AddCookie(c)
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestRenderLocalizedMoney(t *testing.T) {
+	tests := []struct {
+		name   string
+		locale string
+		in     *pb.Money
+		want   string
+	}{
+		{"default", "", &pb.Money{CurrencyCode: "USD", Units: 1234, Nanos: 500000000}, "$1234.50"},
+		{"en-US", "en-US", &pb.Money{CurrencyCode: "USD", Units: 1234, Nanos: 500000000}, "$1,234.50"},
+		{"de-DE", "de-DE", &pb.Money{CurrencyCode: "EUR", Units: 1234, Nanos: 500000000}, "1.234,50\u00a0€"},
+		{"ja-JP", "ja-JP", &pb.Money{CurrencyCode: "JPY", Units: 1234, Nanos: 0}, "¥1,234"},
+		{"rounds like renderMoney", "de", &pb.Money{CurrencyCode: "EUR", Units: 12, Nanos: 999999999}, "13,00\u00a0€"},
+		{"three decimals", "en-US", &pb.Money{CurrencyCode: "KWD", Units: 1234, Nanos: 567890000}, "$1,234.568"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderLocalizedMoney(tt.locale, tt.in); got != tt.want {
+				t.Errorf("renderLocalizedMoney(%q, %v) = %q, want %q", tt.locale, tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMoneyFractionDigits(t *testing.T) {
+	tests := []struct {
+		in   *pb.Money
+		want string
+		json string
+	}{
+		{&pb.Money{CurrencyCode: "USD", Units: 12, Nanos: 999999999}, "$13.00", "13.00"},
+		{&pb.Money{CurrencyCode: "USD", Units: 5, Nanos: 50000000}, "$5.05", "5.05"},
+		{&pb.Money{CurrencyCode: "JPY", Units: 100, Nanos: 600000000}, "¥101", "101"},
+		{&pb.Money{CurrencyCode: "KWD", Units: 3, Nanos: 4500000}, "$3.005", "3.005"},
+		{&pb.Money{CurrencyCode: "EUR", Units: 2, Nanos: 675000000}, "€2.68", "2.68"},
+	}
+	for _, tt := range tests {
+		if got := renderMoney(*tt.in); got != tt.want {
+			t.Errorf("renderMoney(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+		if got := formatAmount(tt.in); got != tt.json {
+			t.Errorf("formatAmount(%v) = %q, want %q", tt.in, got, tt.json)
+		}
+	}
+}
+
+func TestCurrentLocale(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		cookie string
+		want   string
+	}{
+		{"none", "", "", ""},
+		{"accept-language en-US", "en-US,en;q=0.9", "", "en-US"},
+		{"accept-language de-DE", "de-DE,de;q=0.9,en;q=0.5", "", "de"},
+		{"accept-language ja-JP", "ja-JP", "", "ja"},
+		{"cookie wins", "en-US", "de-DE", "de"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Language", tt.accept)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: cookieLocale, Value: tt.cookie})
+			}
+			if got := currentLocale(r); got != tt.want {
+				t.Errorf("currentLocale() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrencyForLanguages(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"de-DE,de;q=0.9,en;q=0.8", "EUR"},
+		{"fr", "EUR"},
+		{"en-GB", "GBP"},
+		{"ja-JP", "JPY"},
+		{"pt-BR,en-US;q=0.5", "USD"},
+		{"sw-KE", defaultCurrency},
+		{"not a header;;", defaultCurrency},
+	}
+	for _, tt := range tests {
+		if got := currencyForLanguages(tt.accept); got != tt.want {
+			t.Errorf("currencyForLanguages(%q) = %s, want %s", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestAutoSelectCurrency(t *testing.T) {
+	var seen string
+	handler := autoSelectCurrency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = currentCurrency(r)
+	}))
+
+	tests := []struct {
+		name       string
+		accept     string
+		cookie     string
+		wantCookie string
+		wantSeen   string
+	}{
+		{"mapped region", "de-DE,de;q=0.9", "", "EUR", "EUR"},
+		{"unmapped region", "sw-KE", "", defaultCurrency, defaultCurrency},
+		{"explicit choice is sticky", "de-DE", "JPY", "", "JPY"},
+		{"no header", "", "", "", defaultCurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Language", tt.accept)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			var got string
+			for _, c := range rec.Result().Cookies() {
+				if c.Name == cookieCurrency {
+					got = c.Value
+				}
+			}
+			if got != tt.wantCookie {
+				t.Errorf("currency cookie set to %q, want %q", got, tt.wantCookie)
+			}
+			if seen != tt.wantSeen {
+				t.Errorf("request priced in %s, want %s", seen, tt.wantSeen)
+			}
+		})
+	}
+}
+
+func TestSetLocaleHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		locale     string
+		wantStatus int
+		wantCookie string
+		wantMaxAge int
+	}{
+		{"supported", "de-DE", http.StatusFound, "de", cookieMaxAge},
+		{"cleared", "", http.StatusFound, "", -1},
+		{"unsupported", "zh-CN", http.StatusUnprocessableEntity, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(http.MethodPost, "/setLocale", "locale="+tt.locale)
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Referer", "/cart")
+			rec := httptest.NewRecorder()
+			(&frontendServer{}).setLocaleHandler(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			cookies := rec.Result().Cookies()
+			if tt.wantStatus != http.StatusFound {
+				if len(cookies) != 0 {
+					t.Errorf("cookies = %v, want none", cookies)
+				}
+				return
+			}
+			if len(cookies) != 1 || cookies[0].Name != cookieLocale || cookies[0].Value != tt.wantCookie || cookies[0].MaxAge != tt.wantMaxAge {
+				t.Errorf("cookies = %v, want %s=%q with max age %d", cookies, cookieLocale, tt.wantCookie, tt.wantMaxAge)
+			}
+			if got := rec.Header().Get("Location"); got != "/cart" {
+				t.Errorf("Location = %q, want /cart", got)
+			}
+		})
+	}
+}
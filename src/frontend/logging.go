@@ -0,0 +1,144 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// configureLogging applies LOG_LEVEL (a logrus level name, info by default)
+// and LOG_FORMAT ("json", the default, or "text") to l. Verbose dumps of agent
+// requests and responses are logged at debug, so only LOG_LEVEL=debug shows
+// them.
+func configureLogging(l *logrus.Logger) {
+	l.Level = logrus.InfoLevel
+	l.Formatter = &logrus.JSONFormatter{
+		FieldMap: logrus.FieldMap{
+			logrus.FieldKeyTime:  "timestamp",
+			logrus.FieldKeyLevel: "severity",
+			logrus.FieldKeyMsg:   "message",
+		},
+		TimestampFormat: time.RFC3339Nano,
+	}
+
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "json":
+	case "text":
+		l.Formatter = &logrus.TextFormatter{FullTimestamp: true, TimestampFormat: time.RFC3339Nano}
+	default:
+		l.Warnf("invalid LOG_FORMAT %q, using json", format)
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		level, err := logrus.ParseLevel(v)
+		if err != nil {
+			l.Warnf("invalid LOG_LEVEL %q, using %s", v, l.Level)
+			return
+		}
+		l.Level = level
+	}
+}
+
+// redactedLogKeys name JSON fields whose values never belong in the logs:
+// uploaded image data and payment card details.
+var redactedLogKeys = map[string]bool{
+	"image":              true,
+	"inlineData":         true,
+	"credit_card":        true,
+	"creditCard":         true,
+	"credit_card_number": true,
+	"credit_card_cvv":    true,
+}
+
+// redactPayload renders a JSON payload for the logs with the values of
+// redactedLogKeys replaced, at any depth. Payloads that are not JSON are
+// not logged at all.
+func redactPayload(payload []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return "[unparseable payload]"
+	}
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return "[unparseable payload]"
+	}
+	return string(out)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if redactedLogKeys[k] {
+				v[k] = "[REDACTED]"
+			} else {
+				v[k] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = redactValue(elem)
+		}
+	}
+	return v
+}
+
+var (
+	// cardNumberPattern matches runs of 13 to 19 digits, optionally grouped
+	// with spaces or dashes: anything that may be a payment card number.
+	cardNumberPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	// cvvPattern matches a card security code following its label.
+	cvvPattern = regexp.MustCompile(`(?i)\b(cvv|cvc|security code)(\W{0,3})\d{3,4}\b`)
+	// emailPattern matches email addresses.
+	emailPattern = regexp.MustCompile(`[^\s@"'<>()]+@[^\s@"'<>()]+\.[A-Za-z]{2,}`)
+)
+
+// maskCardNumber masks all but the last four digits of a card number.
+func maskCardNumber(pan string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, pan)
+	if len(digits) <= 4 {
+		return strings.Repeat("*", len(digits))
+	}
+	return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+}
+
+// redactOrderData masks card numbers, security codes and email addresses in
+// s, such as an error message a downstream service built from the order it
+// was given.
+func redactOrderData(s string) string {
+	s = cardNumberPattern.ReplaceAllStringFunc(s, maskCardNumber)
+	s = cvvPattern.ReplaceAllString(s, "${1}${2}***")
+	return emailPattern.ReplaceAllStringFunc(s, maskEmail)
+}
+
+// maskEmail keeps the first letter and the domain of an email address, which
+// is enough to tell orders apart in the logs.
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return "***"
+	}
+	return email[:1] + "***" + email[at:]
+}
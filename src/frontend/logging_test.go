@@ -0,0 +1,238 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestNoStrayPrints fails on fmt.Print* and print/println calls in the
+// frontend sources: output that bypasses logrus never reaches the structured
+// logs. Use the request-scoped logger instead.
+func TestNoStrayPrints(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			switch fn := call.Fun.(type) {
+			case *ast.SelectorExpr:
+				if pkg, ok := fn.X.(*ast.Ident); ok && pkg.Name == "fmt" && strings.HasPrefix(fn.Sel.Name, "Print") {
+					t.Errorf("%s: fmt.%s bypasses structured logging", fset.Position(call.Pos()), fn.Sel.Name)
+				}
+			case *ast.Ident:
+				if fn.Name == "print" || fn.Name == "println" {
+					t.Errorf("%s: %s bypasses structured logging", fset.Position(call.Pos()), fn.Name)
+				}
+			}
+			return true
+		})
+	}
+}
+
+func TestConfigureLogging(t *testing.T) {
+	tests := []struct {
+		level, format string
+		wantLevel     logrus.Level
+		wantText      bool
+	}{
+		{"", "", logrus.InfoLevel, false},
+		{"info", "json", logrus.InfoLevel, false},
+		{"WARN", "text", logrus.WarnLevel, true},
+		{"chatty", "xml", logrus.InfoLevel, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level+"/"+tt.format, func(t *testing.T) {
+			t.Setenv("LOG_LEVEL", tt.level)
+			t.Setenv("LOG_FORMAT", tt.format)
+			l := logrus.New()
+			l.Out = io.Discard
+			configureLogging(l)
+			if l.Level != tt.wantLevel {
+				t.Errorf("level = %s, want %s", l.Level, tt.wantLevel)
+			}
+			if _, text := l.Formatter.(*logrus.TextFormatter); text != tt.wantText {
+				t.Errorf("formatter = %T, want text %v", l.Formatter, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestRedactPayload(t *testing.T) {
+	got := redactPayload([]byte(`{"newMessage": {"parts": [{"text": "red shoes"}, {"inlineData": {"data": "aGVsbG8=", "mimeType": "image/jpeg"}}]},
+		"credit_card": {"credit_card_number": "4432801561520454", "credit_card_cvv": 672}}`))
+	for _, secret := range []string{"aGVsbG8=", "4432801561520454", "672"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("redacted payload %s contains %q", got, secret)
+		}
+	}
+	if !strings.Contains(got, "red shoes") {
+		t.Errorf("redacted payload %s lost the message text", got)
+	}
+	if got := redactPayload([]byte("not json")); got != "[unparseable payload]" {
+		t.Errorf("redactPayload(not json) = %q", got)
+	}
+}
+
+// TestAgentSearchResponseNotLogged checks that the agent response, which
+// may carry what the shopper told the agent, is not dumped at any level.
+func TestAgentSearchResponseNotLogged(t *testing.T) {
+	const marker = "full-response-marker"
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/run" {
+			io.WriteString(w, `[{"content": {"parts": [{"text": "`+marker+`"}]}}]`)
+			return
+		}
+		io.WriteString(w, `{"id": "adk-session"}`)
+	}))
+	defer gateway.Close()
+
+	for _, level := range []logrus.Level{logrus.InfoLevel, logrus.DebugLevel} {
+		t.Run(level.String(), func(t *testing.T) {
+			fe := &frontendServer{agentsGatewayURL: gateway.URL}
+			var out bytes.Buffer
+			logger := logrus.New()
+			logger.Out = &out
+			logger.Level = level
+
+			r := httptest.NewRequest(http.MethodPost, "/api/agent/search", strings.NewReader(`{"userId": "u1", "newMessage": {"parts": [{"text": "shoes"}]}}`))
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyLog{}, logrus.FieldLogger(logger)))
+			fe.agentSearchHandler(httptest.NewRecorder(), r)
+
+			if strings.Contains(out.String(), marker) {
+				t.Errorf("agent response logged at %s level:\n%s", level, out.String())
+			}
+		})
+	}
+}
+
+type rejectingCheckoutService struct {
+	pb.UnimplementedCheckoutServiceServer
+}
+
+func (rejectingCheckoutService) PlaceOrder(ctx context.Context, req *pb.PlaceOrderRequest) (*pb.PlaceOrderResponse, error) {
+	card := req.GetCreditCard()
+	return nil, status.Errorf(codes.Internal, "failed to charge card %s (cvv: %d) for %s",
+		card.GetCreditCardNumber(), card.GetCreditCardCvv(), req.GetEmail())
+}
+
+func TestPlaceOrderErrorRedactsCardData(t *testing.T) {
+	fe := &frontendServer{checkoutSvcConn: serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCheckoutServiceServer(s, rejectingCheckoutService{})
+	})}
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &out
+
+	form := url.Values{
+		"email":                        {"someone@example.com"},
+		"street_address":               {"1600 Amphitheatre Parkway"},
+		"zip_code":                     {"94043"},
+		"city":                         {"Mountain View"},
+		"state":                        {"CA"},
+		"country":                      {"United States"},
+		"credit_card_number":           {"4432801561520454"},
+		"credit_card_expiration_month": {"1"},
+		"credit_card_expiration_year":  {"2039"},
+		"credit_card_cvv":              {"672"},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/cart/checkout", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := context.WithValue(r.Context(), ctxKeyLog{}, logrus.FieldLogger(logger))
+	r = r.WithContext(context.WithValue(ctx, ctxKeySessionID{}, "test-session"))
+	rec := httptest.NewRecorder()
+	fe.placeOrderHandler(rec, r)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if !strings.Contains(out.String(), "0454") {
+		t.Fatalf("error was not logged:\n%s", out.String())
+	}
+	for _, leaked := range []string{"4432801561520454", "cvv: 672", "someone@example.com"} {
+		if strings.Contains(out.String(), leaked) {
+			t.Errorf("log output contains %q:\n%s", leaked, out.String())
+		}
+		if strings.Contains(rec.Body.String(), leaked) {
+			t.Errorf("error page contains %q", leaked)
+		}
+	}
+}
+
+func TestRedactCardData(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"card 4432801561520454 declined", "card ************0454 declined"},
+		{"card 4432-8015-6152-0454", "card ************0454"},
+		{"CVV: 672 invalid", "CVV: *** invalid"},
+		{"order 42 for 3 items", "order 42 for 3 items"},
+		{`confirmation to "someone@example.com" failed`, `confirmation to "s***@example.com" failed`},
+	}
+	for _, tt := range tests {
+		if got := redactOrderData(tt.in); got != tt.want {
+			t.Errorf("redactOrderData(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := maskEmail("someone@example.com"); got != "s***@example.com" {
+		t.Errorf("maskEmail = %q", got)
+	}
+}
+
+// TestParseAgentResponseLogsAtDebug checks that parsing agent events logs
+// through the request logger, and only at debug level.
+func TestParseAgentResponseLogsAtDebug(t *testing.T) {
+	body := `[{"content": {"parts": [{"text": "Here you go"}]}}]`
+	for _, level := range []logrus.Level{logrus.InfoLevel, logrus.DebugLevel} {
+		var out bytes.Buffer
+		logger := logrus.New()
+		logger.Out = &out
+		logger.Level = level
+		if _, _, err := (&frontendServer{}).parseAgentResponse(logger, strings.NewReader(body)); err != nil {
+			t.Fatal(err)
+		}
+		if logged := out.Len() > 0; logged != (level == logrus.DebugLevel) {
+			t.Errorf("parsing logged = %v at %s level:\n%s", logged, level, out.String())
+		}
+	}
+}
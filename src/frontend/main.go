@@ -17,10 +17,13 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/profiler"
@@ -34,6 +37,8 @@ import (
 	"go.opentelemetry.io/otel/propagation"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/validator"
 )
 
 const (
@@ -44,6 +49,15 @@ const (
 	cookiePrefix    = "shop_"
 	cookieSessionID = cookiePrefix + "session-id"
 	cookieCurrency  = cookiePrefix + "currency"
+
+	// defaultMaxRecommendations fits the recommendation row of the UI.
+	defaultMaxRecommendations = 4
+	// defaultMaxRecommendationContext bounds the product ids a
+	// recommendation request carries as context.
+	defaultMaxRecommendationContext = 20
+	// defaultMaxAdContextKeys bounds the categories an ad request carries
+	// as context.
+	defaultMaxAdContextKeys = 5
 )
 
 var (
@@ -89,50 +103,100 @@ type frontendServer struct {
 	shoppingAssistantSvcAddr string
 
 	agentsGatewaySvcAddr string
-	useAgentsGateway     bool
-	migrationPercent     int
 
-	// ADK session cache: key is userId+"::"+appName, value is sessionId
-	adkSessions   map[string]string
+	flags featureFlags
+
+	// Arm weights of the A/B experiments, by name; see experimentArm
+	experiments map[string][]int
+
+	// ADK session cache: key is userId+"::"+appName
+	adkSessions   map[string]adkSession
 	adkSessionsMu sync.RWMutex
 
-	// Reasoning Engine app name/resource to use for ADK sessions
-	reAppName string
+	// Agent app name (or Reasoning Engine resource) of each agent-backed
+	// feature; see agentApp
+	agentApps map[agentFeature]string
+
+	// Base URL of the agents-gateway ADK API; defaults to defaultAgentsGatewayURL
+	agentsGatewayURL string
+
+	// HMAC key of signed session ids; see signSessionID
+	sessionKey []byte
+
+	// Bounds the background cart analyses in flight
+	cartAnalysisSem chan struct{}
+
+	// Carts are analyzed once they hold this many units or, if set, cost
+	// this many US dollars; see cartAnalysisDue
+	cartAnalysisMinItems    int
+	cartAnalysisMinTotalUSD int
+
+	// Number of recommendations shown on the product and order pages and,
+	// if set, on the cart page; see recommendationLimit
+	maxRecommendations     int
+	cartMaxRecommendations int
+
+	// Most product ids sent as recommendation context; see
+	// recommendationContextLimit
+	maxRecommendationContext int
+
+	// Most categories sent as ad context; see adContextKeys
+	maxAdContextKeys int
+
+	// Most distinct products and total quantity a cart may hold; 0 is
+	// unlimited. See checkCartLimits
+	maxCartItems    int
+	maxCartQuantity int
+
+	// Carts with at most this many units, costing less than this many US
+	// dollars, get local checkout guidance; see isSimpleCheckout
+	simpleCheckoutMaxItems    int
+	simpleCheckoutMaxTotalUSD int
 
-	// ADK app name (module) to address agents-gateway endpoints (no slashes)
-	adkAppName string
+	// Show USD prices for products whose price could not be converted on
+	// the home and search pages, instead of failing the page
+	currencyFallbackUSD bool
+
+	// Answer /api/checkout with a synthetic confirmation instead of placing
+	// an order; see apiCheckout
+	checkoutDemoMode bool
+
+	// Orders placed through this frontend, for "buy it again"
+	orders *orderHistory
+
+	// Returns of those orders; see customerServiceHandler
+	returns returnsService
+
+	// Recently viewed and added products per session, for personalization
+	history *sessionHistory
+
+	// Analytics sink for product views and add-to-carts; see emitProductEvent
+	events eventEmitter
+
+	// Typeahead index built from the catalog
+	suggest suggestions
+
+	// Negotiated product picture URLs; see productImageHandler
+	imageVariants imageVariants
+
+	// Supported currencies; see getCurrencies
+	currencies currencyList
 }
 
 func main() {
 	ctx := context.Background()
 	log := logrus.New()
-	log.Level = logrus.DebugLevel
-	log.Formatter = &logrus.JSONFormatter{
-		FieldMap: logrus.FieldMap{
-			logrus.FieldKeyTime:  "timestamp",
-			logrus.FieldKeyLevel: "severity",
-			logrus.FieldKeyMsg:   "message",
-		},
-		TimestampFormat: time.RFC3339Nano,
-	}
 	log.Out = os.Stdout
+	configureLogging(log)
 
 	svc := new(frontendServer)
 	// Initialize ADK session cache
-	svc.adkSessions = make(map[string]string)
-	// Configure the ADK app name (Reasoning Engine resource) for sessions
-	// If not provided, default to legacy app name for backward-compat
-	if v := os.Getenv("REASONING_ENGINE_APP_NAME"); v != "" {
-		svc.reAppName = v
-	} else {
-		svc.reAppName = "shopping_assistant_agent"
-	}
-	// Configure the agents-gateway app name (module id)
-	if v := os.Getenv("ADK_APP_NAME"); v != "" {
-		svc.adkAppName = v
-	} else {
-		svc.adkAppName = "shopping_assistant_agent"
-	}
+	svc.adkSessions = make(map[string]adkSession)
+	svc.orders = &orderHistory{}
+	svc.returns = newLocalReturns(svc.orders)
+	svc.history = &sessionHistory{}
+	svc.agentApps = loadAgentApps(log)
+	svc.sessionKey = loadSessionKey(log)
 
 	otel.SetTextMapPropagator(
 		propagation.NewCompositeTextMapPropagator(
@@ -170,9 +234,38 @@ func main() {
 
 	// Agent gateway configuration
 	mustMapEnv(&svc.agentsGatewaySvcAddr, "AGENTS_GATEWAY_SERVICE_ADDR")
-	svc.useAgentsGateway = os.Getenv("USE_AGENTS_GATEWAY") == "true"
-	if percent := os.Getenv("AGENT_MIGRATION_PERCENT"); percent != "" {
-		svc.migrationPercent, _ = strconv.Atoi(percent)
+	svc.flags = loadFeatureFlags(log)
+	svc.experiments = loadExperiments(log)
+
+	loadAgentTimeouts(log)
+	loadHTTPServerTimeouts(log)
+	loadPackagingTimeout(log)
+	loadCatalogTimeout(log)
+	loadReturnWindow(log)
+
+	cartAnalysisConcurrency := positiveIntEnv(log, "AGENT_CART_ANALYSIS_CONCURRENCY", defaultCartAnalysisConcurrency)
+	svc.cartAnalysisSem = make(chan struct{}, cartAnalysisConcurrency)
+	svc.cartAnalysisMinItems = positiveIntEnv(log, "AGENT_CART_ANALYSIS_MIN_ITEMS", 1)
+	svc.cartAnalysisMinTotalUSD = positiveIntEnv(log, "AGENT_CART_ANALYSIS_MIN_TOTAL_USD", 0)
+
+	svc.maxRecommendations = positiveIntEnv(log, "MAX_RECOMMENDATIONS", defaultMaxRecommendations)
+	svc.cartMaxRecommendations = positiveIntEnv(log, "CART_MAX_RECOMMENDATIONS", svc.maxRecommendations)
+	svc.maxRecommendationContext = positiveIntEnv(log, "MAX_RECOMMENDATION_CONTEXT_IDS", defaultMaxRecommendationContext)
+	svc.maxAdContextKeys = positiveIntEnv(log, "MAX_AD_CONTEXT_KEYS", defaultMaxAdContextKeys)
+	svc.currencyFallbackUSD = os.Getenv("CURRENCY_FALLBACK_USD") == "true"
+	svc.checkoutDemoMode = os.Getenv("CHECKOUT_DEMO_MODE") == "true"
+	svc.maxCartItems = nonNegativeIntEnv(log, "MAX_CART_ITEMS", defaultMaxCartItems)
+	svc.maxCartQuantity = nonNegativeIntEnv(log, "MAX_CART_TOTAL_QUANTITY", defaultMaxCartQuantity)
+	svc.simpleCheckoutMaxItems = nonNegativeIntEnv(log, "CHECKOUT_ASSISTANCE_SIMPLE_MAX_ITEMS", defaultSimpleCheckoutMaxItems)
+	svc.simpleCheckoutMaxTotalUSD = nonNegativeIntEnv(log, "CHECKOUT_ASSISTANCE_SIMPLE_MAX_TOTAL_USD", defaultSimpleCheckoutMaxTotalUSD)
+	svc.events = newEventEmitter(ctx, log, os.Getenv("EVENT_SINK_URL"))
+
+	if v := os.Getenv("MAX_CART_ITEM_QUANTITY"); v != "" {
+		if n, err := strconv.ParseUint(v, 10, 32); err == nil {
+			validator.SetMaxQuantity(n)
+		} else {
+			log.Warnf("invalid MAX_CART_ITEM_QUANTITY %q, using default %d", v, validator.DefaultMaxQuantity)
+		}
 	}
 
 	mustConnGRPC(ctx, &svc.currencySvcConn, svc.currencySvcAddr)
@@ -191,34 +284,56 @@ func main() {
 	r.HandleFunc(baseUrl+"/cart", svc.addToCartHandler).Methods(http.MethodPost)
 	r.HandleFunc(baseUrl+"/cart/empty", svc.emptyCartHandler).Methods(http.MethodPost)
 	r.HandleFunc(baseUrl+"/setCurrency", svc.setCurrencyHandler).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl+"/setLocale", svc.setLocaleHandler).Methods(http.MethodPost)
 	r.HandleFunc(baseUrl+"/logout", svc.logoutHandler).Methods(http.MethodGet)
 	r.HandleFunc(baseUrl+"/cart/checkout", svc.placeOrderHandler).Methods(http.MethodPost)
 	r.HandleFunc(baseUrl+"/assistant", svc.assistantHandler).Methods(http.MethodGet)
 	r.HandleFunc(baseUrl+"/support", svc.supportHandler).Methods(http.MethodGet)
+	r.HandleFunc(baseUrl+"/img/{productId}", svc.productImageHandler).Methods(http.MethodGet, http.MethodHead)
 	r.PathPrefix(baseUrl + "/static/").Handler(http.StripPrefix(baseUrl+"/static/", http.FileServer(http.Dir("./static/"))))
 	r.HandleFunc(baseUrl+"/robots.txt", func(w http.ResponseWriter, _ *http.Request) { fmt.Fprint(w, "User-agent: *\nDisallow: /") })
 	r.HandleFunc(baseUrl+"/_healthz", func(w http.ResponseWriter, _ *http.Request) { fmt.Fprint(w, "ok") })
 	r.HandleFunc(baseUrl+"/product-meta/{ids}", svc.getProductByID).Methods(http.MethodGet)
 	r.HandleFunc(baseUrl+"/bot", svc.chatBotHandler).Methods(http.MethodPost)
-	// Agent tools HTTP endpoints
-	r.HandleFunc(baseUrl+"/api/cart", svc.apiGetCart).Methods(http.MethodGet)
-	r.HandleFunc(baseUrl+"/api/cart/add", svc.apiAddToCart).Methods(http.MethodPost)
-	r.HandleFunc(baseUrl+"/api/cart/remove", svc.apiRemoveFromCart).Methods(http.MethodPost)
-	r.HandleFunc(baseUrl+"/api/checkout", svc.apiCheckout).Methods(http.MethodPost)
-	r.HandleFunc(baseUrl+"/api/agent-search", svc.agentSearchHandler).Methods(http.MethodPost, http.MethodOptions)
-	r.HandleFunc(baseUrl+"/api/search", svc.fallbackSearchHandler).Methods(http.MethodGet)
-	r.HandleFunc(baseUrl+"/api/feature-flags", svc.featureFlagsHandler).Methods(http.MethodGet)
-	r.HandleFunc(baseUrl+"/api/cart/recommendations", svc.smartCartRecommendationsHandler).Methods(http.MethodGet)
-	r.HandleFunc(baseUrl+"/api/checkout/assistance", svc.checkoutAssistanceHandler).Methods(http.MethodGet)
-	r.HandleFunc(baseUrl+"/api/customer-service", svc.customerServiceHandler).Methods(http.MethodPost, http.MethodOptions)
+	svc.registerAPIRoutes(r)
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		r.Handle(baseUrl+"/admin/banner", requireAdminToken(token, http.HandlerFunc(bannerAdminHandler))).Methods(http.MethodPost)
+	}
+
+	r.MethodNotAllowedHandler = methodNotAllowed(r)
 
 	var handler http.Handler = r
+	if os.Getenv("ENABLE_GZIP") != "false" {
+		handler = gzipResponses(handler) // compress responses
+	} else {
+		log.Info("Response compression disabled.")
+	}
+	if os.Getenv("AUTO_SELECT_CURRENCY") == "true" {
+		handler = autoSelectCurrency(handler) // pick a currency from Accept-Language
+	}
+	if jwt := loadJWTVerifier(log); jwt != nil {
+		handler = jwt.middleware(handler) // verify bearer tokens
+	}
+	if header := os.Getenv("AUTH_USER_HEADER"); header != "" {
+		handler = authUserHeaderMiddleware(header, handler) // trust the proxy's user header
+	}
 	handler = &logHandler{log: log, next: handler}     // add logging
-	handler = ensureSessionID(handler)                 // add session ID
+	handler = svc.ensureSessionID(handler)             // add session ID
+	handler = ensureValidCurrency(handler)             // drop unsupported currencies
 	handler = otelhttp.NewHandler(handler, "frontend") // add OTel tracing
 
+	lis, err := net.Listen("tcp", addr+":"+srvPort)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	log.Infof("starting server on " + addr + ":" + srvPort)
-	log.Fatal(http.ListenAndServe(addr+":"+srvPort, handler))
+	if err := serveGracefully(ctx, log, newHTTPServer(addr+":"+srvPort, handler), lis); err != nil {
+		log.Fatal(err)
+	}
+	log.Info("shutdown complete")
 }
 func initStats(log logrus.FieldLogger) {
 	// TODO(arbrown) Implement OpenTelemtry stats
@@ -272,6 +387,36 @@ func mustMapEnv(target *string, envKey string) {
 	*target = v
 }
 
+// positiveIntEnv returns the positive integer in envKey, or def if the
+// variable is unset or invalid.
+func positiveIntEnv(log logrus.FieldLogger, envKey string, def int) int {
+	v := os.Getenv(envKey)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Warnf("invalid %s %q, using default %d", envKey, v, def)
+		return def
+	}
+	return n
+}
+
+// nonNegativeIntEnv is positiveIntEnv for settings where 0 has a meaning of
+// its own, such as disabling a feature.
+func nonNegativeIntEnv(log logrus.FieldLogger, envKey string, def int) int {
+	v := os.Getenv(envKey)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Warnf("invalid %s %q, using default %d", envKey, v, def)
+		return def
+	}
+	return n
+}
+
 func mustConnGRPC(ctx context.Context, conn **grpc.ClientConn, addr string) {
 	var err error
 	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
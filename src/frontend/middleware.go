@@ -18,6 +18,7 @@ import (
 	"context"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -54,6 +55,9 @@ func (r *responseRecorder) WriteHeader(statusCode int) {
 	r.w.WriteHeader(statusCode)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter { return r.w }
+
 func (lh *logHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	requestID, _ := uuid.NewRandom()
@@ -82,10 +86,13 @@ func (lh *logHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	lh.next.ServeHTTP(rr, r)
 }
 
-func ensureSessionID(next http.Handler) http.HandlerFunc {
+// ensureSessionID puts the request in the session named by its session
+// cookie, or in a new session when it has none. The cookie holds the signed
+// session id (see signSessionID); a cookie that does not verify, because it
+// was forged, tampered with or has expired, is replaced by a new session.
+func (fe *frontendServer) ensureSessionID(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var sessionID string
-		c, err := r.Cookie(cookieSessionID)
+		sessionID, err := fe.cookieSessionID(r)
 		if err == http.ErrNoCookie {
 			if os.Getenv("ENABLE_SINGLE_SHARED_SESSION") == "true" {
 				// Hard coded user id, shared across sessions
@@ -98,7 +105,7 @@ func ensureSessionID(next http.Handler) http.HandlerFunc {
 			secure := r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
 			http.SetCookie(w, &http.Cookie{
 				Name:     cookieSessionID,
-				Value:    sessionID,
+				Value:    fe.signSessionID(sessionID),
 				MaxAge:   cookieMaxAge,
 				Path:     "/",
 				HttpOnly: true,
@@ -107,11 +114,43 @@ func ensureSessionID(next http.Handler) http.HandlerFunc {
 			})
 		} else if err != nil {
 			return
-		} else {
-			sessionID = c.Value
 		}
 		ctx := context.WithValue(r.Context(), ctxKeySessionID{}, sessionID)
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	}
 }
+
+// cookieSessionID returns the session id in the request's session cookie.
+// It returns http.ErrNoCookie when there is no cookie, or none that verifies
+// as a session the frontend issued. Only authentication middleware may put a
+// request in an authenticated user's session, so cookies naming one are not
+// accepted either.
+func (fe *frontendServer) cookieSessionID(r *http.Request) (string, error) {
+	c, err := r.Cookie(cookieSessionID)
+	if err != nil {
+		return "", err
+	}
+	id, ok := fe.verifySessionID(c.Value)
+	if !ok || strings.HasPrefix(id, authUserPrefix) {
+		return "", http.ErrNoCookie
+	}
+	return id, nil
+}
+
+// ensureValidCurrency replaces a currency cookie holding a currency that is
+// not (or no longer) supported with the default, so stale or tampered values
+// do not reach the currency service. currentCurrency ignores such values for
+// the request at hand.
+func ensureValidCurrency(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(cookieCurrency); err == nil && !whitelistedCurrencies[c.Value] {
+			http.SetCookie(w, &http.Cookie{
+				Name:   cookieCurrency,
+				Value:  defaultCurrency,
+				MaxAge: cookieMaxAge,
+			})
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -16,6 +16,9 @@ package money
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"math/bits"
 
 	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
 )
@@ -29,6 +32,7 @@ const (
 var (
 	ErrInvalidValue        = errors.New("one of the specified money values is invalid")
 	ErrMismatchingCurrency = errors.New("mismatching currency codes")
+	ErrOverflow            = errors.New("money value overflows the supported range")
 )
 
 // IsValid checks if specified value has a valid units/nanos signs and ranges.
@@ -87,21 +91,26 @@ func Must(v pb.Money, err error) pb.Money {
 	return v
 }
 
-// Sum adds two values. Returns an error if one of the values are invalid or
+// Sum adds two values. Returns an error if one of the values are invalid,
 // currency codes are not matching (unless currency code is unspecified for
-// both).
+// both) or the result does not fit in the units range.
 func Sum(l, r pb.Money) (pb.Money, error) {
 	if !IsValid(l) || !IsValid(r) {
 		return pb.Money{}, ErrInvalidValue
 	} else if l.GetCurrencyCode() != r.GetCurrencyCode() {
 		return pb.Money{}, ErrMismatchingCurrency
 	}
-	units := l.GetUnits() + r.GetUnits()
+	units, ok := addUnits(l.GetUnits(), r.GetUnits())
+	if !ok {
+		return pb.Money{}, ErrOverflow
+	}
 	nanos := l.GetNanos() + r.GetNanos()
 
-	if (units == 0 && nanos == 0) || (units > 0 && nanos >= 0) || (units < 0 && nanos <= 0) {
+	if units == 0 || (units > 0 && nanos >= 0) || (units < 0 && nanos <= 0) {
 		// same sign <units, nanos>
-		units += int64(nanos / nanosMod)
+		if units, ok = addUnits(units, int64(nanos/nanosMod)); !ok {
+			return pb.Money{}, ErrOverflow
+		}
 		nanos = nanos % nanosMod
 	} else {
 		// different sign. nanos guaranteed to not to go over the limit
@@ -120,13 +129,81 @@ func Sum(l, r pb.Money) (pb.Money, error) {
 		CurrencyCode: l.GetCurrencyCode()}, nil
 }
 
-// MultiplySlow is a slow multiplication operation done through adding the value
-// to itself n-1 times.
-func MultiplySlow(m pb.Money, n uint32) pb.Money {
-	out := m
-	for n > 1 {
-		out = Must(Sum(out, m))
-		n--
+// MultiplySlow multiplies the value by n, as if adding it to itself n-1 times
+// (so n = 0 returns the value unchanged). Despite the name the product is
+// computed directly, in time independent of n. Returns ErrOverflow if the
+// product does not fit in the units range.
+func MultiplySlow(m pb.Money, n uint32) (pb.Money, error) {
+	if !IsValid(m) {
+		return pb.Money{}, ErrInvalidValue
+	}
+	if n <= 1 {
+		return m, nil
+	}
+	// Units and nanos share a sign: multiply the magnitudes and apply it
+	// at the end. A negative product may reach one unit further.
+	negative := m.GetUnits() < 0 || m.GetNanos() < 0
+	units, nanos, limit := uint64(m.GetUnits()), int64(m.GetNanos()), uint64(math.MaxInt64)
+	if negative {
+		units, nanos, limit = -units, -nanos, limit+1
+	}
+	nanos *= int64(n) // below nanosMod * 2^32, no overflow
+	hi, lo := bits.Mul64(units, uint64(n))
+	lo, carry := bits.Add64(lo, uint64(nanos/nanosMod), 0)
+	if hi != 0 || carry != 0 || lo > limit {
+		return pb.Money{}, ErrOverflow
+	}
+	out := pb.Money{
+		Units:        int64(lo),
+		Nanos:        int32(nanos % nanosMod),
+		CurrencyCode: m.GetCurrencyCode()}
+	if negative {
+		out.Units, out.Nanos = -out.Units, -out.Nanos
+	}
+	return out, nil
+}
+
+// addUnits adds two unit values, reporting false if the result overflows.
+func addUnits(l, r int64) (int64, bool) {
+	sum := l + r
+	if (r > 0 && sum < l) || (r < 0 && sum > l) {
+		return 0, false
+	}
+	return sum, true
+}
+
+// Format renders m as a decimal amount with the given number of fraction
+// digits (0 to 9), rounding half away from zero. It works on units and nanos
+// directly, so amounts like 2.675 round to "2.68" where float formatting
+// gives "2.67". The currency code is not included.
+func Format(m *pb.Money, places int) string {
+	if places < 0 {
+		places = 0
+	} else if places > 9 {
+		places = 9
+	}
+	units, nanos := m.GetUnits(), int64(m.GetNanos())
+	negative := units < 0 || nanos < 0
+	if negative {
+		units, nanos = -units, -nanos
+	}
+
+	step := int64(math.Pow10(9 - places))
+	frac := nanos / step
+	if nanos%step*2 >= step {
+		frac++
+	}
+	if limit := int64(math.Pow10(places)); frac >= limit {
+		units++
+		frac -= limit
+	}
+
+	sign := ""
+	if negative && (units != 0 || frac != 0) {
+		sign = "-"
+	}
+	if places == 0 {
+		return fmt.Sprintf("%s%d", sign, units)
 	}
-	return out
+	return fmt.Sprintf("%s%d.%0*d", sign, units, places, frac)
 }
@@ -16,6 +16,7 @@ package money
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"testing"
 
@@ -228,6 +229,9 @@ func TestSum(t *testing.T) {
 		{"mixed (larger positive, with borrow)", args{mm(11, 100000000), mm(-2, -9000000 /*.09*/)}, mm(9, 91000000 /*.091*/), nil},
 		{"mixed (larger negative, no borrow)", args{mm(-11, -100000000), mm(2, 100000000)}, mm(-9, 0), nil},
 		{"mixed (larger negative, with borrow)", args{mm(-11, -100000000), mm(2, 9000000 /*.09*/)}, mm(-9, -91000000 /*.091*/), nil},
+		{"just nanos (no carry)", args{mm(0, 335000000), mm(0, 335000000)}, mm(0, 670000000), nil},
+		{"just nanos (carry)", args{mm(0, 670000000), mm(0, 335000000)}, mm(1, 5000000), nil},
+		{"mixed (units cancel)", args{mm(1, 0), mm(-1, -500000000)}, mm(0, -500000000), nil},
 		{"0+negative", args{mm(0, 0), mm(-2, -100000000)}, mm(-2, -100000000), nil},
 		{"negative+0", args{mm(-2, -100000000), mm(0, 0)}, mm(-2, -100000000), nil},
 	}
@@ -243,3 +247,84 @@ func TestSum(t *testing.T) {
 		})
 	}
 }
+
+func TestSum_overflow(t *testing.T) {
+	tests := []struct {
+		name string
+		l, r pb.Money
+	}{
+		{"units overflow", mm(math.MaxInt64, 0), mm(1, 0)},
+		{"carry overflow", mm(math.MaxInt64, 600000000), mm(0, 600000000)},
+		{"negative units overflow", mm(math.MinInt64, 0), mm(-1, 0)},
+		{"negative carry overflow", mm(math.MinInt64, -600000000), mm(0, -600000000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Sum(tt.l, tt.r); err != ErrOverflow {
+				t.Errorf("Sum([%v],[%v]): expected err=%q got=%v", tt.l, tt.r, ErrOverflow, err)
+			}
+		})
+	}
+}
+
+func TestMultiplySlow(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      pb.Money
+		n       uint32
+		want    pb.Money
+		wantErr error
+	}{
+		{"x0", mm(2, 500000000), 0, mm(2, 500000000), nil},
+		{"x1", mm(2, 500000000), 1, mm(2, 500000000), nil},
+		{"x3 with carry", mm(2, 500000000), 3, mm(7, 500000000), nil},
+		{"negative", mm(-2, -500000000), 2, mm(-5, 0), nil},
+		{"near max", mm(math.MaxInt64/2, 0), 2, mm(math.MaxInt64-1, 0), nil},
+		{"near max overflow", mm(math.MaxInt64/2+1, 0), 2, mm(0, 0), ErrOverflow},
+		{"large multiplier overflow", mm(math.MaxInt64/1000, 0), math.MaxUint32, mm(0, 0), ErrOverflow},
+		{"nanos carry overflow", mm(math.MaxInt64/3, 999999999), 3, mm(0, 0), ErrOverflow},
+		{"max multiplier", mm(0, 1), math.MaxUint32, mm(4, 294967295), nil},
+		{"max multiplier with units", mm(3, 500000000), math.MaxUint32, mm(15032385532, 500000000), nil},
+		{"negative near min", mm(math.MinInt64/2, 0), 2, mm(math.MinInt64, 0), nil},
+		{"negative overflow", mm(math.MinInt64/2-1, 0), 2, mm(0, 0), ErrOverflow},
+		{"invalid value", mm(1, -1), 2, mm(0, 0), ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MultiplySlow(tt.in, tt.n)
+			if err != tt.wantErr {
+				t.Fatalf("MultiplySlow([%v], %d): expected err=\"%v\" got=\"%v\"", tt.in, tt.n, tt.wantErr, err)
+			}
+			if !AreEquals(got, tt.want) {
+				t.Errorf("MultiplySlow([%v], %d) = %v, want %v", tt.in, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     pb.Money
+		places int
+		want   string
+	}{
+		{"exact cents", mm(19, 990000000), 2, "19.99"},
+		{"float rounds down 2.675", mm(2, 675000000), 2, "2.68"},
+		{"float rounds down 1.005", mm(1, 5000000), 2, "1.01"},
+		{"below half", mm(1, 4999999), 2, "1.00"},
+		{"carry into units", mm(9, 995000000), 2, "10.00"},
+		{"negative", mm(-2, -675000000), 2, "-2.68"},
+		{"negative rounding to zero", mm(0, -4000000), 2, "0.00"},
+		{"no decimals", mm(100, 500000000), 0, "101"},
+		{"three decimals", mm(1, 234500000), 3, "1.235"},
+		{"nanos", mm(0, 1), 9, "0.000000001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Format(&tt.in, tt.places); got != tt.want {
+				t.Errorf("Format(%v, %d) = %q, want %q", tt.in, tt.places, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+// maxOrderHistory bounds the number of orders remembered per user.
+const maxOrderHistory = 20
+
+// maxOrderHistoryUsers bounds the number of users whose orders are
+// remembered. Past it, the orders of the user who ordered least recently are
+// forgotten, so minting sessions cannot grow the history without bound.
+const maxOrderHistoryUsers = 10000
+
+// orderHistory remembers the orders placed through this frontend. The
+// checkout service does not keep past orders, so this in-memory record is
+// what "buy it again", order tracking and returns work from; it is lost when the
+// frontend restarts. A nil *orderHistory records nothing.
+type orderHistory struct {
+	maxUsers int // maxOrderHistoryUsers when 0
+
+	mu    sync.Mutex
+	users map[string]*list.Element // of *userOrders, in lru
+	lru   list.List                // most recently ordering user first
+	byID  map[string]placedOrder
+}
+
+// userOrders are the orders of one user, oldest first.
+type userOrders struct {
+	userID string
+	orders []*pb.OrderResult
+}
+
+// placedOrder is an order with the details needed to look it up for its
+// customer.
+type placedOrder struct {
+	order    *pb.OrderResult
+	email    string
+	placedAt time.Time
+}
+
+func (h *orderHistory) record(userID, email string, order *pb.OrderResult) {
+	if h == nil || order == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.users == nil {
+		h.users = make(map[string]*list.Element)
+		h.byID = make(map[string]placedOrder)
+	}
+	e, ok := h.users[userID]
+	if ok {
+		h.lru.MoveToFront(e)
+	} else {
+		e = h.lru.PushFront(&userOrders{userID: userID})
+		h.users[userID] = e
+	}
+	u := e.Value.(*userOrders)
+	u.orders = append(u.orders, order)
+	if len(u.orders) > maxOrderHistory {
+		h.forget(u.orders[:len(u.orders)-maxOrderHistory])
+		u.orders = u.orders[len(u.orders)-maxOrderHistory:]
+	}
+	maxUsers := h.maxUsers
+	if maxUsers == 0 {
+		maxUsers = maxOrderHistoryUsers
+	}
+	for h.lru.Len() > maxUsers {
+		old := h.lru.Remove(h.lru.Back()).(*userOrders)
+		delete(h.users, old.userID)
+		h.forget(old.orders)
+	}
+	if order.GetOrderId() != "" {
+		h.byID[order.GetOrderId()] = placedOrder{
+			order:    order,
+			email:    strings.ToLower(strings.TrimSpace(email)),
+			placedAt: time.Now(),
+		}
+	}
+}
+
+// forget drops orders from the order ID index. h.mu must be held.
+func (h *orderHistory) forget(orders []*pb.OrderResult) {
+	for _, o := range orders {
+		delete(h.byID, o.GetOrderId())
+	}
+}
+
+// lookup returns the order with the given ID, and false if it is unknown.
+func (h *orderHistory) lookup(orderID string) (placedOrder, bool) {
+	if h == nil {
+		return placedOrder{}, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	o, ok := h.byID[orderID]
+	return o, ok
+}
+
+// recent returns the orders of userID, most recent first.
+func (h *orderHistory) recent(userID string) []*pb.OrderResult {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.users[userID]
+	if !ok {
+		return nil
+	}
+	orders := e.Value.(*userOrders).orders
+	out := make([]*pb.OrderResult, len(orders))
+	for i, o := range orders {
+		out[len(orders)-1-i] = o
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestOrderHistoryEvictsLeastRecentUser(t *testing.T) {
+	h := &orderHistory{maxUsers: 2}
+	order := func(id string) *pb.OrderResult { return &pb.OrderResult{OrderId: id} }
+	h.record("alice", "a@example.com", order("A1"))
+	h.record("bob", "b@example.com", order("B1"))
+	h.record("alice", "a@example.com", order("A2")) // alice ordered more recently
+	h.record("carol", "c@example.com", order("C1"))
+
+	if got := h.recent("bob"); len(got) != 0 {
+		t.Errorf("bob still has %d orders after being evicted", len(got))
+	}
+	if _, ok := h.lookup("B1"); ok {
+		t.Error("the evicted user's order can still be looked up")
+	}
+	for user, want := range map[string]int{"alice": 2, "carol": 1} {
+		if got := h.recent(user); len(got) != want {
+			t.Errorf("%s has %d orders, want %d", user, len(got), want)
+		}
+	}
+	if _, ok := h.lookup("A1"); !ok {
+		t.Error("order A1 of a kept user was dropped")
+	}
+}
+
+func TestOrderHistoryBoundsOrdersPerUser(t *testing.T) {
+	h := &orderHistory{}
+	for i := 0; i < maxOrderHistory+5; i++ {
+		h.record("alice", "a@example.com", &pb.OrderResult{OrderId: fmt.Sprint(i)})
+	}
+	got := h.recent("alice")
+	if len(got) != maxOrderHistory || got[0].GetOrderId() != fmt.Sprint(maxOrderHistory+4) {
+		t.Errorf("kept %d orders starting with %q, want the latest %d", len(got), got[0].GetOrderId(), maxOrderHistory)
+	}
+	if _, ok := h.lookup("0"); ok {
+		t.Error("a dropped order can still be looked up")
+	}
+}
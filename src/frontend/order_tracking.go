@@ -0,0 +1,87 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// errOrderNotFound is returned for unknown orders and for orders placed with
+// another email address, so a lookup does not reveal which order IDs exist.
+var errOrderNotFound = errors.New("order not found")
+
+// orderStatus is what a customer may learn about one of their orders.
+type orderStatus struct {
+	OrderID    string    `json:"order_id"`
+	Status     string    `json:"status"`
+	TrackingID string    `json:"tracking_id,omitempty"`
+	ItemCount  int       `json:"item_count"`
+	PlacedAt   time.Time `json:"placed_at"`
+}
+
+// getOrderStatus returns the status of an order placed through this
+// frontend, if email is the address it was placed with. The shipping service
+// ships every order as it is placed, so an order with a tracking ID is
+// reported as shipped. Orders come from the in-memory orderHistory: those
+// placed before a restart, through another replica, or by a user it no
+// longer tracks (see maxOrderHistoryUsers) are not found.
+func (fe *frontendServer) getOrderStatus(ctx context.Context, orderID, email string) (*orderStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	placed, err := fe.orders.lookupForEmail(orderID, email)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &orderStatus{
+		OrderID:    placed.order.GetOrderId(),
+		Status:     "processing",
+		TrackingID: placed.order.GetShippingTrackingId(),
+		PlacedAt:   placed.placedAt,
+	}
+	if status.TrackingID != "" {
+		status.Status = "shipped"
+	}
+	for _, item := range placed.order.GetItems() {
+		status.ItemCount += int(item.GetItem().GetQuantity())
+	}
+	return status, nil
+}
+
+// lookupForEmail returns the order with the given ID if email is the address
+// it was placed with, and errOrderNotFound otherwise.
+func (h *orderHistory) lookupForEmail(orderID, email string) (placedOrder, error) {
+	placed, ok := h.lookup(strings.TrimSpace(orderID))
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !ok || email == "" || subtle.ConstantTimeCompare([]byte(placed.email), []byte(email)) != 1 {
+		return placedOrder{}, errOrderNotFound
+	}
+	return placed, nil
+}
+
+// describe renders the status for the support agent's prompt.
+func (s *orderStatus) describe() string {
+	desc := fmt.Sprintf("Order %s (%d items) placed %s is %s.", s.OrderID, s.ItemCount, s.PlacedAt.UTC().Format(time.RFC1123), s.Status)
+	if s.TrackingID != "" {
+		desc += " Tracking ID: " + s.TrackingID + "."
+	}
+	return desc
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestGetOrderStatus(t *testing.T) {
+	fe := &frontendServer{orders: &orderHistory{}}
+	fe.orders.record("user-1", "Ada@Example.com", &pb.OrderResult{
+		OrderId:            "ORDER-1",
+		ShippingTrackingId: "TRACK-1",
+		Items: []*pb.OrderItem{
+			{Item: &pb.CartItem{ProductId: "MUG", Quantity: 2}},
+			{Item: &pb.CartItem{ProductId: "HAT", Quantity: 1}},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		orderID string
+		email   string
+		wantErr error
+	}{
+		{"found", "ORDER-1", " ada@example.com ", nil},
+		{"mismatched email", "ORDER-1", "eve@example.com", errOrderNotFound},
+		{"missing email", "ORDER-1", "", errOrderNotFound},
+		{"missing order", "ORDER-2", "ada@example.com", errOrderNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := fe.getOrderStatus(context.Background(), tt.orderID, tt.email)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if status.Status != "shipped" || status.TrackingID != "TRACK-1" || status.ItemCount != 3 {
+				t.Errorf("status = %+v, want shipped with TRACK-1 and 3 items", status)
+			}
+		})
+	}
+}
+
+func TestCustomerServiceOrderNotFound(t *testing.T) {
+	// No gateway is configured: a missing order must be answered locally.
+	fe := &frontendServer{
+		orders:           &orderHistory{},
+		agentsGatewayURL: "http://127.0.0.1:0",
+		flags:            featureFlags{CustomerService: true},
+	}
+	fe.orders.record("user-1", "ada@example.com", &pb.OrderResult{OrderId: "ORDER-1"})
+
+	rec := httptest.NewRecorder()
+	fe.customerServiceHandler(rec, newTestRequest(http.MethodPost, "/api/customer-service",
+		`{"type": "order_tracking", "message": "Where is it?", "order_id": "ORDER-1", "email": "eve@example.com"}`))
+
+	var resp struct {
+		OrderFound     *bool  `json:"order_found"`
+		NotFoundReason string `json:"not_found_reason"`
+		AgentPowered   bool   `json:"agent_powered"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.OrderFound == nil || *resp.OrderFound || resp.AgentPowered {
+		t.Errorf("response = %s, want a local order-not-found answer", rec.Body.String())
+	}
+	if resp.NotFoundReason != "not_found_on_replica" {
+		t.Errorf("not_found_reason = %q, want not_found_on_replica", resp.NotFoundReason)
+	}
+}
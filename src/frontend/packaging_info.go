@@ -15,11 +15,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 /*
@@ -29,6 +33,11 @@ This file contains code related to the frontend and the "packaging" microservice
 
 var (
 	packagingServiceUrl string
+
+	// packagingTimeout bounds each packaging info request, so a slow packaging
+	// service delays the product page by at most this much. Overridden by
+	// PACKAGING_SERVICE_TIMEOUT.
+	packagingTimeout = 500 * time.Millisecond
 )
 
 type PackagingInfo struct {
@@ -47,33 +56,38 @@ func isPackagingServiceConfigured() bool {
 	return packagingServiceUrl != ""
 }
 
-func httpGetPackagingInfo(productId string) (*PackagingInfo, error) {
-	// Make the GET request
-	url := packagingServiceUrl + "/" + productId
-	fmt.Println("Requesting packaging info from URL: ", url)
-	resp, err := http.Get(url)
+// loadPackagingTimeout applies the PACKAGING_SERVICE_TIMEOUT override.
+func loadPackagingTimeout(log logrus.FieldLogger) {
+	loadDurations(log, map[string]*time.Duration{
+		"PACKAGING_SERVICE_TIMEOUT": &packagingTimeout,
+	})
+}
+
+// httpGetPackagingInfo fetches the packaging info of a product. The request
+// is bound to ctx and cut off after packagingTimeout.
+func httpGetPackagingInfo(ctx context.Context, log logrus.FieldLogger, productId string) (*PackagingInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, packagingTimeout)
+	defer cancel()
+
+	endpoint := packagingServiceUrl + "/" + url.PathEscape(productId)
+	log.WithField("url", endpoint).Debug("requesting packaging info")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := upstreamClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	// Read the JSON response body
-	responseBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	// Decode the JSON response into a PackagingInfo struct
 	var packagingInfo PackagingInfo
-	err = json.Unmarshal(responseBody, &packagingInfo)
-	if err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(&packagingInfo); err != nil {
 		return nil, err
 	}
-
 	return &packagingInfo, nil
 }
@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestProductPageWithSlowPackagingService(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	packaging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer packaging.Close()
+	defer close(release)
+
+	defer func(url string, timeout time.Duration) {
+		packagingServiceUrl, packagingTimeout = url, timeout
+	}(packagingServiceUrl, packagingTimeout)
+	packagingServiceUrl, packagingTimeout = packaging.URL, 100*time.Millisecond
+
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, &fakeProductCatalog{products: []*pb.Product{
+			{Id: "A", Name: "Alpha", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 10}},
+		}})
+		pb.RegisterCurrencyServiceServer(s, fakeCurrencyService{})
+		pb.RegisterCartServiceServer(s, &fakeCartService{})
+		pb.RegisterRecommendationServiceServer(s, fakeRecommendationService{})
+	})
+	fe := &frontendServer{
+		productCatalogSvcConn: conn,
+		currencySvcConn:       conn,
+		cartSvcConn:           conn,
+		recommendationSvcConn: conn,
+		adSvcConn:             conn,
+	}
+
+	r := mux.SetURLVars(newTestRequest(http.MethodGet, "/product/A", ""), map[string]string{"id": "A"})
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	fe.productHandler(rec, r)
+	elapsed := time.Since(start)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if requests.Load() != 1 {
+		t.Errorf("packaging service got %d requests, want 1", requests.Load())
+	}
+	if elapsed > time.Second {
+		t.Errorf("product page took %s with a stalled packaging service", elapsed)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// gcpMetadataHost resolves only from inside Google Cloud.
+const gcpMetadataHost = "metadata.google.internal."
+
+// platformLookupTimeout bounds the metadata lookup so a slow or broken
+// resolver can't hold up the first page render.
+var platformLookupTimeout = 500 * time.Millisecond
+
+// hostResolver is the part of *net.Resolver platform detection needs.
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+var (
+	platformOnce sync.Once
+	plat         platformDetails
+)
+
+// currentPlatform returns the platform the frontend runs on, detecting it on
+// the first request that renders a page.
+func currentPlatform(ctx context.Context) platformDetails {
+	platformOnce.Do(func() {
+		log, ok := ctx.Value(ctxKeyLog{}).(logrus.FieldLogger)
+		if !ok {
+			log = logrus.StandardLogger()
+		}
+		plat = detectPlatform(context.WithoutCancel(ctx), log, net.DefaultResolver, platformLookupTimeout)
+	})
+	return plat
+}
+
+// detectPlatform uses ENV_PLATFORM when it names a known platform and
+// "local" otherwise, unless the GCP metadata server resolves, which overrides
+// it. The lookup is abandoned after timeout even if the resolver ignores ctx.
+func detectPlatform(ctx context.Context, log logrus.FieldLogger, resolver hostResolver, timeout time.Duration) platformDetails {
+	env := strings.ToLower(os.Getenv("ENV_PLATFORM"))
+	if !stringinSlice(validEnvs, env) {
+		log.Debug("env platform is either empty or invalid")
+		env = "local"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	type lookup struct {
+		addrs []string
+		err   error
+	}
+	done := make(chan lookup, 1)
+	go func() {
+		addrs, err := resolver.LookupHost(ctx, gcpMetadataHost)
+		done <- lookup{addrs, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err == nil && len(res.addrs) > 0 {
+			log.Debugf("Detected Google metadata server: %v, setting ENV_PLATFORM to GCP.", res.addrs)
+			env = "gcp"
+		}
+	case <-ctx.Done():
+		log.Warnf("GCP metadata lookup did not finish within %v, assuming ENV_PLATFORM %s", timeout, env)
+	}
+
+	log.Debugf("ENV_PLATFORM is: %s", env)
+	var p platformDetails
+	p.setPlatformDetails(env)
+	return p
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type hangingResolver struct{ release chan struct{} }
+
+// LookupHost blocks until released, ignoring ctx like a stuck resolver would.
+func (h hangingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	<-h.release
+	return nil, errors.New("released")
+}
+
+type stubResolver []string
+
+func (s stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if len(s) == 0 {
+		return nil, errors.New("no such host")
+	}
+	return s, nil
+}
+
+func TestDetectPlatformGivesUpOnHangingResolver(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+	resolver := hangingResolver{release: make(chan struct{})}
+	t.Cleanup(func() { close(resolver.release) })
+
+	for env, want := range map[string]string{"": "local", "aws": "AWS", "bogus": "local"} {
+		t.Setenv("ENV_PLATFORM", env)
+		start := time.Now()
+		got := detectPlatform(context.Background(), log, resolver, 50*time.Millisecond)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("ENV_PLATFORM=%q: detection took %v, want it to give up after 50ms", env, elapsed)
+		}
+		if got.provider != want {
+			t.Errorf("ENV_PLATFORM=%q: provider = %q, want %q", env, got.provider, want)
+		}
+	}
+}
+
+func TestDetectPlatformMetadataServer(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+	t.Setenv("ENV_PLATFORM", "aws")
+
+	if got := detectPlatform(context.Background(), log, stubResolver{"169.254.169.254"}, time.Second); got.provider != "Google Cloud" {
+		t.Errorf("with metadata server: provider = %q, want Google Cloud", got.provider)
+	}
+	if got := detectPlatform(context.Background(), log, stubResolver{}, time.Second); got.provider != "AWS" {
+		t.Errorf("without metadata server: provider = %q, want AWS", got.provider)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// productAvailability is the availability of a product. Stock is omitted for
+// products that do not track it, which are always available.
+type productAvailability struct {
+	ProductID string `json:"product_id"`
+	Available bool   `json:"available"`
+	Stock     *int32 `json:"stock,omitempty"`
+}
+
+// GET /api/product/{id}/availability
+// Reports whether a product can be bought. Unknown products are reported as
+// unavailable rather than with a 404, so agents can branch on one field.
+func (fe *frontendServer) apiProductAvailability(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	id := normalizeProductID(mux.Vars(r)["id"])
+	resp := productAvailability{ProductID: id}
+
+	p, err := fe.getProduct(r.Context(), id)
+	switch {
+	case status.Code(err) == codes.NotFound:
+	case err != nil:
+		log.WithField("error", err).WithField("product_id", id).Error("could not retrieve product availability")
+		writeAPIError(w, r, http.StatusBadGateway, errCodeCatalogUnavailable, "product catalog temporarily unavailable")
+		return
+	default:
+		resp.Available = p.Stock == nil || p.GetStock() > 0
+		resp.Stock = p.Stock
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestAPIProductAvailability(t *testing.T) {
+	fe := &frontendServer{productCatalogSvcConn: serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, &fakeProductCatalog{products: []*pb.Product{
+			{Id: "INSTOCK", Name: "Mug", Stock: proto.Int32(3)},
+			{Id: "SOLDOUT", Name: "Jar", Stock: proto.Int32(0)},
+			{Id: "UNTRACKED", Name: "Cup"},
+		}})
+	})}
+
+	tests := []struct {
+		id            string
+		wantAvailable bool
+		wantStock     *int32
+	}{
+		{"INSTOCK", true, proto.Int32(3)},
+		{"SOLDOUT", false, proto.Int32(0)},
+		{"UNTRACKED", true, nil},
+		{"NOPE", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			r := mux.SetURLVars(newTestRequest(http.MethodGet, "/api/product/"+tt.id+"/availability", ""), map[string]string{"id": tt.id})
+			rec := httptest.NewRecorder()
+			fe.apiProductAvailability(rec, r)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+			}
+
+			var got productAvailability
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Available != tt.wantAvailable {
+				t.Errorf("available = %v, want %v", got.Available, tt.wantAvailable)
+			}
+			if (got.Stock == nil) != (tt.wantStock == nil) || (got.Stock != nil && *got.Stock != *tt.wantStock) {
+				t.Errorf("stock = %v, want %v", got.Stock, tt.wantStock)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+// lowStockThreshold is the stock level at or below which a product is shown
+// as low in stock.
+const lowStockThreshold = 5
+
+// isOnSale reports whether p has a sale price below its regular price.
+func isOnSale(p *pb.Product) bool {
+	sale, price := p.GetSalePriceUsd(), p.GetPriceUsd()
+	return sale != nil && price != nil &&
+		sale.GetCurrencyCode() == price.GetCurrencyCode() &&
+		moneyNanos(sale) < moneyNanos(price)
+}
+
+// unitPrice returns the USD price p is charged at: its sale price while it
+// is on sale, its regular price otherwise. Carts and checkoutservice charge
+// the price the product pages advertise.
+func unitPrice(p *pb.Product) *pb.Money {
+	if isOnSale(p) {
+		return p.GetSalePriceUsd()
+	}
+	return p.GetPriceUsd()
+}
+
+// discountPercent returns the sale discount of p in whole percent, rounded
+// down, or 0 if p is not on sale.
+func discountPercent(p *pb.Product) int {
+	if !isOnSale(p) {
+		return 0
+	}
+	price := moneyNanos(p.GetPriceUsd())
+	return int((price - moneyNanos(p.GetSalePriceUsd())) * 100 / price)
+}
+
+// isLowStock reports whether p tracks stock and has few units left. Products
+// that are out of stock are not "low".
+func isLowStock(p *pb.Product) bool {
+	return p.Stock != nil && p.GetStock() > 0 && p.GetStock() <= lowStockThreshold
+}
+
+func moneyNanos(m *pb.Money) int64 {
+	return m.GetUnits()*1e9 + int64(m.GetNanos())
+}
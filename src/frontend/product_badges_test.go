@@ -0,0 +1,174 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestProductBadges(t *testing.T) {
+	usd := func(units int64, nanos int32) *pb.Money {
+		return &pb.Money{CurrencyCode: "USD", Units: units, Nanos: nanos}
+	}
+	tests := []struct {
+		name         string
+		product      *pb.Product
+		wantOnSale   bool
+		wantDiscount int
+		wantLowStock bool
+	}{
+		{"plain", &pb.Product{PriceUsd: usd(10, 0)}, false, 0, false},
+		{"on sale", &pb.Product{PriceUsd: usd(10, 0), SalePriceUsd: usd(7, 490000000)}, true, 25, false},
+		{"sale not cheaper", &pb.Product{PriceUsd: usd(10, 0), SalePriceUsd: usd(10, 0)}, false, 0, false},
+		{"sale in other currency", &pb.Product{PriceUsd: usd(10, 0), SalePriceUsd: &pb.Money{CurrencyCode: "EUR", Units: 5}}, false, 0, false},
+		{"low stock", &pb.Product{PriceUsd: usd(10, 0), Stock: proto.Int32(lowStockThreshold)}, false, 0, true},
+		{"plenty of stock", &pb.Product{PriceUsd: usd(10, 0), Stock: proto.Int32(lowStockThreshold + 1)}, false, 0, false},
+		{"out of stock", &pb.Product{PriceUsd: usd(10, 0), Stock: proto.Int32(0)}, false, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOnSale(tt.product); got != tt.wantOnSale {
+				t.Errorf("isOnSale() = %v, want %v", got, tt.wantOnSale)
+			}
+			if got := discountPercent(tt.product); got != tt.wantDiscount {
+				t.Errorf("discountPercent() = %d, want %d", got, tt.wantDiscount)
+			}
+			if got := isLowStock(tt.product); got != tt.wantLowStock {
+				t.Errorf("isLowStock() = %v, want %v", got, tt.wantLowStock)
+			}
+			wantPrice := tt.product.GetPriceUsd()
+			if tt.wantOnSale {
+				wantPrice = tt.product.GetSalePriceUsd()
+			}
+			if got := unitPrice(tt.product); got != wantPrice {
+				t.Errorf("unitPrice() = %v, want %v", got, wantPrice)
+			}
+		})
+	}
+}
+
+func TestProductPageSaleBadge(t *testing.T) {
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, &fakeProductCatalog{products: []*pb.Product{
+			{Id: "SALE", Name: "Mug", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 10},
+				SalePriceUsd: &pb.Money{CurrencyCode: "USD", Units: 8}, Stock: proto.Int32(2)},
+			{Id: "FULL", Name: "Cup", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 10}},
+		}})
+		pb.RegisterCurrencyServiceServer(s, fakeCurrencyService{})
+		pb.RegisterCartServiceServer(s, &fakeCartService{})
+		pb.RegisterRecommendationServiceServer(s, fakeRecommendationService{})
+	})
+	fe := &frontendServer{
+		productCatalogSvcConn: conn,
+		currencySvcConn:       conn,
+		cartSvcConn:           conn,
+		recommendationSvcConn: conn,
+		adSvcConn:             conn,
+	}
+
+	render := func(id string) string {
+		t.Helper()
+		r := mux.SetURLVars(newTestRequest(http.MethodGet, "/product/"+id, ""), map[string]string{"id": id})
+		r.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "EUR"})
+		rec := httptest.NewRecorder()
+		fe.productHandler(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	body := render("SALE")
+	for _, want := range []string{`class="sale-price">€4.00`, "-20%", "Only 2 left"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("product page on sale does not contain %q", want)
+		}
+	}
+	body = render("FULL")
+	for _, unwanted := range []string{"sale-price", "product-badge"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("product page not on sale contains %q", unwanted)
+		}
+	}
+}
+
+func TestHomePageSaleBadges(t *testing.T) {
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, &fakeProductCatalog{products: []*pb.Product{
+			{Id: "FULL", Name: "Cup", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 6}},
+			{Id: "SALE", Name: "Mug", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 10},
+				SalePriceUsd: &pb.Money{CurrencyCode: "USD", Units: 5}},
+		}})
+		pb.RegisterCurrencyServiceServer(s, fakeCurrencyService{})
+		pb.RegisterCartServiceServer(s, &fakeCartService{})
+	})
+	fe := &frontendServer{currencySvcConn: conn, productCatalogSvcConn: conn, cartSvcConn: conn, adSvcConn: conn}
+
+	req := newTestRequest(http.MethodGet, "/", "")
+	req.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "EUR"})
+	rec := httptest.NewRecorder()
+	fe.homeHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`class="hot-product-card-price">€3.00`, `class="sale-price">€2.50`, "-50%"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("home page does not contain %q", want)
+		}
+	}
+	if n := strings.Count(body, `class="sale-price"`); n != 1 {
+		t.Errorf("home page shows %d sale prices, want 1", n)
+	}
+}
+
+func TestCartChargesSalePrice(t *testing.T) {
+	cart := &fakeCartService{items: map[string][]*pb.CartItem{"u": {{ProductId: "A", Quantity: 2}}}}
+	catalog := &fakeProductCatalog{products: []*pb.Product{{
+		Id:           "A",
+		PriceUsd:     &pb.Money{CurrencyCode: "USD", Units: 10, Nanos: 470000000},
+		SalePriceUsd: &pb.Money{CurrencyCode: "USD", Units: 8, Nanos: 490000000},
+	}}}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, cart)
+		pb.RegisterProductCatalogServiceServer(s, catalog)
+	})
+	fe := &frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn, sessionKey: testSessionKey}
+
+	rec := httptest.NewRecorder()
+	fe.apiGetCart(rec, newTestRequest(http.MethodGet, "/api/cart?userId="+fe.signSessionID("u"), ""))
+	var resp struct {
+		Items []struct {
+			Price string `json:"price"`
+		} `json:"items"`
+		TotalPrice string `json:"total_price"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Items) != 1 || resp.Items[0].Price != "8.49" || resp.TotalPrice != "16.98" {
+		t.Errorf("cart = %+v, want the sale price 8.49 and total 16.98", resp)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestNormalizeProductID(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"OLJCESPC7Z", "OLJCESPC7Z"},
+		{"  OLJCESPC7Z ", "OLJCESPC7Z"},
+		{"\tOLJCESPC7Z\n", "OLJCESPC7Z"},
+		{"oljcespc7z", "OLJCESPC7Z"},
+		{" OljCespc7z", "OLJCESPC7Z"},
+		{"   ", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeProductID(tt.in); got != tt.want {
+			t.Errorf("normalizeProductID(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAddToCartNormalizesProductID(t *testing.T) {
+	cart := &fakeCartService{}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCartServiceServer(s, cart)
+		pb.RegisterProductCatalogServiceServer(s, &fakeProductCatalog{products: []*pb.Product{{Id: "OLJCESPC7Z", Name: "Sunglasses"}}})
+	})
+	fe := &frontendServer{cartSvcConn: conn, productCatalogSvcConn: conn}
+
+	req := newTestRequest(http.MethodPost, "/cart", "product_id=+oljcespc7z+&quantity=1")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	fe.addToCartHandler(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("form add: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	fe.apiAddToCart(httptest.NewRecorder(), newTestRequest(http.MethodPost, "/api/cart/add", `{"productId": " OljCespc7z\n"}`))
+
+	items := cart.items["test-session"]
+	if len(items) != 2 {
+		t.Fatalf("cart has %d items, want 2", len(items))
+	}
+	for _, item := range items {
+		if item.GetProductId() != "OLJCESPC7Z" {
+			t.Errorf("cart item product ID = %q, want OLJCESPC7Z", item.GetProductId())
+		}
+	}
+}
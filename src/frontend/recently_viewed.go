@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+// viewedProduct is one entry of GET /api/recently-viewed.
+type viewedProduct struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Picture     string    `json:"picture"`
+	Price       string    `json:"price,omitempty"`
+	PriceMoney  *pb.Money `json:"price_money,omitempty"`
+}
+
+// GET /api/recently-viewed?userId=
+// Returns the products the session viewed lately, most recent first, each
+// once and at most maxSessionHistory of them, priced in the session's
+// currency. Products no longer in the catalog are left out.
+func (fe *frontendServer) apiRecentlyViewed(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	userId := fe.apiUserID(r, r.URL.Query().Get("userId"))
+	currency := currentCurrency(r)
+
+	viewed, _ := fe.history.recent(userId)
+	products, discontinued, err := fe.getProductsByID(r.Context(), viewed)
+	if err != nil {
+		log.WithField("error", err).Error("could not retrieve recently viewed products")
+		writeAPIError(w, r, http.StatusBadGateway, errCodeCatalogUnavailable, "product catalog temporarily unavailable")
+		return
+	}
+	if len(discontinued) > 0 {
+		log.WithField("products", discontinued).Debug("skipping discontinued products")
+	}
+
+	amounts := make([]*pb.Money, len(products))
+	for i, p := range products {
+		amounts[i] = p.GetPriceUsd()
+	}
+	// Prices that cannot be converted are shown in USD.
+	prices, err := fe.convertCurrencyBatch(r.Context(), amounts, currency)
+	if err != nil {
+		log.WithError(err).Warnf("could not convert recently viewed prices to %s", currency)
+	}
+	out := make([]viewedProduct, len(products))
+	for i, p := range products {
+		price := prices[i]
+		if price == nil {
+			price = p.GetPriceUsd()
+		}
+		out[i] = viewedProduct{
+			ID:          p.GetId(),
+			Name:        p.GetName(),
+			Description: p.GetDescription(),
+			Picture:     productPicture(p.GetPicture()),
+			PriceMoney:  price,
+		}
+		if price != nil {
+			out[i].Price = renderMoney(*price)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"user_id":  userId,
+		"currency": currency,
+		"products": out,
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestAPIRecentlyViewed(t *testing.T) {
+	catalog := &fakeProductCatalog{products: []*pb.Product{
+		{Id: "A", Name: "Mug", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 8}},
+		{Id: "B", Name: "Cup", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 4}},
+		{Id: "C", Name: "Bowl", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 6}},
+	}}
+	fe := &frontendServer{
+		productCatalogSvcConn: serveGRPC(t, func(s *grpc.Server) { pb.RegisterProductCatalogServiceServer(s, catalog) }),
+		currencySvcConn:       serveGRPC(t, func(s *grpc.Server) { pb.RegisterCurrencyServiceServer(s, fakeCurrencyService{}) }),
+		history:               &sessionHistory{},
+	}
+	// GONE has been discontinued since it was viewed.
+	for _, id := range []string{"A", "B", "GONE", "A", "C"} {
+		fe.history.recordView("test-session", id)
+	}
+	fe.history.recordView("other-session", "B")
+
+	r := newTestRequest(http.MethodGet, "/api/recently-viewed", "")
+	r.AddCookie(&http.Cookie{Name: cookieCurrency, Value: "EUR"})
+	rec := httptest.NewRecorder()
+	fe.apiRecentlyViewed(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	var resp struct {
+		Currency string          `json:"currency"`
+		Products []viewedProduct `json:"products"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, p := range resp.Products {
+		ids = append(ids, p.ID)
+	}
+	if want := []string{"C", "A", "B"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("products = %v, want %v (most recent first, once each, without GONE)", ids, want)
+	}
+	if resp.Currency != "EUR" || resp.Products[0].Price != "€3.00" {
+		t.Errorf("first price = %q in %s, want €3.00 in EUR", resp.Products[0].Price, resp.Currency)
+	}
+}
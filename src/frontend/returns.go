@@ -0,0 +1,121 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// returnWindow is how long after an order is placed it may be returned.
+// Override with a Go duration string in RETURN_WINDOW.
+var returnWindow = 30 * 24 * time.Hour
+
+// loadReturnWindow applies the RETURN_WINDOW override.
+func loadReturnWindow(log logrus.FieldLogger) {
+	loadDurations(log, map[string]*time.Duration{
+		"RETURN_WINDOW": &returnWindow,
+	})
+}
+
+// errReturnWindowClosed is returned for orders placed more than returnWindow
+// ago.
+var errReturnWindowClosed = errors.New("order is outside the return window")
+
+// returnRequest asks for the return of a whole order.
+type returnRequest struct {
+	OrderID string
+	Email   string
+	Reason  string
+}
+
+// returnRecord is a return that has been accepted.
+type returnRecord struct {
+	RMANumber string    `json:"rma_number"`
+	OrderID   string    `json:"order_id"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ReturnBy  time.Time `json:"return_by"`
+}
+
+// returnsService creates returns. It mirrors the RPC a returns service would
+// expose, so the frontend can move to one without changing its handlers.
+type returnsService interface {
+	CreateReturn(ctx context.Context, req returnRequest) (*returnRecord, error)
+}
+
+// localReturns is the in-process returnsService. It checks orders against
+// the frontend's order history and, like it, keeps returns in memory only:
+// orders and returns from before a restart, or from another replica, are
+// unknown to it. Asking again for the return of the same order gives back the
+// existing RMA.
+type localReturns struct {
+	orders *orderHistory
+	now    func() time.Time
+
+	mu      sync.Mutex
+	byOrder map[string]*returnRecord
+}
+
+func newLocalReturns(orders *orderHistory) *localReturns {
+	return &localReturns{orders: orders, now: time.Now}
+}
+
+func (s *localReturns) CreateReturn(ctx context.Context, req returnRequest) (*returnRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	placed, err := s.orders.lookupForEmail(req.OrderID, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	orderID := placed.order.GetOrderId()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.byOrder[orderID]; ok {
+		return rec, nil
+	}
+	now := s.now()
+	returnBy := placed.placedAt.Add(returnWindow)
+	if now.After(returnBy) {
+		return nil, errReturnWindowClosed
+	}
+	rec := &returnRecord{
+		RMANumber: "RMA-" + strings.ToUpper(uuid.NewString()[:8]),
+		OrderID:   orderID,
+		Reason:    strings.TrimSpace(req.Reason),
+		CreatedAt: now,
+		ReturnBy:  returnBy,
+	}
+	if s.byOrder == nil {
+		s.byOrder = make(map[string]*returnRecord)
+	}
+	s.byOrder[orderID] = rec
+	return rec, nil
+}
+
+// describe renders the return for the support agent's prompt.
+func (r *returnRecord) describe() string {
+	return fmt.Sprintf("Return %s was created for order %s. Items must be sent back by %s.",
+		r.RMANumber, r.OrderID, r.ReturnBy.UTC().Format(time.RFC1123))
+}
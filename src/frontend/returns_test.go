@@ -0,0 +1,135 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestCreateReturn(t *testing.T) {
+	orders := &orderHistory{}
+	orders.record("user-1", "ada@example.com", &pb.OrderResult{OrderId: "ORDER-1"})
+	placedAt := time.Now()
+
+	tests := []struct {
+		name    string
+		orderID string
+		email   string
+		after   time.Duration
+		wantErr error
+	}{
+		{"eligible", "ORDER-1", "ada@example.com", 24 * time.Hour, nil},
+		{"out of window", "ORDER-1", "ada@example.com", returnWindow + time.Hour, errReturnWindowClosed},
+		{"unknown order", "ORDER-2", "ada@example.com", time.Hour, errOrderNotFound},
+		{"wrong email", "ORDER-1", "eve@example.com", time.Hour, errOrderNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newLocalReturns(orders)
+			s.now = func() time.Time { return placedAt.Add(tt.after) }
+
+			rec, err := s.CreateReturn(context.Background(), returnRequest{OrderID: tt.orderID, Email: tt.email, Reason: "Too small"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !strings.HasPrefix(rec.RMANumber, "RMA-") || rec.OrderID != "ORDER-1" || rec.Reason != "Too small" {
+				t.Errorf("return = %+v", rec)
+			}
+			again, err := s.CreateReturn(context.Background(), returnRequest{OrderID: tt.orderID, Email: tt.email})
+			if err != nil || again.RMANumber != rec.RMANumber {
+				t.Errorf("repeated return = %+v, %v; want RMA %s", again, err, rec.RMANumber)
+			}
+		})
+	}
+}
+
+func TestCustomerServiceReturnGivesAgentRMA(t *testing.T) {
+	var prompt string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/run" {
+			body, _ := io.ReadAll(r.Body)
+			prompt = string(body)
+			io.WriteString(w, `[{"content": {"parts": [{"text": "Your return is on its way."}]}}]`)
+			return
+		}
+		io.WriteString(w, `{"id": "adk-session"}`)
+	}))
+	defer gateway.Close()
+
+	orders := &orderHistory{}
+	orders.record("user-1", "ada@example.com", &pb.OrderResult{OrderId: "ORDER-1"})
+	fe := &frontendServer{
+		agentsGatewayURL: gateway.URL,
+		flags:            featureFlags{CustomerService: true},
+		orders:           orders,
+		returns:          newLocalReturns(orders),
+	}
+
+	rec := httptest.NewRecorder()
+	fe.customerServiceHandler(rec, newTestRequest(http.MethodPost, "/api/customer-service",
+		`{"type": "returns", "message": "It does not fit", "order_id": "ORDER-1", "email": "ada@example.com"}`))
+
+	var resp struct {
+		RMANumber      string `json:"rma_number"`
+		ReturnEligible bool   `json:"return_eligible"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.ReturnEligible || resp.RMANumber == "" {
+		t.Fatalf("response = %s, want an eligible return with an RMA number", rec.Body.String())
+	}
+	if !strings.Contains(prompt, resp.RMANumber) {
+		t.Errorf("agent prompt %q does not include RMA %s", prompt, resp.RMANumber)
+	}
+}
+
+func TestCustomerServiceReturnUnknownOrderSaysLookupWasLocal(t *testing.T) {
+	orders := &orderHistory{}
+	fe := &frontendServer{
+		agentsGatewayURL: "http://127.0.0.1:0",
+		flags:            featureFlags{CustomerService: true},
+		orders:           orders,
+		returns:          newLocalReturns(orders),
+	}
+
+	rec := httptest.NewRecorder()
+	fe.customerServiceHandler(rec, newTestRequest(http.MethodPost, "/api/customer-service",
+		`{"type": "returns", "message": "It does not fit", "order_id": "ORDER-9", "email": "ada@example.com"}`))
+
+	var resp struct {
+		Response       string `json:"response"`
+		NotFoundReason string `json:"not_found_reason"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.NotFoundReason != "not_found_on_replica" || !strings.Contains(resp.Response, "this server") {
+		t.Errorf("response = %s, want a not-found answer scoped to this replica", rec.Body.String())
+	}
+}
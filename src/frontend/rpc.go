@@ -16,19 +16,53 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/validator"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	avoidNoopCurrencyConversionRPC = false
+
+	// currencyConversionConcurrency bounds the Convert calls in flight when
+	// the currency service does not support ConvertBatch.
+	currencyConversionConcurrency = 8
+
+	// productFetchConcurrency bounds the GetProduct calls in flight when
+	// loading the products of a cart.
+	productFetchConcurrency = 8
 )
 
+// catalogTimeout bounds each product catalog call, so a slow catalog service
+// fails the page promptly instead of holding it until the client gives up.
+// Override with a Go duration string in CATALOG_TIMEOUT.
+var catalogTimeout = 3 * time.Second
+
+// loadCatalogTimeout applies the CATALOG_TIMEOUT override.
+func loadCatalogTimeout(log logrus.FieldLogger) {
+	loadDurations(log, map[string]*time.Duration{
+		"CATALOG_TIMEOUT": &catalogTimeout,
+	})
+}
+
+// getCurrencies returns the supported currencies the frontend offers. The
+// list is cached for currencyListTTL.
 func (fe *frontendServer) getCurrencies(ctx context.Context) ([]string, error) {
+	return fe.currencies.cachedCurrencies(ctx, fe.fetchCurrencies)
+}
+
+func (fe *frontendServer) fetchCurrencies(ctx context.Context) ([]string, error) {
 	currs, err := pb.NewCurrencyServiceClient(fe.currencySvcConn).
 		GetSupportedCurrencies(ctx, &pb.Empty{})
 	if err != nil {
@@ -45,16 +79,22 @@ func (fe *frontendServer) getCurrencies(ctx context.Context) ([]string, error) {
 
 func (fe *frontendServer) getProducts(ctx context.Context) ([]*pb.Product, error) {
 	// Homepage: Use cache for fast loading (no database header)
+	ctx, cancel := context.WithTimeout(ctx, catalogTimeout)
+	defer cancel()
 	resp, err := pb.NewProductCatalogServiceClient(fe.productCatalogSvcConn).
 		ListProducts(ctx, &pb.Empty{})
+	rewriteProductPictures(resp.GetProducts()...)
 	return resp.GetProducts(), err
 }
 
 func (fe *frontendServer) getProduct(ctx context.Context, id string) (*pb.Product, error) {
 	// Product details: Force database lookup for data consistency
 	ctx = fe.addDatabaseHeader(ctx)
+	ctx, cancel := context.WithTimeout(ctx, catalogTimeout)
+	defer cancel()
 	resp, err := pb.NewProductCatalogServiceClient(fe.productCatalogSvcConn).
 		GetProduct(ctx, &pb.GetProductRequest{Id: id})
+	rewriteProductPictures(resp)
 	return resp, err
 }
 
@@ -88,16 +128,100 @@ func (fe *frontendServer) convertCurrency(ctx context.Context, money *pb.Money,
 			ToCode: currency})
 }
 
-func (fe *frontendServer) getShippingQuote(ctx context.Context, items []*pb.CartItem, currency string) (*pb.Money, error) {
-	quote, err := pb.NewShippingServiceClient(fe.shippingSvcConn).GetQuote(ctx,
-		&pb.GetQuoteRequest{
-			Address: nil,
-			Items:   items})
-	if err != nil {
+// getCartProducts fetches the product of every cart item, a few at once, and
+// returns them in cart order. The first error cancels the remaining calls.
+func (fe *frontendServer) getCartProducts(ctx context.Context, cart []*pb.CartItem) ([]*pb.Product, error) {
+	products := make([]*pb.Product, len(cart))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(productFetchConcurrency)
+	for i, item := range cart {
+		g.Go(func() error {
+			p, err := fe.getProduct(ctx, item.GetProductId())
+			if err != nil {
+				return errors.Wrapf(err, "could not retrieve product #%s", item.GetProductId())
+			}
+			products[i] = p
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
 		return nil, err
 	}
-	localized, err := fe.convertCurrency(ctx, quote.GetCostUsd(), currency)
-	return localized, errors.Wrap(err, "failed to convert currency for shipping cost")
+	return products, nil
+}
+
+// convertCurrencyBatch converts amounts into currency with a single
+// ConvertBatch call and returns the results in order. Currency services
+// without ConvertBatch get concurrent Convert calls instead. On error, the
+// results hold the amounts that were converted and nil for the others.
+func (fe *frontendServer) convertCurrencyBatch(ctx context.Context, amounts []*pb.Money, currency string) ([]*pb.Money, error) {
+	results := make([]*pb.Money, len(amounts))
+	var pending []int
+	for i, m := range amounts {
+		if avoidNoopCurrencyConversionRPC && m.GetCurrencyCode() == currency {
+			results[i] = m
+			continue
+		}
+		pending = append(pending, i)
+	}
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	from := make([]*pb.Money, len(pending))
+	for j, i := range pending {
+		from[j] = amounts[i]
+	}
+	resp, err := pb.NewCurrencyServiceClient(fe.currencySvcConn).
+		ConvertBatch(ctx, &pb.CurrencyConversionBatchRequest{From: from, ToCode: currency})
+	if status.Code(err) == codes.Unimplemented {
+		return results, fe.convertCurrencyEach(ctx, amounts, pending, results, currency)
+	}
+	if err != nil {
+		return results, err
+	}
+	if len(resp.GetResults()) != len(pending) {
+		return results, fmt.Errorf("currency service converted %d of %d amounts", len(resp.GetResults()), len(pending))
+	}
+	for j, i := range pending {
+		results[i] = resp.GetResults()[j]
+	}
+	return results, nil
+}
+
+// convertCurrencyEach converts the pending amounts one Convert call at a time,
+// a few at once, and returns the first error.
+func (fe *frontendServer) convertCurrencyEach(ctx context.Context, amounts []*pb.Money, pending []int, results []*pb.Money, currency string) error {
+	var g errgroup.Group
+	g.SetLimit(currencyConversionConcurrency)
+	for _, i := range pending {
+		g.Go(func() error {
+			converted, err := fe.convertCurrency(ctx, amounts[i], currency)
+			if err != nil {
+				return err
+			}
+			results[i] = converted
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// localizePrices converts product prices for display. If currencyFallbackUSD
+// is set, prices that could not be converted are shown in USD rather than
+// failing the page.
+func (fe *frontendServer) localizePrices(ctx context.Context, log logrus.FieldLogger, prices []*pb.Money, currency string) ([]*pb.Money, error) {
+	converted, err := fe.convertCurrencyBatch(ctx, prices, currency)
+	if err == nil || !fe.currencyFallbackUSD {
+		return converted, err
+	}
+	log.WithField("error", err).Warn("currency conversion failed, showing USD prices")
+	for i, m := range converted {
+		if m == nil {
+			converted[i] = prices[i]
+		}
+	}
+	return converted, nil
 }
 
 // addDatabaseHeader adds metadata to request database access
@@ -108,40 +232,133 @@ func (fe *frontendServer) addDatabaseHeader(ctx context.Context) context.Context
 func (fe *frontendServer) searchProducts(ctx context.Context, query string) ([]*pb.Product, error) {
 	// Search: Use database for consistency with cart/product details
 	ctx = fe.addDatabaseHeader(ctx)
+	ctx, cancel := context.WithTimeout(ctx, catalogTimeout)
+	defer cancel()
 	resp, err := pb.NewProductCatalogServiceClient(fe.productCatalogSvcConn).
 		SearchProducts(ctx, &pb.SearchProductsRequest{Query: query})
 	if err != nil {
 		return nil, err
 	}
+	rewriteProductPictures(resp.GetResults()...)
 	return resp.GetResults(), nil
 }
 
-func (fe *frontendServer) getRecommendations(ctx context.Context, userID string, productIDs []string) ([]*pb.Product, error) {
+func (fe *frontendServer) placeOrder(ctx context.Context, userID, currency string, payload validator.PlaceOrderPayload) (*pb.PlaceOrderResponse, error) {
+	resp, err := pb.NewCheckoutServiceClient(fe.checkoutSvcConn).
+		PlaceOrder(ctx, &pb.PlaceOrderRequest{
+			Email: payload.Email,
+			CreditCard: &pb.CreditCardInfo{
+				CreditCardNumber:          payload.CcNumber,
+				CreditCardExpirationMonth: int32(payload.CcMonth),
+				CreditCardExpirationYear:  int32(payload.CcYear),
+				CreditCardCvv:             int32(payload.CcCVV)},
+			UserId:       userID,
+			UserCurrency: currency,
+			Address: &pb.Address{
+				StreetAddress: payload.StreetAddress,
+				City:          payload.City,
+				State:         payload.State,
+				ZipCode:       int32(payload.ZipCode),
+				Country:       payload.Country},
+		})
+	if err != nil {
+		return nil, err
+	}
+	fe.orders.record(userID, payload.Email, resp.GetOrder())
+	return resp, nil
+}
+
+// pageRecommendations returns the recommendations shown on a page about
+// productIDs to a shopper with cart, leaving out those products and the
+// ones already in the cart.
+func (fe *frontendServer) pageRecommendations(ctx context.Context, userID string, productIDs []string, cart []*pb.CartItem, limit int) ([]*pb.Product, error) {
+	exclude := append(append([]string(nil), productIDs...), cartIDs(cart)...)
+	return fe.getRecommendations(ctx, userID, productIDs, limit, exclude...)
+}
+
+// getRecommendations returns up to limit recommended products, in the order
+// the recommendation service ranked them, skipping the excluded ids. A limit
+// of zero or less uses the configured maximum.
+func (fe *frontendServer) getRecommendations(ctx context.Context, userID string, productIDs []string, limit int, exclude ...string) ([]*pb.Product, error) {
+	// The session's recent views and adds are context too; userID is the
+	// browser session ID.
+	productIDs = appendUnique(append([]string(nil), productIDs...), fe.personalizationContext(userID)...)
+	// Large carts would otherwise send every product as context; the page's
+	// own products come first, so they are the ones kept.
+	if max := fe.recommendationContextLimit(); len(productIDs) > max {
+		productIDs = productIDs[:max]
+	}
 	resp, err := pb.NewRecommendationServiceClient(fe.recommendationSvcConn).ListRecommendations(ctx,
 		&pb.ListRecommendationsRequest{UserId: userID, ProductIds: productIDs})
 	if err != nil {
 		return nil, err
 	}
-	out := make([]*pb.Product, len(resp.GetProductIds()))
-	for i, v := range resp.GetProductIds() {
+	if limit <= 0 {
+		limit = fe.recommendationLimit()
+	}
+	var ids []string
+	for _, id := range resp.GetProductIds() {
+		if !slices.Contains(exclude, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	out := make([]*pb.Product, len(ids))
+	for i, v := range ids {
 		p, err := fe.getProduct(ctx, v)
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to get recommended product info (#%s)", v)
 		}
 		out[i] = p
 	}
-	if len(out) > 4 {
-		out = out[:4] // take only first four to fit the UI
-	}
 	return out, err
 }
 
+// recommendationLimit is the number of recommendations shown by default.
+func (fe *frontendServer) recommendationLimit() int {
+	if fe.maxRecommendations > 0 {
+		return fe.maxRecommendations
+	}
+	return defaultMaxRecommendations
+}
+
+// recommendationContextLimit is the most product ids sent to the
+// recommendation service as context.
+func (fe *frontendServer) recommendationContextLimit() int {
+	if fe.maxRecommendationContext > 0 {
+		return fe.maxRecommendationContext
+	}
+	return defaultMaxRecommendationContext
+}
+
+// adContextKeys normalizes categories into ad context keys: trimmed,
+// lower-cased like the ad service's keywords, without blanks or duplicates,
+// and at most maxAdContextKeys of them, keeping the first ones.
+func (fe *frontendServer) adContextKeys(categories []string) []string {
+	max := fe.maxAdContextKeys
+	if max <= 0 {
+		max = defaultMaxAdContextKeys
+	}
+	var keys []string
+	for _, c := range categories {
+		if len(keys) == max {
+			break
+		}
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" && !slices.Contains(keys, c) {
+			keys = append(keys, c)
+		}
+	}
+	return keys
+}
+
 func (fe *frontendServer) getAd(ctx context.Context, ctxKeys []string) ([]*pb.Ad, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
 	defer cancel()
 
 	resp, err := pb.NewAdServiceClient(fe.adSvcConn).GetAds(ctx, &pb.AdRequest{
-		ContextKeys: ctxKeys,
+		ContextKeys: fe.adContextKeys(ctxKeys),
 	})
 	return resp.GetAds(), errors.Wrap(err, "failed to get ads")
 }
@@ -0,0 +1,191 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+// batchCurrencyService supports ConvertBatch on top of fakeCurrencyService
+// and counts the calls of each kind.
+type batchCurrencyService struct {
+	fakeCurrencyService
+	batchCalls, convertCalls atomic.Int32
+	fail                     bool
+}
+
+func (f *batchCurrencyService) Convert(ctx context.Context, req *pb.CurrencyConversionRequest) (*pb.Money, error) {
+	f.convertCalls.Add(1)
+	return f.fakeCurrencyService.Convert(ctx, req)
+}
+
+func (f *batchCurrencyService) ConvertBatch(ctx context.Context, req *pb.CurrencyConversionBatchRequest) (*pb.CurrencyConversionBatchResponse, error) {
+	f.batchCalls.Add(1)
+	if f.fail {
+		return nil, status.Error(codes.Unavailable, "currency service down")
+	}
+	resp := &pb.CurrencyConversionBatchResponse{}
+	for _, m := range req.GetFrom() {
+		converted, err := f.fakeCurrencyService.Convert(ctx, &pb.CurrencyConversionRequest{From: m, ToCode: req.GetToCode()})
+		if err != nil {
+			return nil, err
+		}
+		resp.Results = append(resp.Results, converted)
+	}
+	return resp, nil
+}
+
+// searchableCatalog matches products by name.
+type searchableCatalog struct {
+	*fakeProductCatalog
+}
+
+func (c searchableCatalog) SearchProducts(ctx context.Context, req *pb.SearchProductsRequest) (*pb.SearchProductsResponse, error) {
+	resp := &pb.SearchProductsResponse{}
+	for _, p := range c.products {
+		if strings.Contains(strings.ToLower(p.GetName()), strings.ToLower(req.GetQuery())) {
+			resp.Results = append(resp.Results, p)
+		}
+	}
+	return resp, nil
+}
+
+var batchTestPrices = []*pb.Money{
+	{CurrencyCode: "USD", Units: 10},
+	{CurrencyCode: "USD", Units: 3},
+	{CurrencyCode: "USD", Units: 8},
+}
+
+func TestSearchPageConvertsPricesInOneCall(t *testing.T) {
+	currency := &batchCurrencyService{}
+	catalog := &fakeProductCatalog{}
+	for i, price := range batchTestPrices {
+		catalog.products = append(catalog.products, &pb.Product{Id: string(rune('A' + i)), Name: "Mug", PriceUsd: price})
+	}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCurrencyServiceServer(s, currency)
+		pb.RegisterProductCatalogServiceServer(s, searchableCatalog{catalog})
+		pb.RegisterCartServiceServer(s, &fakeCartService{})
+	})
+	fe := &frontendServer{currencySvcConn: conn, productCatalogSvcConn: conn, cartSvcConn: conn}
+
+	rec := httptest.NewRecorder()
+	fe.searchHandler(rec, newTestRequest(http.MethodGet, "/search?q=mug", ""))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := currency.batchCalls.Load(); got != 1 {
+		t.Errorf("ConvertBatch called %d times, want 1", got)
+	}
+	if got := currency.convertCalls.Load(); got != 0 {
+		t.Errorf("Convert called %d times, want 0", got)
+	}
+}
+
+func TestConvertCurrencyBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		service pb.CurrencyServiceServer
+	}{
+		{"batch", &batchCurrencyService{}},
+		{"without ConvertBatch", fakeCurrencyService{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := serveGRPC(t, func(s *grpc.Server) {
+				pb.RegisterCurrencyServiceServer(s, tt.service)
+			})
+			fe := &frontendServer{currencySvcConn: conn}
+
+			got, err := fe.convertCurrencyBatch(context.Background(), batchTestPrices, "EUR")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"€5.00", "€1.50", "€4.00"}
+			if len(got) != len(want) {
+				t.Fatalf("got %d results, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if s := renderMoney(*got[i]); s != want[i] {
+					t.Errorf("result %d = %s, want %s", i, s, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLocalizePricesFallbackUSD(t *testing.T) {
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterCurrencyServiceServer(s, &batchCurrencyService{fail: true})
+	})
+	logger := logrus.New()
+	logger.Out = io.Discard
+
+	fe := &frontendServer{currencySvcConn: conn}
+	if _, err := fe.localizePrices(context.Background(), logger, batchTestPrices, "EUR"); err == nil {
+		t.Error("expected an error without the USD fallback")
+	}
+
+	fe.currencyFallbackUSD = true
+	got, err := fe.localizePrices(context.Background(), logger, batchTestPrices, "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range got {
+		if m != batchTestPrices[i] {
+			t.Errorf("price %d = %v, want the USD price %v", i, m, batchTestPrices[i])
+		}
+	}
+}
+
+func TestProductPageCatalogTimeout(t *testing.T) {
+	defer func(d time.Duration) { catalogTimeout = d }(catalogTimeout)
+	catalogTimeout = 50 * time.Millisecond
+
+	catalog := &fakeProductCatalog{products: []*pb.Product{{Id: "A", Name: "Mug"}}}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(s, slowProductCatalog{catalog, 5 * time.Second})
+	})
+	fe := &frontendServer{productCatalogSvcConn: conn}
+
+	r := mux.SetURLVars(newTestRequest(http.MethodGet, "/product/A", ""), map[string]string{"id": "A"})
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	fe.productHandler(rec, r)
+	elapsed := time.Since(start)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if elapsed > time.Second {
+		t.Errorf("product page took %s with a stalled catalog, want about %s", elapsed, catalogTimeout)
+	}
+}
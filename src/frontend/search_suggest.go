@@ -0,0 +1,178 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+const (
+	// maxSearchSuggestions is the number of typeahead suggestions returned.
+	maxSearchSuggestions = 8
+	// suggestIndexTTL is how long the suggestion index is used before it is
+	// rebuilt from the catalog.
+	suggestIndexTTL = 5 * time.Minute
+)
+
+type searchSuggestion struct {
+	Text string `json:"text"`
+	Type string `json:"type"` // "product" or "category"
+	ID   string `json:"id,omitempty"`
+}
+
+type suggestEntry struct {
+	searchSuggestion
+	lower string
+}
+
+// suggestIndex holds the lowercased product names and categories of the
+// catalog, so suggestions are answered without touching the catalog service.
+type suggestIndex struct {
+	entries []suggestEntry
+	builtAt time.Time
+}
+
+func newSuggestIndex(products []*pb.Product) *suggestIndex {
+	idx := &suggestIndex{builtAt: time.Now()}
+	categories := make(map[string]bool)
+	for _, p := range products {
+		if p.GetName() != "" {
+			idx.entries = append(idx.entries, suggestEntry{
+				searchSuggestion: searchSuggestion{Text: p.GetName(), Type: "product", ID: p.GetId()},
+				lower:            strings.ToLower(p.GetName()),
+			})
+		}
+		for _, c := range p.GetCategories() {
+			lower := strings.ToLower(strings.TrimSpace(c))
+			if lower == "" || categories[lower] {
+				continue
+			}
+			categories[lower] = true
+			idx.entries = append(idx.entries, suggestEntry{
+				searchSuggestion: searchSuggestion{Text: lower, Type: "category"},
+				lower:            lower,
+			})
+		}
+	}
+	return idx
+}
+
+// matchRank ranks how text matches query: 0 for a prefix of the whole text,
+// 1 for a prefix of a later word, 2 for any other substring and -1 for no
+// match.
+func matchRank(text, query string) int {
+	i := strings.Index(text, query)
+	switch {
+	case i < 0:
+		return -1
+	case i == 0:
+		return 0
+	}
+	for _, word := range strings.Fields(text)[1:] {
+		if strings.HasPrefix(word, query) {
+			return 1
+		}
+	}
+	return 2
+}
+
+// suggest returns up to limit entries matching query, prefix matches first.
+func (idx *suggestIndex) suggest(query string, limit int) []searchSuggestion {
+	query = strings.ToLower(strings.TrimSpace(query))
+	type match struct {
+		entry *suggestEntry
+		rank  int
+	}
+	var matches []match
+	for i := range idx.entries {
+		if rank := matchRank(idx.entries[i].lower, query); rank >= 0 {
+			matches = append(matches, match{&idx.entries[i], rank})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		return len(matches[i].entry.lower) < len(matches[j].entry.lower)
+	})
+
+	out := make([]searchSuggestion, 0, limit)
+	for _, m := range matches {
+		if len(out) == limit {
+			break
+		}
+		out = append(out, m.entry.searchSuggestion)
+	}
+	return out
+}
+
+// suggestions is the cached suggestion index of a frontendServer.
+type suggestions struct {
+	mu  sync.Mutex
+	idx *suggestIndex
+}
+
+// suggestionIndex returns the suggestion index, building it from the catalog
+// when it is missing or older than suggestIndexTTL.
+func (fe *frontendServer) suggestionIndex(ctx context.Context) (*suggestIndex, error) {
+	fe.suggest.mu.Lock()
+	defer fe.suggest.mu.Unlock()
+	if idx := fe.suggest.idx; idx != nil && time.Since(idx.builtAt) < suggestIndexTTL {
+		return idx, nil
+	}
+	products, err := fe.getProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fe.suggest.idx = newSuggestIndex(products)
+	return fe.suggest.idx, nil
+}
+
+// GET /api/search/suggest?q=
+// Returns typeahead suggestions of product names and categories for q.
+func (fe *frontendServer) searchSuggestHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	w.Header().Set("Content-Type", "application/json")
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if !fe.flags.SearchSuggestions || query == "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"query":       query,
+			"suggestions": []searchSuggestion{},
+		})
+		return
+	}
+
+	idx, err := fe.suggestionIndex(r.Context())
+	if err != nil {
+		log.WithField("error", err).Error("failed to build the search suggestion index")
+		writeAPIError(w, r, http.StatusInternalServerError, errCodeSearchUnavailable, "search suggestions temporarily unavailable")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":       query,
+		"suggestions": idx.suggest(query, maxSearchSuggestions),
+	})
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestSearchSuggestHandler(t *testing.T) {
+	catalog := &fakeProductCatalog{products: []*pb.Product{
+		{Id: "SUN", Name: "Sunglasses", Categories: []string{"accessories"}},
+		{Id: "TAN", Name: "Tank Top", Categories: []string{"clothing", "tops"}},
+		{Id: "MUG", Name: "Sunny Mug", Categories: []string{"kitchen"}},
+		{Id: "JAR", Name: "Mason Jar", Categories: []string{"kitchen"}},
+		{Id: "HAT", Name: "Big Sun Hat", Categories: []string{"accessories"}},
+	}}
+	fe := &frontendServer{
+		flags: featureFlags{SearchSuggestions: true},
+		productCatalogSvcConn: serveGRPC(t, func(s *grpc.Server) {
+			pb.RegisterProductCatalogServiceServer(s, catalog)
+		}),
+	}
+
+	tests := []struct {
+		name string
+		q    string
+		want []searchSuggestion
+	}{
+		{"product name prefix", "Sun", []searchSuggestion{
+			{Text: "Sunny Mug", Type: "product", ID: "MUG"},
+			{Text: "Sunglasses", Type: "product", ID: "SUN"},
+			{Text: "Big Sun Hat", Type: "product", ID: "HAT"},
+		}},
+		{"category only", "kitch", []searchSuggestion{
+			{Text: "kitchen", Type: "category"},
+		}},
+		{"no match", "zzz", []searchSuggestion{}},
+		{"empty query", "", []searchSuggestion{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			fe.searchSuggestHandler(rec, newTestRequest(http.MethodGet, "/api/search/suggest?q="+tt.q, ""))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+			}
+			var resp struct {
+				Suggestions []searchSuggestion `json:"suggestions"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp.Suggestions, tt.want) {
+				t.Errorf("suggestions = %+v, want %+v", resp.Suggestions, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggestIndexLimitAndSubstring(t *testing.T) {
+	idx := newSuggestIndex([]*pb.Product{
+		{Id: "A", Name: "Vintage Camera"},
+		{Id: "B", Name: "Camera Lens"},
+		{Id: "C", Name: "Minicamera"},
+	})
+	got := idx.suggest("camera", 2)
+	want := []searchSuggestion{
+		{Text: "Camera Lens", Type: "product", ID: "B"},
+		{Text: "Vintage Camera", Type: "product", ID: "A"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("suggest = %+v, want %+v", got, want)
+	}
+	if got := idx.suggest("camera", 10); len(got) != 3 || got[2].ID != "C" {
+		t.Errorf("substring match not ranked last: %+v", got)
+	}
+}
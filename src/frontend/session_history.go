@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxSessionHistory bounds the product IDs remembered per session and
+	// kind of interaction.
+	maxSessionHistory = 10
+	// sessionHistoryTTL is how long a session's history is kept after its
+	// last interaction.
+	sessionHistoryTTL = 30 * time.Minute
+)
+
+// sessionHistory remembers the products each browser session recently viewed
+// and added to its cart, to personalize recommendations. Entries expire
+// sessionHistoryTTL after their last update. A nil *sessionHistory records
+// nothing.
+type sessionHistory struct {
+	mu        sync.Mutex
+	sessions  map[string]*sessionInteractions
+	lastSweep time.Time
+	now       func() time.Time // for tests; time.Now if nil
+}
+
+type sessionInteractions struct {
+	viewed, added []string // most recent first
+	updated       time.Time
+}
+
+func (h *sessionHistory) recordView(sessionID, productID string) {
+	h.record(sessionID, productID, func(s *sessionInteractions) *[]string { return &s.viewed })
+}
+
+func (h *sessionHistory) recordAdd(sessionID, productID string) {
+	h.record(sessionID, productID, func(s *sessionInteractions) *[]string { return &s.added })
+}
+
+func (h *sessionHistory) record(sessionID, productID string, list func(*sessionInteractions) *[]string) {
+	if h == nil || sessionID == "" || productID == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.clock()
+	h.sweep(now)
+	if h.sessions == nil {
+		h.sessions = make(map[string]*sessionInteractions)
+	}
+	s, ok := h.sessions[sessionID]
+	if !ok {
+		s = &sessionInteractions{}
+		h.sessions[sessionID] = s
+	}
+	s.updated = now
+	*list(s) = pushRecent(*list(s), productID)
+}
+
+// recent returns the products the session recently viewed and added to its
+// cart, most recent first.
+func (h *sessionHistory) recent(sessionID string) (viewed, added []string) {
+	if h == nil {
+		return nil, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[sessionID]
+	if !ok || h.clock().Sub(s.updated) > sessionHistoryTTL {
+		return nil, nil
+	}
+	return append([]string(nil), s.viewed...), append([]string(nil), s.added...)
+}
+
+// sweep drops expired sessions, at most once per TTL.
+func (h *sessionHistory) sweep(now time.Time) {
+	if now.Sub(h.lastSweep) < sessionHistoryTTL {
+		return
+	}
+	h.lastSweep = now
+	for id, s := range h.sessions {
+		if now.Sub(s.updated) > sessionHistoryTTL {
+			delete(h.sessions, id)
+		}
+	}
+}
+
+func (h *sessionHistory) clock() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+// pushRecent moves id to the front of ids, keeping at most maxSessionHistory.
+func pushRecent(ids []string, id string) []string {
+	out := make([]string, 0, len(ids)+1)
+	out = append(out, id)
+	for _, v := range ids {
+		if v != id && len(out) < maxSessionHistory {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// personalizationContext returns the products the session recently added or
+// viewed, for recommendations to take into account, or nothing if
+// personalization is disabled.
+func (fe *frontendServer) personalizationContext(sessionID string) []string {
+	if !fe.flags.AssistantPersonalize {
+		return nil
+	}
+	viewed, added := fe.history.recent(sessionID)
+	return appendUnique(added, viewed...)
+}
+
+// appendUnique appends the ids not already in dst.
+func appendUnique(dst []string, ids ...string) []string {
+	seen := make(map[string]bool, len(dst)+len(ids))
+	for _, id := range dst {
+		seen[id] = true
+	}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			dst = append(dst, id)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+func TestSessionHistory(t *testing.T) {
+	now := time.Now()
+	h := &sessionHistory{now: func() time.Time { return now }}
+
+	for _, id := range []string{"A", "B", "A", "C"} {
+		h.recordView("s1", id)
+	}
+	h.recordAdd("s1", "B")
+	viewed, added := h.recent("s1")
+	if want := []string{"C", "A", "B"}; !reflect.DeepEqual(viewed, want) {
+		t.Errorf("viewed = %v, want %v", viewed, want)
+	}
+	if want := []string{"B"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %v, want %v", added, want)
+	}
+	if viewed, added := h.recent("s2"); viewed != nil || added != nil {
+		t.Errorf("other session sees %v, %v", viewed, added)
+	}
+
+	for i := 0; i < 2*maxSessionHistory; i++ {
+		h.recordView("s1", fmt.Sprintf("P%d", i))
+	}
+	if viewed, _ := h.recent("s1"); len(viewed) != maxSessionHistory || viewed[0] != fmt.Sprintf("P%d", 2*maxSessionHistory-1) {
+		t.Errorf("viewed = %v, want the %d most recent", viewed, maxSessionHistory)
+	}
+
+	now = now.Add(sessionHistoryTTL + time.Second)
+	if viewed, added := h.recent("s1"); viewed != nil || added != nil {
+		t.Errorf("expired history returned %v, %v", viewed, added)
+	}
+	h.recordView("s2", "A")
+	if _, ok := h.sessions["s1"]; ok {
+		t.Error("expired session was not swept")
+	}
+}
+
+type capturingRecommendationService struct {
+	pb.UnimplementedRecommendationServiceServer
+	mu  sync.Mutex
+	got []string
+}
+
+func (f *capturingRecommendationService) ListRecommendations(ctx context.Context, req *pb.ListRecommendationsRequest) (*pb.ListRecommendationsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.got = req.GetProductIds()
+	return &pb.ListRecommendationsResponse{}, nil
+}
+
+func TestRecommendationsUseSessionHistory(t *testing.T) {
+	recs := &capturingRecommendationService{}
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterRecommendationServiceServer(s, recs)
+	})
+
+	tests := []struct {
+		name        string
+		personalize bool
+		want        []string
+	}{
+		{"personalized", true, []string{"D", "C", "B", "A"}},
+		{"personalization disabled", false, []string{"D"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fe := &frontendServer{
+				recommendationSvcConn: conn,
+				history:               &sessionHistory{},
+				flags:                 featureFlags{AssistantPersonalize: tt.personalize},
+			}
+			fe.history.recordView("s1", "A")
+			fe.history.recordView("s1", "B")
+			fe.history.recordAdd("s1", "C")
+			fe.history.recordView("s1", "D")
+
+			if _, err := fe.getRecommendations(context.Background(), "s1", []string{"D"}, 0); err != nil {
+				t.Fatal(err)
+			}
+			recs.mu.Lock()
+			defer recs.mu.Unlock()
+			if !reflect.DeepEqual(recs.got, tt.want) {
+				t.Errorf("recommendation context = %v, want %v", recs.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecommendationContextCapped(t *testing.T) {
+	recs := &capturingRecommendationService{}
+	fe := &frontendServer{
+		recommendationSvcConn: serveGRPC(t, func(s *grpc.Server) {
+			pb.RegisterRecommendationServiceServer(s, recs)
+		}),
+		history:                  &sessionHistory{},
+		flags:                    featureFlags{AssistantPersonalize: true},
+		maxRecommendationContext: 5,
+	}
+	fe.history.recordView("s1", "viewed")
+
+	cart := make([]*pb.CartItem, 100)
+	for i := range cart {
+		cart[i] = &pb.CartItem{ProductId: fmt.Sprintf("P%03d", i), Quantity: 1}
+	}
+	if _, err := fe.getRecommendations(context.Background(), "s1", cartIDs(cart), 0); err != nil {
+		t.Fatal(err)
+	}
+	recs.mu.Lock()
+	defer recs.mu.Unlock()
+	if want := []string{"P000", "P001", "P002", "P003", "P004"}; !reflect.DeepEqual(recs.got, want) {
+		t.Errorf("recommendation context = %v, want %v", recs.got, want)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sessionTokenTTL is how long a signed session id stays valid: as long as
+// the session cookie it is issued in.
+const sessionTokenTTL = cookieMaxAge * time.Second
+
+// loadSessionKey returns the HMAC key signing session ids, from
+// SESSION_SECRET. Without it a random key is used, so signed ids, session
+// cookies included, only verify on the replica that issued them, until it
+// restarts.
+func loadSessionKey(log logrus.FieldLogger) []byte {
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	log.Warn("SESSION_SECRET not set, signing session ids with a random key")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("could not generate a session key: %v", err)
+	}
+	return key
+}
+
+// signSessionID returns id with its expiry and signature appended, as
+// "<id>.<unix expiry>.<mac>", valid for sessionTokenTTL. This is the form in
+// which a session id is stored in the session cookie and handed to other
+// callers, such as agents, that act on the session through the /api
+// endpoints.
+func (fe *frontendServer) signSessionID(id string) string {
+	return fe.signSessionIDUntil(id, time.Now().Add(sessionTokenTTL))
+}
+
+func (fe *frontendServer) signSessionIDUntil(id string, expires time.Time) string {
+	payload := id + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + fe.sessionMAC(payload)
+}
+
+// verifySessionID returns the session id a signed token stands for, and
+// false if the token is unsigned, its signature does not match or it has
+// expired.
+func (fe *frontendServer) verifySessionID(token string) (string, bool) {
+	i := strings.LastIndex(token, ".")
+	if i <= 0 || len(fe.sessionKey) == 0 {
+		return "", false
+	}
+	payload, mac := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(fe.sessionMAC(payload))) {
+		return "", false
+	}
+	j := strings.LastIndex(payload, ".")
+	if j <= 0 {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(payload[j+1:], 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return "", false
+	}
+	return payload[:j], true
+}
+
+func (fe *frontendServer) sessionMAC(payload string) string {
+	h := hmac.New(sha256.New, fe.sessionKey)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// apiUserID returns the session an /api request acts on. A caller-supplied
+// userId is honored only if it is signed, or if it is the request's own
+// session id; anything else is ignored in favor of the request's session, so
+// one session cannot read or change another's cart.
+func (fe *frontendServer) apiUserID(r *http.Request, supplied string) string {
+	own := sessionID(r)
+	if supplied == "" || supplied == own {
+		return own
+	}
+	if id, ok := fe.verifySessionID(supplied); ok {
+		return id
+	}
+	if log, ok := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger); ok {
+		log.Warn("ignoring unsigned or forged userId")
+	}
+	return own
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSessionKey = []byte("test-session-key")
+
+func TestAPIUserID(t *testing.T) {
+	fe := &frontendServer{sessionKey: testSessionKey}
+	other := &frontendServer{sessionKey: []byte("another-key")}
+
+	tests := []struct {
+		name     string
+		supplied string
+		want     string
+	}{
+		{"signed id", fe.signSessionID("victim"), "victim"},
+		{"unsigned id", "victim", "test-session"},
+		{"forged signature", "victim.c2lnbmF0dXJl", "test-session"},
+		{"signed with another key", other.signSessionID("victim"), "test-session"},
+		{"signature of another id", "victim.9999999999." + fe.sessionMAC("attacker.9999999999"), "test-session"},
+		{"expired", fe.signSessionIDUntil("victim", time.Now().Add(-time.Minute)), "test-session"},
+		{"expiry changed", strings.Replace(fe.signSessionIDUntil("victim", time.Now().Add(-time.Minute)), ".", ".9", 1), "test-session"},
+		{"own session", "test-session", "test-session"},
+		{"not supplied", "", "test-session"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(http.MethodGet, "/api/cart", "")
+			if got := fe.apiUserID(r, tt.supplied); got != tt.want {
+				t.Errorf("apiUserID(%q) = %q, want %q", tt.supplied, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySessionIDWithoutKey(t *testing.T) {
+	signed := (&frontendServer{sessionKey: testSessionKey}).signSessionID("victim")
+	if id, ok := (&frontendServer{}).verifySessionID(signed); ok {
+		t.Errorf("verified %q as %q without a session key", signed, id)
+	}
+}
+
+func TestEnsureSessionIDVerifiesCookie(t *testing.T) {
+	fe := &frontendServer{sessionKey: testSessionKey}
+	var got string
+	h := fe.ensureSessionID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = sessionID(r)
+	}))
+	serve := func(cookie string) (*httptest.ResponseRecorder, string) {
+		got = ""
+		r := httptest.NewRequest(http.MethodGet, "/cart", nil)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: cookieSessionID, Value: cookie})
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec, got
+	}
+
+	// A new session is issued as a signed cookie ...
+	rec, issued := serve("")
+	cookies := rec.Result().Cookies()
+	if issued == "" || len(cookies) != 1 {
+		t.Fatalf("session %q, cookies %v; want a new session cookie", issued, cookies)
+	}
+	if id, ok := fe.verifySessionID(cookies[0].Value); !ok || id != issued {
+		t.Fatalf("cookie %q does not verify as session %q", cookies[0].Value, issued)
+	}
+	// ... which later requests stay in.
+	if rec, s := serve(cookies[0].Value); s != issued || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("signed cookie: session %q, want %q without a new cookie", s, issued)
+	}
+
+	for name, cookie := range map[string]string{
+		"unsigned":            issued,
+		"forged":              issued + ".9999999999.c2lnbmF0dXJl",
+		"expired":             fe.signSessionIDUntil(issued, time.Now().Add(-time.Minute)),
+		"signed with another": (&frontendServer{sessionKey: []byte("another-key")}).signSessionID(issued),
+		"authenticated user":  fe.signSessionID(authUserID("alice")),
+	} {
+		rec, s := serve(cookie)
+		if s == "" || s == issued || s == authUserID("alice") {
+			t.Errorf("%s cookie: session %q, want a new one", name, s)
+		}
+		if len(rec.Result().Cookies()) != 1 {
+			t.Errorf("%s cookie: no new session cookie set", name)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/money"
+)
+
+// Weight-based shipping. Packaging weights are in kilograms and dimensions in
+// centimeters; a parcel is billed by the larger of its actual and its
+// dimensional weight (volume / dimensionalWeightDivisor).
+const (
+	// includedShippingWeightKg is covered by the flat shipping quote.
+	includedShippingWeightKg = 1.0
+	// shippingCostPerKgUSD is charged for every kilogram above that.
+	shippingCostPerKgUSD = 1.50
+	// dimensionalWeightDivisor converts cubic centimeters to kilograms.
+	dimensionalWeightDivisor = 5000.0
+)
+
+// billableWeightKg is the weight a package is charged by.
+func billableWeightKg(info *PackagingInfo) float64 {
+	volumetric := float64(info.Width) * float64(info.Height) * float64(info.Depth) / dimensionalWeightDivisor
+	return math.Max(float64(info.Weight), volumetric)
+}
+
+// weightSurchargeUSD is the shipping surcharge for items given the packaging
+// info of their products. Items without packaging info add nothing, so they
+// ship at the flat rate.
+func weightSurchargeUSD(items []*pb.CartItem, packaging map[string]*PackagingInfo) *pb.Money {
+	var weight float64
+	for _, item := range items {
+		if info := packaging[item.GetProductId()]; info != nil {
+			weight += billableWeightKg(info) * float64(item.GetQuantity())
+		}
+	}
+	extra := math.Max(0, weight-includedShippingWeightKg)
+	// Round to cents so the surcharge renders like any other price.
+	return moneyFromFloat("USD", math.Round(extra*shippingCostPerKgUSD*100)/100)
+}
+
+// shippingEstimate is the shipping shown on the cart page.
+type shippingEstimate struct {
+	// Quote is the flat quote of the shipping service, which checkout
+	// charges and the cart total includes.
+	Quote *pb.Money
+	// WeightSurcharge is the surcharge the weight of the items would add,
+	// shown as an estimate next to the quote but neither charged nor part
+	// of the total. Nil without the packaging service or when the items
+	// are light enough.
+	WeightSurcharge *pb.Money
+}
+
+// estimateShippingCost returns the shipping quote for items in currency and,
+// when the packaging service is configured, an estimate of the weight
+// surcharge.
+func (fe *frontendServer) estimateShippingCost(ctx context.Context, log logrus.FieldLogger, items []*pb.CartItem, currency string) (shippingEstimate, error) {
+	quote, err := pb.NewShippingServiceClient(fe.shippingSvcConn).GetQuote(ctx,
+		&pb.GetQuoteRequest{Items: items})
+	if err != nil {
+		return shippingEstimate{}, err
+	}
+	var est shippingEstimate
+	if est.Quote, err = fe.convertCurrency(ctx, quote.GetCostUsd(), currency); err != nil {
+		return shippingEstimate{}, errors.Wrap(err, "failed to convert currency for shipping cost")
+	}
+
+	if isPackagingServiceConfigured() {
+		surcharge := weightSurchargeUSD(items, fe.getPackagingInfos(ctx, log, items))
+		if !money.IsZero(*surcharge) {
+			if est.WeightSurcharge, err = fe.convertCurrency(ctx, surcharge, currency); err != nil {
+				return shippingEstimate{}, errors.Wrap(err, "failed to convert currency for weight surcharge")
+			}
+		}
+	}
+	return est, nil
+}
+
+// getPackagingInfos fetches the packaging info of the items' products, a few
+// at once, keyed by product id. Products without packaging info are left
+// out, so they ship at the flat rate.
+func (fe *frontendServer) getPackagingInfos(ctx context.Context, log logrus.FieldLogger, items []*pb.CartItem) map[string]*PackagingInfo {
+	infos := make([]*PackagingInfo, len(items))
+	var g errgroup.Group
+	g.SetLimit(productFetchConcurrency)
+	for i, item := range items {
+		g.Go(func() error {
+			info, err := httpGetPackagingInfo(ctx, log, item.GetProductId())
+			if err != nil {
+				log.WithField("error", err).Debugf("no packaging info for %s, shipping it at the flat rate", item.GetProductId())
+				return nil
+			}
+			infos[i] = info
+			return nil
+		})
+	}
+	g.Wait()
+
+	packaging := make(map[string]*PackagingInfo, len(items))
+	for i, info := range infos {
+		if info != nil {
+			packaging[items[i].GetProductId()] = info
+		}
+	}
+	return packaging
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+)
+
+type flatShippingService struct {
+	pb.UnimplementedShippingServiceServer
+}
+
+func (flatShippingService) GetQuote(context.Context, *pb.GetQuoteRequest) (*pb.GetQuoteResponse, error) {
+	return &pb.GetQuoteResponse{CostUsd: &pb.Money{CurrencyCode: "USD", Units: 8, Nanos: 990000000}}, nil
+}
+
+// identityCurrencyService converts by relabeling, keeping amounts comparable.
+type identityCurrencyService struct {
+	pb.UnimplementedCurrencyServiceServer
+}
+
+func (identityCurrencyService) Convert(ctx context.Context, req *pb.CurrencyConversionRequest) (*pb.Money, error) {
+	return &pb.Money{CurrencyCode: req.GetToCode(), Units: req.GetFrom().GetUnits(), Nanos: req.GetFrom().GetNanos()}, nil
+}
+
+func TestEstimateShippingCost(t *testing.T) {
+	packaging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/HEAVY":
+			io.WriteString(w, `{"weight": 2.0, "width": 10, "height": 10, "depth": 10}`)
+		case "/BULKY":
+			// 50x40x30 cm is 12 kg of dimensional weight.
+			io.WriteString(w, `{"weight": 0.5, "width": 50, "height": 40, "depth": 30}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer packaging.Close()
+	defer func(url string) { packagingServiceUrl = url }(packagingServiceUrl)
+
+	conn := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterShippingServiceServer(s, flatShippingService{})
+		pb.RegisterCurrencyServiceServer(s, identityCurrencyService{})
+	})
+	fe := &frontendServer{shippingSvcConn: conn, currencySvcConn: conn}
+	logger := logrus.New()
+	logger.Out = io.Discard
+
+	tests := []struct {
+		name          string
+		packagingURL  string
+		items         []*pb.CartItem
+		wantSurcharge string
+	}{
+		{"no packaging service", "", []*pb.CartItem{{ProductId: "HEAVY", Quantity: 2}}, ""},
+		// 2 x 2 kg, 1 kg included: 3 kg x $1.50.
+		{"weight surcharge", packaging.URL, []*pb.CartItem{{ProductId: "HEAVY", Quantity: 2}}, "$4.50"},
+		// 12 kg dimensional weight, 1 kg included: 11 kg x $1.50.
+		{"dimensional weight", packaging.URL, []*pb.CartItem{{ProductId: "BULKY", Quantity: 1}}, "$16.50"},
+		{"several products", packaging.URL, []*pb.CartItem{{ProductId: "HEAVY", Quantity: 1}, {ProductId: "BULKY", Quantity: 1}, {ProductId: "UNKNOWN", Quantity: 1}}, "$19.50"},
+		{"missing packaging info", packaging.URL, []*pb.CartItem{{ProductId: "UNKNOWN", Quantity: 5}}, ""},
+		{"light items", packaging.URL, []*pb.CartItem{{ProductId: "HEAVY", Quantity: 0}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packagingServiceUrl = tt.packagingURL
+			est, err := fe.estimateShippingCost(context.Background(), logger, tt.items, "USD")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The quote is what checkout charges, whatever the weight.
+			if got := renderMoney(*est.Quote); got != "$8.99" {
+				t.Errorf("quote = %s, want $8.99", got)
+			}
+			var surcharge string
+			if est.WeightSurcharge != nil {
+				surcharge = renderMoney(*est.WeightSurcharge)
+			}
+			if surcharge != tt.wantSurcharge {
+				t.Errorf("weight surcharge = %q, want %q", surcharge, tt.wantSurcharge)
+			}
+		})
+	}
+}
@@ -213,6 +213,11 @@ class SearchResults {
     parseAgentResponse(agentData) {
         console.log('Parsing agent response');
         
+        // The frontend normalizes agent and fallback results to {products, query, count, message}
+        if (Array.isArray(agentData.products)) {
+            return agentData.products;
+        }
+
         // Use the centralized agent response parser
         if (window.AgentResponseParser) {
             const parser = new window.AgentResponseParser();
@@ -191,6 +191,11 @@ class SmartSearch {
     parseAgentResponse(agentData) {
         console.log('Parsing agent response');
         
+        // The frontend normalizes agent and fallback results to {products, query, count, message}
+        if (Array.isArray(agentData.products)) {
+            return agentData.products;
+        }
+
         // Handle structured output from agent (with output_schema)
         if (agentData.search_results && agentData.search_results.products) {
             console.log('Found structured search results:', agentData.search_results);
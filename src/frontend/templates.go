@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"sync"
+)
+
+// pageTemplates are the templates the handlers render. Loading fails when
+// any is missing, rather than every page failing later.
+var pageTemplates = []string{"home", "product", "cart", "order", "error", "search", "assistant", "support"}
+
+// templateFuncs are the helpers available to every page template.
+var templateFuncs = template.FuncMap{
+	"renderMoney":          renderMoney,
+	"renderLocalizedMoney": renderLocalizedMoney,
+	"renderCurrencyLogo":   renderCurrencyLogo,
+	"imageURL":             renderImageURL,
+}
+
+// templateSet holds the parsed page templates. In development mode
+// (DEV_MODE=true) they are parsed again before every page is rendered, so
+// template edits show up without a restart; otherwise they are parsed once.
+type templateSet struct {
+	pattern  string
+	reload   bool
+	required []string
+
+	mu   sync.Mutex
+	tmpl *template.Template
+}
+
+// loadTemplates parses the templates matching pattern and checks that every
+// required template is defined.
+func loadTemplates(pattern string, reload bool, required ...string) (*templateSet, error) {
+	s := &templateSet{pattern: pattern, reload: reload, required: required}
+	t, err := s.parse()
+	if err != nil {
+		return nil, err
+	}
+	s.tmpl = t
+	return s, nil
+}
+
+// mustLoadTemplates is like loadTemplates but panics on error.
+func mustLoadTemplates(pattern string, reload bool, required ...string) *templateSet {
+	s, err := loadTemplates(pattern, reload, required...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *templateSet) parse() (*template.Template, error) {
+	t, err := template.New("").Funcs(templateFuncs).ParseGlob(s.pattern)
+	if err != nil {
+		return nil, fmt.Errorf("loading templates %s (is the working directory the frontend root?): %w", s.pattern, err)
+	}
+	var missing []string
+	for _, name := range s.required {
+		if t.Lookup(name) == nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("templates %s do not define %s", s.pattern, strings.Join(missing, ", "))
+	}
+	return t, nil
+}
+
+// current returns the templates to render with, parsing them again first in
+// development mode. A failed reparse keeps the previous templates and
+// returns the error.
+func (s *templateSet) current() (*template.Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reload {
+		t, err := s.parse()
+		if err != nil {
+			return nil, err
+		}
+		s.tmpl = t
+	}
+	return s.tmpl, nil
+}
+
+// ExecuteTemplate renders the template called name to w.
+func (s *templateSet) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	t, err := s.current()
+	if err != nil {
+		return err
+	}
+	return t.ExecuteTemplate(w, name, data)
+}
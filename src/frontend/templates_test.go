@@ -0,0 +1,84 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateReload(t *testing.T) {
+	tests := []struct {
+		name   string
+		reload bool
+		want   string
+	}{
+		{"dev mode", true, "after"},
+		{"production", false, "before"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			page := filepath.Join(dir, "page.html")
+			write := func(body string) {
+				if err := os.WriteFile(page, []byte(`{{define "page"}}`+body+`{{end}}`), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			write("before")
+			templates := mustLoadTemplates(filepath.Join(dir, "*.html"), tt.reload)
+
+			var out strings.Builder
+			if err := templates.ExecuteTemplate(&out, "page", nil); err != nil || out.String() != "before" {
+				t.Fatalf("first render = %q, %v; want before", out.String(), err)
+			}
+			write("after")
+			out.Reset()
+			if err := templates.ExecuteTemplate(&out, "page", nil); err != nil || out.String() != tt.want {
+				t.Errorf("render after edit = %q, %v; want %s", out.String(), err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadTemplatesRequiresPageTemplates(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range pageTemplates {
+		if name == "home" {
+			continue
+		}
+		body := `{{define "` + name + `"}}` + name + `{{end}}`
+		if err := os.WriteFile(filepath.Join(dir, name+".html"), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := loadTemplates(filepath.Join(dir, "*.html"), false, pageTemplates...)
+	if err == nil {
+		t.Fatal("templates without home loaded")
+	}
+	if msg := err.Error(); !strings.HasSuffix(msg, "do not define home") {
+		t.Errorf("error = %q, want it to name only the missing home template", msg)
+	}
+
+	if _, err := loadTemplates(filepath.Join(t.TempDir(), "*.html"), false, pageTemplates...); err == nil {
+		t.Error("empty template directory loaded")
+	}
+	if _, err := loadTemplates("templates/*.html", false, pageTemplates...); err != nil {
+		t.Errorf("shipped templates: %v", err)
+	}
+}
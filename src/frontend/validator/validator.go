@@ -21,21 +21,43 @@ import (
 	"github.com/go-playground/validator/v10"
 )
 
-var validate *validator.Validate
+// DefaultMaxQuantity is the largest quantity of a single product that can be
+// added to the cart in one request unless overridden with SetMaxQuantity.
+const DefaultMaxQuantity = 100
+
+var (
+	validate    *validator.Validate
+	maxQuantity uint64 = DefaultMaxQuantity
+)
 
 // init() is a special function that will run when this package is imported.
 // It instantiates a SINGLE instance of *validator.Validate with the added
 // benefit of caching struct info and validations.
 func init() {
 	validate = validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterValidation("max_quantity", func(fl validator.FieldLevel) bool {
+		return fl.Field().Uint() <= maxQuantity
+	})
+}
+
+// SetMaxQuantity changes the upper bound enforced on AddToCartPayload.Quantity.
+// A value of 0 restores DefaultMaxQuantity.
+func SetMaxQuantity(n uint64) {
+	if n == 0 {
+		n = DefaultMaxQuantity
+	}
+	maxQuantity = n
 }
 
+// MaxQuantity returns the upper bound enforced on AddToCartPayload.Quantity.
+func MaxQuantity() uint64 { return maxQuantity }
+
 type Payload interface {
 	Validate() error
 }
 
 type AddToCartPayload struct {
-	Quantity  uint64 `validate:"required,gte=1,lte=10"`
+	Quantity  uint64 `validate:"required,gte=1,max_quantity"`
 	ProductID string `validate:"required"`
 }
 
@@ -129,7 +129,7 @@ func TestAddToCartFailsValidation(t *testing.T) {
 		productID string
 	}{
 		{"invalid min quantity", 0, "OLJCESPC7Z"},
-		{"invalid max quantity", 11, "OLJCESPC7Z"},
+		{"invalid max quantity", DefaultMaxQuantity + 1, "OLJCESPC7Z"},
 		{"invalid product id", 1, ""},
 		{"invalid quantity and product id", 0, ""},
 	}
@@ -143,6 +143,42 @@ func TestAddToCartFailsValidation(t *testing.T) {
 	}
 }
 
+func TestAddToCartQuantityBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity uint64
+		wantErr  bool
+	}{
+		{"zero", 0, true},
+		{"one", 1, false},
+		{"max", DefaultMaxQuantity, false},
+		{"max+1", DefaultMaxQuantity + 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := AddToCartPayload{Quantity: tt.quantity, ProductID: "OLJCESPC7Z"}
+			if err := payload.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate(%v) error = %v, wantErr %v", payload, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddToCartConfiguredMaxQuantity(t *testing.T) {
+	SetMaxQuantity(5)
+	defer SetMaxQuantity(DefaultMaxQuantity)
+
+	if got := MaxQuantity(); got != 5 {
+		t.Fatalf("MaxQuantity() = %d, want 5", got)
+	}
+	if err := (&AddToCartPayload{Quantity: 5, ProductID: "OLJCESPC7Z"}).Validate(); err != nil {
+		t.Errorf("want quantity 5 to pass, got %v", err)
+	}
+	if err := (&AddToCartPayload{Quantity: 6, ProductID: "OLJCESPC7Z"}).Validate(); err == nil {
+		t.Errorf("want quantity 6 to fail with max 5")
+	}
+}
+
 func TestSetCurrencyPassesValidation(t *testing.T) {
 	tests := []struct {
 		name     string
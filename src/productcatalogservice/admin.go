@@ -0,0 +1,84 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+)
+
+// reload loads the catalog from its data source and swaps it in only if
+// loading succeeded, so a failed reload keeps serving the old catalog. A
+// successful reload drops cached query results. It returns the number of
+// products now served.
+func (p *productCatalog) reload() (int, error) {
+	var fresh pb.ListProductsResponse
+	if err := loadCatalog(&fresh); err != nil {
+		return 0, err
+	}
+	catalogMutex.Lock()
+	p.catalog.Products = fresh.Products
+	catalogMutex.Unlock()
+	p.purgeQueryCaches()
+	return len(fresh.Products), nil
+}
+
+// adminHandler serves the operator endpoints. Every request must carry
+// "Authorization: Bearer <token>".
+func (p *productCatalog) adminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/catalog/reload", p.catalogReloadHandler)
+	mux.HandleFunc("PATCH /admin/product/{id}", p.productPatchHandler)
+	return requireToken(token, mux)
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *productCatalog) catalogReloadHandler(w http.ResponseWriter, r *http.Request) {
+	count, err := p.reload()
+	if err != nil {
+		log.Warnf("catalog reload failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "catalog reload failed"})
+		return
+	}
+	log.Infof("catalog reloaded through the admin endpoint (%d products)", count)
+
+	catalogMutex.Lock()
+	version := catalogVersion
+	catalogMutex.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"products": count,
+		"version":  version,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
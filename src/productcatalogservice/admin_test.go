@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+)
+
+func writeCatalogFile(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdminCatalogReload(t *testing.T) {
+	defer func(f string) { catalogFile = f }(catalogFile)
+	catalogFile = filepath.Join(t.TempDir(), "products.json")
+	writeCatalogFile(t, catalogFile, `{"version": "v1", "products": [
+		{"id": "A1", "name": "Alpha", "priceUsd": {"currencyCode": "USD", "units": 1}}
+	]}`)
+
+	svc := &productCatalog{}
+	if err := loadCatalog(&svc.catalog); err != nil {
+		t.Fatal(err)
+	}
+
+	// Update the backing source; the served catalog stays cached until reload.
+	writeCatalogFile(t, catalogFile, `{"version": "v2", "products": [
+		{"id": "A1", "name": "Alpha", "priceUsd": {"currencyCode": "USD", "units": 1}},
+		{"id": "B2", "name": "Beta", "priceUsd": {"currencyCode": "USD", "units": 2}}
+	]}`)
+	listed, err := svc.ListProducts(context.Background(), &pb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(listed.Products); n != 1 {
+		t.Fatalf("served %d products before reload, want 1", n)
+	}
+
+	handler := svc.adminHandler("s3cret")
+	req := httptest.NewRequest(http.MethodPost, "/admin/catalog/reload", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Products int    `json:"products"`
+		Version  string `json:"version"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Products != 2 || resp.Version != "v2" {
+		t.Errorf("response = %+v, want 2 products at v2", resp)
+	}
+	listed, err = svc.ListProducts(context.Background(), &pb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(listed.Products); n != 2 {
+		t.Errorf("served %d products after reload, want 2", n)
+	}
+
+	// A broken source keeps the current catalog.
+	writeCatalogFile(t, catalogFile, `not json`)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d for a broken catalog, want 500", rec.Code)
+	}
+	if n := len(svc.catalog.Products); n != 2 {
+		t.Errorf("failed reload replaced the catalog: %d products", n)
+	}
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	handler := (&productCatalog{}).adminHandler("s3cret")
+	for _, auth := range []string{"", "Bearer wrong", "s3cret", "Basic s3cret"} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/catalog/reload", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", auth, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/sync/singleflight"
+)
+
+// Failed pool creations are retried no sooner than dbReconnectBackoff later,
+// doubling up to dbReconnectMaxBackoff while AlloyDB stays unreachable.
+// Variables so tests can shorten them.
+var (
+	dbReconnectBackoff    = 100 * time.Millisecond
+	dbReconnectMaxBackoff = 30 * time.Second
+)
+
+// dbQuerier is the part of *pgxpool.Pool the catalog uses.
+type dbQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Ping(ctx context.Context) error
+}
+
+// dbPool is the persistent AlloyDB connection pool. It is created on first
+// use and recreated after a fatal connection error, such as a failover or a
+// rotated password.
+type dbPool struct {
+	connect func(ctx context.Context) (dbQuerier, func(), error)
+
+	// dialing lets one caller connect while the others wait for its result,
+	// without holding mu through the Secret Manager fetch and the dial.
+	dialing singleflight.Group
+
+	mu          sync.Mutex
+	pool        dbQuerier
+	cleanup     func()
+	lastErr     error
+	backoff     time.Duration
+	nextAttempt time.Time
+}
+
+// alloyDB is the pool of the primary instance, shared by all AlloyDB loads
+// unless a read pool is configured.
+var alloyDB = &dbPool{
+	connect: func(ctx context.Context) (dbQuerier, func(), error) {
+		return connectAlloyDB(ctx, primaryEndpoint())
+	},
+}
+
+// alloyDBReplica is the pool of the read instance, set up by
+// configureReadReplica; nil when reads go to the primary.
+var alloyDBReplica *dbPool
+
+// configureReadReplica sets up alloyDBReplica when a read instance is
+// configured.
+func configureReadReplica() {
+	ep, ok := readEndpoint()
+	if !ok {
+		return
+	}
+	log.Info("routing catalog reads to the AlloyDB read pool")
+	alloyDBReplica = &dbPool{connect: func(ctx context.Context) (dbQuerier, func(), error) {
+		return connectAlloyDB(ctx, ep)
+	}}
+}
+
+// readAlloyDB runs the read-only fn against the read pool when there is one,
+// falling back to the primary while the read pool cannot be reached. Writes
+// use alloyDB directly.
+func readAlloyDB(ctx context.Context, fn func(q dbQuerier) error) error {
+	if alloyDBReplica == nil {
+		return alloyDB.do(ctx, fn)
+	}
+	err := alloyDBReplica.do(ctx, fn)
+	if !isFatalConnError(err) {
+		return err
+	}
+	log.Warnf("AlloyDB read pool unavailable, reading from the primary: %v", err)
+	return alloyDB.do(ctx, fn)
+}
+
+// get returns the pool, creating it if needed. While creation keeps failing
+// it returns the last error until the backoff has passed. Concurrent callers
+// share a single connection attempt.
+func (p *dbPool) get(ctx context.Context) (dbQuerier, error) {
+	if q, err, ok := p.current(); ok {
+		return q, err
+	}
+	q, err, _ := p.dialing.Do("", func() (any, error) { return p.dial(ctx) })
+	if err != nil {
+		return nil, err
+	}
+	return q.(dbQuerier), nil
+}
+
+// current returns the pool, or the last connection error while backing off,
+// and false when a new connection should be attempted.
+func (p *dbPool) current() (dbQuerier, error, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pool != nil {
+		return p.pool, nil, true
+	}
+	if p.lastErr != nil && time.Now().Before(p.nextAttempt) {
+		return nil, fmt.Errorf("alloydb unavailable, reconnecting after %s: %w", p.nextAttempt.Format(time.RFC3339), p.lastErr), true
+	}
+	return nil, nil, false
+}
+
+// dial connects outside mu and swaps the new pool in under it.
+func (p *dbPool) dial(ctx context.Context) (dbQuerier, error) {
+	// A dial that finished just before this one started may have already
+	// replaced the pool.
+	if q, err, ok := p.current(); ok {
+		return q, err
+	}
+
+	pool, cleanup, err := p.connect(ctx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		if p.backoff == 0 {
+			p.backoff = dbReconnectBackoff
+		} else if p.backoff *= 2; p.backoff > dbReconnectMaxBackoff {
+			p.backoff = dbReconnectMaxBackoff
+		}
+		p.lastErr = err
+		p.nextAttempt = time.Now().Add(p.backoff)
+		log.Warnf("failed to connect to AlloyDB, retrying in %s: %v", p.backoff, err)
+		return nil, err
+	}
+	if p.lastErr != nil {
+		log.Info("reconnected to AlloyDB")
+	}
+	p.pool, p.cleanup = pool, cleanup
+	p.lastErr, p.backoff = nil, 0
+	return pool, nil
+}
+
+// do runs fn against the pool and drops the pool if fn failed with a fatal
+// connection error, so the next call connects afresh.
+func (p *dbPool) do(ctx context.Context, fn func(q dbQuerier) error) error {
+	q, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(q)
+	p.reportError(q, err)
+	return err
+}
+
+// reportError drops q if err shows its connections are no longer usable.
+func (p *dbPool) reportError(q dbQuerier, err error) {
+	if !isFatalConnError(err) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pool != q {
+		return // already replaced
+	}
+	if isAuthError(err) {
+		log.Warnf("AlloyDB rejected the credentials, recreating the pool with a fresh secret: %v", err)
+		alloyDBPassword.invalidate()
+	} else {
+		log.Warnf("AlloyDB connection lost, recreating the pool: %v", err)
+	}
+	p.cleanup()
+	p.pool, p.cleanup = nil, nil
+	p.lastErr = err
+}
+
+// check pings AlloyDB through the pool for the health check.
+func (p *dbPool) check(ctx context.Context) error {
+	return p.do(ctx, func(q dbQuerier) error { return q.Ping(ctx) })
+}
+
+// close closes the pool, if any. It is a no-op on a nil pool.
+func (p *dbPool) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pool != nil {
+		p.cleanup()
+		p.pool, p.cleanup = nil, nil
+	}
+}
+
+// isFatalConnError reports whether err means the pool must be recreated:
+// the connection broke, the server is shutting down or refused the
+// credentials. Query errors, missing rows and cancellations are not fatal.
+func isFatalConnError(err error) bool {
+	if err == nil || errors.Is(err, pgx.ErrNoRows) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "57P01", "57P02", "57P03": // admin/crash shutdown, cannot connect now
+			return true
+		}
+		// connection exception, invalid authorization
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "28")
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) || errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isAuthError reports whether AlloyDB refused the credentials.
+func isAuthError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "28")
+}
@@ -0,0 +1,308 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeQuerier is a pool whose Ping returns err.
+type fakeQuerier struct {
+	dbQuerier
+	err error
+}
+
+func (f *fakeQuerier) Ping(context.Context) error { return f.err }
+
+func TestDBPoolRecreatesAfterFatalError(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantRecreate bool
+	}{
+		{"failover", &pgconn.PgError{Code: "57P01"}, true},
+		{"rotated password", &pgconn.PgError{Code: "28P01"}, true},
+		{"broken connection", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"query error", &pgconn.PgError{Code: "42P01"}, false},
+		{"missing row", pgx.ErrNoRows, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pools []*fakeQuerier
+			closed := 0
+			p := &dbPool{connect: func(context.Context) (dbQuerier, func(), error) {
+				q := &fakeQuerier{}
+				if len(pools) == 0 {
+					q.err = tt.err
+				}
+				pools = append(pools, q)
+				return q, func() { closed++ }, nil
+			}}
+
+			if err := p.check(context.Background()); !errors.Is(err, tt.err) {
+				t.Fatalf("first check = %v, want %v", err, tt.err)
+			}
+			err := p.check(context.Background())
+			if tt.wantRecreate {
+				if err != nil || len(pools) != 2 || closed != 1 {
+					t.Errorf("second check = %v with %d pools created and %d closed, want a healthy second pool", err, len(pools), closed)
+				}
+			} else if len(pools) != 1 || closed != 0 {
+				t.Errorf("%d pools created and %d closed, want the first pool kept", len(pools), closed)
+			}
+		})
+	}
+}
+
+func TestDBPoolBacksOffFailedConnects(t *testing.T) {
+	defer func(d time.Duration) { dbReconnectBackoff = d }(dbReconnectBackoff)
+	dbReconnectBackoff = 50 * time.Millisecond
+
+	attempts := 0
+	down := true
+	p := &dbPool{connect: func(context.Context) (dbQuerier, func(), error) {
+		attempts++
+		if down {
+			return nil, nil, &pgconn.ConnectError{}
+		}
+		return &fakeQuerier{}, func() {}, nil
+	}}
+
+	if err := p.check(context.Background()); err == nil {
+		t.Fatal("check succeeded with AlloyDB down")
+	}
+	err := p.check(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "reconnecting") || attempts != 1 {
+		t.Errorf("check during backoff = %v after %d attempts, want the backoff error and no new attempt", err, attempts)
+	}
+
+	down = false
+	time.Sleep(dbReconnectBackoff)
+	if err := p.check(context.Background()); err != nil || attempts != 2 {
+		t.Errorf("check after backoff = %v after %d attempts, want a reconnect", err, attempts)
+	}
+}
+
+func TestDBPoolConnectsOutsideLock(t *testing.T) {
+	release := make(chan struct{})
+	var attempts atomic.Int32
+	p := &dbPool{connect: func(context.Context) (dbQuerier, func(), error) {
+		attempts.Add(1)
+		<-release
+		return &fakeQuerier{}, func() {}, nil
+	}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.check(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// A slow dial must not hold the lock other pool operations take.
+	for attempts.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	locked := make(chan struct{})
+	go func() {
+		p.close()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("close blocked behind the dial")
+	}
+	close(release)
+	wg.Wait()
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("%d connection attempts by 5 concurrent callers, want 1", n)
+	}
+}
+
+func TestAlloyDBPasswordFetchedOnce(t *testing.T) {
+	defer func(c *secretCache, p *dbPool) { alloyDBPassword, alloyDB = c, p }(alloyDBPassword, alloyDB)
+	fetches := 0
+	alloyDBPassword = &secretCache{fetch: func(project, secret, version string) (string, error) {
+		fetches++
+		return "s3cret", nil
+	}}
+	alloyDB = &dbPool{connect: func(ctx context.Context) (dbQuerier, func(), error) {
+		return connectAlloyDB(ctx, primaryEndpoint())
+	}}
+	t.Setenv("ALLOYDB_PRIMARY_IP", "127.0.0.1")
+	t.Setenv("ALLOYDB_TABLE_NAME", "products")
+
+	// Nothing listens for AlloyDB here: every lookup fails on a connection
+	// error and recreates the pool, without reading the secret again.
+	for i := 0; i < 3; i++ {
+		if _, err := loadSingleProductFromAlloyDB("OLJCESPC7Z"); err == nil {
+			t.Fatal("lookup succeeded without a database")
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("secret fetched %d times over 3 lookups, want 1", fetches)
+	}
+
+	// A rejected password is read again on the next connection.
+	q, err := alloyDB.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alloyDB.reportError(q, &pgconn.PgError{Code: "28P01"})
+	if _, err := alloyDB.get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 2 {
+		t.Errorf("secret fetched %d times after an auth failure, want 2", fetches)
+	}
+}
+
+// noRowsQuerier is a pool that counts lookups and finds nothing.
+type noRowsQuerier struct {
+	dbQuerier
+	lookups int
+}
+
+type noRow struct{}
+
+func (noRow) Scan(...any) error { return pgx.ErrNoRows }
+
+func (q *noRowsQuerier) QueryRow(context.Context, string, ...any) pgx.Row {
+	q.lookups++
+	return noRow{}
+}
+
+func TestReadsUseReadPool(t *testing.T) {
+	defer func(p, r *dbPool) { alloyDB, alloyDBReplica = p, r }(alloyDB, alloyDBReplica)
+	t.Setenv("ALLOYDB_TABLE_NAME", "products")
+
+	pool := func(q *noRowsQuerier, err error) *dbPool {
+		return &dbPool{connect: func(context.Context) (dbQuerier, func(), error) {
+			if err != nil {
+				return nil, nil, err
+			}
+			return q, func() {}, nil
+		}}
+	}
+	tests := []struct {
+		name        string
+		replica     bool
+		replicaErr  error
+		wantPrimary int
+		wantReplica int
+	}{
+		{"no read pool", false, nil, 1, 0},
+		{"read pool", true, nil, 0, 1},
+		{"read pool down", true, &pgconn.ConnectError{}, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, replica := &noRowsQuerier{}, &noRowsQuerier{}
+			alloyDB, alloyDBReplica = pool(primary, nil), nil
+			if tt.replica {
+				alloyDBReplica = pool(replica, tt.replicaErr)
+			}
+			if _, err := loadSingleProductFromAlloyDB("OLJCESPC7Z"); !errors.Is(err, pgx.ErrNoRows) {
+				t.Fatalf("lookup error = %v, want %v", err, pgx.ErrNoRows)
+			}
+			if primary.lookups != tt.wantPrimary || replica.lookups != tt.wantReplica {
+				t.Errorf("primary served %d lookups and replica %d, want %d and %d",
+					primary.lookups, replica.lookups, tt.wantPrimary, tt.wantReplica)
+			}
+		})
+	}
+}
+
+// sqlQuerier is a pool that records the SQL and arguments of its lookups
+// and finds nothing.
+type sqlQuerier struct {
+	dbQuerier
+	sql  []string
+	args [][]any
+}
+
+func (q *sqlQuerier) QueryRow(_ context.Context, sql string, args ...any) pgx.Row {
+	q.sql, q.args = append(q.sql, sql), append(q.args, args)
+	return noRow{}
+}
+
+// TestSingleProductLookupComparesIDColumn checks that lookups compare the
+// stored, canonical id with the normalized request id, leaving the id
+// column bare so its index can serve them.
+func TestSingleProductLookupComparesIDColumn(t *testing.T) {
+	defer func(p, r *dbPool) { alloyDB, alloyDBReplica = p, r }(alloyDB, alloyDBReplica)
+	t.Setenv("ALLOYDB_TABLE_NAME", "products")
+	db := &sqlQuerier{}
+	alloyDB, alloyDBReplica = &dbPool{connect: func(context.Context) (dbQuerier, func(), error) { return db, func() {}, nil }}, nil
+
+	loadSingleProductFromAlloyDB(" oljcespc7z ")
+	if len(db.sql) != 1 || !strings.Contains(db.sql[0], `WHERE "id" = $1`) || db.args[0][0] != "OLJCESPC7Z" {
+		t.Errorf("lookup ran %q with %v, want the id column compared with OLJCESPC7Z", db.sql, db.args)
+	}
+}
+
+// TestPatchReadsPrimary checks that the read half of a product patch is not
+// served by a possibly lagging read pool.
+func TestPatchReadsPrimary(t *testing.T) {
+	defer func(p, r *dbPool) { alloyDB, alloyDBReplica = p, r }(alloyDB, alloyDBReplica)
+	t.Setenv("ALLOYDB_TABLE_NAME", "products")
+	primary, replica := &noRowsQuerier{}, &noRowsQuerier{}
+	alloyDB = &dbPool{connect: func(context.Context) (dbQuerier, func(), error) { return primary, func() {}, nil }}
+	alloyDBReplica = &dbPool{connect: func(context.Context) (dbQuerier, func(), error) { return replica, func() {}, nil }}
+
+	name := "Sunglasses"
+	if _, err := patchProductInAlloyDB(context.Background(), "OLJCESPC7Z", &productPatch{Name: &name}); !errors.Is(err, errProductNotFound) {
+		t.Fatalf("patch error = %v, want %v", err, errProductNotFound)
+	}
+	if primary.lookups != 1 || replica.lookups != 0 {
+		t.Errorf("primary served %d lookups and replica %d, want the primary only", primary.lookups, replica.lookups)
+	}
+}
+
+func TestReadEndpointConfig(t *testing.T) {
+	if _, ok := readEndpoint(); ok {
+		t.Fatal("read endpoint configured without ALLOYDB_READ_IP or ALLOYDB_READ_INSTANCE_NAME")
+	}
+	t.Setenv("ALLOYDB_READ_IP", "10.0.0.9")
+	ep, ok := readEndpoint()
+	if !ok {
+		t.Fatal("ALLOYDB_READ_IP did not configure a read endpoint")
+	}
+	config, err := ep.poolConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ConnConfig.Host != "10.0.0.9" || config.ConnConfig.Port != 5432 {
+		t.Errorf("read pool connects to %s:%d, want 10.0.0.9:5432", config.ConnConfig.Host, config.ConnConfig.Port)
+	}
+	if config.ConnConfig.TLSConfig == nil {
+		t.Error("direct read pool connections do not require TLS")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// catalogColumns are the product columns read from AlloyDB, in scan order,
+// under their default names. ALLOYDB_COLUMN_MAP renames them.
+var catalogColumns = []string{
+	"id", "name", "description", "picture", "price_usd_currency_code",
+	"price_usd_units", "price_usd_nanos", "categories",
+}
+
+// catalogSchema is where products live in AlloyDB: the table and the column
+// holding each of catalogColumns (and optionally the attributes, whether the
+// product is published and its stock). Every name
+// is quoted as an identifier when building queries, so configuration cannot
+// inject SQL.
+type catalogSchema struct {
+	table      pgx.Identifier
+	columns    map[string]string // default name -> actual name
+	attributes string            // jsonb attributes column; "" when absent
+	published  string            // boolean published column; "" when absent
+	stock      string            // integer stock column; "" when absent
+}
+
+// catalogSchemaFromEnv reads the schema from ALLOYDB_TABLE_NAME (optionally
+// schema-qualified, e.g. "shop.products"), ALLOYDB_COLUMN_MAP (comma
+// separated default=actual pairs, e.g. "id=sku,name=title"),
+// ALLOYDB_ATTRIBUTES_COLUMN, ALLOYDB_PUBLISHED_COLUMN and
+// ALLOYDB_STOCK_COLUMN.
+func catalogSchemaFromEnv() (*catalogSchema, error) {
+	s, err := parseCatalogSchema(os.Getenv("ALLOYDB_TABLE_NAME"),
+		os.Getenv("ALLOYDB_COLUMN_MAP"), os.Getenv("ALLOYDB_ATTRIBUTES_COLUMN"))
+	if err != nil {
+		return nil, err
+	}
+	s.published = strings.TrimSpace(os.Getenv("ALLOYDB_PUBLISHED_COLUMN"))
+	s.stock = strings.TrimSpace(os.Getenv("ALLOYDB_STOCK_COLUMN"))
+	return s, nil
+}
+
+func parseCatalogSchema(table, columnMap, attributes string) (*catalogSchema, error) {
+	s := &catalogSchema{columns: map[string]string{}, attributes: strings.TrimSpace(attributes)}
+	for _, part := range strings.Split(strings.TrimSpace(table), ".") {
+		if part = strings.TrimSpace(part); part == "" {
+			return nil, fmt.Errorf("invalid ALLOYDB_TABLE_NAME %q", table)
+		}
+		s.table = append(s.table, part)
+	}
+	if len(s.table) > 2 {
+		return nil, fmt.Errorf("invalid ALLOYDB_TABLE_NAME %q: want table or schema.table", table)
+	}
+	for _, c := range catalogColumns {
+		s.columns[c] = c
+	}
+	for _, pair := range strings.Split(columnMap, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || to == "" {
+			return nil, fmt.Errorf("invalid ALLOYDB_COLUMN_MAP entry %q: want column=name", pair)
+		}
+		if _, known := s.columns[from]; !known {
+			return nil, fmt.Errorf("ALLOYDB_COLUMN_MAP: unknown column %q", from)
+		}
+		s.columns[from] = to
+	}
+	return s, nil
+}
+
+// selectList returns the quoted select list matching the scan order of
+// catalogColumns, followed by the attributes, published and stock columns
+// (NULL when the table has no such column).
+func (s *catalogSchema) selectList() string {
+	cols := make([]string, 0, len(catalogColumns)+3)
+	for _, c := range catalogColumns {
+		cols = append(cols, s.column(c))
+	}
+	if s.attributes != "" {
+		cols = append(cols, pgx.Identifier{s.attributes}.Sanitize())
+	} else {
+		cols = append(cols, "NULL::jsonb")
+	}
+	if s.published != "" {
+		cols = append(cols, pgx.Identifier{s.published}.Sanitize())
+	} else {
+		cols = append(cols, "NULL::boolean")
+	}
+	if s.stock != "" {
+		cols = append(cols, pgx.Identifier{s.stock}.Sanitize())
+	} else {
+		cols = append(cols, "NULL::integer")
+	}
+	return strings.Join(cols, ", ")
+}
+
+// column returns the quoted name of the column with the given default name.
+func (s *catalogSchema) column(name string) string {
+	return pgx.Identifier{s.columns[name]}.Sanitize()
+}
+
+// tableName returns the quoted, possibly schema-qualified, table name.
+func (s *catalogSchema) tableName() string {
+	return s.table.Sanitize()
+}
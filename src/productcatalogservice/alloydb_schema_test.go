@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestCatalogSchemaQuotesTable(t *testing.T) {
+	tests := []struct {
+		table string
+		want  string
+	}{
+		{"catalog_items", `"catalog_items"`},
+		{"shop.catalog_items", `"shop"."catalog_items"`},
+		{`items; DROP TABLE users; --`, `"items; DROP TABLE users; --"`},
+		{`it"ems`, `"it""ems"`},
+	}
+	for _, tt := range tests {
+		s, err := parseCatalogSchema(tt.table, "", "")
+		if err != nil {
+			t.Errorf("parseCatalogSchema(%q): %v", tt.table, err)
+			continue
+		}
+		if got := s.tableName(); got != tt.want {
+			t.Errorf("tableName(%q) = %s, want %s", tt.table, got, tt.want)
+		}
+	}
+
+	for _, table := range []string{"", "a..b", "a.b.c"} {
+		if _, err := parseCatalogSchema(table, "", ""); err == nil {
+			t.Errorf("parseCatalogSchema(%q) succeeded, want an error", table)
+		}
+	}
+}
+
+func TestCatalogSchemaColumnMap(t *testing.T) {
+	s, err := parseCatalogSchema("products", " id = sku, name=title,price_usd_units=dollars", "attrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `"sku", "title", "description", "picture", "price_usd_currency_code", ` +
+		`"dollars", "price_usd_nanos", "categories", "attrs", NULL::boolean, NULL::integer`
+	if got := s.selectList(); got != want {
+		t.Errorf("selectList() = %s, want %s", got, want)
+	}
+	if got := s.column("id"); got != `"sku"` {
+		t.Errorf(`column("id") = %s, want "sku"`, got)
+	}
+
+	s, err = parseCatalogSchema("products", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"id", "name", "description", "picture", "price_usd_currency_code", ` +
+		`"price_usd_units", "price_usd_nanos", "categories", NULL::jsonb, NULL::boolean, NULL::integer`; s.selectList() != want {
+		t.Errorf("default selectList() = %s, want %s", s.selectList(), want)
+	}
+
+	for _, m := range []string{"price=cost", "id", "id="} {
+		if _, err := parseCatalogSchema("products", m, ""); err == nil {
+			t.Errorf("column map %q accepted, want an error", m)
+		}
+	}
+}
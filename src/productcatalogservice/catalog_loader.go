@@ -15,47 +15,71 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"net"
 	"os"
 	"strings"
+	"sync"
 
 	"cloud.google.com/go/alloydbconn"
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
-	"github.com/golang/protobuf/jsonpb"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// loadCatalog loads the catalog from its data source into catalog. The
+// loaders read the source without holding catalogMutex and only take it to
+// swap in the result, so a slow fetch does not hold up requests served from
+// the cached catalog.
 func loadCatalog(catalog *pb.ListProductsResponse) error {
-	catalogMutex.Lock()
-	defer catalogMutex.Unlock()
-
 	if os.Getenv("ALLOYDB_CLUSTER_NAME") != "" {
 		return loadCatalogFromAlloyDB(catalog)
 	}
 
-	return loadCatalogFromLocalFile(catalog)
+	return loadCatalogFromSource(catalog)
 }
 
-func loadCatalogFromLocalFile(catalog *pb.ListProductsResponse) error {
-	log.Info("loading catalog from local products.json file...")
+// catalogFile is the local catalog used when AlloyDB is not configured and
+// CATALOG_SOURCE is unset.
+var catalogFile = "products.json"
+
+func loadCatalogFromSource(catalog *pb.ListProductsResponse) error {
+	src := catalogSrc
+	if src == nil {
+		src = fileCatalogSource(catalogFile)
+	}
+	log.Infof("loading catalog from %s...", src)
 
-	catalogJSON, err := os.ReadFile("products.json")
+	ctx, cancel := context.WithTimeout(context.Background(), catalogFetchTimeout)
+	defer cancel()
+	catalogJSON, err := src.fetch(ctx)
 	if err != nil {
-		log.Warnf("failed to open product catalog json file: %v", err)
-		return err
+		catalogMutex.Lock()
+		lastGood := lastGoodCatalog
+		catalogMutex.Unlock()
+		if lastGood.source != src.String() || lastGood.data == nil {
+			log.Warnf("failed to fetch product catalog: %v", err)
+			return err
+		}
+		log.Warnf("failed to fetch product catalog, using the last good copy: %v", err)
+		catalogJSON = lastGood.data
 	}
 
-	if err := jsonpb.Unmarshal(bytes.NewReader(catalogJSON), catalog); err != nil {
+	var fresh pb.ListProductsResponse
+	version, err := parseCatalogJSON(catalogJSON, &fresh, strictCatalog)
+	if err != nil {
 		log.Warnf("failed to parse the catalog JSON: %v", err)
 		return err
 	}
+	catalogMutex.Lock()
+	catalog.Products = fresh.Products
+	catalogVersion = version
+	lastGoodCatalog.source, lastGoodCatalog.data = src.String(), catalogJSON
+	catalogMutex.Unlock()
 
-	log.Info("successfully parsed product catalog json")
+	log.Infof("successfully parsed product catalog json (version %q, %d products)", version, len(fresh.Products))
 	return nil
 }
 
@@ -82,99 +106,200 @@ func getSecretPayload(project, secret, version string) (string, error) {
 	return string(result.Payload.Data), nil
 }
 
-func loadCatalogFromAlloyDB(catalog *pb.ListProductsResponse) error {
-	log.Info("loading catalog from AlloyDB...")
+// secretCache holds the AlloyDB password read from Secret Manager, so pools
+// are created without a Secret Manager call each time. invalidate drops it
+// after AlloyDB rejects it, and the next get reads the rotated secret.
+type secretCache struct {
+	fetch func(project, secret, version string) (string, error)
 
-	projectID := os.Getenv("PROJECT_ID")
-	region := os.Getenv("REGION")
-	pgClusterName := os.Getenv("ALLOYDB_CLUSTER_NAME")
-	pgInstanceName := os.Getenv("ALLOYDB_INSTANCE_NAME")
-	pgDatabaseName := os.Getenv("ALLOYDB_DATABASE_NAME")
-	pgTableName := os.Getenv("ALLOYDB_TABLE_NAME")
-	pgSecretName := os.Getenv("ALLOYDB_SECRET_NAME")
-	pgPrimaryIP := os.Getenv("ALLOYDB_PRIMARY_IP")
+	mu    sync.Mutex
+	value string
+}
 
-	pgPassword, err := getSecretPayload(projectID, pgSecretName, "latest")
+// alloyDBPassword caches the secret named by ALLOYDB_SECRET_NAME.
+var alloyDBPassword = &secretCache{fetch: getSecretPayload}
+
+func (c *secretCache) get(project, secret string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.value != "" {
+		return c.value, nil
+	}
+	v, err := c.fetch(project, secret, "latest")
 	if err != nil {
-		return err
+		return "", err
 	}
+	c.value = v
+	return v, nil
+}
+
+func (c *secretCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value = ""
+}
+
+// alloyDBEndpoint is an AlloyDB instance to connect to: directly at ip when
+// set, otherwise through the AlloyDB connector to the named instance.
+type alloyDBEndpoint struct {
+	ip       string
+	instance string
+}
+
+// primaryEndpoint is the primary instance, from ALLOYDB_PRIMARY_IP or
+// ALLOYDB_INSTANCE_NAME.
+func primaryEndpoint() alloyDBEndpoint {
+	return alloyDBEndpoint{ip: os.Getenv("ALLOYDB_PRIMARY_IP"), instance: os.Getenv("ALLOYDB_INSTANCE_NAME")}
+}
+
+// readEndpoint is the read pool instance, from ALLOYDB_READ_IP or
+// ALLOYDB_READ_INSTANCE_NAME. ok is false when neither is set.
+func readEndpoint() (ep alloyDBEndpoint, ok bool) {
+	ep = alloyDBEndpoint{ip: os.Getenv("ALLOYDB_READ_IP"), instance: os.Getenv("ALLOYDB_READ_INSTANCE_NAME")}
+	return ep, ep.ip != "" || ep.instance != ""
+}
 
+// poolConfig returns the pool configuration for ep, without credentials or
+// the connector dialer.
+func (ep alloyDBEndpoint) poolConfig() (*pgxpool.Config, error) {
 	sslMode := "disable"
-	if pgPrimaryIP != "" {
+	if ep.ip != "" {
 		// Direct private IP connections must use TLS.
 		sslMode = "require"
 	}
 
 	dsn := fmt.Sprintf(
-		"user=%s password=%s dbname=%s sslmode=%s",
-		"postgres", pgPassword, pgDatabaseName, sslMode,
+		"user=%s dbname=%s sslmode=%s",
+		"postgres", os.Getenv("ALLOYDB_DATABASE_NAME"), sslMode,
 	)
 
-	config, err := pgxpool.ParseConfig(dsn)
+	if ep.ip != "" {
+		// Use direct TCP to the private IP. The host goes in the DSN, not on
+		// the parsed config, so the TLS settings are derived for it.
+		dsn += fmt.Sprintf(" host=%s port=5432", ep.ip)
+	}
+	return pgxpool.ParseConfig(dsn)
+}
+
+// connectAlloyDB opens a pgx pool against the AlloyDB instance ep.
+// The returned cleanup function closes the pool and any connector resources
+// and must be called once the pool is no longer needed. Loads go through the
+// shared alloyDB and alloyDBReplica pools rather than calling this directly.
+// The password comes from alloyDBPassword.
+func connectAlloyDB(ctx context.Context, ep alloyDBEndpoint) (*pgxpool.Pool, func(), error) {
+	projectID := os.Getenv("PROJECT_ID")
+	region := os.Getenv("REGION")
+	pgClusterName := os.Getenv("ALLOYDB_CLUSTER_NAME")
+	pgSecretName := os.Getenv("ALLOYDB_SECRET_NAME")
+
+	pgPassword, err := alloyDBPassword.get(projectID, pgSecretName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config, err := ep.poolConfig()
 	if err != nil {
 		log.Warnf("failed to parse DSN config: %v", err)
-		return err
+		return nil, nil, err
 	}
+	// Set apart from the DSN so the password never shows in its errors.
+	config.ConnConfig.Password = pgPassword
 
-	if pgPrimaryIP != "" {
-		// Use direct TCP to the private IP
-		config.ConnConfig.Host = pgPrimaryIP
-		config.ConnConfig.Port = 5432
-		log.Infof("connecting to AlloyDB via private IP %s:5432", pgPrimaryIP)
+	closeDialer := func() {}
+	if ep.ip != "" {
+		log.Infof("connecting to AlloyDB via private IP %s:5432", ep.ip)
 	} else {
 		// Fallback to AlloyDB connector
-		dialer, err := alloydbconn.NewDialer(context.Background())
+		dialer, err := alloydbconn.NewDialer(ctx)
 		if err != nil {
 			log.Warnf("failed to set-up dialer connection: %v", err)
-			return err
+			return nil, nil, err
 		}
-		cleanup := func() error { return dialer.Close() }
-		defer cleanup()
+		closeDialer = func() { dialer.Close() }
 
-		pgInstanceURI := fmt.Sprintf("projects/%s/locations/%s/clusters/%s/instances/%s", projectID, region, pgClusterName, pgInstanceName)
+		pgInstanceURI := fmt.Sprintf("projects/%s/locations/%s/clusters/%s/instances/%s", projectID, region, pgClusterName, ep.instance)
 		config.ConnConfig.DialFunc = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
 			return dialer.Dial(ctx, pgInstanceURI)
 		}
 	}
 
-	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
 		log.Warnf("failed to set-up pgx pool: %v", err)
-		return err
+		closeDialer()
+		return nil, nil, err
 	}
-	defer pool.Close()
 
-	// query := "SELECT id, name, description, picture, price_usd_currency_code, price_usd_units, price_usd_nanos, categories FROM " + pgTableName
-	query := "SELECT id, name, description, picture, price_usd_currency_code, " +
-    "price_usd_units, price_usd_nanos, categories " +
-    "FROM " + pgTableName + " " +
-    "ORDER BY RANDOM() LIMIT 20"
-	rows, err := pool.Query(context.Background(), query)
+	return pool, func() {
+		pool.Close()
+		closeDialer()
+	}, nil
+}
+
+// pingAlloyDB verifies that the configured AlloyDB instance is reachable
+// through the shared pool. While the pool is being recreated it reports why.
+func pingAlloyDB(ctx context.Context) error {
+	return alloyDB.check(ctx)
+}
+
+func loadCatalogFromAlloyDB(catalog *pb.ListProductsResponse) error {
+	log.Info("loading catalog from AlloyDB...")
+
+	schema, err := catalogSchemaFromEnv()
 	if err != nil {
-		log.Warnf("failed to query database: %v", err)
+		log.Warnf("invalid catalog table configuration: %v", err)
 		return err
 	}
-	defer rows.Close()
-
-	catalog.Products = catalog.Products[:0]
-	for rows.Next() {
-		product := &pb.Product{}
-		product.PriceUsd = &pb.Money{}
-
-		var categories string
-		err = rows.Scan(&product.Id, &product.Name, &product.Description,
-			&product.Picture, &product.PriceUsd.CurrencyCode, &product.PriceUsd.Units,
-			&product.PriceUsd.Nanos, &categories)
+	query := "SELECT " + schema.selectList() + " FROM " + schema.tableName() +
+		" ORDER BY RANDOM() LIMIT 20"
+	ctx := context.Background()
+	var products []*pb.Product
+	err = readAlloyDB(ctx, func(pool dbQuerier) error {
+		rows, err := pool.Query(ctx, query)
 		if err != nil {
-			log.Warnf("failed to scan query result row: %v", err)
+			log.Warnf("failed to query database: %v", err)
 			return err
 		}
-		categories = strings.ToLower(categories)
-		product.Categories = strings.Split(categories, ",")
+		defer rows.Close()
+
+		products = products[:0]
+		for rows.Next() {
+			product := &pb.Product{}
+			product.PriceUsd = &pb.Money{}
 
-		catalog.Products = append(catalog.Products, product)
+			var categories string
+			var attributes []byte
+			err = rows.Scan(&product.Id, &product.Name, &product.Description,
+				&product.Picture, &product.PriceUsd.CurrencyCode, &product.PriceUsd.Units,
+				&product.PriceUsd.Nanos, &categories, &attributes, &product.Published, &product.Stock)
+			if err != nil {
+				log.Warnf("failed to scan query result row: %v", err)
+				return err
+			}
+			categories = strings.ToLower(categories)
+			product.Categories = strings.Split(categories, ",")
+			if product.Attributes, err = parseAttributes(attributes); err != nil {
+				log.Warnf("invalid attributes of product %s: %v", product.Id, err)
+				return err
+			}
+
+			products = append(products, product)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
 	}
 
+	products, err = validateProducts(products, strictCatalog)
+	if err != nil {
+		log.Warnf("invalid catalog in AlloyDB: %v", err)
+		return err
+	}
+	catalogMutex.Lock()
+	catalog.Products = products
+	catalogMutex.Unlock()
+
 	log.Info("successfully parsed product catalog from AlloyDB")
 	return nil
 }
@@ -0,0 +1,47 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// catalogReloadInterval is how often the catalog is reloaded in the
+// background, so an updated products.json (e.g. a remounted ConfigMap) is
+// picked up without a restart. Zero disables polling. Set with
+// CATALOG_RELOAD_INTERVAL.
+var catalogReloadInterval time.Duration
+
+// pollCatalog reloads the catalog every interval until ctx is done. Each wait
+// is stretched by up to a tenth of interval so replicas started together do
+// not all hit the data source at the same moment. A failed reload keeps the
+// current catalog.
+func (p *productCatalog) pollCatalog(ctx context.Context, interval time.Duration) {
+	for {
+		jitter := time.Duration(rand.Int63n(int64(interval)/10 + 1))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval + jitter):
+		}
+		if n, err := p.reload(); err != nil {
+			log.Warnf("periodic catalog reload failed: %v", err)
+		} else {
+			log.Debugf("periodic catalog reload served %d products", n)
+		}
+	}
+}
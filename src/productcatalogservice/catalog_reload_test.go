@@ -0,0 +1,48 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPollCatalogPicksUpChangedFile(t *testing.T) {
+	defer func(f string) { catalogFile = f }(catalogFile)
+	catalogFile = filepath.Join(t.TempDir(), "products.json")
+	writeCatalogFile(t, catalogFile, `{"products": [
+		{"id": "A", "name": "Mug", "priceUsd": {"currencyCode": "USD", "units": 1}}]}`)
+	svc := &productCatalog{}
+	if err := loadCatalog(&svc.catalog); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go svc.pollCatalog(ctx, 20*time.Millisecond)
+
+	writeCatalogFile(t, catalogFile, `{"products": [
+		{"id": "A", "name": "Mug", "priceUsd": {"currencyCode": "USD", "units": 1}},
+		{"id": "B", "name": "Cup", "priceUsd": {"currencyCode": "USD", "units": 2}}]}`)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(svc.products()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("catalog has %d products after the file changed, want 2", len(svc.products()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
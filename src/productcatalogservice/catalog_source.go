@@ -0,0 +1,162 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// catalogFetchTimeout bounds a single remote catalog download.
+	catalogFetchTimeout = 10 * time.Second
+	// maxCatalogBytes caps the size of a downloaded catalog document.
+	maxCatalogBytes = 32 << 20
+)
+
+// catalogSource fetches the raw products.json document when AlloyDB is not
+// configured.
+type catalogSource interface {
+	fetch(ctx context.Context) ([]byte, error)
+	String() string
+}
+
+// catalogSrc is selected at startup from CATALOG_SOURCE. When nil, catalogFile
+// is read from disk.
+var catalogSrc catalogSource
+
+// newCatalogSource parses a CATALOG_SOURCE value: a file:// path, an http(s)
+// URL, or a gs://bucket/object reference. An empty spec selects the default
+// local file.
+func newCatalogSource(ctx context.Context, spec string) (catalogSource, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid CATALOG_SOURCE %q", spec)
+	}
+	switch u.Scheme {
+	case "":
+		if spec == "" {
+			return nil, nil
+		}
+		return fileCatalogSource(spec), nil
+	case "file":
+		return fileCatalogSource(u.Host + u.Path), nil
+	case "http", "https":
+		return &httpCatalogSource{url: spec, client: &http.Client{Timeout: catalogFetchTimeout}}, nil
+	case "gs":
+		object := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || object == "" {
+			return nil, errors.Errorf("invalid CATALOG_SOURCE %q: want gs://bucket/object", spec)
+		}
+		client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_only")
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create GCS client")
+		}
+		client.Timeout = catalogFetchTimeout
+		return &httpCatalogSource{
+			url: fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s?alt=media",
+				url.PathEscape(u.Host), url.PathEscape(object)),
+			name:   spec,
+			client: client,
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported CATALOG_SOURCE scheme %q", u.Scheme)
+	}
+}
+
+// fileCatalogSource reads the catalog from a local path.
+type fileCatalogSource string
+
+func (f fileCatalogSource) fetch(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(string(f))
+}
+
+func (f fileCatalogSource) String() string { return "file://" + string(f) }
+
+// httpCatalogSource downloads the catalog with a GET request. The last
+// response is kept with its ETag so unchanged catalogs are revalidated with
+// If-None-Match instead of being downloaded again.
+type httpCatalogSource struct {
+	url    string
+	name   string // shown in logs instead of url when set
+	client *http.Client
+
+	mu   sync.Mutex
+	etag string
+	body []byte
+}
+
+func (h *httpCatalogSource) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	etag, cached := h.etag, h.body
+	h.mu.Unlock()
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not fetch catalog from %s", h)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("could not fetch catalog from %s: %s", h, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCatalogBytes+1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read catalog from %s", h)
+	}
+	if len(body) > maxCatalogBytes {
+		return nil, errors.Errorf("catalog from %s exceeds %d bytes", h, maxCatalogBytes)
+	}
+
+	h.mu.Lock()
+	h.etag, h.body = resp.Header.Get("ETag"), body
+	h.mu.Unlock()
+	return body, nil
+}
+
+func (h *httpCatalogSource) String() string {
+	if h.name != "" {
+		return h.name
+	}
+	return h.url
+}
+
+// lastGoodCatalog is the most recent document from a source that parsed
+// successfully. It is served when that source cannot be fetched, so a
+// flaky bucket or web server does not empty the catalog. Guarded by
+// catalogMutex.
+var lastGoodCatalog struct {
+	source string
+	data   []byte
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+)
+
+func TestNewCatalogSource(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{spec: "", want: "<nil>"},
+		{spec: "products.json", want: "file://products.json"},
+		{spec: "file:///data/products.json", want: "file:///data/products.json"},
+		{spec: "https://example.com/products.json", want: "https://example.com/products.json"},
+		{spec: "gs://bucket", wantErr: true},
+		{spec: "ftp://example.com/products.json", wantErr: true},
+	}
+	for _, tt := range tests {
+		src, err := newCatalogSource(context.Background(), tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newCatalogSource(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got := "<nil>"
+		if src != nil {
+			got = src.String()
+		}
+		if got != tt.want {
+			t.Errorf("newCatalogSource(%q) = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestLoadCatalogFromFileSource(t *testing.T) {
+	defer func(s catalogSource) { catalogSrc = s }(catalogSrc)
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	writeCatalogFile(t, path, `{"products": [
+		{"id": "A", "name": "Mug", "priceUsd": {"currencyCode": "USD", "units": 1}}]}`)
+	src, err := newCatalogSource(context.Background(), "file://"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogSrc = src
+
+	var catalog pb.ListProductsResponse
+	if err := loadCatalog(&catalog); err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Products) != 1 || catalog.Products[0].Id != "A" {
+		t.Errorf("loaded %v, want product A", catalog.Products)
+	}
+}
+
+func TestLoadCatalogFromHTTPSource(t *testing.T) {
+	defer func(s catalogSource) { catalogSrc = s }(catalogSrc)
+	var fail atomic.Bool
+	var downloads atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads.Add(1)
+		w.Write([]byte(`{"products": [
+			{"id": "A", "name": "Mug", "priceUsd": {"currencyCode": "USD", "units": 1}},
+			{"id": "B", "name": "Cup", "priceUsd": {"currencyCode": "USD", "units": 2}}]}`))
+	}))
+	defer srv.Close()
+	src, err := newCatalogSource(context.Background(), srv.URL+"/products.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogSrc = src
+
+	svc := &productCatalog{}
+	for i := 0; i < 2; i++ {
+		if n, err := svc.reload(); err != nil || n != 2 {
+			t.Fatalf("reload() = %d, %v, want 2 products", n, err)
+		}
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Errorf("downloaded the unchanged catalog %d times, want 1", n)
+	}
+
+	// A fetch failure keeps serving the last good catalog.
+	fail.Store(true)
+	if n, err := svc.reload(); err != nil || n != 2 {
+		t.Errorf("reload() with the source down = %d, %v, want the last good 2 products", n, err)
+	}
+
+	// Without a last good copy the failure is reported.
+	src, err = newCatalogSource(context.Background(), srv.URL+"/other.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogSrc = src
+	if err := loadCatalog(&pb.ListProductsResponse{}); err == nil {
+		t.Error("loadCatalog() from an unavailable source without a last good copy succeeded")
+	}
+}
+
+// blockingSource is a catalog source whose fetch waits for release.
+type blockingSource struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSource) fetch(ctx context.Context) ([]byte, error) {
+	close(s.started)
+	<-s.release
+	return []byte(`{"products": [{"id": "B", "name": "Cup", "priceUsd": {"currencyCode": "USD", "units": 2}}]}`), nil
+}
+
+func (s *blockingSource) String() string { return "blocking" }
+
+func TestLoadCatalogFetchesOutsideLock(t *testing.T) {
+	defer func(s catalogSource) { catalogSrc = s }(catalogSrc)
+	src := &blockingSource{started: make(chan struct{}), release: make(chan struct{})}
+	catalogSrc = src
+	svc := &productCatalog{catalog: pb.ListProductsResponse{Products: []*pb.Product{{Id: "A"}}}}
+
+	reloaded := make(chan error)
+	go func() {
+		_, err := svc.reload()
+		reloaded <- err
+	}()
+	<-src.started
+	// A slow fetch must not hold up requests served from the cached catalog.
+	read := make(chan []*pb.Product)
+	go func() { read <- svc.products() }()
+	select {
+	case got := <-read:
+		if len(got) != 1 || got[0].Id != "A" {
+			t.Errorf("products() during the fetch = %v, want the cached product A", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("products() blocked behind the catalog fetch")
+	}
+
+	close(src.release)
+	if err := <-reloaded; err != nil {
+		t.Fatal(err)
+	}
+	if got := svc.products(); len(got) != 1 || got[0].Id != "B" {
+		t.Errorf("products() after the reload = %v, want the fetched product B", got)
+	}
+}
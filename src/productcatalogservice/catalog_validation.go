@@ -0,0 +1,140 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+	"github.com/golang/protobuf/jsonpb"
+)
+
+// catalogSchemaVersion is the newest products.json schema this service
+// understands. Files without a schemaVersion are treated as version 1.
+const catalogSchemaVersion = 1
+
+var currencyCodeRe = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// catalogVersion is the version of the most recently loaded catalog file, as
+// declared by its top-level "version" field. Guarded by catalogMutex.
+var catalogVersion string
+
+// catalogFileHeader holds the top-level metadata of products.json.
+type catalogFileHeader struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Version       string `json:"version"`
+}
+
+// parseCatalogJSON parses a products.json document into catalog and returns
+// the catalog version it declares. Invalid products are dropped and logged,
+// or fail the whole load if strict is set.
+func parseCatalogJSON(data []byte, catalog *pb.ListProductsResponse, strict bool) (string, error) {
+	var header catalogFileHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return "", err
+	}
+	if header.SchemaVersion == 0 {
+		header.SchemaVersion = 1
+	}
+	if header.SchemaVersion > catalogSchemaVersion {
+		return "", fmt.Errorf("unsupported catalog schema version %d (max %d)",
+			header.SchemaVersion, catalogSchemaVersion)
+	}
+
+	// The metadata fields are not part of ListProductsResponse.
+	var parsed pb.ListProductsResponse
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err := unmarshaler.Unmarshal(bytes.NewReader(data), &parsed); err != nil {
+		return "", err
+	}
+
+	products, err := validateProducts(parsed.Products, strict)
+	if err != nil {
+		return "", err
+	}
+	catalog.Products = products
+	return header.Version, nil
+}
+
+// validateProducts returns the valid products. In strict mode the first
+// invalid product is returned as an error instead of being skipped.
+func validateProducts(products []*pb.Product, strict bool) ([]*pb.Product, error) {
+	valid := make([]*pb.Product, 0, len(products))
+	for i, p := range products {
+		if err := validateProduct(p); err != nil {
+			if strict {
+				return nil, fmt.Errorf("invalid product at index %d: %v", i, err)
+			}
+			log.Warnf("skipping invalid product at index %d: %v", i, err)
+			continue
+		}
+		valid = append(valid, p)
+	}
+	return valid, nil
+}
+
+func validateProduct(p *pb.Product) error {
+	if p.GetId() == "" {
+		return fmt.Errorf("missing id")
+	}
+	if p.GetName() == "" {
+		return fmt.Errorf("product %s: missing name", p.GetId())
+	}
+	price := p.GetPriceUsd()
+	if price == nil {
+		return fmt.Errorf("product %s: missing priceUsd", p.GetId())
+	}
+	if err := validatePrice(price); err != nil {
+		return fmt.Errorf("product %s: %v", p.GetId(), err)
+	}
+	if sale := p.GetSalePriceUsd(); sale != nil {
+		if err := validatePrice(sale); err != nil {
+			return fmt.Errorf("product %s: sale price: %v", p.GetId(), err)
+		}
+		if sale.GetCurrencyCode() != price.GetCurrencyCode() {
+			return fmt.Errorf("product %s: sale price in %s, price in %s", p.GetId(), sale.GetCurrencyCode(), price.GetCurrencyCode())
+		}
+		if nanosOf(sale) >= nanosOf(price) {
+			return fmt.Errorf("product %s: sale price is not below the price", p.GetId())
+		}
+	}
+	if p.Stock != nil && p.GetStock() < 0 {
+		return fmt.Errorf("product %s: negative stock %d", p.GetId(), p.GetStock())
+	}
+	for k := range p.GetAttributes() {
+		if k == "" {
+			return fmt.Errorf("product %s: empty attribute name", p.GetId())
+		}
+	}
+	return nil
+}
+
+func validatePrice(m *pb.Money) error {
+	if !currencyCodeRe.MatchString(m.GetCurrencyCode()) {
+		return fmt.Errorf("invalid currency code %q", m.GetCurrencyCode())
+	}
+	if m.GetUnits() < 0 || m.GetNanos() < 0 || m.GetNanos() > 999999999 {
+		return fmt.Errorf("invalid price %d.%09d", m.GetUnits(), m.GetNanos())
+	}
+	return nil
+}
+
+// nanosOf returns a validated, non-negative amount in billionths.
+func nanosOf(m *pb.Money) int64 {
+	return m.GetUnits()*1e9 + int64(m.GetNanos())
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+	"google.golang.org/protobuf/proto"
+)
+
+const validCatalogJSON = `{
+  "schemaVersion": 1,
+  "version": "v42",
+  "products": [
+    {"id": "A1", "name": "Alpha", "priceUsd": {"currencyCode": "USD", "units": 10, "nanos": 500000000}, "attributes": {"color": "red"}},
+    {"id": "B2", "name": "Beta", "priceUsd": {"currencyCode": "USD", "units": 3}}
+  ]
+}`
+
+const oneBadProductCatalogJSON = `{
+  "products": [
+    {"id": "A1", "name": "Alpha", "priceUsd": {"currencyCode": "USD", "units": 10}},
+    {"id": "B2", "name": "Beta", "priceUsd": {"currencyCode": "usd", "units": 3}}
+  ]
+}`
+
+func TestParseCatalogJSONValid(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		var catalog pb.ListProductsResponse
+		version, err := parseCatalogJSON([]byte(validCatalogJSON), &catalog, strict)
+		if err != nil {
+			t.Fatalf("strict=%v: %v", strict, err)
+		}
+		if version != "v42" {
+			t.Errorf("strict=%v: version = %q, want v42", strict, version)
+		}
+		if got := len(catalog.Products); got != 2 {
+			t.Fatalf("strict=%v: got %d products, want 2", strict, got)
+		}
+		if got := catalog.Products[0].Attributes["color"]; got != "red" {
+			t.Errorf("strict=%v: color = %q, want red", strict, got)
+		}
+		if got := catalog.Products[1].Attributes; len(got) != 0 {
+			t.Errorf("strict=%v: attributes = %v, want none", strict, got)
+		}
+	}
+}
+
+func TestParseCatalogJSONOneBadProduct(t *testing.T) {
+	var catalog pb.ListProductsResponse
+	if _, err := parseCatalogJSON([]byte(oneBadProductCatalogJSON), &catalog, false); err != nil {
+		t.Fatalf("lenient mode: %v", err)
+	}
+	if len(catalog.Products) != 1 || catalog.Products[0].Id != "A1" {
+		t.Errorf("lenient mode: got %v, want only A1", catalog.Products)
+	}
+
+	if _, err := parseCatalogJSON([]byte(oneBadProductCatalogJSON), &pb.ListProductsResponse{}, true); err == nil {
+		t.Error("strict mode: expected an error")
+	}
+}
+
+func TestParseCatalogJSONUnsupportedSchema(t *testing.T) {
+	_, err := parseCatalogJSON([]byte(`{"schemaVersion": 2, "products": []}`), &pb.ListProductsResponse{}, false)
+	if err == nil {
+		t.Error("expected an error for a newer schema version")
+	}
+}
+
+func TestValidateProduct(t *testing.T) {
+	usd := func(units int64, nanos int32) *pb.Money {
+		return &pb.Money{CurrencyCode: "USD", Units: units, Nanos: nanos}
+	}
+	tests := []struct {
+		name    string
+		product *pb.Product
+		wantErr bool
+	}{
+		{"valid", &pb.Product{Id: "A", Name: "a", PriceUsd: usd(1, 0)}, false},
+		{"free", &pb.Product{Id: "A", Name: "a", PriceUsd: usd(0, 0)}, false},
+		{"missing id", &pb.Product{Name: "a", PriceUsd: usd(1, 0)}, true},
+		{"missing name", &pb.Product{Id: "A", PriceUsd: usd(1, 0)}, true},
+		{"missing price", &pb.Product{Id: "A", Name: "a"}, true},
+		{"bad currency", &pb.Product{Id: "A", Name: "a", PriceUsd: &pb.Money{CurrencyCode: "DOLLARS", Units: 1}}, true},
+		{"negative units", &pb.Product{Id: "A", Name: "a", PriceUsd: usd(-1, 0)}, true},
+		{"negative nanos", &pb.Product{Id: "A", Name: "a", PriceUsd: usd(0, -5)}, true},
+		{"nanos overflow", &pb.Product{Id: "A", Name: "a", PriceUsd: usd(1, 1000000000)}, true},
+		{"on sale", &pb.Product{Id: "A", Name: "a", PriceUsd: usd(2, 0), SalePriceUsd: usd(1, 500000000)}, false},
+		{"sale not below price", &pb.Product{Id: "A", Name: "a", PriceUsd: usd(2, 0), SalePriceUsd: usd(2, 0)}, true},
+		{"sale in other currency", &pb.Product{Id: "A", Name: "a", PriceUsd: usd(2, 0), SalePriceUsd: &pb.Money{CurrencyCode: "EUR", Units: 1}}, true},
+		{"in stock", &pb.Product{Id: "A", Name: "a", PriceUsd: usd(1, 0), Stock: proto.Int32(0)}, false},
+		{"negative stock", &pb.Product{Id: "A", Name: "a", PriceUsd: usd(1, 0), Stock: proto.Int32(-1)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateProduct(tt.product); (err != nil) != tt.wantErr {
+				t.Errorf("validateProduct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShippedCatalogIsValid(t *testing.T) {
+	data, err := os.ReadFile("products.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var catalog pb.ListProductsResponse
+	if _, err := parseCatalogJSON(data, &catalog, true); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// dataSource is where a catalog RPC reads products from.
+type dataSource int
+
+const (
+	dataSourceCache dataSource = iota
+	dataSourceDatabase
+)
+
+func (d dataSource) String() string {
+	if d == dataSourceDatabase {
+		return "database"
+	}
+	return "cache"
+}
+
+// dataSourceKey is the context key of the data source resolved for an RPC.
+type dataSourceKey struct{}
+
+// dataSourcePolicy decides the data source of each RPC:
+//
+//   - DATA_SOURCE ("cache" or "database") forces one for every request;
+//   - otherwise, with ENABLE_SELECTIVE_ROUTING=true, requests carrying the
+//     use-database: true metadata read AlloyDB and the rest the cache;
+//   - otherwise AlloyDB is used whenever ALLOYDB_CLUSTER_NAME is set.
+type dataSourcePolicy struct {
+	forced    string
+	selective bool
+	alloyDB   bool
+}
+
+func loadDataSourcePolicy() dataSourcePolicy {
+	p := dataSourcePolicy{
+		forced:    strings.ToLower(strings.TrimSpace(os.Getenv("DATA_SOURCE"))),
+		selective: os.Getenv("ENABLE_SELECTIVE_ROUTING") == "true",
+		alloyDB:   os.Getenv("ALLOYDB_CLUSTER_NAME") != "",
+	}
+	if p.forced != "" && p.forced != "cache" && p.forced != "database" {
+		log.Warnf("invalid DATA_SOURCE %q, routing requests per ENABLE_SELECTIVE_ROUTING", p.forced)
+		p.forced = ""
+	}
+	return p
+}
+
+// resolve picks the data source for a request with incoming context ctx.
+func (p dataSourcePolicy) resolve(ctx context.Context) dataSource {
+	switch {
+	case p.forced == "database":
+		return dataSourceDatabase
+	case p.forced == "cache":
+		return dataSourceCache
+	case !p.selective:
+		if p.alloyDB {
+			return dataSourceDatabase
+		}
+		return dataSourceCache
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("use-database"); len(values) > 0 && values[0] == "true" {
+			return dataSourceDatabase
+		}
+	}
+	// Default to cache for performance when selective routing is enabled
+	return dataSourceCache
+}
+
+// dataSourceInterceptor resolves the data source of each RPC once, so the
+// handlers only read it back with usesDatabase.
+func dataSourceInterceptor(p dataSourcePolicy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		source := p.resolve(ctx)
+		log.Debugf("%s served from %s", info.FullMethod, source)
+		return handler(context.WithValue(ctx, dataSourceKey{}, source), req)
+	}
+}
+
+// usesDatabase reports whether the RPC with context ctx reads AlloyDB.
+// Contexts that did not pass through dataSourceInterceptor are resolved
+// against the current environment.
+func usesDatabase(ctx context.Context) bool {
+	source, ok := ctx.Value(dataSourceKey{}).(dataSource)
+	if !ok {
+		source = loadDataSourcePolicy().resolve(ctx)
+	}
+	return source == dataSourceDatabase
+}
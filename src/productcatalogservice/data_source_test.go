@@ -0,0 +1,72 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestDataSourceInterceptor(t *testing.T) {
+	withHeader := metadata.NewIncomingContext(context.Background(), metadata.Pairs("use-database", "true"))
+	tests := []struct {
+		name   string
+		env    map[string]string
+		ctx    context.Context
+		wantDB bool
+	}{
+		{"header present", map[string]string{"ENABLE_SELECTIVE_ROUTING": "true"}, withHeader, true},
+		{"header absent", map[string]string{"ENABLE_SELECTIVE_ROUTING": "true"}, context.Background(), false},
+		{"header without selective routing", map[string]string{}, withHeader, false},
+		{"alloydb without selective routing", map[string]string{"ALLOYDB_CLUSTER_NAME": "c"}, context.Background(), true},
+		{"policy forces cache", map[string]string{"ENABLE_SELECTIVE_ROUTING": "true", "DATA_SOURCE": "cache"}, withHeader, false},
+		{"policy forces database", map[string]string{"ENABLE_SELECTIVE_ROUTING": "true", "DATA_SOURCE": "Database"}, context.Background(), true},
+		{"invalid policy ignored", map[string]string{"ENABLE_SELECTIVE_ROUTING": "true", "DATA_SOURCE": "disk"}, withHeader, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range []string{"ENABLE_SELECTIVE_ROUTING", "ALLOYDB_CLUSTER_NAME", "DATA_SOURCE"} {
+				t.Setenv(env, tt.env[env])
+			}
+			interceptor := dataSourceInterceptor(loadDataSourcePolicy())
+
+			var gotDB bool
+			_, err := interceptor(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/hipstershop.ProductCatalogService/ListProducts"},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					gotDB = usesDatabase(ctx)
+					return nil, nil
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotDB != tt.wantDB {
+				t.Errorf("usesDatabase = %v, want %v", gotDB, tt.wantDB)
+			}
+		})
+	}
+}
+
+func TestUsesDatabaseReadsResolvedSource(t *testing.T) {
+	// The resolved decision wins over metadata the handler might re-parse.
+	t.Setenv("ENABLE_SELECTIVE_ROUTING", "true")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("use-database", "true"))
+	ctx = context.WithValue(ctx, dataSourceKey{}, dataSourceCache)
+	if usesDatabase(ctx) {
+		t.Error("usesDatabase = true, want the resolved cache source")
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Database loads are retried dbRetryAttempts times, waiting dbRetryBackoff,
+// then twice that, between attempts, before the caller falls back to the
+// cache. Variables so tests can shorten them.
+var (
+	dbRetryAttempts = 2
+	dbRetryBackoff  = 50 * time.Millisecond
+)
+
+// Database failures by kind: transient ones succeeded on a retry, persistent
+// ones exhausted the retries and were answered from the cache.
+var (
+	dbTransientFailures  atomic.Int64
+	dbPersistentFailures atomic.Int64
+)
+
+// withDBRetry runs load, retrying failures with a short exponential backoff
+// until it succeeds, the retries run out or ctx is done. A missing row is not
+// retried.
+func withDBRetry[T any](ctx context.Context, op string, load func() (T, error)) (T, error) {
+	backoff := dbRetryBackoff
+	var failures int
+	for {
+		v, err := load()
+		if err == nil {
+			if failures > 0 {
+				dbTransientFailures.Add(1)
+				log.WithField("op", op).WithField("failure", "transient").WithField("attempts", failures+1).
+					Warn("database load succeeded after retrying")
+			}
+			return v, nil
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return v, err
+		}
+		failures++
+		if failures > dbRetryAttempts {
+			dbPersistentFailures.Add(1)
+			log.WithField("op", op).WithField("failure", "persistent").WithField("attempts", failures).
+				Warnf("database load failed: %v", err)
+			return v, err
+		}
+		select {
+		case <-ctx.Done():
+			dbPersistentFailures.Add(1)
+			log.WithField("op", op).WithField("failure", "persistent").WithField("attempts", failures).
+				Warnf("database load failed, request ended before retrying: %v", err)
+			return v, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+)
+
+func shortDBRetries(t *testing.T) {
+	t.Helper()
+	attempts, backoff := dbRetryAttempts, dbRetryBackoff
+	t.Cleanup(func() { dbRetryAttempts, dbRetryBackoff = attempts, backoff })
+	dbRetryAttempts, dbRetryBackoff = 2, time.Millisecond
+}
+
+func TestWithDBRetryTransientFailure(t *testing.T) {
+	shortDBRetries(t)
+	transient, persistent := dbTransientFailures.Load(), dbPersistentFailures.Load()
+
+	calls := 0
+	got, err := withDBRetry(context.Background(), "test", func() (string, error) {
+		calls++
+		if calls == 1 {
+			return "", errors.New("connection reset")
+		}
+		return "fresh", nil
+	})
+	if err != nil || got != "fresh" {
+		t.Fatalf("withDBRetry() = %q, %v, want fresh", got, err)
+	}
+	if calls != 2 {
+		t.Errorf("load called %d times, want 2", calls)
+	}
+	if d := dbTransientFailures.Load() - transient; d != 1 {
+		t.Errorf("transient failures grew by %d, want 1", d)
+	}
+	if d := dbPersistentFailures.Load() - persistent; d != 0 {
+		t.Errorf("persistent failures grew by %d, want 0", d)
+	}
+}
+
+func TestWithDBRetryPersistentFailure(t *testing.T) {
+	shortDBRetries(t)
+	persistent := dbPersistentFailures.Load()
+
+	calls := 0
+	_, err := withDBRetry(context.Background(), "test", func() (string, error) {
+		calls++
+		return "", errors.New("database is down")
+	})
+	if err == nil {
+		t.Fatal("withDBRetry() succeeded, want an error")
+	}
+	if calls != 1+dbRetryAttempts {
+		t.Errorf("load called %d times, want %d", calls, 1+dbRetryAttempts)
+	}
+	if d := dbPersistentFailures.Load() - persistent; d != 1 {
+		t.Errorf("persistent failures grew by %d, want 1", d)
+	}
+}
+
+func TestWithDBRetryStopsWithContext(t *testing.T) {
+	shortDBRetries(t)
+	dbRetryBackoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	_, err := withDBRetry(ctx, "test", func() (string, error) {
+		calls++
+		return "", errors.New("database is down")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("withDBRetry() = %v after %d calls, want an error after 1", err, calls)
+	}
+}
+
+func TestSearchFallsBackToCacheAfterRetries(t *testing.T) {
+	shortDBRetries(t)
+	defer func(f string) { catalogFile = f }(catalogFile)
+	catalogFile = filepath.Join(t.TempDir(), "missing.json")
+	svc := &productCatalog{catalog: pb.ListProductsResponse{Products: []*pb.Product{{Id: "A", Name: "Cached mug"}}}}
+	persistent := dbPersistentFailures.Load()
+
+	resp, err := svc.searchProductsFromDatabase(context.Background(), &pb.SearchProductsRequest{Query: "mug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetResults()) != 1 || resp.GetResults()[0].GetId() != "A" {
+		t.Errorf("results = %v, want the cached product", resp.GetResults())
+	}
+	if d := dbPersistentFailures.Load() - persistent; d != 1 {
+		t.Errorf("persistent failures grew by %d, want 1", d)
+	}
+}
@@ -633,6 +633,7 @@ var ShippingService_ServiceDesc = grpc.ServiceDesc{
 const (
 	CurrencyService_GetSupportedCurrencies_FullMethodName = "/hipstershop.CurrencyService/GetSupportedCurrencies"
 	CurrencyService_Convert_FullMethodName                = "/hipstershop.CurrencyService/Convert"
+	CurrencyService_ConvertBatch_FullMethodName           = "/hipstershop.CurrencyService/ConvertBatch"
 )
 
 // CurrencyServiceClient is the client API for CurrencyService service.
@@ -641,6 +642,7 @@ const (
 type CurrencyServiceClient interface {
 	GetSupportedCurrencies(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*GetSupportedCurrenciesResponse, error)
 	Convert(ctx context.Context, in *CurrencyConversionRequest, opts ...grpc.CallOption) (*Money, error)
+	ConvertBatch(ctx context.Context, in *CurrencyConversionBatchRequest, opts ...grpc.CallOption) (*CurrencyConversionBatchResponse, error)
 }
 
 type currencyServiceClient struct {
@@ -671,12 +673,23 @@ func (c *currencyServiceClient) Convert(ctx context.Context, in *CurrencyConvers
 	return out, nil
 }
 
+func (c *currencyServiceClient) ConvertBatch(ctx context.Context, in *CurrencyConversionBatchRequest, opts ...grpc.CallOption) (*CurrencyConversionBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CurrencyConversionBatchResponse)
+	err := c.cc.Invoke(ctx, CurrencyService_ConvertBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CurrencyServiceServer is the server API for CurrencyService service.
 // All implementations must embed UnimplementedCurrencyServiceServer
 // for forward compatibility.
 type CurrencyServiceServer interface {
 	GetSupportedCurrencies(context.Context, *Empty) (*GetSupportedCurrenciesResponse, error)
 	Convert(context.Context, *CurrencyConversionRequest) (*Money, error)
+	ConvertBatch(context.Context, *CurrencyConversionBatchRequest) (*CurrencyConversionBatchResponse, error)
 	mustEmbedUnimplementedCurrencyServiceServer()
 }
 
@@ -693,6 +706,9 @@ func (UnimplementedCurrencyServiceServer) GetSupportedCurrencies(context.Context
 func (UnimplementedCurrencyServiceServer) Convert(context.Context, *CurrencyConversionRequest) (*Money, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Convert not implemented")
 }
+func (UnimplementedCurrencyServiceServer) ConvertBatch(context.Context, *CurrencyConversionBatchRequest) (*CurrencyConversionBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConvertBatch not implemented")
+}
 func (UnimplementedCurrencyServiceServer) mustEmbedUnimplementedCurrencyServiceServer() {}
 func (UnimplementedCurrencyServiceServer) testEmbeddedByValue()                         {}
 
@@ -750,6 +766,24 @@ func _CurrencyService_Convert_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CurrencyService_ConvertBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CurrencyConversionBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServiceServer).ConvertBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CurrencyService_ConvertBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServiceServer).ConvertBatch(ctx, req.(*CurrencyConversionBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CurrencyService_ServiceDesc is the grpc.ServiceDesc for CurrencyService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -765,6 +799,10 @@ var CurrencyService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Convert",
 			Handler:    _CurrencyService_Convert_Handler,
 		},
+		{
+			MethodName: "ConvertBatch",
+			Handler:    _CurrencyService_ConvertBatch_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "demo.proto",
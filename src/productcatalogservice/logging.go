@@ -0,0 +1,53 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// configureLogging applies LOG_LEVEL (a logrus level name, info by default)
+// and LOG_FORMAT ("json", the default, or "text") to l.
+func configureLogging(l *logrus.Logger) {
+	l.Level = logrus.InfoLevel
+	l.Formatter = &logrus.JSONFormatter{
+		FieldMap: logrus.FieldMap{
+			logrus.FieldKeyTime:  "timestamp",
+			logrus.FieldKeyLevel: "severity",
+			logrus.FieldKeyMsg:   "message",
+		},
+		TimestampFormat: time.RFC3339Nano,
+	}
+
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "json":
+	case "text":
+		l.Formatter = &logrus.TextFormatter{FullTimestamp: true, TimestampFormat: time.RFC3339Nano}
+	default:
+		l.Warnf("invalid LOG_FORMAT %q, using json", format)
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		level, err := logrus.ParseLevel(v)
+		if err != nil {
+			l.Warnf("invalid LOG_LEVEL %q, using %s", v, l.Level)
+			return
+		}
+		l.Level = level
+	}
+}
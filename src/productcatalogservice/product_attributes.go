@@ -0,0 +1,47 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+)
+
+// parseAttributes decodes a jsonb attributes value. SQL NULL and JSON null
+// both yield no attributes.
+func parseAttributes(raw []byte) (map[string]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var attrs map[string]string
+	if err := json.Unmarshal(raw, &attrs); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// matchesAttributes reports whether p has every attribute in want, comparing
+// values case-insensitively.
+func matchesAttributes(p *pb.Product, want map[string]string) bool {
+	for k, v := range want {
+		got, ok := p.GetAttributes()[k]
+		if !ok || !strings.EqualFold(got, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -16,25 +16,83 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
+	"sync"
 	"time"
 
 	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
 	"google.golang.org/grpc/codes"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
-	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
+const (
+	// healthProbeTimeout bounds how long a single data source probe may take.
+	healthProbeTimeout = 2 * time.Second
+	// healthCacheTTL is how long a probe result is reused before probing again.
+	healthCacheTTL = 5 * time.Second
+)
+
 type productCatalog struct {
 	pb.UnimplementedProductCatalogServiceServer
 	catalog pb.ListProductsResponse
+
+	// probe checks the backing data source. Defaults to probeDataSource.
+	probe func(ctx context.Context) error
+
+	// Cached database results of GetProduct by normalized id and of
+	// SearchProducts by searchCacheKey; nil when query caching is off.
+	productCache *queryCache[*pb.Product]
+	searchCache  *queryCache[*pb.SearchProductsResponse]
+
+	healthMu      sync.Mutex
+	healthChecked time.Time
+	healthErr     error
 }
 
 func (p *productCatalog) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if err := p.checkHealth(ctx); err != nil {
+		log.Warnf("health check failed: %v", err)
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+	}
 	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
 }
 
+// checkHealth probes the data source, reusing a recent result so frequent
+// health checks don't hammer the database.
+func (p *productCatalog) checkHealth(ctx context.Context) error {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+
+	if !p.healthChecked.IsZero() && time.Since(p.healthChecked) < healthCacheTTL {
+		return p.healthErr
+	}
+
+	probe := p.probe
+	if probe == nil {
+		probe = p.probeDataSource
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	p.healthErr = probe(ctx)
+	p.healthChecked = time.Now()
+	return p.healthErr
+}
+
+// probeDataSource pings AlloyDB when it is configured, otherwise makes sure
+// the local catalog can be loaded.
+func (p *productCatalog) probeDataSource(ctx context.Context) error {
+	if os.Getenv("ALLOYDB_CLUSTER_NAME") != "" {
+		return pingAlloyDB(ctx)
+	}
+	if len(p.parseCatalog()) == 0 {
+		return errors.New("product catalog is empty or could not be loaded")
+	}
+	return nil
+}
+
 func (p *productCatalog) Watch(req *healthpb.HealthCheckRequest, ws healthpb.Health_WatchServer) error {
 	return status.Errorf(codes.Unimplemented, "health check via Watch not implemented")
 }
@@ -42,7 +100,7 @@ func (p *productCatalog) Watch(req *healthpb.HealthCheckRequest, ws healthpb.Hea
 func (p *productCatalog) ListProducts(ctx context.Context, req *pb.Empty) (*pb.ListProductsResponse, error) {
 	time.Sleep(extraLatency)
 
-	if shouldUseDatabase(ctx) {
+	if usesDatabase(ctx) {
 		return p.getProductsFromDatabase(ctx)
 	}
 	return p.getProductsFromCache(ctx)
@@ -51,57 +109,55 @@ func (p *productCatalog) ListProducts(ctx context.Context, req *pb.Empty) (*pb.L
 func (p *productCatalog) GetProduct(ctx context.Context, req *pb.GetProductRequest) (*pb.Product, error) {
 	time.Sleep(extraLatency)
 
-	if shouldUseDatabase(ctx) {
-		return p.getProductFromDatabase(ctx, req.Id)
+	id := normalizeProductID(req.Id)
+	var product *pb.Product
+	var err error
+	if usesDatabase(ctx) {
+		product, err = p.getProductFromDatabase(ctx, id)
+	} else {
+		product, err = p.getProductFromCache(ctx, id)
 	}
-	return p.getProductFromCache(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	// Drafts are only visible to previews; to anyone else they don't exist.
+	if !isPublished(product) && !previewRequested(ctx) {
+		return nil, status.Errorf(codes.NotFound, "no product with ID %s", id)
+	}
+	return product, nil
 }
 
 func (p *productCatalog) SearchProducts(ctx context.Context, req *pb.SearchProductsRequest) (*pb.SearchProductsResponse, error) {
 	time.Sleep(extraLatency)
 
-	if shouldUseDatabase(ctx) {
-		return p.searchProductsFromDatabase(ctx, req.Query)
+	if usesDatabase(ctx) {
+		return p.searchProductsFromDatabase(ctx, req)
 	}
-	return p.searchProductsFromCache(ctx, req.Query)
+	return p.searchProductsFromCache(ctx, req)
 }
 
 func (p *productCatalog) parseCatalog() []*pb.Product {
-	if reloadCatalog || len(p.catalog.Products) == 0 {
+	if reloadCatalog || len(p.products()) == 0 {
 		err := loadCatalog(&p.catalog)
 		if err != nil {
 			return []*pb.Product{}
 		}
 	}
 
-	return p.catalog.Products
+	return p.products()
 }
 
-// shouldUseDatabase checks request headers to determine data source routing
-func shouldUseDatabase(ctx context.Context) bool {
-	// Feature flag: only enable selective routing if explicitly configured
-	if os.Getenv("ENABLE_SELECTIVE_ROUTING") != "true" {
-		// Default behavior: use existing logic (AlloyDB if configured, else local file)
-		return os.Getenv("ALLOYDB_CLUSTER_NAME") != ""
-	}
-
-	// Check for gRPC metadata requesting database access
-	if md, ok := metadata.FromIncomingContext(ctx); ok {
-		if values := md.Get("use-database"); len(values) > 0 && values[0] == "true" {
-			log.Info("Request header indicates database access required")
-			return true
-		}
-	}
-
-	// Default to cache for performance when selective routing is enabled
-	log.Info("Using cache for fast response")
-	return false
+// products returns the cached catalog. Reloads swap it under catalogMutex.
+func (p *productCatalog) products() []*pb.Product {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+	return p.catalog.Products
 }
 
 // getProductsFromCache returns products from the cached catalog
 func (p *productCatalog) getProductsFromCache(ctx context.Context) (*pb.ListProductsResponse, error) {
 	log.Info("Loading products from cache")
-	return &pb.ListProductsResponse{Products: p.parseCatalog()}, nil
+	return &pb.ListProductsResponse{Products: publishedProducts(p.parseCatalog())}, nil
 }
 
 // getProductsFromDatabase forces a fresh load from AlloyDB
@@ -109,15 +165,24 @@ func (p *productCatalog) getProductsFromDatabase(ctx context.Context) (*pb.ListP
 	log.Info("Loading products from database (forced reload)")
 
 	// Create a fresh catalog response to force database reload
-	freshCatalog := pb.ListProductsResponse{}
-	err := loadCatalog(&freshCatalog)
+	products, err := withDBRetry(ctx, "list_products", loadFreshCatalog)
 	if err != nil {
 		log.Warnf("Database load failed, falling back to cache: %v", err)
 		// Fallback to cache if database fails
 		return p.getProductsFromCache(ctx)
 	}
 
-	return &pb.ListProductsResponse{Products: freshCatalog.Products}, nil
+	return &pb.ListProductsResponse{Products: publishedProducts(products)}, nil
+}
+
+// loadFreshCatalog loads the catalog from its data source, bypassing the
+// cached copy.
+func loadFreshCatalog() ([]*pb.Product, error) {
+	freshCatalog := pb.ListProductsResponse{}
+	if err := loadCatalog(&freshCatalog); err != nil {
+		return nil, err
+	}
+	return freshCatalog.Products, nil
 }
 
 // getProductFromCache finds a product by ID in the cached catalog
@@ -125,9 +190,10 @@ func (p *productCatalog) getProductFromCache(ctx context.Context, productID stri
 	log.Infof("Looking up product %s from cache", productID)
 
 	var found *pb.Product
-	for i := 0; i < len(p.parseCatalog()); i++ {
-		if productID == p.parseCatalog()[i].Id {
-			found = p.parseCatalog()[i]
+	productID = normalizeProductID(productID)
+	for _, product := range p.parseCatalog() {
+		if normalizeProductID(product.Id) == productID {
+			found = product
 			break
 		}
 	}
@@ -148,13 +214,20 @@ func (p *productCatalog) getProductFromDatabase(ctx context.Context, productID s
 		return p.getProductFromCache(ctx, productID)
 	}
 
+	if product, ok := p.productCache.get(productID); ok {
+		return product, nil
+	}
+
 	// Direct database lookup for single product
-	product, err := loadSingleProductFromAlloyDB(productID)
+	product, err := withDBRetry(ctx, "get_product", func() (*pb.Product, error) {
+		return loadSingleProductFromAlloyDB(productID)
+	})
 	if err != nil {
 		log.Warnf("Database lookup failed for product %s: %v, falling back to cache", productID, err)
 		// Fallback to cache if database fails
 		return p.getProductFromCache(ctx, productID)
 	}
 
+	p.productCache.put(productID, product)
 	return product, nil
 }
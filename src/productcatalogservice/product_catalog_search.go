@@ -22,41 +22,101 @@ import (
 )
 
 // searchProductsFromCache performs search in the cached catalog
-func (p *productCatalog) searchProductsFromCache(ctx context.Context, query string) (*pb.SearchProductsResponse, error) {
-	log.Infof("Searching products in cache for query: %s", query)
-
-	var ps []*pb.Product
-	for _, product := range p.parseCatalog() {
-		if strings.Contains(strings.ToLower(product.Name), strings.ToLower(query)) ||
-			strings.Contains(strings.ToLower(product.Description), strings.ToLower(query)) {
-			ps = append(ps, product)
-		}
-	}
+func (p *productCatalog) searchProductsFromCache(ctx context.Context, req *pb.SearchProductsRequest) (*pb.SearchProductsResponse, error) {
+	log.Infof("Searching products in cache for query: %s", req.Query)
 
-	return &pb.SearchProductsResponse{Results: ps}, nil
+	return searchProducts(p.parseCatalog(), req), nil
 }
 
 // searchProductsFromDatabase performs search with fresh database data
-func (p *productCatalog) searchProductsFromDatabase(ctx context.Context, query string) (*pb.SearchProductsResponse, error) {
-	log.Infof("Searching products in database for query: %s", query)
+func (p *productCatalog) searchProductsFromDatabase(ctx context.Context, req *pb.SearchProductsRequest) (*pb.SearchProductsResponse, error) {
+	log.Infof("Searching products in database for query: %s", req.Query)
+
+	key := searchCacheKey(req)
+	if resp, ok := p.searchCache.get(key); ok {
+		return resp, nil
+	}
 
 	// Force fresh load from database
-	freshCatalog := pb.ListProductsResponse{}
-	err := loadCatalog(&freshCatalog)
+	products, err := withDBRetry(ctx, "search_products", loadFreshCatalog)
 	if err != nil {
 		log.Warnf("Database load failed, falling back to cache: %v", err)
 		// Fallback to cache if database fails
-		return p.searchProductsFromCache(ctx, query)
+		return p.searchProductsFromCache(ctx, req)
 	}
 
 	// Search in fresh database results
-	var ps []*pb.Product
-	for _, product := range freshCatalog.Products {
-		if strings.Contains(strings.ToLower(product.Name), strings.ToLower(query)) ||
-			strings.Contains(strings.ToLower(product.Description), strings.ToLower(query)) {
-			ps = append(ps, product)
-		}
+	resp := searchProducts(products, req)
+	p.searchCache.put(key, resp)
+	return resp, nil
+}
+
+// Relevance tiers of a search match, best first.
+const (
+	matchExactName = iota
+	matchNamePrefix
+	matchNameSubstring
+	matchDescription
+	noMatch
+)
+
+// Score and matched field reported for each relevance tier. Scores decrease
+// with the tier, so results are ordered by non-increasing score.
+var (
+	tierScores = [noMatch]float32{
+		matchExactName:     1,
+		matchNamePrefix:    0.75,
+		matchNameSubstring: 0.5,
+		matchDescription:   0.25,
 	}
+	tierFields = [noMatch]string{
+		matchExactName:     "name",
+		matchNamePrefix:    "name",
+		matchNameSubstring: "name",
+		matchDescription:   "description",
+	}
+)
+
+// searchRank returns how well product matches the lower-cased query.
+func searchRank(product *pb.Product, query string) int {
+	name := strings.ToLower(product.Name)
+	switch {
+	case name == query:
+		return matchExactName
+	case strings.HasPrefix(name, query):
+		return matchNamePrefix
+	case strings.Contains(name, query):
+		return matchNameSubstring
+	case strings.Contains(strings.ToLower(product.Description), query):
+		return matchDescription
+	}
+	return noMatch
+}
 
-	return &pb.SearchProductsResponse{Results: ps}, nil
+// searchProducts returns the published products whose name or description
+// contains the query and whose attributes match the requested ones, most
+// relevant first: exact name matches, then name prefixes, name substrings and
+// description substrings. Products within a tier keep their catalog order, and
+// products listed more than once are returned once. Each result comes with the
+// score and matched field of its tier.
+func searchProducts(products []*pb.Product, req *pb.SearchProductsRequest) *pb.SearchProductsResponse {
+	query := strings.ToLower(req.Query)
+	var tiers [noMatch][]*pb.Product
+	seen := make(map[string]bool)
+	for _, product := range products {
+		rank := searchRank(product, query)
+		if rank == noMatch || seen[product.Id] || !isPublished(product) || !matchesAttributes(product, req.Attributes) {
+			continue
+		}
+		seen[product.Id] = true
+		tiers[rank] = append(tiers[rank], product)
+	}
+	resp := &pb.SearchProductsResponse{}
+	for rank, tier := range tiers {
+		for _, product := range tier {
+			resp.Results = append(resp.Results, product)
+			resp.Matches = append(resp.Matches, &pb.SearchMatch{Score: tierScores[rank], MatchedField: tierFields[rank]})
+		}
+	}
+	return resp
 }
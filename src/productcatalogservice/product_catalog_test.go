@@ -16,11 +16,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
+	"reflect"
 	"testing"
 
 	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 )
 
@@ -76,6 +79,21 @@ func TestGetProductNotFound(t *testing.T) {
 	}
 }
 
+func TestGetProductNormalizesID(t *testing.T) {
+	for _, id := range []string{" abc003", "abc003\n", "ABC003", "  Abc003  "} {
+		product, err := mockProductCatalog.GetProduct(context.Background(),
+			&pb.GetProductRequest{Id: id},
+		)
+		if err != nil {
+			t.Errorf("GetProduct(%q): %v", id, err)
+			continue
+		}
+		if got, want := product.Id, "abc003"; got != want {
+			t.Errorf("GetProduct(%q) = %s, want %s", id, got, want)
+		}
+	}
+}
+
 func TestListProducts(t *testing.T) {
 	products, err := mockProductCatalog.ListProducts(context.Background(),
 		&pb.Empty{},
@@ -99,3 +117,159 @@ func TestSearchProducts(t *testing.T) {
 		t.Errorf("got %d, want %d", got, want)
 	}
 }
+
+func TestSearchProductsByAttributes(t *testing.T) {
+	catalog := &productCatalog{catalog: pb.ListProductsResponse{Products: []*pb.Product{
+		{Id: "p1", Name: "Blue Track Pants", Attributes: map[string]string{"color": "blue", "brand": "Yorker"}},
+		{Id: "p2", Name: "Multicolor Track Pants", Attributes: map[string]string{"color": "multicolor", "brand": "Yorker"}},
+		{Id: "p3", Name: "Blue T-Shirt", Attributes: map[string]string{"color": "Blue"}},
+		{Id: "p4", Name: "Plain Socks"},
+	}}}
+
+	tests := []struct {
+		name string
+		req  *pb.SearchProductsRequest
+		want []string
+	}{
+		{"no filter", &pb.SearchProductsRequest{Query: "pants"}, []string{"p1", "p2"}},
+		{"attribute only", &pb.SearchProductsRequest{Attributes: map[string]string{"color": "blue"}}, []string{"p1", "p3"}},
+		{"query and attribute", &pb.SearchProductsRequest{Query: "pants", Attributes: map[string]string{"color": "BLUE"}}, []string{"p1"}},
+		{"all attributes must match", &pb.SearchProductsRequest{Attributes: map[string]string{"color": "blue", "brand": "yorker"}}, []string{"p1"}},
+		{"unknown attribute", &pb.SearchProductsRequest{Attributes: map[string]string{"size": "m"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := catalog.SearchProducts(context.Background(), tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range resp.Results {
+				got = append(got, p.Id)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	for _, raw := range []string{"", "null"} {
+		if attrs, err := parseAttributes([]byte(raw)); err != nil || len(attrs) != 0 {
+			t.Errorf("parseAttributes(%q) = %v, %v, want no attributes", raw, attrs, err)
+		}
+	}
+	attrs, err := parseAttributes([]byte(`{"color": "red"}`))
+	if err != nil || attrs["color"] != "red" {
+		t.Errorf("parseAttributes = %v, %v", attrs, err)
+	}
+	if _, err := parseAttributes([]byte(`{"size": 42}`)); err == nil {
+		t.Error("expected an error for a non-string attribute")
+	}
+}
+
+func TestCheckHealthy(t *testing.T) {
+	catalog := &productCatalog{probe: func(ctx context.Context) error { return nil }}
+	resp, err := catalog.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Status, healthpb.HealthCheckResponse_SERVING; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCheckUnhealthyDatabase(t *testing.T) {
+	catalog := &productCatalog{probe: func(ctx context.Context) error { return errors.New("connection refused") }}
+	resp, err := catalog.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Status, healthpb.HealthCheckResponse_NOT_SERVING; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCheckCachesProbeResult(t *testing.T) {
+	calls := 0
+	catalog := &productCatalog{probe: func(ctx context.Context) error {
+		calls++
+		return nil
+	}}
+	for i := 0; i < 3; i++ {
+		if _, err := catalog.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := calls, 1; got != want {
+		t.Errorf("got %d probes, want %d", got, want)
+	}
+}
+
+func TestCheckCacheMode(t *testing.T) {
+	resp, err := mockProductCatalog.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Status, healthpb.HealthCheckResponse_SERVING; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestSearchProductsRelevanceOrder(t *testing.T) {
+	catalog := &productCatalog{catalog: pb.ListProductsResponse{Products: []*pb.Product{
+		{Id: "description", Name: "Sofa", Description: "Great for watching TV"},
+		{Id: "substring", Name: "Smart Watch"},
+		{Id: "prefix", Name: "Watch Strap"},
+		{Id: "exact", Name: "WATCH"},
+		{Id: "prefix", Name: "Watch Strap"},
+		{Id: "none", Name: "Mug"},
+	}}}
+
+	resp, err := catalog.SearchProducts(context.Background(), &pb.SearchProductsRequest{Query: "Watch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range resp.Results {
+		got = append(got, p.Id)
+	}
+	if want := []string{"exact", "prefix", "substring", "description"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSearchProductsScores(t *testing.T) {
+	catalog := &productCatalog{catalog: pb.ListProductsResponse{Products: []*pb.Product{
+		{Id: "description", Name: "Sofa", Description: "Great for watching TV"},
+		{Id: "substring", Name: "Smart Watch"},
+		{Id: "prefix", Name: "Watch Strap"},
+		{Id: "exact", Name: "WATCH"},
+		{Id: "prefix2", Name: "Watch Band"},
+	}}}
+
+	resp, err := catalog.SearchProducts(context.Background(), &pb.SearchProductsRequest{Query: "Watch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Matches) != len(resp.Results) {
+		t.Fatalf("got %d matches for %d results", len(resp.Matches), len(resp.Results))
+	}
+	var fields []string
+	for i, m := range resp.Matches {
+		if m.Score <= 0 || m.Score > 1 {
+			t.Errorf("result %s has score %v, want one in (0, 1]", resp.Results[i].Id, m.Score)
+		}
+		if i > 0 && m.Score > resp.Matches[i-1].Score {
+			t.Errorf("score of %s (%v) exceeds the one ranked above it (%v)", resp.Results[i].Id, m.Score, resp.Matches[i-1].Score)
+		}
+		fields = append(fields, m.MatchedField)
+	}
+	if want := []string{"name", "name", "name", "name", "description"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("matched fields = %v, want %v", fields, want)
+	}
+	if resp.Matches[0].Score == resp.Matches[1].Score || resp.Matches[1].Score != resp.Matches[2].Score {
+		t.Errorf("scores = %v, want the exact match above the two equally ranked prefixes", resp.Matches)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "strings"
+
+// normalizeProductID returns the canonical form of a product ID: trimmed of
+// surrounding whitespace and upper-cased. Product IDs are case-insensitive,
+// so lookups compare canonical forms on both sides. products.json products
+// keep the ID they were written with; AlloyDB stores IDs in canonical form,
+// so queries compare the id column as is and can use its index.
+func normalizeProductID(id string) string {
+	return strings.ToUpper(strings.TrimSpace(id))
+}
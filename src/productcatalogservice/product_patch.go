@@ -0,0 +1,238 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/jackc/pgx/v5"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+)
+
+// productPatch is the body of PATCH /admin/product/{id}: the mutable product
+// fields, named as in products.json. Fields left out are not changed.
+type productPatch struct {
+	Name        *string    `json:"name"`
+	Description *string    `json:"description"`
+	PriceUsd    *moneyJSON `json:"priceUsd"`
+	Categories  []string   `json:"categories"`
+	Stock       *int32     `json:"stock"`
+}
+
+type moneyJSON struct {
+	CurrencyCode string `json:"currencyCode"`
+	Units        int64  `json:"units"`
+	Nanos        int32  `json:"nanos"`
+}
+
+// errProductNotFound is returned by patchProduct for unknown product ids.
+var errProductNotFound = errors.New("product not found")
+
+// patchError is a patch that cannot be applied, answered with 400.
+type patchError struct{ msg string }
+
+func (e *patchError) Error() string { return e.msg }
+
+// decodeProductPatch reads a patch, rejecting unknown fields and patches
+// that change nothing.
+func decodeProductPatch(r *http.Request) (*productPatch, error) {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	var patch productPatch
+	if err := dec.Decode(&patch); err != nil {
+		return nil, &patchError{fmt.Sprintf("invalid patch: %v", err)}
+	}
+	if patch.Name == nil && patch.Description == nil && patch.PriceUsd == nil &&
+		patch.Categories == nil && patch.Stock == nil {
+		return nil, &patchError{"patch changes no fields"}
+	}
+	for i, c := range patch.Categories {
+		if patch.Categories[i] = strings.ToLower(strings.TrimSpace(c)); patch.Categories[i] == "" {
+			return nil, &patchError{"categories must not be empty"}
+		}
+	}
+	return &patch, nil
+}
+
+// apply returns a copy of product with the patch applied, validated like a
+// catalog entry.
+func (patch *productPatch) apply(product *pb.Product) (*pb.Product, error) {
+	patched := proto.Clone(product).(*pb.Product)
+	if patch.Name != nil {
+		patched.Name = strings.TrimSpace(*patch.Name)
+	}
+	if patch.Description != nil {
+		patched.Description = *patch.Description
+	}
+	if m := patch.PriceUsd; m != nil {
+		patched.PriceUsd = &pb.Money{CurrencyCode: m.CurrencyCode, Units: m.Units, Nanos: m.Nanos}
+	}
+	if patch.Categories != nil {
+		patched.Categories = patch.Categories
+	}
+	if patch.Stock != nil {
+		patched.Stock = proto.Int32(*patch.Stock)
+	}
+	if err := validateProduct(patched); err != nil {
+		return nil, &patchError{err.Error()}
+	}
+	return patched, nil
+}
+
+// patchProduct applies patch to the product with the given id in AlloyDB,
+// when the catalog lives there, and in the in-memory catalog, then drops
+// cached query results. Changes to products.json catalogs only last until
+// the next reload.
+func (p *productCatalog) patchProduct(ctx context.Context, id string, patch *productPatch) (*pb.Product, error) {
+	id = normalizeProductID(id)
+	var patched *pb.Product
+	if os.Getenv("ALLOYDB_CLUSTER_NAME") != "" {
+		var err error
+		if patched, err = patchProductInAlloyDB(ctx, id, patch); err != nil {
+			return nil, err
+		}
+	} else {
+		// Make sure the catalog is loaded before patching it.
+		p.parseCatalog()
+	}
+
+	catalogMutex.Lock()
+	products := append([]*pb.Product(nil), p.catalog.Products...)
+	for i, product := range products {
+		if normalizeProductID(product.Id) != id {
+			continue
+		}
+		if patched == nil {
+			var err error
+			if patched, err = patch.apply(product); err != nil {
+				catalogMutex.Unlock()
+				return nil, err
+			}
+		}
+		// Readers may still hold the old slice, so it is replaced, not
+		// written to.
+		products[i] = patched
+		p.catalog.Products = products
+		break
+	}
+	catalogMutex.Unlock()
+
+	if patched == nil {
+		return nil, errProductNotFound
+	}
+	p.purgeQueryCaches()
+	return patched, nil
+}
+
+// patchProductInAlloyDB validates the patch against the stored product and
+// writes the patched columns on the primary.
+func patchProductInAlloyDB(ctx context.Context, id string, patch *productPatch) (*pb.Product, error) {
+	schema, err := catalogSchemaFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if patch.Stock != nil && schema.stock == "" {
+		return nil, &patchError{"stock is not stored in AlloyDB: ALLOYDB_STOCK_COLUMN is not set"}
+	}
+	// Read from the primary: a lagging read pool could return a product
+	// missing recent patches, which this update would then overwrite.
+	product, err := loadSingleProduct(ctx, alloyDB.do, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errProductNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	patched, err := patch.apply(product)
+	if err != nil {
+		return nil, err
+	}
+
+	var sets []string
+	args := []any{id}
+	set := func(column string, value any) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if patch.Name != nil {
+		set(schema.column("name"), patched.Name)
+	}
+	if patch.Description != nil {
+		set(schema.column("description"), patched.Description)
+	}
+	if patch.PriceUsd != nil {
+		set(schema.column("price_usd_currency_code"), patched.PriceUsd.CurrencyCode)
+		set(schema.column("price_usd_units"), patched.PriceUsd.Units)
+		set(schema.column("price_usd_nanos"), patched.PriceUsd.Nanos)
+	}
+	if patch.Categories != nil {
+		set(schema.column("categories"), strings.Join(patched.Categories, ","))
+	}
+	if patch.Stock != nil {
+		set(pgx.Identifier{schema.stock}.Sanitize(), patched.GetStock())
+	}
+	query := "UPDATE " + schema.tableName() + " SET " + strings.Join(sets, ", ") +
+		" WHERE " + schema.column("id") + " = $1 RETURNING " + schema.column("id")
+
+	err = alloyDB.do(ctx, func(q dbQuerier) error {
+		var updated string
+		return q.QueryRow(ctx, query, args...).Scan(&updated)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errProductNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
+
+func (p *productCatalog) productPatchHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	patch, err := decodeProductPatch(r)
+	if err != nil {
+		writePatchError(w, id, err)
+		return
+	}
+	product, err := p.patchProduct(r.Context(), id, patch)
+	if err != nil {
+		writePatchError(w, id, err)
+		return
+	}
+	log.Infof("product %s patched through the admin endpoint", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	(&jsonpb.Marshaler{}).Marshal(w, product)
+}
+
+func writePatchError(w http.ResponseWriter, id string, err error) {
+	var invalid *patchError
+	switch {
+	case errors.As(err, &invalid):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": invalid.msg})
+	case errors.Is(err, errProductNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no product with ID " + id})
+	default:
+		log.Warnf("patching product %s failed: %v", id, err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "product update failed"})
+	}
+}
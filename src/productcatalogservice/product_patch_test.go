@@ -0,0 +1,123 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+	"google.golang.org/protobuf/proto"
+)
+
+func newPatchCatalog() *productCatalog {
+	return &productCatalog{
+		catalog: pb.ListProductsResponse{Products: []*pb.Product{
+			{Id: "A1", Name: "Alpha", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 10},
+				SalePriceUsd: &pb.Money{CurrencyCode: "USD", Units: 8}, Categories: []string{"kitchen"}},
+			{Id: "B2", Name: "Beta", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 2}},
+		}},
+		productCache: newQueryCache[*pb.Product](10, time.Minute),
+		searchCache:  newQueryCache[*pb.SearchProductsResponse](10, time.Minute),
+	}
+}
+
+func patchRequest(handler http.Handler, id, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/admin/product/"+id, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminPatchProduct(t *testing.T) {
+	svc := newPatchCatalog()
+	old := svc.catalog.Products[0]
+	svc.searchCache.put("alpha", &pb.SearchProductsResponse{Results: []*pb.Product{old}})
+
+	rec := patchRequest(svc.adminHandler("s3cret"), "a1", `{
+		"name": "Alpha Prime",
+		"priceUsd": {"currencyCode": "USD", "units": 12, "nanos": 500000000},
+		"categories": [" Kitchen", "Decor"],
+		"stock": 3
+	}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != "A1" || resp.Name != "Alpha Prime" {
+		t.Errorf("response = %+v, want the patched A1", resp)
+	}
+
+	got, err := svc.GetProduct(context.Background(), &pb.GetProductRequest{Id: "A1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &pb.Product{Id: "A1", Name: "Alpha Prime",
+		PriceUsd:     &pb.Money{CurrencyCode: "USD", Units: 12, Nanos: 500000000},
+		SalePriceUsd: &pb.Money{CurrencyCode: "USD", Units: 8},
+		Categories:   []string{"kitchen", "decor"}, Stock: proto.Int32(3)}
+	if !proto.Equal(got, want) {
+		t.Errorf("GetProduct = %v, want %v", got, want)
+	}
+	if old.Name != "Alpha" {
+		t.Errorf("patch modified the product in place: name %q", old.Name)
+	}
+	if _, ok := svc.searchCache.get("alpha"); ok {
+		t.Error("search cache still holds results from before the patch")
+	}
+}
+
+func TestAdminPatchProductValidation(t *testing.T) {
+	svc := newPatchCatalog()
+	handler := svc.adminHandler("s3cret")
+	before := append([]*pb.Product(nil), svc.catalog.Products...)
+
+	tests := []struct {
+		name, id, body string
+		want           int
+	}{
+		{"unknown field", "A1", `{"name": "X", "color": "red"}`, http.StatusBadRequest},
+		{"id is not mutable", "A1", `{"id": "Z9"}`, http.StatusBadRequest},
+		{"empty patch", "A1", `{}`, http.StatusBadRequest},
+		{"empty name", "A1", `{"name": "  "}`, http.StatusBadRequest},
+		{"negative stock", "A1", `{"stock": -1}`, http.StatusBadRequest},
+		{"bad currency", "A1", `{"priceUsd": {"currencyCode": "dollars", "units": 1}}`, http.StatusBadRequest},
+		{"price below sale price", "A1", `{"priceUsd": {"currencyCode": "USD", "units": 5}}`, http.StatusBadRequest},
+		{"empty category", "A1", `{"categories": ["kitchen", ""]}`, http.StatusBadRequest},
+		{"not json", "A1", `name=X`, http.StatusBadRequest},
+		{"unknown product", "Z9", `{"name": "X"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := patchRequest(handler, tt.id, tt.body); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+	if !reflect.DeepEqual(svc.catalog.Products, before) {
+		t.Error("rejected patches changed the catalog")
+	}
+}
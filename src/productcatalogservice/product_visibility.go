@@ -0,0 +1,50 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+	"google.golang.org/grpc/metadata"
+)
+
+// isPublished reports whether p is shown in listings and search. Products
+// that don't say are published.
+func isPublished(p *pb.Product) bool {
+	return p.Published == nil || p.GetPublished()
+}
+
+// publishedProducts returns the published products, in order.
+func publishedProducts(products []*pb.Product) []*pb.Product {
+	published := make([]*pb.Product, 0, len(products))
+	for _, p := range products {
+		if isPublished(p) {
+			published = append(published, p)
+		}
+	}
+	return published
+}
+
+// previewRequested reports whether the RPC carries the preview: true
+// metadata, which lets GetProduct return unpublished products.
+func previewRequested(ctx context.Context) bool {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("preview"); len(values) > 0 && values[0] == "true" {
+			return true
+		}
+	}
+	return false
+}
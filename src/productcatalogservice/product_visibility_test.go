@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUnpublishedProductsHidden(t *testing.T) {
+	catalog := &productCatalog{catalog: pb.ListProductsResponse{Products: []*pb.Product{
+		{Id: "p1", Name: "Blue Mug"},
+		{Id: "p2", Name: "Red Mug", Published: proto.Bool(false)},
+		{Id: "p3", Name: "Green Mug", Published: proto.Bool(true)},
+	}}}
+	ids := func(products []*pb.Product) []string {
+		var ids []string
+		for _, p := range products {
+			ids = append(ids, p.Id)
+		}
+		return ids
+	}
+	want := []string{"p1", "p3"}
+
+	list, err := catalog.ListProducts(context.Background(), &pb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(list.Products); !reflect.DeepEqual(got, want) {
+		t.Errorf("ListProducts = %v, want %v", got, want)
+	}
+
+	search, err := catalog.SearchProducts(context.Background(), &pb.SearchProductsRequest{Query: "mug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(search.Results); !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchProducts = %v, want %v", got, want)
+	}
+	if len(search.Matches) != len(search.Results) {
+		t.Errorf("got %d matches for %d results", len(search.Matches), len(search.Results))
+	}
+
+	_, err = catalog.GetProduct(context.Background(), &pb.GetProductRequest{Id: "p2"})
+	if got := status.Code(err); got != codes.NotFound {
+		t.Errorf("GetProduct of a draft without preview: code = %s, want %s", got, codes.NotFound)
+	}
+
+	preview := metadata.NewIncomingContext(context.Background(), metadata.Pairs("preview", "true"))
+	product, err := catalog.GetProduct(preview, &pb.GetProductRequest{Id: "p2"})
+	if err != nil {
+		t.Fatalf("GetProduct of a draft with preview: %v", err)
+	}
+	if product.Name != "Red Mug" {
+		t.Errorf("GetProduct with preview = %q, want Red Mug", product.Name)
+	}
+}
+
+func TestPublishedColumn(t *testing.T) {
+	t.Setenv("ALLOYDB_TABLE_NAME", "products")
+	t.Setenv("ALLOYDB_PUBLISHED_COLUMN", "is_live")
+	s, err := catalogSchemaFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `"id", "name", "description", "picture", "price_usd_currency_code", ` +
+		`"price_usd_units", "price_usd_nanos", "categories", NULL::jsonb, "is_live", NULL::integer`
+	if got := s.selectList(); got != want {
+		t.Errorf("selectList() = %s, want %s", got, want)
+	}
+}
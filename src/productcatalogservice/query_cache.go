@@ -0,0 +1,136 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+)
+
+// Database query results are cached when queryCacheSize is positive: up to
+// that many GetProduct and SearchProducts results each, for queryCacheTTL.
+// Off by default so database mode always serves fresh data. Set with
+// QUERY_CACHE_SIZE and QUERY_CACHE_TTL.
+var (
+	queryCacheSize int
+	queryCacheTTL  = 30 * time.Second
+)
+
+// queryCache is a fixed-size LRU cache whose entries expire ttl after they
+// were stored. A nil *queryCache caches nothing.
+type queryCache[V any] struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List // of *queryCacheEntry[V], most recently used first
+	entries map[string]*list.Element
+}
+
+type queryCacheEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// newQueryCache returns a cache of size entries, or nil when size is not
+// positive.
+func newQueryCache[V any](size int, ttl time.Duration) *queryCache[V] {
+	if size <= 0 {
+		return nil
+	}
+	return &queryCache[V]{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *queryCache[V]) get(key string) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*queryCacheEntry[V])
+	if !c.now().Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *queryCache[V]) put(key string, value V) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &queryCacheEntry[V]{key: key, value: value, expires: c.now().Add(c.ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(e)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*queryCacheEntry[V]).key)
+	}
+}
+
+// purge drops every entry.
+func (c *queryCache[V]) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.entries)
+}
+
+// purgeQueryCaches drops cached query results, e.g. after a catalog reload.
+func (p *productCatalog) purgeQueryCaches() {
+	p.productCache.purge()
+	p.searchCache.purge()
+}
+
+// searchCacheKey identifies a search by its query and attributes, with the
+// query and attribute values case-folded as searchProducts compares them.
+func searchCacheKey(req *pb.SearchProductsRequest) string {
+	attrs := make([]string, 0, len(req.Attributes))
+	for k, v := range req.Attributes {
+		attrs = append(attrs, k+"\x01"+strings.ToLower(v))
+	}
+	sort.Strings(attrs)
+	return strings.ToLower(req.Query) + "\x00" + strings.Join(attrs, "\x00")
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+)
+
+func TestQueryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newQueryCache[int](2, time.Minute)
+	c.put("a", 1)
+	c.put("b", 2)
+	c.get("a")
+	c.put("c", 3)
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry was kept")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if got, ok := c.get(key); !ok || got != want {
+			t.Errorf("get(%q) = %d, %v, want %d", key, got, ok, want)
+		}
+	}
+	if newQueryCache[int](0, time.Minute) != nil {
+		t.Error("cache of size 0 is not disabled")
+	}
+}
+
+// productRowQuerier is a pool that counts lookups and returns a product.
+type productRowQuerier struct {
+	dbQuerier
+	lookups int
+}
+
+type productRow struct{ name string }
+
+func (r productRow) Scan(dest ...any) error {
+	*dest[0].(*string) = "OLJCESPC7Z"
+	*dest[1].(*string) = r.name
+	*dest[4].(*string) = "USD"
+	*dest[5].(*int64) = 1
+	return nil
+}
+
+func (q *productRowQuerier) QueryRow(context.Context, string, ...any) pgx.Row {
+	q.lookups++
+	return productRow{name: fmt.Sprintf("Sunglasses v%d", q.lookups)}
+}
+
+func TestProductQueryCache(t *testing.T) {
+	defer func(p, r *dbPool) { alloyDB, alloyDBReplica = p, r }(alloyDB, alloyDBReplica)
+	t.Setenv("ALLOYDB_CLUSTER_NAME", "c")
+	t.Setenv("ALLOYDB_TABLE_NAME", "products")
+	db := &productRowQuerier{}
+	alloyDB, alloyDBReplica = &dbPool{connect: func(context.Context) (dbQuerier, func(), error) {
+		return db, func() {}, nil
+	}}, nil
+
+	now := time.Now()
+	svc := &productCatalog{productCache: newQueryCache[*pb.Product](10, time.Minute)}
+	svc.productCache.now = func() time.Time { return now }
+	get := func() string {
+		t.Helper()
+		p, err := svc.getProductFromDatabase(context.Background(), "OLJCESPC7Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Name
+	}
+
+	if first, second := get(), get(); first != second || db.lookups != 1 {
+		t.Errorf("got %q then %q after %d lookups, want one lookup served twice", first, second, db.lookups)
+	}
+	now = now.Add(time.Minute)
+	if got := get(); got != "Sunglasses v2" || db.lookups != 2 {
+		t.Errorf("after the TTL got %q after %d lookups, want a fresh lookup", got, db.lookups)
+	}
+
+	uncached := &productCatalog{}
+	for i := 0; i < 2; i++ {
+		if _, err := uncached.getProductFromDatabase(context.Background(), "OLJCESPC7Z"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.lookups != 4 {
+		t.Errorf("uncached catalog made %d lookups, want every request to query", db.lookups-2)
+	}
+}
+
+func TestSearchQueryCacheInvalidatedOnReload(t *testing.T) {
+	defer func(f string) { catalogFile = f }(catalogFile)
+	catalogFile = filepath.Join(t.TempDir(), "products.json")
+	writeCatalogFile(t, catalogFile, `{"products": [
+		{"id": "A", "name": "Mug", "priceUsd": {"currencyCode": "USD", "units": 1}}]}`)
+	svc := &productCatalog{searchCache: newQueryCache[*pb.SearchProductsResponse](10, time.Minute)}
+	search := func(query string) int {
+		t.Helper()
+		resp, err := svc.searchProductsFromDatabase(context.Background(), &pb.SearchProductsRequest{Query: query})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(resp.Results)
+	}
+
+	if n := search("mug"); n != 1 {
+		t.Fatalf("search found %d products, want 1", n)
+	}
+	writeCatalogFile(t, catalogFile, `{"products": [
+		{"id": "A", "name": "Mug", "priceUsd": {"currencyCode": "USD", "units": 1}},
+		{"id": "B", "name": "Mug holder", "priceUsd": {"currencyCode": "USD", "units": 2}}]}`)
+	if n := search("MUG"); n != 1 {
+		t.Errorf("repeated search found %d products, want the cached 1", n)
+	}
+	if _, err := svc.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if n := search("mug"); n != 2 {
+		t.Errorf("search after reload found %d products, want 2", n)
+	}
+}
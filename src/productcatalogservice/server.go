@@ -19,8 +19,10 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -45,22 +47,25 @@ var (
 	log          *logrus.Logger
 	extraLatency time.Duration
 
-	port = "3550"
+	port      = "3550"
+	adminPort = "3551"
 
 	reloadCatalog bool
+
+	// strictCatalog fails catalog loads containing invalid products instead
+	// of skipping them.
+	strictCatalog bool
+
+	// shutdownTimeout bounds how long in-flight requests may take to finish
+	// after SIGTERM before they are cancelled. Set with SHUTDOWN_TIMEOUT; keep
+	// it below the pod's terminationGracePeriodSeconds (5s).
+	shutdownTimeout = 4 * time.Second
 )
 
 func init() {
 	log = logrus.New()
-	log.Formatter = &logrus.JSONFormatter{
-		FieldMap: logrus.FieldMap{
-			logrus.FieldKeyTime:  "timestamp",
-			logrus.FieldKeyLevel: "severity",
-			logrus.FieldKeyMsg:   "message",
-		},
-		TimestampFormat: time.RFC3339Nano,
-	}
 	log.Out = os.Stdout
+	configureLogging(log)
 	catalogMutex = &sync.Mutex{}
 }
 
@@ -111,15 +116,73 @@ func main() {
 		}
 	}()
 
+	shutdownTimeout = shutdownTimeoutFromEnv(shutdownTimeout)
+
+	if s := os.Getenv("CATALOG_RELOAD_INTERVAL"); s != "" {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("failed to parse CATALOG_RELOAD_INTERVAL (%s) as time.Duration: %+v", s, err)
+		}
+		catalogReloadInterval = v
+	}
+
+	if s := os.Getenv("QUERY_CACHE_SIZE"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			log.Fatalf("failed to parse QUERY_CACHE_SIZE (%s) as a non-negative integer", s)
+		}
+		queryCacheSize = v
+	}
+
+	if s := os.Getenv("QUERY_CACHE_TTL"); s != "" {
+		v, err := time.ParseDuration(s)
+		if err != nil || v <= 0 {
+			log.Fatalf("failed to parse QUERY_CACHE_TTL (%s) as a positive time.Duration", s)
+		}
+		queryCacheTTL = v
+	}
+
+	if os.Getenv("CATALOG_STRICT_VALIDATION") == "true" {
+		strictCatalog = true
+		log.Info("strict catalog validation enabled")
+	}
+
+	if os.Getenv("ALLOYDB_CLUSTER_NAME") != "" {
+		if _, err := catalogSchemaFromEnv(); err != nil {
+			log.Fatalf("invalid AlloyDB catalog table configuration: %+v", err)
+		}
+		configureReadReplica()
+	}
+
+	src, err := newCatalogSource(context.Background(), os.Getenv("CATALOG_SOURCE"))
+	if err != nil {
+		log.Fatalf("failed to configure the catalog source: %+v", err)
+	}
+	catalogSrc = src
+
 	if os.Getenv("PORT") != "" {
 		port = os.Getenv("PORT")
 	}
 	log.Infof("starting grpc server at :%s", port)
-	run(port)
-	select {}
+	_, shutdown := run(port)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+
+	log.Infof("shutting down, draining requests for up to %s", shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	shutdown(ctx)
+	alloyDB.close()
+	alloyDBReplica.close()
+	log.Info("shutdown complete")
 }
 
-func run(port string) string {
+// run starts the gRPC server, and the admin server if configured. It returns
+// the gRPC address and a function that stops accepting connections and waits
+// for in-flight requests, cancelling those still running when ctx is done.
+func run(port string) (string, func(ctx context.Context)) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
 	if err != nil {
 		log.Fatal(err)
@@ -131,10 +194,16 @@ func run(port string) string {
 			propagation.TraceContext{}, propagation.Baggage{}))
 	var srv *grpc.Server
 	srv = grpc.NewServer(
-		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), dataSourceInterceptor(loadDataSourcePolicy())),
 		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()))
 
-	svc := &productCatalog{}
+	svc := &productCatalog{
+		productCache: newQueryCache[*pb.Product](queryCacheSize, queryCacheTTL),
+		searchCache:  newQueryCache[*pb.SearchProductsResponse](queryCacheSize, queryCacheTTL),
+	}
+	if queryCacheSize > 0 {
+		log.Infof("caching up to %d query results for %s", queryCacheSize, queryCacheTTL)
+	}
 	err = loadCatalog(&svc.catalog)
 	if err != nil {
 		log.Fatalf("could not parse product catalog: %v", err)
@@ -144,7 +213,73 @@ func run(port string) string {
 	healthpb.RegisterHealthServer(srv, svc)
 	go srv.Serve(listener)
 
-	return listener.Addr().String()
+	stopPolling := func() {}
+	if catalogReloadInterval > 0 {
+		log.Infof("reloading the catalog every %s", catalogReloadInterval)
+		var ctx context.Context
+		ctx, stopPolling = context.WithCancel(context.Background())
+		go svc.pollCatalog(ctx, catalogReloadInterval)
+	}
+
+	var admin *http.Server
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		if os.Getenv("ADMIN_PORT") != "" {
+			adminPort = os.Getenv("ADMIN_PORT")
+		}
+		log.Infof("starting admin server at :%s", adminPort)
+		admin = &http.Server{Addr: ":" + adminPort, Handler: svc.adminHandler(token)}
+		go func() {
+			if err := admin.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	} else {
+		log.Info("ADMIN_TOKEN not set, admin endpoints disabled")
+	}
+
+	return listener.Addr().String(), func(ctx context.Context) {
+		stopPolling()
+		if admin != nil {
+			if err := admin.Shutdown(ctx); err != nil {
+				log.Warnf("admin server did not drain in time: %v", err)
+				admin.Close()
+			}
+		}
+		stopGRPC(ctx, srv)
+	}
+}
+
+// shutdownTimeoutFromEnv returns SHUTDOWN_TIMEOUT, or def when it is unset
+// or not a positive duration, like the checkout and shipping services.
+func shutdownTimeoutFromEnv(def time.Duration) time.Duration {
+	s := os.Getenv("SHUTDOWN_TIMEOUT")
+	if s == "" {
+		return def
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil || v <= 0 {
+		log.Warnf("invalid SHUTDOWN_TIMEOUT %q, using %s", s, def)
+		return def
+	}
+	return v
+}
+
+// stopGRPC stops srv gracefully, cancelling the RPCs still running when ctx
+// is done. It returns once every RPC has; main closes the AlloyDB pools
+// afterwards, so no RPC loses its connection mid-query.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn("in-flight requests did not finish in time, cancelling them")
+		srv.Stop()
+		<-done
+	}
 }
 
 func initStats() {
@@ -0,0 +1,92 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	defer func(d time.Duration) { extraLatency = d }(extraLatency)
+	extraLatency = 300 * time.Millisecond
+
+	addr, shutdown := run("0")
+	_, port, _ := net.SplitHostPort(addr)
+	conn, err := grpc.NewClient("localhost:"+port, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewProductCatalogServiceClient(conn)
+
+	type result struct {
+		product *pb.Product
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		p, err := client.GetProduct(context.Background(), &pb.GetProductRequest{Id: "TKPFCZ9EA7H5FYZH"})
+		done <- result{p, err}
+	}()
+	time.Sleep(100 * time.Millisecond) // let the request start
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown(ctx)
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatalf("in-flight request failed: %v", res.err)
+		}
+		if res.product.GetId() != "TKPFCZ9EA7H5FYZH" {
+			t.Errorf("got product %q", res.product.GetId())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("in-flight request did not complete")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.GetProduct(ctx, &pb.GetProductRequest{Id: "TKPFCZ9EA7H5FYZH"}); err == nil {
+		t.Error("request after shutdown succeeded")
+	}
+}
+
+func TestShutdownTimeoutFromEnv(t *testing.T) {
+	const def = 4 * time.Second
+	tests := []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", def},
+		{"2s", 2 * time.Second},
+		{"soon", def},
+		{"0s", def},
+	}
+	for _, tt := range tests {
+		t.Setenv("SHUTDOWN_TIMEOUT", tt.env)
+		if got := shutdownTimeoutFromEnv(def); got != tt.want {
+			t.Errorf("shutdownTimeoutFromEnv with %q = %s, want %s", tt.env, got, tt.want)
+		}
+	}
+}
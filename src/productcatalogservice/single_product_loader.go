@@ -16,94 +16,44 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"net"
-	"os"
 	"strings"
 
-	"cloud.google.com/go/alloydbconn"
 	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // loadSingleProductFromAlloyDB loads a single product by ID from AlloyDB
 func loadSingleProductFromAlloyDB(productID string) (*pb.Product, error) {
-	log.Infof("loading single product %s from AlloyDB...", productID)
-
-	projectID := os.Getenv("PROJECT_ID")
-	region := os.Getenv("REGION")
-	pgClusterName := os.Getenv("ALLOYDB_CLUSTER_NAME")
-	pgInstanceName := os.Getenv("ALLOYDB_INSTANCE_NAME")
-	pgDatabaseName := os.Getenv("ALLOYDB_DATABASE_NAME")
-	pgTableName := os.Getenv("ALLOYDB_TABLE_NAME")
-	pgSecretName := os.Getenv("ALLOYDB_SECRET_NAME")
-	pgPrimaryIP := os.Getenv("ALLOYDB_PRIMARY_IP")
-
-	pgPassword, err := getSecretPayload(projectID, pgSecretName, "latest")
-	if err != nil {
-		return nil, err
-	}
-
-	sslMode := "disable"
-	if pgPrimaryIP != "" {
-		// Direct private IP connections must use TLS.
-		sslMode = "require"
-	}
-
-	dsn := fmt.Sprintf(
-		"user=%s password=%s dbname=%s sslmode=%s",
-		"postgres", pgPassword, pgDatabaseName, sslMode,
-	)
-
-	config, err := pgxpool.ParseConfig(dsn)
-	if err != nil {
-		log.Warnf("failed to parse DSN config: %v", err)
-		return nil, err
-	}
-
-	if pgPrimaryIP != "" {
-		// Use direct TCP to the private IP
-		config.ConnConfig.Host = pgPrimaryIP
-		config.ConnConfig.Port = 5432
-		log.Infof("connecting to AlloyDB via private IP %s:5432", pgPrimaryIP)
-	} else {
-		// Fallback to AlloyDB connector
-		dialer, err := alloydbconn.NewDialer(context.Background())
-		if err != nil {
-			log.Warnf("failed to set-up dialer connection: %v", err)
-			return nil, err
-		}
-		cleanup := func() error { return dialer.Close() }
-		defer cleanup()
+	return loadSingleProduct(context.Background(), readAlloyDB, productID)
+}
 
-		pgInstanceURI := fmt.Sprintf("projects/%s/locations/%s/clusters/%s/instances/%s", projectID, region, pgClusterName, pgInstanceName)
-		config.ConnConfig.DialFunc = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
-			return dialer.Dial(ctx, pgInstanceURI)
-		}
-	}
+// loadSingleProduct loads a single product by ID through run, which is
+// readAlloyDB for reads that may be served by the read pool and alloyDB.do
+// for reads that must see the primary's latest writes.
+func loadSingleProduct(ctx context.Context, run func(context.Context, func(dbQuerier) error) error, productID string) (*pb.Product, error) {
+	log.Infof("loading single product %s from AlloyDB...", productID)
 
-	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	schema, err := catalogSchemaFromEnv()
 	if err != nil {
-		log.Warnf("failed to set-up pgx pool: %v", err)
+		log.Warnf("invalid catalog table configuration: %v", err)
 		return nil, err
 	}
-	defer pool.Close()
-
-	// Query for the specific product by ID
-	query := "SELECT id, name, description, picture, price_usd_currency_code, " +
-		"price_usd_units, price_usd_nanos, categories " +
-		"FROM " + pgTableName + " " +
-		"WHERE id = $1 LIMIT 1"
 
-	row := pool.QueryRow(context.Background(), query, productID)
+	// Query for the specific product by ID. IDs are stored in canonical form
+	// (see normalizeProductID), so the plain comparison can use the index.
+	query := "SELECT " + schema.selectList() + " FROM " + schema.tableName() +
+		" WHERE " + schema.column("id") + " = $1 LIMIT 1"
 
 	product := &pb.Product{}
 	product.PriceUsd = &pb.Money{}
 
 	var categories string
-	err = row.Scan(&product.Id, &product.Name, &product.Description,
-		&product.Picture, &product.PriceUsd.CurrencyCode, &product.PriceUsd.Units,
-		&product.PriceUsd.Nanos, &categories)
+	var attributes []byte
+	err = run(ctx, func(pool dbQuerier) error {
+		return pool.QueryRow(ctx, query, normalizeProductID(productID)).Scan(
+			&product.Id, &product.Name, &product.Description,
+			&product.Picture, &product.PriceUsd.CurrencyCode, &product.PriceUsd.Units,
+			&product.PriceUsd.Nanos, &categories, &attributes, &product.Published, &product.Stock)
+	})
 	if err != nil {
 		log.Warnf("failed to scan product %s: %v", productID, err)
 		return nil, err
@@ -111,6 +61,10 @@ func loadSingleProductFromAlloyDB(productID string) (*pb.Product, error) {
 
 	categories = strings.ToLower(categories)
 	product.Categories = strings.Split(categories, ",")
+	if product.Attributes, err = parseAttributes(attributes); err != nil {
+		log.Warnf("invalid attributes of product %s: %v", productID, err)
+		return nil, err
+	}
 
 	log.Infof("successfully loaded product %s from AlloyDB", productID)
 	return product, nil
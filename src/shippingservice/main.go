@@ -94,9 +94,10 @@ func main() {
 
 	// Register reflection service on gRPC server.
 	reflection.Register(srv)
-	if err := srv.Serve(lis); err != nil {
+	if err := serveGracefully(srv, lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
+	log.Info("shutdown complete")
 }
 
 // server controls RPC service responses.
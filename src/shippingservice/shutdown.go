@@ -0,0 +1,83 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// defaultShutdownTimeout is how long in-flight RPCs get to finish after
+// SIGTERM, below the pod's default 30s termination grace period. It can be
+// overridden with SHUTDOWN_TIMEOUT.
+const defaultShutdownTimeout = 25 * time.Second
+
+// serveGracefully serves srv on lis until SIGTERM or an interrupt, then stops
+// accepting connections and waits for in-flight RPCs, cancelling those still
+// running after the shutdown timeout.
+func serveGracefully(srv *grpc.Server, lis net.Listener) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	return serveUntil(ctx, srv, lis, shutdownTimeout())
+}
+
+// serveUntil serves srv on lis until ctx is done, then drains it like
+// serveGracefully. The checkout and shipping services keep identical copies
+// of this file.
+func serveUntil(ctx context.Context, srv *grpc.Server, lis net.Listener, timeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(lis) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Infof("shutting down, draining requests for up to %s", timeout)
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("in-flight requests did not finish in time, cancelling them")
+		srv.Stop()
+		<-done
+	}
+	return nil
+}
+
+// shutdownTimeout returns SHUTDOWN_TIMEOUT, or defaultShutdownTimeout when
+// it is unset or not a positive duration.
+func shutdownTimeout() time.Duration {
+	v := os.Getenv("SHUTDOWN_TIMEOUT")
+	if v == "" {
+		return defaultShutdownTimeout
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Warnf("invalid SHUTDOWN_TIMEOUT %q, using %s", v, defaultShutdownTimeout)
+		return defaultShutdownTimeout
+	}
+	return d
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", defaultShutdownTimeout},
+		{"10s", 10 * time.Second},
+		{"soon", defaultShutdownTimeout},
+		{"-1s", defaultShutdownTimeout},
+	}
+	for _, tt := range tests {
+		t.Setenv("SHUTDOWN_TIMEOUT", tt.env)
+		if got := shutdownTimeout(); got != tt.want {
+			t.Errorf("shutdownTimeout() with %q = %s, want %s", tt.env, got, tt.want)
+		}
+	}
+}
+
+// TestServeUntilCancelsStuckRPCs checks that an RPC still running after the
+// shutdown timeout is cancelled instead of holding up the shutdown.
+func TestServeUntilCancelsStuckRPCs(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- serveUntil(ctx, srv, lis, 100*time.Millisecond) }()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Watch streams until the client or the server gives up.
+	stream, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serveUntil = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown waited for the stuck RPC")
+	}
+	if _, err := stream.Recv(); err == nil {
+		t.Error("stuck RPC was not cancelled")
+	}
+}